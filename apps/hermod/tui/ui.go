@@ -109,6 +109,7 @@ func NewHermod() hermod {
 	fsh := filesharing.New(
 		man,
 		nnet,
+		str,
 		cfg.ReceivedFolder,
 	)
 
@@ -120,7 +121,11 @@ func NewHermod() hermod {
 	her.objectstore = str
 	her.objectmanager = man
 	her.fsh = fsh
-	her.blobmanager = blob.NewManager(ctx, blob.WithObjectManager(man))
+	her.blobmanager = blob.NewManager(
+		ctx,
+		blob.WithObjectManager(man),
+		blob.WithObjectStore(str),
+	)
 	her.transfers = make(map[string]*transferWrap)
 
 	go func() {
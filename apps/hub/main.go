@@ -30,6 +30,7 @@ import (
 	"github.com/shurcooL/httpfs/vfsutil"
 	"github.com/skip2/go-qrcode"
 
+	"nimona.io/pkg/blob"
 	"nimona.io/pkg/config"
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
@@ -39,7 +40,7 @@ import (
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectmanager"
 	"nimona.io/pkg/objectstore"
-	"nimona.io/pkg/sqlobjectstore"
+	"nimona.io/pkg/search"
 	"nimona.io/pkg/tilde"
 	"nimona.io/schema/relationship"
 )
@@ -200,6 +201,11 @@ func main() {
 		log.Fatal(err)
 	}
 
+	blobManager := blob.NewManager(
+		context.New(),
+		blob.WithObjectStore(d.ObjectStore()),
+	)
+
 	cssAssets, _ := fs.Sub(assets, "assets/css")
 	r.Use(middleware.Logger)
 
@@ -722,7 +728,7 @@ func main() {
 	})
 
 	r.Get("/objects", func(w http.ResponseWriter, r *http.Request) {
-		sqlFilters := []sqlobjectstore.FilterOption{}
+		sqlFilters := []objectstore.FilterOption{}
 		filters := []string{}
 		vs, _ := url.ParseQuery(r.URL.RawQuery)
 		if vf, ok := vs["type"]; ok {
@@ -730,22 +736,36 @@ func main() {
 			for _, vvf := range vf {
 				sqlFilters = append(
 					sqlFilters,
-					sqlobjectstore.FilterByObjectType(vvf),
+					objectstore.FilterByObjectType(vvf),
 				)
 			}
 		}
-		reader, err := d.ObjectStore().(*sqlobjectstore.Store).Filter(sqlFilters...)
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		var (
+			reader object.ReadCloser
+			err    error
+		)
+		if query != "" {
+			reader, err = d.Search().Search(
+				query,
+				search.FilterByObjectType(filters...),
+			)
+		} else {
+			reader, err = d.ObjectStore().Filter(sqlFilters...)
+		}
 		if err != nil && err != objectstore.ErrNotFound {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		values := struct {
 			URL     string
+			Query   string
 			Filters []string
 			Types   []string
 			Objects []*object.Object
 		}{
 			URL:     r.URL.String(),
+			Query:   query,
 			Filters: filters,
 			Types:   []string{}, // TODO get from object store
 			Objects: []*object.Object{},
@@ -789,10 +809,12 @@ func main() {
 			JSON          template.HTML
 			StreamRoot    string
 			StreamObjects []*object.Object
+			IsBlob        bool
 		}{
-			Hash: hash,
-			Type: obj.Type,
-			JSON: template.HTML(prettyJSON(string(body))),
+			Hash:   hash,
+			Type:   obj.Type,
+			JSON:   template.HTML(prettyJSON(string(body))),
+			IsBlob: obj.Type == blob.BlobType,
 		}
 		if !obj.Metadata.Root.IsEmpty() {
 			values.StreamRoot = obj.Metadata.Root.String()
@@ -818,6 +840,29 @@ func main() {
 		}
 	})
 
+	r.Get("/blobs/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		hash := chi.URLParam(r, "hash")
+		// the chunks are streamed from the store as they are written out,
+		// so large blobs are never loaded in memory
+		br, err := blobManager.Open(
+			context.FromContext(r.Context()),
+			tilde.Digest(hash),
+		)
+		if err == objectstore.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer br.Close() // nolint: errcheck
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := io.Copy(w, br); err != nil {
+			log.Println("unable to write blob,", err)
+		}
+	})
+
 	if err := http.ListenAndServe(":"+port, r); err != nil {
 		fmt.Printf("unable to start http server, %s", err.Error())
 	}
@@ -187,6 +187,22 @@ func NimonaBridgeCall(
 	case "getConnectionInfo":
 		o, _ := object.Marshal(nimonaProvider.GetConnectionInfo())
 		return renderObject(o)
+	case "encode":
+		m := tilde.Map{}
+		if err := json.Unmarshal(payloadBytes, &m); err != nil {
+			return renderBytes(nil, err)
+		}
+		return renderBytes(tilde.Marshal(m))
+	case "decode":
+		v, err := tilde.Unmarshal(payloadBytes)
+		if err != nil {
+			return renderBytes(nil, err)
+		}
+		m, ok := v.(tilde.Map)
+		if !ok {
+			return renderBytes(nil, errors.New("encoded value is not a map"))
+		}
+		return renderBytes(json.Marshal(m))
 	}
 
 	return renderBytes([]byte("error"), errors.New(nameString+" not implemented"))
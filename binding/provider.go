@@ -13,9 +13,9 @@ import (
 	"nimona.io/pkg/network"
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectmanager"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/peer"
 	"nimona.io/pkg/resolver"
-	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
 	"nimona.io/pkg/version"
 )
@@ -24,7 +24,7 @@ type (
 	Provider struct {
 		network       network.Network
 		resolver      resolver.Resolver
-		objectstore   *sqlobjectstore.Store
+		objectstore   objectstore.Store
 		objectmanager objectmanager.ObjectManager
 		logger        log.Logger
 	}
@@ -66,7 +66,7 @@ func New(initRequest *InitRequest) *Provider {
 
 	nConfig := d.Config()
 	net := d.Network()
-	str := d.ObjectStore().(*sqlobjectstore.Store)
+	str := d.ObjectStore()
 	res := d.Resolver()
 	man := d.ObjectManager()
 
@@ -101,6 +101,9 @@ type GetRequest struct {
 	OrderDir string   `json:"orderDir"`
 	Limit    int      `json:"limit"`
 	Offset   int      `json:"offset"`
+	// After is the hash of the last object of the previous page, when set
+	// Limit is used as the page size and Offset is ignored
+	After string `json:"after"`
 }
 
 // nolint: deadcode
@@ -112,7 +115,7 @@ func (p *Provider) Get(
 	ctx context.Context,
 	req GetRequest,
 ) (object.ReadCloser, error) {
-	opts := []sqlobjectstore.FilterOption{}
+	opts := []objectstore.FilterOption{}
 	filterByType := []string{}
 	filterByHash := []tilde.Digest{}
 	filterByOwner := []did.DID{}
@@ -151,44 +154,49 @@ func (p *Provider) Get(
 		if req.OrderBy != "" {
 			opts = append(
 				opts,
-				sqlobjectstore.FilterOrderBy(req.OrderBy),
+				objectstore.FilterOrderBy(req.OrderBy),
 			)
 		}
 		if req.OrderDir != "" {
 			opts = append(
 				opts,
-				sqlobjectstore.FilterOrderDir(req.OrderDir),
+				objectstore.FilterOrderDir(req.OrderDir),
 			)
 		}
-		if req.Limit > 0 && req.Offset > 0 {
+		if req.Limit > 0 && req.After != "" {
 			opts = append(
 				opts,
-				sqlobjectstore.FilterLimit(req.Limit, req.Offset),
+				objectstore.FilterPage(tilde.Digest(req.After), req.Limit),
+			)
+		} else if req.Limit > 0 && req.Offset > 0 {
+			opts = append(
+				opts,
+				objectstore.FilterLimit(req.Limit, req.Offset),
 			)
 		}
 	}
 	if len(filterByType) > 0 {
 		opts = append(
 			opts,
-			sqlobjectstore.FilterByObjectType(filterByType...),
+			objectstore.FilterByObjectType(filterByType...),
 		)
 	}
 	if len(filterByHash) > 0 {
 		opts = append(
 			opts,
-			sqlobjectstore.FilterByHash(filterByHash...),
+			objectstore.FilterByHash(filterByHash...),
 		)
 	}
 	if len(filterByOwner) > 0 {
 		opts = append(
 			opts,
-			sqlobjectstore.FilterByOwner(filterByOwner...),
+			objectstore.FilterByOwner(filterByOwner...),
 		)
 	}
 	if len(filterByStreamHash) > 0 {
 		opts = append(
 			opts,
-			sqlobjectstore.FilterByStreamHash(filterByStreamHash...),
+			objectstore.FilterByStreamHash(filterByStreamHash...),
 		)
 	}
 	return p.objectstore.Filter(opts...)
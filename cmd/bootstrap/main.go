@@ -77,6 +77,8 @@ func main() {
 		ctx,
 		inet,
 		cfg.Peer.PrivateKey,
+		network.WithRelay(),
+		network.WithMailbox(),
 	)
 
 	// start listening
@@ -4,25 +4,59 @@ package main
 
 import (
 	object "nimona.io/pkg/object"
+	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const ConversationStreamRootType = "stream:poc.nimona.io/conversation"
 
 type ConversationStreamRoot struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=stream:poc.nimona.io/conversation"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=stream:poc.nimona.io/conversation,context=8wDCjSBrgZ7tiogjET96WFLLZADq9ixZfihtvcZFBrnD"`
 	Nonce    string          `nimona:"nonce:s"`
 }
 
 const ConversationNicknameUpdatedType = "poc.nimona.io/conversation.NicknameUpdated"
 
 type ConversationNicknameUpdated struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=poc.nimona.io/conversation.NicknameUpdated"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=poc.nimona.io/conversation.NicknameUpdated,context=8wDCjSBrgZ7tiogjET96WFLLZADq9ixZfihtvcZFBrnD"`
 	Nickname string          `nimona:"nickname:s"`
 }
 
 const ConversationMessageAddedType = "poc.nimona.io/conversation.MessageAdded"
 
 type ConversationMessageAdded struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=poc.nimona.io/conversation.MessageAdded"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=poc.nimona.io/conversation.MessageAdded,context=8wDCjSBrgZ7tiogjET96WFLLZADq9ixZfihtvcZFBrnD"`
 	Body     string          `nimona:"body:s"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("8wDCjSBrgZ7tiogjET96WFLLZADq9ixZfihtvcZFBrnD")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "main",
+	Types: []*schema.Type{{
+		Name: "stream:poc.nimona.io/conversation",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}},
+	}, {
+		Name: "poc.nimona.io/conversation.NicknameUpdated",
+		Properties: []*schema.Property{{
+			Name: "nickname",
+			Hint: "s",
+		}},
+	}, {
+		Name: "poc.nimona.io/conversation.MessageAdded",
+		Properties: []*schema.Property{{
+			Name: "body",
+			Hint: "s",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
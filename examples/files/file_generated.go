@@ -5,12 +5,36 @@ package main
 import (
 	object "nimona.io/pkg/object"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const FileType = "nimona.io/File"
 
 type File struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/File"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/File,context=AHTRTNRmtdmmjyjDmxvwS6uVL8v96aE9esNQELCez8EN"`
 	Name     string          `nimona:"name:s"`
 	Blob     tilde.Digest    `nimona:"blob:r"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("AHTRTNRmtdmmjyjDmxvwS6uVL8v96aE9esNQELCez8EN")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "main",
+	Types: []*schema.Type{{
+		Name: "nimona.io/File",
+		Properties: []*schema.Property{{
+			Name: "name",
+			Hint: "s",
+		}, {
+			Name: "blob",
+			Hint: "r",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
@@ -65,6 +65,16 @@ resource "scaleway_instance_security_group" "server" {
       port   = inbound_rule.value
     }
   }
+
+  dynamic "inbound_rule" {
+    for_each = var.inbound_ports
+
+    content {
+      action   = "accept"
+      port     = inbound_rule.value
+      protocol = "UDP"
+    }
+  }
 }
 
 locals {
@@ -4,12 +4,14 @@ package fixtures
 
 import (
 	object "nimona.io/pkg/object"
+	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const CompositeTestType = "compositeTest"
 
 type CompositeTest struct {
-	Metadata                    object.Metadata `nimona:"@metadata:m,type=compositeTest"`
+	Metadata                    object.Metadata `nimona:"@metadata:m,type=compositeTest,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	CompositeStringTest         *Composite      `nimona:"compositeStringTest:s"`
 	CompositeDataTest           *Composite      `nimona:"compositeDataTest:d"`
 	RepeatedCompositeStringTest []*Composite    `nimona:"repeatedCompositeStringTest:as"`
@@ -19,7 +21,7 @@ type CompositeTest struct {
 const TestPolicyType = "nimona.io/fixtures.TestPolicy"
 
 type TestPolicy struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestPolicy"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestPolicy,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Subjects   []string        `nimona:"subjects:as"`
 	Resources  []string        `nimona:"resources:as"`
 	Conditions []string        `nimona:"conditions:as"`
@@ -29,7 +31,7 @@ type TestPolicy struct {
 const TestStreamType = "nimona.io/fixtures.TestStream"
 
 type TestStream struct {
-	Metadata        object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestStream"`
+	Metadata        object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestStream,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Nonce           string          `nimona:"nonce:s"`
 	CreatedDateTime string          `nimona:"createdDateTime:s"`
 }
@@ -37,21 +39,21 @@ type TestStream struct {
 const TestSubscribedType = "nimona.io/fixtures.TestSubscribed"
 
 type TestSubscribed struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestSubscribed"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestSubscribed,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Nonce    string          `nimona:"nonce:s"`
 }
 
 const TestUnsubscribedType = "nimona.io/fixtures.TestUnsubscribed"
 
 type TestUnsubscribed struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestUnsubscribed"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestUnsubscribed,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Nonce    string          `nimona:"nonce:s"`
 }
 
 const TestRequestType = "nimona.io/fixtures.TestRequest"
 
 type TestRequest struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestRequest"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestRequest,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	RequestID string          `nimona:"requestID:s"`
 	Foo       string          `nimona:"foo:s"`
 }
@@ -59,7 +61,7 @@ type TestRequest struct {
 const TestResponseType = "nimona.io/fixtures.TestResponse"
 
 type TestResponse struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestResponse"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/fixtures.TestResponse,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	RequestID string          `nimona:"requestID:s"`
 	Foo       string          `nimona:"foo:s"`
 }
@@ -67,7 +69,7 @@ type TestResponse struct {
 const ParentType = "parent"
 
 type Parent struct {
-	Metadata      object.Metadata `nimona:"@metadata:m,type=parent"`
+	Metadata      object.Metadata `nimona:"@metadata:m,type=parent,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Foo           string          `nimona:"foo:s"`
 	Child         *Child          `nimona:"child:m"`
 	RepeatedChild []*Child        `nimona:"repeatedChild:am"`
@@ -76,6 +78,113 @@ type Parent struct {
 const ChildType = "child"
 
 type Child struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=child"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=child,context=4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ"`
 	Foo      string          `nimona:"foo:s"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("4r1tYs3PUHr2GXGGaV2XppvL9GkdXyNYAJKeF5c2BqhZ")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/fixtures",
+	Types: []*schema.Type{{
+		Name: "compositeTest",
+		Properties: []*schema.Property{{
+			Name: "compositeStringTest",
+			Hint: "s",
+		}, {
+			Name: "compositeDataTest",
+			Hint: "d",
+		}, {
+			Name:     "repeatedCompositeStringTest",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "repeatedCompositeDataTest",
+			Hint:     "d",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestPolicy",
+		Properties: []*schema.Property{{
+			Name:     "subjects",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "resources",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "conditions",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name: "action",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestStream",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}, {
+			Name: "createdDateTime",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestSubscribed",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestUnsubscribed",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "foo",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/fixtures.TestResponse",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "foo",
+			Hint: "s",
+		}},
+	}, {
+		Name: "parent",
+		Properties: []*schema.Property{{
+			Name: "foo",
+			Hint: "s",
+		}, {
+			Name: "child",
+			Hint: "m",
+		}, {
+			Name:     "repeatedChild",
+			Hint:     "m",
+			Repeated: true,
+		}},
+	}, {
+		Name: "child",
+		Properties: []*schema.Property{{
+			Name: "foo",
+			Hint: "s",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
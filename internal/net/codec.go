@@ -0,0 +1,323 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+const (
+	// ObjectFormatJSON is newline delimited json, all peers support it and
+	// every connection starts with it
+	ObjectFormatJSON = "json"
+	// ObjectFormatBinary is a binary encoding of the object's tilde map, it
+	// avoids inflating data values; objects are split into frames that are
+	// multiplexed over a number of streams, see mux.go
+	ObjectFormatBinary = "binary"
+	// maxBinaryObjectSize limits how much we are willing to allocate for a
+	// single incoming object
+	maxBinaryObjectSize = 64 << 20
+)
+
+// defaultObjectFormats are the formats supported by default, in order of
+// preference
+var defaultObjectFormats = []string{
+	ObjectFormatBinary,
+	ObjectFormatJSON,
+}
+
+type (
+	objectEncoder interface {
+		Encode(v interface{}) error
+	}
+	objectDecoder interface {
+		Decode(v interface{}) error
+	}
+)
+
+// negotiateObjectFormat returns the first of our formats that the remote
+// also supports, or json if there are none
+func negotiateObjectFormat(local, remote []string) string {
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r {
+				return l
+			}
+		}
+	}
+	return ObjectFormatJSON
+}
+
+func encodeBinaryObject(o *object.Object) ([]byte, error) {
+	m, err := o.MarshalMap()
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	if err := writeBinaryMap(b, m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeBinaryObject(b []byte, o *object.Object) error {
+	m, err := readBinaryMap(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return o.UnmarshalMap(m)
+}
+
+// writeBinaryMap encodes the map's entries sorted by key, each entry consists
+// of its key, hint, and value; empty values are skipped same as with json
+func writeBinaryMap(w *bytes.Buffer, m tilde.Map) error {
+	ks := []string{}
+	for k, v := range m {
+		if v == nil {
+			continue
+		}
+		switch vv := v.(type) {
+		case tilde.Map:
+			if len(vv) == 0 {
+				continue
+			}
+		case tilde.ArrayValue:
+			if vv.Len() == 0 {
+				continue
+			}
+		}
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	writeBinaryUvarint(w, uint64(len(ks)))
+	for _, k := range ks {
+		v := m[k]
+		writeBinaryBytes(w, []byte(k))
+		writeBinaryBytes(w, []byte(v.Hint()))
+		if err := writeBinaryValue(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBinaryValue(w *bytes.Buffer, v tilde.Value) error {
+	switch vv := v.(type) {
+	case tilde.Bool:
+		if vv {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case tilde.Data:
+		writeBinaryBytes(w, vv)
+	case tilde.Float:
+		b := [8]byte{}
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(float64(vv)))
+		w.Write(b[:])
+	case tilde.Int:
+		w.Write(binary.AppendVarint(nil, int64(vv)))
+	case tilde.Map:
+		return writeBinaryMap(w, vv)
+	case tilde.String:
+		writeBinaryBytes(w, []byte(vv))
+	case tilde.Uint:
+		writeBinaryUvarint(w, uint64(vv))
+	case tilde.Digest:
+		writeBinaryBytes(w, []byte(vv))
+	case tilde.ArrayValue:
+		writeBinaryUvarint(w, uint64(vv.Len()))
+		var err error
+		vv.Range(func(_ int, iv tilde.Value) bool {
+			err = writeBinaryValue(w, iv)
+			return err != nil
+		})
+		return err
+	default:
+		return fmt.Errorf("binary format does not support %T", v)
+	}
+	return nil
+}
+
+func writeBinaryUvarint(w *bytes.Buffer, v uint64) {
+	w.Write(binary.AppendUvarint(nil, v))
+}
+
+func writeBinaryBytes(w *bytes.Buffer, b []byte) {
+	writeBinaryUvarint(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func readBinaryMap(r *bytes.Reader) (tilde.Map, error) {
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return nil, err
+	}
+	m := tilde.Map{}
+	for i := 0; i < n; i++ {
+		k, err := readBinaryBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		h, err := readBinaryBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readBinaryValue(r, tilde.Hint(h))
+		if err != nil {
+			return nil, err
+		}
+		m[string(k)] = v
+	}
+	return m, nil
+}
+
+func readBinaryValue(r *bytes.Reader, h tilde.Hint) (tilde.Value, error) {
+	switch h {
+	case tilde.BoolHint:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return tilde.Bool(b == 1), nil
+	case tilde.DataHint:
+		b, err := readBinaryBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		return tilde.Data(b), nil
+	case tilde.FloatHint:
+		b := [8]byte{}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		return tilde.Float(
+			math.Float64frombits(binary.BigEndian.Uint64(b[:])),
+		), nil
+	case tilde.IntHint:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		return tilde.Int(v), nil
+	case tilde.MapHint:
+		return readBinaryMap(r)
+	case tilde.StringHint:
+		b, err := readBinaryBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		return tilde.String(b), nil
+	case tilde.UintHint:
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		return tilde.Uint(v), nil
+	case tilde.DigestHint:
+		b, err := readBinaryBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		return tilde.Digest(b), nil
+	case tilde.BoolArrayHint:
+		vs := tilde.BoolArray{}
+		err := readBinaryArray(r, tilde.BoolHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Bool))
+		})
+		return vs, err
+	case tilde.DataArrayHint:
+		vs := tilde.DataArray{}
+		err := readBinaryArray(r, tilde.DataHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Data))
+		})
+		return vs, err
+	case tilde.FloatArrayHint:
+		vs := tilde.FloatArray{}
+		err := readBinaryArray(r, tilde.FloatHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Float))
+		})
+		return vs, err
+	case tilde.IntArrayHint:
+		vs := tilde.IntArray{}
+		err := readBinaryArray(r, tilde.IntHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Int))
+		})
+		return vs, err
+	case tilde.MapArrayHint:
+		vs := tilde.MapArray{}
+		err := readBinaryArray(r, tilde.MapHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Map))
+		})
+		return vs, err
+	case tilde.StringArrayHint:
+		vs := tilde.StringArray{}
+		err := readBinaryArray(r, tilde.StringHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.String))
+		})
+		return vs, err
+	case tilde.UintArrayHint:
+		vs := tilde.UintArray{}
+		err := readBinaryArray(r, tilde.UintHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Uint))
+		})
+		return vs, err
+	case tilde.DigestArrayHint:
+		vs := tilde.DigestArray{}
+		err := readBinaryArray(r, tilde.DigestHint, func(v tilde.Value) {
+			vs = append(vs, v.(tilde.Digest))
+		})
+		return vs, err
+	}
+	return nil, fmt.Errorf("binary format does not support hint %s", h)
+}
+
+func readBinaryArray(
+	r *bytes.Reader,
+	h tilde.Hint,
+	f func(tilde.Value),
+) error {
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		v, err := readBinaryValue(r, h)
+		if err != nil {
+			return err
+		}
+		f(v)
+	}
+	return nil
+}
+
+// readBinaryLength reads a length and makes sure that it is not larger than
+// what is left to be read, as every item takes at least a byte
+func readBinaryLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readBinaryBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readBinaryLength(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,149 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+	"nimona.io/pkg/tilde"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	k, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	o := &object.Object{
+		Type: "foo",
+		Metadata: object.Metadata{
+			Owner:    k.PublicKey().DID(),
+			Sequence: 3,
+		},
+		Data: tilde.Map{
+			"bool":   tilde.Bool(true),
+			"data":   tilde.Data(bytes.Repeat([]byte{0, 1, 2}, 1024)),
+			"float":  tilde.Float(-1.25),
+			"int":    tilde.Int(-42),
+			"uint":   tilde.Uint(1 << 63),
+			"string": tilde.String("bar"),
+			"digest": tilde.Digest("baz"),
+			"map": tilde.Map{
+				"nested": tilde.String("value"),
+				"empty":  tilde.Map{},
+			},
+			"bools":   tilde.BoolArray{true, false},
+			"datas":   tilde.DataArray{{1}, {}},
+			"floats":  tilde.FloatArray{1.5, 2},
+			"ints":    tilde.IntArray{-1, 1},
+			"maps":    tilde.MapArray{{"a": tilde.Int(1)}},
+			"strings": tilde.StringArray{"a", "b"},
+			"uints":   tilde.UintArray{1, 2},
+			"digests": tilde.DigestArray{"a", "b"},
+			"empty":   tilde.StringArray{},
+		},
+	}
+	require.NoError(t, object.Sign(k, o))
+
+	// objects should decode the same as they would using json
+	jb, err := json.Marshal(o)
+	require.NoError(t, err)
+	jo := &object.Object{}
+	require.NoError(t, json.Unmarshal(jb, jo))
+
+	b, err := encodeBinaryObject(o)
+	require.NoError(t, err)
+	assert.Less(t, len(b), len(jb))
+
+	bo := &object.Object{}
+	require.NoError(t, decodeBinaryObject(b, bo))
+
+	assert.Equal(t, jo, bo)
+	assert.Equal(t, o.Hash(), bo.Hash())
+}
+
+func TestCodec_Negotiate(t *testing.T) {
+	assert.Equal(t,
+		ObjectFormatBinary,
+		negotiateObjectFormat(defaultObjectFormats, defaultObjectFormats),
+	)
+	assert.Equal(t,
+		ObjectFormatJSON,
+		negotiateObjectFormat(defaultObjectFormats, []string{"json"}),
+	)
+	assert.Equal(t,
+		ObjectFormatJSON,
+		negotiateObjectFormat(defaultObjectFormats, nil),
+	)
+}
+
+func TestCodec_Connection(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+	n3 := newMemPeer(t, sb, "", WithObjectFormats(ObjectFormatJSON))
+
+	assert.Equal(t, defaultObjectFormats, n1.ObjectFormats())
+	assert.Equal(t, []string{ObjectFormatJSON}, n3.ObjectFormats())
+
+	tests := []struct {
+		name   string
+		local  *network
+		remote *network
+		format string
+	}{{
+		name:   "both support binary",
+		local:  n2,
+		remote: n1,
+		format: ObjectFormatBinary,
+	}, {
+		name:   "dialing json only peer",
+		local:  n1,
+		remote: n3,
+		format: ObjectFormatJSON,
+	}, {
+		name:   "dialed by json only peer",
+		local:  n3,
+		remote: n2,
+		format: ObjectFormatJSON,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scs := make(chan Connection, 1)
+			tt.remote.RegisterConnectionHandler(func(c Connection) {
+				scs <- c
+			})
+
+			c, err := tt.local.Dial(context.New(), &peer.ConnectionInfo{
+				Metadata: object.Metadata{
+					Owner: tt.remote.peerKey.PublicKey().DID(),
+				},
+				Addresses:     tt.remote.Addresses(),
+				ObjectFormats: tt.remote.ObjectFormats(),
+			})
+			require.NoError(t, err)
+			sc := <-scs
+
+			obj := newTestObject("foo")
+			obj.Data["data"] = tilde.Data(bytes.Repeat([]byte{1}, 64<<10))
+
+			// local to remote
+			r := sc.Read(context.New())
+			require.NoError(t, c.Write(context.New(), obj))
+			requireObjects(t, r, obj)
+
+			// and back
+			r = c.Read(context.New())
+			require.NoError(t, sc.Write(context.New(), obj))
+			requireObjects(t, r, obj)
+
+			_, isMux := c.(*connection).encoder.(*mux)
+			assert.Equal(t, tt.format == ObjectFormatBinary, isMux)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/Tv0ridobro/data-structure/list"
 
@@ -12,6 +13,7 @@ import (
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
 )
 
 type (
@@ -21,7 +23,11 @@ type (
 		RemoteAddr() string
 		LocalPeerKey() crypto.PublicKey
 		RemotePeerKey() crypto.PublicKey
-		Write(ctx context.Context, o *object.Object) error
+		Write(
+			ctx context.Context,
+			o *object.Object,
+			opts ...WriteOption,
+		) error
 		Read(ctx context.Context) object.ReadCloser
 	}
 	connection struct {
@@ -34,8 +40,12 @@ type (
 		remoteAddress string
 		localAddress  string
 
-		encoder *json.Encoder
-		decoder *json.Decoder
+		// objects are always encoded as json until the handshake switches
+		// each direction to the negotiated format
+		encoder       objectEncoder
+		decoder       objectDecoder
+		objectFormats []string
+		mux           *mux
 
 		conn   io.ReadWriteCloser
 		closer chan struct{}
@@ -67,6 +77,9 @@ func (c *connection) Close() error {
 	// TODO close all subs
 	close(c.closer)
 	c.closed = true
+	if c.mux != nil {
+		c.mux.close(nil)
+	}
 	err := c.conn.Close()
 	c.conn = nil
 	return err
@@ -94,9 +107,28 @@ func (c *connection) RemotePeerKey() crypto.PublicKey {
 	return c.remotePeerKey
 }
 
-func (c *connection) Write(ctx context.Context, o *object.Object) error {
-	// TODO use context for timeout
+func (c *connection) Write(
+	ctx context.Context,
+	o *object.Object,
+	opts ...WriteOption,
+) error {
+	options := &writeOptions{
+		priority: PriorityNormal,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
 	c.mutex.Lock()
+	// multiplexed connections can be written to concurrently, and priorities
+	// are only supported by them
+	if m, ok := c.encoder.(*mux); ok {
+		c.mutex.Unlock()
+		if err := m.write(ctx, o, options.priority); err != nil {
+			return fmt.Errorf("error writing object: %w", err)
+		}
+		return nil
+	}
+	// TODO use context for timeout
 	defer c.mutex.Unlock()
 	if err := c.encoder.Encode(o); err != nil {
 		return fmt.Errorf("error marshaling object: %w", err)
@@ -121,6 +153,89 @@ func (c *connection) read() (o *object.Object, err error) {
 	return o, nil
 }
 
+// writeHandshake writes the ping that lets the remote know that all
+// following objects will be encoded using the given format, and switches our
+// encoder to it
+func (c *connection) writeHandshake(format string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return ErrConnectionClosed
+	}
+	ping := &object.Object{
+		Type: "ping",
+		Data: tilde.Map{
+			"dt": tilde.String(time.Now().Format(time.RFC3339)),
+		},
+	}
+	if format != ObjectFormatJSON {
+		ping.Data["objectFormat"] = tilde.String(format)
+	}
+	if err := c.encoder.Encode(ping); err != nil {
+		return fmt.Errorf("error marshaling object: %w", err)
+	}
+	switch format {
+	case ObjectFormatBinary:
+		c.encoder = c.getMux()
+	default:
+		c.encoder = json.NewEncoder(c.conn)
+	}
+	return nil
+}
+
+// getMux returns the connection's multiplexer, creating it if needed; must be
+// called with the mutex held
+func (c *connection) getMux() *mux {
+	if c.mux == nil {
+		c.mux = newMux(c.conn)
+	}
+	return c.mux
+}
+
+// handleHandshake switches our decoder if the remote has switched its
+// encoder, for incoming connections we also reply with our own handshake
+func (c *connection) handleHandshake(o *object.Object) error {
+	v, ok := o.Data["objectFormat"].(tilde.String)
+	if !ok {
+		return nil
+	}
+	format := string(v)
+	supported := false
+	for _, f := range c.objectFormats {
+		if f == format {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported object format %s", format)
+	}
+	c.mutex.RLock()
+	conn := c.conn
+	c.mutex.RUnlock()
+	if conn == nil {
+		return ErrConnectionClosed
+	}
+	// the json decoder might have already buffered some of the objects that
+	// follow the handshake
+	r := io.Reader(conn)
+	if d, ok := c.decoder.(*json.Decoder); ok {
+		r = io.MultiReader(d.Buffered(), conn)
+	}
+	switch format {
+	case ObjectFormatBinary:
+		c.mutex.Lock()
+		c.decoder = c.getMux().decoder(r)
+		c.mutex.Unlock()
+	default:
+		c.decoder = json.NewDecoder(r)
+	}
+	if !c.IsIncoming {
+		return nil
+	}
+	return c.writeHandshake(format)
+}
+
 func (c *connection) Read(ctx context.Context) object.ReadCloser {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -168,29 +283,37 @@ func (c *connection) publish(o *object.Object) {
 
 func newConnection(conn io.ReadWriteCloser, incoming bool) *connection {
 	c := &connection{
-		ID:         rand.String(12),
-		conn:       conn,
-		IsIncoming: incoming,
-		encoder:    json.NewEncoder(conn),
-		decoder:    json.NewDecoder(conn),
-		pubsub:     NewObjectPubSub(),
-		mutex:      sync.RWMutex{},
-		closer:     make(chan struct{}),
-		buffer:     &list.List[*object.Object]{},
-		bufferLock: sync.RWMutex{},
-		bufferSize: 8,
+		ID:            rand.String(12),
+		conn:          conn,
+		IsIncoming:    incoming,
+		encoder:       json.NewEncoder(conn),
+		decoder:       json.NewDecoder(conn),
+		objectFormats: []string{ObjectFormatJSON},
+		pubsub:        NewObjectPubSub(),
+		mutex:         sync.RWMutex{},
+		closer:        make(chan struct{}),
+		buffer:        &list.List[*object.Object]{},
+		bufferLock:    sync.RWMutex{},
+		bufferSize:    8,
 	}
+	return c
+}
 
-	go func() {
-		for {
-			o, err := c.read()
-			if err != nil {
-				c.Close()
+// readLoop reads and publishes incoming objects until the connection fails,
+// it should be started once the connection's object formats have been set
+func (c *connection) readLoop() {
+	for {
+		o, err := c.read()
+		if err != nil {
+			c.Close() // nolint: errcheck
+			return
+		}
+		if o.Type == "ping" {
+			if err := c.handleHandshake(o); err != nil {
+				c.Close() // nolint: errcheck
 				return
 			}
-			c.publish(o)
 		}
-	}()
-
-	return c
+		c.publish(o)
+	}
 }
@@ -16,4 +16,8 @@ const (
 	// ErrConnectionClosed connection is closed, will usually be merged with
 	// an underlying error
 	ErrConnectionClosed = errors.Error("connection closed")
+	// ErrNoConnection there is no open connection to the peer
+	ErrNoConnection = errors.Error("no connection")
+	// ErrPeerBlocked the peer has been blocked
+	ErrPeerBlocked = errors.Error("peer is blocked")
 )
@@ -0,0 +1,381 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/object"
+)
+
+// Connections using the binary object format multiplex objects over a number
+// of logical streams, one per priority class, so that large objects do not
+// block smaller and more urgent ones from being sent.
+//
+// Objects are split into frames, every frame has the following form:
+//
+//   uvarint(stream) byte(flags) uvarint(length) payload
+//
+// Frames carrying the last part of an object have the muxFlagEnd flag set.
+// Each stream has its own send window; the sender can only have up to that
+// many bytes in flight and waits for the receiver to grant more via frames
+// with the muxFlagWindow flag set, which carry a uvarint increment.
+// The receiver grants the bytes of an object back once the object has been
+// consumed, so a slow consumer slows the sender down. Objects that are larger
+// than half the window are granted back as they are received, as they could
+// otherwise never be completed.
+
+// Priority of an object written to a connection
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+	priorityCount
+)
+
+const (
+	muxFlagEnd    byte = 1 << 0
+	muxFlagWindow byte = 1 << 1
+	// muxMaxFramePayload is the largest payload a single frame can carry
+	muxMaxFramePayload = 16 << 10
+	// muxInitialWindow is how many bytes can be in flight for each stream
+	muxInitialWindow = 256 << 10
+)
+
+// muxWeights is how many frames each priority gets to send in every round,
+// lower priorities still make progress under load but at a lower rate
+var muxWeights = [priorityCount]int{
+	PriorityHigh:   8,
+	PriorityNormal: 4,
+	PriorityLow:    1,
+}
+
+type (
+	mux struct {
+		writer  io.Writer
+		mutex   sync.Mutex
+		cond    *sync.Cond
+		control []*muxFrame
+		streams [priorityCount]*muxStream
+		quotas  [priorityCount]int
+		closed  bool
+		err     error
+	}
+	muxStream struct {
+		queue  []*muxFrame
+		window int
+		// received is the number of bytes we have received and not yet
+		// granted back to the remote
+		received int
+	}
+	muxFrame struct {
+		stream  Priority
+		flags   byte
+		payload []byte
+		// done is only set for the last frame of an object
+		done chan error
+	}
+	muxDecoder struct {
+		mux     *mux
+		reader  *bufio.Reader
+		partial [priorityCount][]byte
+		// unconsumed is the number of bytes of each stream's partial object
+		// that have not been granted back yet
+		unconsumed [priorityCount]int
+		// consumed is the number of bytes of the objects returned to the
+		// caller, they are granted back when the next object is requested
+		consumed [priorityCount]int
+		// the decoder is created after the json handshake, which the json
+		// encoder terminates with a newline that still needs to be read
+		skipNewline bool
+	}
+)
+
+func newMux(w io.Writer) *mux {
+	m := &mux{
+		writer: w,
+	}
+	m.cond = sync.NewCond(&m.mutex)
+	for i := range m.streams {
+		m.streams[i] = &muxStream{
+			window: muxInitialWindow,
+		}
+	}
+	go m.writeLoop()
+	return m
+}
+
+// Encode writes the object with normal priority
+func (m *mux) Encode(v interface{}) error {
+	o, ok := v.(*object.Object)
+	if !ok {
+		return fmt.Errorf("binary format can only encode objects, got %T", v)
+	}
+	return m.write(context.Background(), o, PriorityNormal)
+}
+
+// write queues the object's frames and waits for them to be written, if the
+// context is done before any of them have been written they are discarded
+func (m *mux) write(
+	ctx context.Context,
+	o *object.Object,
+	priority Priority,
+) error {
+	if priority < 0 || priority >= priorityCount {
+		priority = PriorityNormal
+	}
+	body, err := encodeBinaryObject(o)
+	if err != nil {
+		return err
+	}
+	frames := []*muxFrame{}
+	for {
+		n := len(body)
+		if n > muxMaxFramePayload {
+			n = muxMaxFramePayload
+		}
+		frames = append(frames, &muxFrame{
+			stream:  priority,
+			payload: body[:n],
+		})
+		body = body[n:]
+		if len(body) == 0 {
+			break
+		}
+	}
+	last := frames[len(frames)-1]
+	last.flags = muxFlagEnd
+	last.done = make(chan error, 1)
+
+	s := m.streams[priority]
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return m.err
+	}
+	s.queue = append(s.queue, frames...)
+	m.cond.Broadcast()
+	m.mutex.Unlock()
+
+	select {
+	case err := <-last.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	m.mutex.Lock()
+	for i, f := range s.queue {
+		if f == frames[0] {
+			s.queue = append(s.queue[:i], s.queue[i+len(frames):]...)
+			m.mutex.Unlock()
+			return ctx.Err()
+		}
+	}
+	m.mutex.Unlock()
+
+	// some of the frames have already been written, so we have to let the
+	// rest go through as well
+	return <-last.done
+}
+
+func (m *mux) writeLoop() {
+	for {
+		m.mutex.Lock()
+		f := m.next()
+		for f == nil && !m.closed {
+			m.cond.Wait()
+			f = m.next()
+		}
+		if f == nil {
+			m.mutex.Unlock()
+			return
+		}
+		m.mutex.Unlock()
+
+		frame := binary.AppendUvarint(nil, uint64(f.stream))
+		frame = append(frame, f.flags)
+		frame = binary.AppendUvarint(frame, uint64(len(f.payload)))
+		frame = append(frame, f.payload...)
+		_, err := m.writer.Write(frame)
+		if err != nil {
+			m.close(err)
+		}
+		if f.done != nil {
+			f.done <- err
+		}
+	}
+}
+
+// next returns the next frame that should be written, window updates go
+// first and then streams are picked based on their weights; must be called
+// with the mutex held
+func (m *mux) next() *muxFrame {
+	if m.closed {
+		return nil
+	}
+	if len(m.control) > 0 {
+		f := m.control[0]
+		m.control = m.control[1:]
+		return f
+	}
+	for refilled := false; ; refilled = true {
+		ready := false
+		for p, s := range m.streams {
+			if len(s.queue) == 0 || s.window < len(s.queue[0].payload) {
+				continue
+			}
+			ready = true
+			if m.quotas[p] == 0 {
+				continue
+			}
+			f := s.queue[0]
+			s.queue = s.queue[1:]
+			s.window -= len(f.payload)
+			m.quotas[p]--
+			return f
+		}
+		if !ready || refilled {
+			return nil
+		}
+		m.quotas = muxWeights
+	}
+}
+
+// received is called with the bytes of the data frames that have been
+// consumed and grants the remote more window once a quarter of it has been
+// used
+func (m *mux) received(p Priority, n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.streams[p]
+	s.received += n
+	if s.received < muxInitialWindow/4 {
+		return
+	}
+	m.control = append(m.control, &muxFrame{
+		stream:  p,
+		flags:   muxFlagWindow,
+		payload: binary.AppendUvarint(nil, uint64(s.received)),
+	})
+	s.received = 0
+	m.cond.Broadcast()
+}
+
+func (m *mux) grant(p Priority, n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.streams[p].window += n
+	m.cond.Broadcast()
+}
+
+func (m *mux) close(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return
+	}
+	if err == nil {
+		err = ErrConnectionClosed
+	}
+	m.closed = true
+	m.err = err
+	for _, s := range m.streams {
+		for _, f := range s.queue {
+			if f.done != nil {
+				f.done <- err
+			}
+		}
+		s.queue = nil
+	}
+	m.cond.Broadcast()
+}
+
+func (m *mux) decoder(r io.Reader) *muxDecoder {
+	return &muxDecoder{
+		mux:         m,
+		reader:      bufio.NewReader(r),
+		skipNewline: true,
+	}
+}
+
+// Decode reads frames until an object has been completed
+func (d *muxDecoder) Decode(v interface{}) error {
+	o, ok := v.(*object.Object)
+	if !ok {
+		return fmt.Errorf("binary format can only decode objects, got %T", v)
+	}
+	if d.skipNewline {
+		d.skipNewline = false
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != '\n' {
+			d.reader.UnreadByte() // nolint: errcheck
+		}
+	}
+	// the caller is done with the previous objects
+	for p, n := range d.consumed {
+		if n > 0 {
+			d.mux.received(Priority(p), n)
+			d.consumed[p] = 0
+		}
+	}
+	for {
+		stream, err := binary.ReadUvarint(d.reader)
+		if err != nil {
+			return err
+		}
+		if stream >= uint64(priorityCount) {
+			return fmt.Errorf("invalid stream %d", stream)
+		}
+		p := Priority(stream)
+		flags, err := d.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		size, err := binary.ReadUvarint(d.reader)
+		if err != nil {
+			return err
+		}
+		if size > muxMaxFramePayload {
+			return fmt.Errorf("frame of %d bytes exceeds maximum size", size)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(d.reader, payload); err != nil {
+			return err
+		}
+		if flags&muxFlagWindow != 0 {
+			n, err := binary.ReadUvarint(bytes.NewReader(payload))
+			if err != nil {
+				return err
+			}
+			d.mux.grant(p, int(n))
+			continue
+		}
+		d.partial[p] = append(d.partial[p], payload...)
+		if len(d.partial[p]) > maxBinaryObjectSize {
+			return fmt.Errorf("object exceeds maximum size")
+		}
+		d.unconsumed[p] += len(payload)
+		if flags&muxFlagEnd == 0 {
+			if d.unconsumed[p] >= muxInitialWindow/2 {
+				d.mux.received(p, d.unconsumed[p])
+				d.unconsumed[p] = 0
+			}
+			continue
+		}
+		body := d.partial[p]
+		d.partial[p] = nil
+		d.consumed[p] += d.unconsumed[p]
+		d.unconsumed[p] = 0
+		return decodeBinaryObject(body, o)
+	}
+}
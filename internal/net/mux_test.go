@@ -0,0 +1,220 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+// frameRecorder keeps the stream of every frame written to it
+type frameRecorder struct {
+	mutex   sync.Mutex
+	streams []Priority
+	bytes   int
+	delay   time.Duration
+}
+
+func (r *frameRecorder) Write(b []byte) (int, error) {
+	time.Sleep(r.delay)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// streams are always written as a single byte uvarint
+	r.streams = append(r.streams, Priority(b[0]))
+	r.bytes += len(b)
+	return len(b), nil
+}
+
+func (r *frameRecorder) written() ([]Priority, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Priority{}, r.streams...), r.bytes
+}
+
+func newLargeTestObject(size int) *object.Object {
+	return &object.Object{
+		Type: "large",
+		Data: tilde.Map{
+			"data": tilde.Data(bytes.Repeat([]byte{1}, size)),
+		},
+	}
+}
+
+func TestMux_Priority(t *testing.T) {
+	w := &frameRecorder{
+		delay: time.Millisecond,
+	}
+	m := newMux(w)
+	defer m.close(nil)
+
+	lowDone := make(chan error)
+	go func() {
+		lowDone <- m.write(
+			context.New(),
+			newLargeTestObject(200<<10),
+			PriorityLow,
+		)
+	}()
+
+	// wait for the large object to start being written
+	require.Eventually(t, func() bool {
+		streams, _ := w.written()
+		return len(streams) > 0
+	}, time.Second, time.Millisecond)
+
+	err := m.write(context.New(), newTestObject("foo"), PriorityHigh)
+	require.NoError(t, err)
+	require.NoError(t, <-lowDone)
+
+	// the high priority object should have overtaken the low priority one
+	streams, _ := w.written()
+	require.Greater(t, len(streams), 2)
+	assert.Equal(t, PriorityLow, streams[0])
+	assert.Equal(t, PriorityLow, streams[len(streams)-1])
+	assert.Contains(t, streams, PriorityHigh)
+}
+
+func TestMux_FlowControl(t *testing.T) {
+	w := &frameRecorder{}
+	m := newMux(w)
+	defer m.close(nil)
+
+	done := make(chan error)
+	go func() {
+		done <- m.write(
+			context.New(),
+			newLargeTestObject(muxInitialWindow*2),
+			PriorityNormal,
+		)
+	}()
+
+	// without the remote granting us more window, we should stop writing
+	// once we have used our initial one
+	time.Sleep(100 * time.Millisecond)
+	_, n := w.written()
+	assert.Less(t, n, muxInitialWindow+muxMaxFramePayload)
+	select {
+	case <-done:
+		t.Fatal("object should not have been written yet")
+	default:
+	}
+
+	// other streams have their own window
+	err := m.write(context.New(), newTestObject("foo"), PriorityHigh)
+	require.NoError(t, err)
+
+	m.grant(PriorityNormal, muxInitialWindow*2)
+	require.NoError(t, <-done)
+}
+
+func TestMux_Cancel(t *testing.T) {
+	w := &frameRecorder{}
+	m := newMux(w)
+	defer m.close(nil)
+
+	// use up the window so that the next object cannot be written
+	go m.write( // nolint: errcheck
+		context.New(),
+		newLargeTestObject(muxInitialWindow*2),
+		PriorityNormal,
+	)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx := context.New(
+		context.WithTimeout(100 * time.Millisecond),
+	)
+	err := m.write(ctx, newTestObject("foo"), PriorityNormal)
+	require.Error(t, err)
+	require.Equal(t, ctx.Err(), err)
+}
+
+func TestMux_Connection(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	m1 := newMux(w1)
+	m2 := newMux(w2)
+	defer m1.close(nil)
+	defer m2.close(nil)
+
+	// m1 needs to be reading in order to receive the window updates
+	d1 := m1.decoder(r2)
+	go func() {
+		for {
+			if err := d1.Decode(&object.Object{}); err != nil {
+				return
+			}
+		}
+	}()
+
+	large := newLargeTestObject(1 << 20)
+	small := newTestObject("foo")
+	go m1.write(context.New(), large, PriorityLow) // nolint: errcheck
+	time.Sleep(10 * time.Millisecond)
+	go m1.write(context.New(), small, PriorityHigh) // nolint: errcheck
+
+	// the small object should arrive first, even though it was sent last
+	d2 := m2.decoder(r1)
+	got := &object.Object{}
+	require.NoError(t, d2.Decode(got))
+	assert.Equal(t, small, got)
+	got = &object.Object{}
+	require.NoError(t, d2.Decode(got))
+	assert.Equal(t, large, got)
+}
+
+// lockedBuffer is a buffer that can be written to by the mux's write loop
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte{}, b.buffer.Bytes()...)
+}
+
+func TestMux_GrantOnConsume(t *testing.T) {
+	w1 := &lockedBuffer{}
+	m1 := newMux(w1)
+	defer m1.close(nil)
+
+	// large enough to need a window update, small enough to fit in the window
+	o := newLargeTestObject(muxInitialWindow * 3 / 8)
+	require.NoError(t, m1.write(context.New(), o, PriorityNormal))
+
+	w2 := &frameRecorder{}
+	m2 := newMux(w2)
+	defer m2.close(nil)
+
+	d2 := m2.decoder(bytes.NewReader(w1.Bytes()))
+	d2.skipNewline = false
+	got := &object.Object{}
+	require.NoError(t, d2.Decode(got))
+	assert.Equal(t, o, got)
+
+	// the object has not been consumed until the next one is requested
+	time.Sleep(10 * time.Millisecond)
+	streams, _ := w2.written()
+	assert.Empty(t, streams)
+
+	require.ErrorIs(t, d2.Decode(&object.Object{}), io.EOF)
+	require.Eventually(t, func() bool {
+		streams, _ := w2.written()
+		return len(streams) == 1
+	}, time.Second, time.Millisecond)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,10 +17,9 @@ import (
 
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/errors"
 	"nimona.io/pkg/log"
-	"nimona.io/pkg/object"
 	"nimona.io/pkg/peer"
-	"nimona.io/pkg/tilde"
 )
 
 //go:generate genny -in=$GENERATORS/pubsub/pubsub.go -out=pubsub_objects_generated.go -pkg=net gen "ObjectType=*object.Object Name=Object name=object"
@@ -61,6 +61,29 @@ var (
 			Help: "Total number of failed dials due to all addresses blocked",
 		},
 	)
+	connPunchAttemptCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_net_punch_attempt_total",
+			Help: "Total number of hole punching attempts",
+		},
+	)
+	connPunchSuccessCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_net_punch_success_total",
+			Help: "Total number of successful hole punching attempts",
+		},
+	)
+	connPunchErrorCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_net_punch_failed_total",
+			Help: "Total number of failed hole punching attempts",
+		},
+	)
+)
+
+const (
+	// punchInterval is how long to wait between dial attempts while punching
+	punchInterval = 100 * time.Millisecond
 )
 
 type (
@@ -75,10 +98,22 @@ type (
 			bindAddress string,
 			listenConfig *ListenConfig,
 		) (Listener, error)
+		Punch(
+			ctx context.Context,
+			peer *peer.ConnectionInfo,
+		) (Connection, error)
+		GetConnection(
+			publicKey crypto.PublicKey,
+		) (Connection, error)
+		BlockPeer(
+			publicKey crypto.PublicKey,
+			duration time.Duration,
+		)
 		RegisterConnectionHandler(
 			handler ConnectionHandler,
 		)
 		Addresses() []string
+		ObjectFormats() []string
 	}
 	ConnectionHandler func(Connection)
 )
@@ -86,6 +121,7 @@ type (
 // New creates a new p2p network
 func New(
 	peerKey crypto.PrivateKey,
+	opts ...Option,
 ) Network {
 	n := &network{
 		peerKey: peerKey,
@@ -93,7 +129,11 @@ func New(
 			"tcps": &tcpTransport{
 				peerKey: peerKey,
 			},
+			"quic": &quicTransport{
+				peerKey: peerKey,
+			},
 		},
+		objectFormats:    defaultObjectFormats,
 		listeners:        []*listener{},
 		blocklist:        cache.New(time.Second*5, time.Second*60),
 		connections:      map[string]*connection{},
@@ -101,16 +141,20 @@ func New(
 		connHandlers:     []ConnectionHandler{},
 		connHandlerMutex: sync.RWMutex{},
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
 	return n
 }
 
 // network allows dialing and listening for p2p connections
 type network struct {
-	peerKey    crypto.PrivateKey
-	transports map[string]Transport
-	listeners  []*listener
-	attempts   attemptsMap
-	blocklist  *cache.Cache
+	peerKey       crypto.PrivateKey
+	transports    map[string]Transport
+	objectFormats []string
+	listeners     []*listener
+	attempts      attemptsMap
+	blocklist     *cache.Cache
 
 	// connections
 	connections      map[string]*connection
@@ -138,15 +182,13 @@ func (n *network) Dial(
 		return nil, fmt.Errorf("failed to get public key from did: %w", err)
 	}
 
-	n.connectionsMutex.RLock()
-	conn, ok := n.connections[pubKey.String()]
-	if ok {
-		if !conn.IsClosed() {
-			n.connectionsMutex.RUnlock()
-			return conn, nil
-		}
+	if n.isPeerBlocked(*pubKey) {
+		return nil, ErrPeerBlocked
+	}
+
+	if conn := n.getConnection(*pubKey); conn != nil {
+		return conn, nil
 	}
-	n.connectionsMutex.RUnlock()
 
 	if len(p.Addresses) == 0 {
 		return nil, ErrNoAddresses
@@ -159,8 +201,9 @@ func (n *network) Dial(
 	// an ErrAllAddressesBlocked error
 	allBlocked := true
 
-	// go through all addresses and try to dial them
-	for _, address := range p.Addresses {
+	// go through all addresses and try to dial them, starting with the ones
+	// using our preferred transports
+	for _, address := range n.sortAddresses(p.Addresses) {
 		// check if address is currently blocked
 		if n.isAddressBlocked(*pubKey, address) {
 			logger.Debug("address is blocked, skipping")
@@ -168,8 +211,7 @@ func (n *network) Dial(
 		}
 		// get protocol from address
 		addressType := strings.Split(address, ":")[0]
-		trsp, ok := n.transports[addressType]
-		if !ok {
+		if _, ok := n.transports[addressType]; !ok {
 			logger.Debug("not sure how to dial",
 				log.String("type", addressType),
 			)
@@ -180,7 +222,7 @@ func (n *network) Dial(
 		allBlocked = false
 
 		// dial address
-		conn, err := trsp.Dial(ctx, address)
+		conn, err := n.dialAddress(ctx, *pubKey, address, p.ObjectFormats)
 		if err != nil {
 			// blocking address
 			attempts, backoff := n.blockAddress(
@@ -196,35 +238,6 @@ func (n *network) Dial(
 			continue
 		}
 
-		// check negotiated key against dialed
-		if !conn.remotePeerKey.Equals(*pubKey) {
-			n.blockAddress(
-				*pubKey,
-				address,
-			)
-			logger.Error("remote didn't match expect key, blocking",
-				log.String("expected", pubKey.String()),
-				log.String("received", conn.remotePeerKey.String()),
-			)
-			continue
-		}
-
-		// try to write something
-		ping := &object.Object{
-			Type: "ping",
-			Data: tilde.Map{
-				"dt": tilde.String(time.Now().Format(time.RFC3339)),
-			},
-		}
-		if err := conn.Write(ctx, ping); err != nil {
-			n.blockAddress(
-				*pubKey,
-				address,
-			)
-			logger.Error("could not actually write to remote, blocking")
-			continue
-		}
-
 		// at this point we consider the connection successful, so we can
 		// reset the failed attempts
 		n.attempts.Put(address, 0)
@@ -250,6 +263,163 @@ func (n *network) Dial(
 	return nil, err
 }
 
+// dialAddress dials a single address, makes sure that the remote is the peer
+// we expected, and lets it know which object format we will be using
+func (n *network) dialAddress(
+	ctx context.Context,
+	pubKey crypto.PublicKey,
+	address string,
+	objectFormats []string,
+) (*connection, error) {
+	addressType := strings.Split(address, ":")[0]
+	trsp, ok := n.transports[addressType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transport %s", addressType)
+	}
+
+	conn, err := trsp.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// check negotiated key against dialed
+	if !conn.remotePeerKey.Equals(pubKey) {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf(
+			"remote didn't match expected key, received %s",
+			conn.remotePeerKey.String(),
+		)
+	}
+
+	// try to write something, this is also where we let the remote know
+	// which object format we will be using
+	conn.objectFormats = n.objectFormats
+	format := negotiateObjectFormat(n.objectFormats, objectFormats)
+	if err := conn.writeHandshake(format); err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("could not actually write to remote: %w", err)
+	}
+
+	return conn, nil
+}
+
+// Punch establishes a direct connection to a peer that might be behind a nat
+// by repeatedly dialing its addresses, usually the ones a relay has observed,
+// until the context is done.
+// The remote peer is expected to be doing the same for our addresses at the
+// same time; our outgoing attempts open our nat for its incoming ones and
+// vice versa, so an incoming connection from the peer also ends the punch.
+// Blocked addresses are dialed as well and are unblocked on success.
+func (n *network) Punch(
+	ctx context.Context,
+	p *peer.ConnectionInfo,
+) (Connection, error) {
+	pubKey, err := crypto.PublicKeyFromDID(p.Metadata.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key from did: %w", err)
+	}
+
+	if n.isPeerBlocked(*pubKey) {
+		return nil, ErrPeerBlocked
+	}
+
+	if conn := n.getConnection(*pubKey); conn != nil {
+		return conn, nil
+	}
+
+	if len(p.Addresses) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	logger := log.FromContext(ctx).With(
+		log.String("peer", p.Metadata.Owner.String()),
+		log.Strings("addresses", p.Addresses),
+	)
+	logger.Debug("punching")
+	connPunchAttemptCounter.Inc()
+
+	ctx = context.New(
+		context.WithParent(ctx),
+	)
+	defer ctx.Cancel()
+
+	conns := make(chan *connection)
+	for _, address := range p.Addresses {
+		go func(address string) {
+			for {
+				conn, err := n.dialAddress(
+					ctx,
+					*pubKey,
+					address,
+					p.ObjectFormats,
+				)
+				if err == nil {
+					select {
+					case conns <- conn:
+					case <-ctx.Done():
+						// the remote might already be using this connection
+						// so closing it is not an option
+						n.handleNewConnection(conn)
+					}
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(punchInterval):
+				}
+			}
+		}(address)
+	}
+
+	ticker := time.NewTicker(punchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case conn := <-conns:
+			for _, address := range p.Addresses {
+				n.unblockAddress(*pubKey, address)
+			}
+			connPunchSuccessCounter.Inc()
+			connConnOutCounter.Inc()
+			n.handleNewConnection(conn)
+			return conn, nil
+		case <-ticker.C:
+			if conn := n.getConnection(*pubKey); conn != nil {
+				connPunchSuccessCounter.Inc()
+				return conn, nil
+			}
+		case <-ctx.Done():
+			connPunchErrorCounter.Inc()
+			logger.Debug("could not punch peer")
+			return nil, errors.Merge(ErrAllAddressesFailed, ctx.Err())
+		}
+	}
+}
+
+// GetConnection returns the open connection to the given peer, regardless of
+// which side initiated it
+func (n *network) GetConnection(
+	publicKey crypto.PublicKey,
+) (Connection, error) {
+	conn := n.getConnection(publicKey)
+	if conn == nil {
+		return nil, ErrNoConnection
+	}
+	return conn, nil
+}
+
+func (n *network) getConnection(publicKey crypto.PublicKey) *connection {
+	n.connectionsMutex.RLock()
+	defer n.connectionsMutex.RUnlock()
+	conn, ok := n.connections[publicKey.String()]
+	if !ok || conn.IsClosed() {
+		return nil
+	}
+	return conn
+}
+
 func (n *network) isAddressBlocked(
 	publicKey crypto.PublicKey,
 	address string,
@@ -276,6 +446,32 @@ func (n *network) blockAddress(
 	return attempts, time.Duration(backoff)
 }
 
+func (n *network) unblockAddress(
+	publicKey crypto.PublicKey,
+	address string,
+) {
+	pk := publicKey.String() + "/" + address
+	n.attempts.Put(pk, 0)
+	n.blocklist.Delete(pk)
+}
+
+// BlockPeer closes any connection to the given peer, and refuses new ones
+// until the given duration has passed
+func (n *network) BlockPeer(
+	publicKey crypto.PublicKey,
+	duration time.Duration,
+) {
+	n.blocklist.Set(publicKey.String(), struct{}{}, duration)
+	if conn := n.getConnection(publicKey); conn != nil {
+		conn.Close() // nolint: errcheck
+	}
+}
+
+func (n *network) isPeerBlocked(publicKey crypto.PublicKey) bool {
+	_, blocked := n.blocklist.Get(publicKey.String())
+	return blocked
+}
+
 // func (n *network) Accept() (*Connection, error) {
 // 	conn := <-n.connections
 // 	return conn, nil
@@ -287,6 +483,41 @@ type ListenConfig struct {
 	BindIPV6    bool
 }
 
+// tlsConn is implemented by raw connections that have been secured using
+// tls, ie tls.Conn and quicConn
+type tlsConn interface {
+	Handshake() error
+	ConnectionState() tls.ConnectionState
+}
+
+// transportPreference lists the transports in the order they should be
+// dialed when a peer advertises more than one of them
+var transportPreference = []string{
+	"mem",
+	"quic",
+	"tcps",
+}
+
+// sortAddresses returns the given addresses ordered by transport preference,
+// addresses of the same transport retain their original order
+func (n *network) sortAddresses(addresses []string) []string {
+	rank := func(address string) int {
+		addressType := strings.Split(address, ":")[0]
+		for i, t := range transportPreference {
+			if t == addressType {
+				return i
+			}
+		}
+		return len(transportPreference)
+	}
+	sorted := make([]string, len(addresses))
+	copy(sorted, addresses)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
+
 // Listen
 // TODO do we need to return a listener?
 func (n *network) Listen(
@@ -302,6 +533,7 @@ func (n *network) Listen(
 	for pt, tsp := range n.transports {
 		lst, err := tsp.Listen(ctx, bindAddress, k)
 		if err != nil {
+			mlst.Close() // nolint: errcheck
 			return nil, err
 		}
 
@@ -319,10 +551,8 @@ func (n *network) Listen(
 		mlst.listeners = append(mlst.listeners, lst)
 		mlst.addresses = append(mlst.addresses, addresses...)
 
-		n.listeners = append(n.listeners, mlst)
-
 		// TODO goroutine never ends
-		go func() {
+		go func(pt string, lst net.Listener) {
 			for {
 				rawConn, err := lst.Accept()
 				if err != nil {
@@ -343,10 +573,11 @@ func (n *network) Listen(
 				}
 
 				conn := newConnection(rawConn, true)
-				conn.remoteAddress = "tcps:" + rawConn.RemoteAddr().String()
+				conn.remoteAddress = pt + ":" + rawConn.RemoteAddr().String()
 				conn.localAddress = rawConn.LocalAddr().String()
+				conn.objectFormats = n.objectFormats
 
-				if tlsConn, ok := rawConn.(*tls.Conn); ok {
+				if tlsConn, ok := rawConn.(tlsConn); ok {
 					if err := tlsConn.Handshake(); err != nil {
 						// not currently supported
 						// TODO find a way to surface this error
@@ -384,8 +615,9 @@ func (n *network) Listen(
 
 				n.handleNewConnection(conn)
 			}
-		}()
+		}(pt, lst)
 	}
+	n.listeners = append(n.listeners, mlst)
 	// block our own addresses, just in case anyone tries to dial them
 	for _, addr := range mlst.addresses {
 		n.blocklist.Set(k.PublicKey().String()+"/"+addr, 0, cache.NoExpiration)
@@ -401,6 +633,12 @@ func (n *network) Addresses() []string {
 	return addrs
 }
 
+// ObjectFormats returns the formats objects can be encoded in when talking to
+// us, in order of preference
+func (n *network) ObjectFormats() []string {
+	return n.objectFormats
+}
+
 func (n *network) RegisterConnectionHandler(
 	handler ConnectionHandler,
 ) {
@@ -410,6 +648,12 @@ func (n *network) RegisterConnectionHandler(
 }
 
 func (n *network) handleNewConnection(conn *connection) {
+	if n.isPeerBlocked(conn.remotePeerKey) {
+		conn.Close() // nolint: errcheck
+		return
+	}
+	// start reading objects
+	go conn.readLoop()
 	// add connection to list of connections
 	n.connectionsMutex.Lock()
 	n.connections[conn.remotePeerKey.String()] = conn
@@ -2,6 +2,7 @@ package net
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -168,6 +169,87 @@ func TestNetDialBackoff(t *testing.T) {
 	assert.Equal(t, ErrAllAddressesFailed, err)
 }
 
+func TestNetConnectionQUIC(t *testing.T) {
+	ctx := context.New()
+
+	n1 := newPeer(t)
+	n2 := newPeer(t)
+
+	_, err := n1.Listen(ctx, "127.0.0.1:0", &ListenConfig{
+		BindLocal: true,
+	})
+	require.NoError(t, err)
+
+	scs := make(chan Connection, 1)
+	n1.RegisterConnectionHandler(func(c Connection) {
+		scs <- c
+	})
+
+	// keep only the quic addresses
+	addresses := []string{}
+	for _, address := range n1.Addresses() {
+		if strings.HasPrefix(address, "quic:") {
+			addresses = append(addresses, address)
+		}
+	}
+	require.NotEmpty(t, addresses)
+
+	cconn, err := n2.Dial(ctx, &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.peerKey.PublicKey().DID(),
+		},
+		Addresses: addresses,
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(cconn.RemoteAddr(), "quic:"))
+	require.True(t, cconn.RemotePeerKey().Equals(n1.peerKey.PublicKey()))
+
+	var sc Connection
+	select {
+	case sc = <-scs:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for connection")
+	}
+	require.True(t, strings.HasPrefix(sc.RemoteAddr(), "quic:"))
+	require.True(t, sc.RemotePeerKey().Equals(n2.peerKey.PublicKey()))
+
+	scReader := sc.Read(ctx)
+
+	gotObj, err := scReader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "ping", gotObj.Type)
+
+	resObj := &object.Object{
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+	err = cconn.Write(ctx, resObj)
+	require.NoError(t, err)
+
+	gotObj, err = scReader.Read()
+	require.NoError(t, err)
+	require.EqualValues(t, resObj, gotObj)
+}
+
+func TestNetSortAddresses(t *testing.T) {
+	n := newPeer(t)
+	got := n.sortAddresses([]string{
+		"tcps:1.1.1.1:1",
+		"foo:1.1.1.1:1",
+		"quic:1.1.1.1:1",
+		"tcps:2.2.2.2:2",
+		"quic:2.2.2.2:2",
+	})
+	assert.Equal(t, []string{
+		"quic:1.1.1.1:1",
+		"quic:2.2.2.2:2",
+		"tcps:1.1.1.1:1",
+		"tcps:2.2.2.2:2",
+		"foo:1.1.1.1:1",
+	}, got)
+}
+
 func newPeer(t *testing.T) *network {
 	pk, err := crypto.NewEd25519PrivateKey()
 	assert.NoError(t, err)
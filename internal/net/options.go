@@ -0,0 +1,53 @@
+package net
+
+type (
+	Option       func(*network)
+	WriteOption  func(*writeOptions)
+	writeOptions struct {
+		priority Priority
+	}
+)
+
+// WithMemoryTransport registers an in-process transport that uses the given
+// switchboard to route connections, its addresses are in the "mem:<name>"
+// format.
+func WithMemoryTransport(sb *Switchboard) Option {
+	return func(n *network) {
+		n.transports["mem"] = &memTransport{
+			peerKey:     n.peerKey,
+			switchboard: sb,
+		}
+	}
+}
+
+// WithoutTransports removes the given transports, ie "tcps" or "quic", so
+// that the network neither dials nor listens using them.
+func WithoutTransports(transports ...string) Option {
+	return func(n *network) {
+		for _, t := range transports {
+			delete(n.transports, t)
+		}
+	}
+}
+
+// WithObjectFormats overrides the formats objects can be encoded in, json
+// will always be supported even if not included.
+func WithObjectFormats(formats ...string) Option {
+	return func(n *network) {
+		n.objectFormats = []string{}
+		for _, f := range formats {
+			if f != ObjectFormatJSON {
+				n.objectFormats = append(n.objectFormats, f)
+			}
+		}
+		n.objectFormats = append(n.objectFormats, ObjectFormatJSON)
+	}
+}
+
+// WriteWithPriority sets the priority of the object being written, it is only
+// taken into account by connections using the binary object format
+func WriteWithPriority(p Priority) WriteOption {
+	return func(o *writeOptions) {
+		o.priority = p
+	}
+}
@@ -0,0 +1,208 @@
+package net
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"nimona.io/pkg/crypto"
+)
+
+type (
+	// Switchboard routes the connections of the in-memory transport, and
+	// allows tests to control the conditions of the links between peers.
+	// All randomness is derived from the seed given when constructing it, so
+	// that the same sequence of writes over a link will always be dropped or
+	// reordered in the same way.
+	Switchboard struct {
+		mutex      sync.Mutex
+		random     *rand.Rand
+		names      int
+		listeners  map[string]*memListener
+		conditions map[string]LinkConditions
+		defaults   LinkConditions
+		partitions map[string]int
+		nats       map[string]bool
+		mappings   map[string]time.Time
+	}
+	// LinkConditions describe how messages are delivered between two peers
+	LinkConditions struct {
+		// Latency is added to the delivery of every message
+		Latency time.Duration
+		// DropRate is the probability, between 0 and 1, of a message being
+		// silently dropped
+		DropRate float64
+		// ReorderRate is the probability, between 0 and 1, of a message being
+		// held back for ReorderDelay, allowing the ones after it to overtake it
+		ReorderRate  float64
+		ReorderDelay time.Duration
+	}
+)
+
+// NewSwitchboard constructs a new switchboard with no latency, drops,
+// reordering, or partitions
+func NewSwitchboard(seed int64) *Switchboard {
+	return &Switchboard{
+		random:     rand.New(rand.NewSource(seed)), // nolint: gosec
+		listeners:  map[string]*memListener{},
+		conditions: map[string]LinkConditions{},
+		partitions: map[string]int{},
+		nats:       map[string]bool{},
+		mappings:   map[string]time.Time{},
+	}
+}
+
+// natMappingTimeout is how long a peer behind a nat remains reachable by
+// another peer after having tried to connect to it
+const natMappingTimeout = 30 * time.Second
+
+// SetDefaultLinkConditions sets the conditions for all links that have not
+// been given their own using SetLinkConditions
+func (sb *Switchboard) SetDefaultLinkConditions(c LinkConditions) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.defaults = c
+}
+
+// SetLinkConditions sets the conditions for messages sent in either direction
+// between the two given peers
+func (sb *Switchboard) SetLinkConditions(
+	a crypto.PublicKey,
+	b crypto.PublicKey,
+	c LinkConditions,
+) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.conditions[linkKey(a, b)] = c
+}
+
+// Partition splits the given peers into groups, peers can only dial or send
+// messages to peers in their own group; messages already in flight are still
+// delivered.
+// Peers that are not part of any group are not affected.
+func (sb *Switchboard) Partition(groups ...[]crypto.PublicKey) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.partitions = map[string]int{}
+	for i, group := range groups {
+		for _, k := range group {
+			sb.partitions[k.String()] = i
+		}
+	}
+}
+
+// Heal removes all partitions
+func (sb *Switchboard) Heal() {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.partitions = map[string]int{}
+}
+
+// SetBehindNAT places the given peer behind a nat, or removes it from one.
+// Peers behind a nat can only be connected to by peers they have recently
+// tried to connect to themselves, so two such peers need to dial each other
+// at about the same time in order to establish a connection.
+func (sb *Switchboard) SetBehindNAT(k crypto.PublicKey, behind bool) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.nats[k.String()] = behind
+}
+
+func (sb *Switchboard) isPartitioned(a, b crypto.PublicKey) bool {
+	ga, oka := sb.partitions[a.String()]
+	gb, okb := sb.partitions[b.String()]
+	return oka && okb && ga != gb
+}
+
+// route decides the fate of a single message sent from one peer to another,
+// returning whether it should be dropped or the delay before delivering it
+func (sb *Switchboard) route(
+	from crypto.PublicKey,
+	to crypto.PublicKey,
+) (drop bool, delay time.Duration) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	if sb.isPartitioned(from, to) {
+		return true, 0
+	}
+	c, ok := sb.conditions[linkKey(from, to)]
+	if !ok {
+		c = sb.defaults
+	}
+	if c.DropRate > 0 && sb.random.Float64() < c.DropRate {
+		return true, 0
+	}
+	delay = c.Latency
+	if c.ReorderRate > 0 && sb.random.Float64() < c.ReorderRate {
+		delay += c.ReorderDelay
+	}
+	return false, delay
+}
+
+func (sb *Switchboard) register(name string, lst *memListener) (string, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	if name == "" {
+		for {
+			sb.names++
+			name = fmt.Sprintf("peer-%d", sb.names)
+			if _, ok := sb.listeners[name]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := sb.listeners[name]; ok {
+		return "", fmt.Errorf("mem address %s already in use", name)
+	}
+	sb.listeners[name] = lst
+	return name, nil
+}
+
+func (sb *Switchboard) unregister(name string) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	delete(sb.listeners, name)
+}
+
+// connect finds the listener for the given name, if the dialing peer is
+// not listening itself it is given a new name to use as its local address
+func (sb *Switchboard) connect(
+	from crypto.PublicKey,
+	localName string,
+	name string,
+) (*memListener, string, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	lst, ok := sb.listeners[name]
+	if !ok {
+		return nil, "", fmt.Errorf("mem address %s not found", name)
+	}
+	to := lst.key.PublicKey()
+	// even attempts that fail open the dialer's nat for the remote peer
+	if sb.nats[from.String()] {
+		sb.mappings[from.String()+"/"+to.String()] = time.Now()
+	}
+	if sb.isPartitioned(from, to) {
+		return nil, "", fmt.Errorf("mem address %s unreachable", name)
+	}
+	if sb.nats[to.String()] {
+		t, ok := sb.mappings[to.String()+"/"+from.String()]
+		if !ok || time.Since(t) > natMappingTimeout {
+			return nil, "", fmt.Errorf("mem address %s is behind a nat", name)
+		}
+	}
+	if localName == "" {
+		sb.names++
+		localName = fmt.Sprintf("dialer-%d", sb.names)
+	}
+	return lst, localName, nil
+}
+
+func linkKey(a, b crypto.PublicKey) string {
+	ka, kb := a.String(), b.String()
+	if ka > kb {
+		ka, kb = kb, ka
+	}
+	return ka + "/" + kb
+}
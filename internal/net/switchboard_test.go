@@ -0,0 +1,376 @@
+package net
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+	"nimona.io/pkg/tilde"
+)
+
+func TestSwitchboard_Connection(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "mem:n1")
+	n2 := newMemPeer(t, sb, "")
+
+	require.Equal(t, []string{"mem:n1"}, n1.Addresses())
+	require.Equal(t, []string{"mem:peer-1"}, n2.Addresses())
+
+	// only one listener can use the same name
+	_, err := New(
+		n1.peerKey,
+		WithMemoryTransport(sb),
+		WithoutTransports("tcps", "quic"),
+	).Listen(context.New(), "mem:n1", nil)
+	require.Error(t, err)
+
+	c, r := dialMemPeer(t, n2, n1)
+	assert.True(t, strings.HasPrefix(c.RemoteAddr(), "mem:"))
+	assert.Equal(t, n1.peerKey.PublicKey(), c.RemotePeerKey())
+
+	obj := newTestObject("foo")
+	require.NoError(t, c.Write(context.New(), obj))
+	requireObjects(t, r, obj)
+}
+
+func TestSwitchboard_Partition(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+	n3 := newMemPeer(t, sb, "")
+
+	c, r := dialMemPeer(t, n2, n1)
+
+	sb.Partition(
+		[]crypto.PublicKey{n1.peerKey.PublicKey()},
+		[]crypto.PublicKey{
+			n2.peerKey.PublicKey(),
+			n3.peerKey.PublicKey(),
+		},
+	)
+
+	// new connections across the partition should fail
+	_, err := n3.Dial(context.New(), &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.peerKey.PublicKey().DID(),
+		},
+		Addresses: n1.Addresses(),
+	})
+	require.ErrorIs(t, err, ErrAllAddressesFailed)
+
+	// and existing connections should drop messages
+	dropped := newTestObject("dropped")
+	require.NoError(t, c.Write(context.New(), dropped))
+
+	sb.Heal()
+
+	delivered := newTestObject("delivered")
+	require.NoError(t, c.Write(context.New(), delivered))
+	requireObjects(t, r, delivered)
+}
+
+func TestSwitchboard_Drop(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	c, r := dialMemPeer(t, n2, n1)
+
+	sb.SetLinkConditions(
+		n1.peerKey.PublicKey(),
+		n2.peerKey.PublicKey(),
+		LinkConditions{
+			DropRate: 1,
+		},
+	)
+	require.NoError(t, c.Write(context.New(), newTestObject("dropped")))
+
+	sb.SetLinkConditions(
+		n1.peerKey.PublicKey(),
+		n2.peerKey.PublicKey(),
+		LinkConditions{},
+	)
+	delivered := newTestObject("delivered")
+	require.NoError(t, c.Write(context.New(), delivered))
+	requireObjects(t, r, delivered)
+}
+
+func TestSwitchboard_Latency(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	c, r := dialMemPeer(t, n2, n1)
+
+	sb.SetDefaultLinkConditions(LinkConditions{
+		Latency: 200 * time.Millisecond,
+	})
+
+	obj1 := newTestObject("1")
+	obj2 := newTestObject("2")
+
+	start := time.Now()
+	require.NoError(t, c.Write(context.New(), obj1))
+	require.NoError(t, c.Write(context.New(), obj2))
+	requireObjects(t, r, obj1, obj2)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestSwitchboard_Reorder(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	c, r := dialMemPeer(t, n2, n1)
+
+	sb.SetDefaultLinkConditions(LinkConditions{
+		ReorderRate:  1,
+		ReorderDelay: 100 * time.Millisecond,
+	})
+	obj1 := newTestObject("1")
+	require.NoError(t, c.Write(context.New(), obj1))
+
+	sb.SetDefaultLinkConditions(LinkConditions{})
+	obj2 := newTestObject("2")
+	require.NoError(t, c.Write(context.New(), obj2))
+
+	requireObjects(t, r, obj2, obj1)
+}
+
+func TestSwitchboard_NAT(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	sb.SetBehindNAT(n1.peerKey.PublicKey(), true)
+	sb.SetBehindNAT(n2.peerKey.PublicKey(), true)
+
+	p1 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.peerKey.PublicKey().DID(),
+		},
+		Addresses: n1.Addresses(),
+	}
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n2.peerKey.PublicKey().DID(),
+		},
+		Addresses: n2.Addresses(),
+	}
+
+	// the first attempt fails, but opens n2's nat for n1
+	_, err := n2.Dial(context.New(), p1)
+	require.ErrorIs(t, err, ErrAllAddressesFailed)
+
+	c, err := n1.Dial(context.New(), p2)
+	require.NoError(t, err)
+	assert.Equal(t, n2.peerKey.PublicKey(), c.RemotePeerKey())
+}
+
+func TestSwitchboard_Punch(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	sb.SetBehindNAT(n1.peerKey.PublicKey(), true)
+	sb.SetBehindNAT(n2.peerKey.PublicKey(), true)
+
+	p1 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.peerKey.PublicKey().DID(),
+		},
+		Addresses: n1.Addresses(),
+	}
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n2.peerKey.PublicKey().DID(),
+		},
+		Addresses: n2.Addresses(),
+	}
+
+	// dialing fails and blocks the address
+	_, err := n1.Dial(context.New(), p2)
+	require.ErrorIs(t, err, ErrAllAddressesFailed)
+
+	// punching from only one side should not succeed
+	ctx := context.New(
+		context.WithTimeout(300 * time.Millisecond),
+	)
+	_, err = n1.Punch(ctx, p2)
+	require.ErrorIs(t, err, ErrAllAddressesFailed)
+
+	// but it should when both sides are punching at the same time
+	type result struct {
+		conn Connection
+		err  error
+	}
+	results := make(chan result, 2)
+	punch := func(n *network, p *peer.ConnectionInfo) {
+		c, err := n.Punch(
+			context.New(context.WithTimeout(time.Second)),
+			p,
+		)
+		results <- result{c, err}
+	}
+	go punch(n1, p2)
+	go punch(n2, p1)
+	for i := 0; i < 2; i++ {
+		r := <-results
+		require.NoError(t, r.err)
+		require.NotNil(t, r.conn)
+	}
+
+	// the connection should now be used when dialing
+	c, err := n1.Dial(context.New(), p2)
+	require.NoError(t, err)
+	assert.Equal(t, n2.peerKey.PublicKey(), c.RemotePeerKey())
+
+	_, err = n1.GetConnection(n2.peerKey.PublicKey())
+	require.NoError(t, err)
+	_, err = n2.GetConnection(n1.peerKey.PublicKey())
+	require.NoError(t, err)
+}
+
+func TestSwitchboard_BlockPeer(t *testing.T) {
+	sb := NewSwitchboard(1)
+	n1 := newMemPeer(t, sb, "")
+	n2 := newMemPeer(t, sb, "")
+
+	c, _ := dialMemPeer(t, n2, n1)
+
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n2.peerKey.PublicKey().DID(),
+		},
+		Addresses: n2.Addresses(),
+	}
+
+	// blocking the peer should close its connection
+	n1.BlockPeer(n2.peerKey.PublicKey(), 200*time.Millisecond)
+	_, err := n1.GetConnection(n2.peerKey.PublicKey())
+	require.ErrorIs(t, err, ErrNoConnection)
+	require.Eventually(t, func() bool {
+		return c.(*connection).IsClosed()
+	}, time.Second, 10*time.Millisecond)
+
+	// and refuse new ones, in either direction
+	_, err = n1.Dial(context.New(), p2)
+	require.ErrorIs(t, err, ErrPeerBlocked)
+	_, err = n2.Dial(context.New(), &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.peerKey.PublicKey().DID(),
+		},
+		Addresses: n1.Addresses(),
+	})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = n1.GetConnection(n2.peerKey.PublicKey())
+	require.ErrorIs(t, err, ErrNoConnection)
+
+	// until the block expires
+	time.Sleep(200 * time.Millisecond)
+	_, err = n1.Dial(context.New(), p2)
+	require.NoError(t, err)
+}
+
+func newMemPeer(
+	t *testing.T,
+	sb *Switchboard,
+	bindAddress string,
+	opts ...Option,
+) *network {
+	pk, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	opts = append(
+		[]Option{
+			WithMemoryTransport(sb),
+			WithoutTransports("tcps", "quic"),
+		},
+		opts...,
+	)
+	n := New(pk, opts...).(*network)
+	lst, err := n.Listen(context.New(), bindAddress, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		lst.Close() // nolint: errcheck
+	})
+	return n
+}
+
+// dialMemPeer dials the remote peer and returns the outgoing connection and
+// a reader for the objects the remote receives
+func dialMemPeer(
+	t *testing.T,
+	local *network,
+	remote *network,
+) (Connection, object.ReadCloser) {
+	scs := make(chan Connection, 1)
+	remote.RegisterConnectionHandler(func(c Connection) {
+		scs <- c
+	})
+
+	c, err := local.Dial(context.New(), &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: remote.peerKey.PublicKey().DID(),
+		},
+		Addresses: remote.Addresses(),
+	})
+	require.NoError(t, err)
+
+	var sc Connection
+	select {
+	case sc = <-scs:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for connection")
+	}
+
+	assert.Equal(t, local.peerKey.PublicKey(), sc.RemotePeerKey())
+
+	return c, sc.Read(context.New())
+}
+
+// requireObjects reads the expected objects in order, skipping pings
+func requireObjects(
+	t *testing.T,
+	r object.ReadCloser,
+	expected ...*object.Object,
+) {
+	for len(expected) > 0 {
+		done := make(chan struct{})
+		var (
+			got *object.Object
+			err error
+		)
+		go func() {
+			got, err = r.Read()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for object")
+		}
+		require.NoError(t, err)
+		if got.Type == "ping" {
+			continue
+		}
+		require.Equal(t, expected[0], got)
+		expected = expected[1:]
+	}
+}
+
+func newTestObject(value string) *object.Object {
+	return &object.Object{
+		Type: "test",
+		Data: tilde.Map{
+			"foo": tilde.String(value),
+		},
+	}
+}
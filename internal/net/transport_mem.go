@@ -0,0 +1,356 @@
+package net
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+)
+
+type (
+	// memTransport connects peers of the same process through a switchboard,
+	// it is meant to be used for tests
+	memTransport struct {
+		peerKey     crypto.PrivateKey
+		switchboard *Switchboard
+		mutex       sync.RWMutex
+		// name of the last listener, outgoing connections use it as their
+		// local address similar to how quic connections share their port
+		name string
+	}
+	memListener struct {
+		switchboard *Switchboard
+		name        string
+		key         crypto.PrivateKey
+		conns       chan net.Conn
+		closer      chan struct{}
+		once        sync.Once
+	}
+	// memConn is one end of an in-memory connection, every Write is
+	// considered a single message that the switchboard can delay or drop
+	memConn struct {
+		switchboard *Switchboard
+		localAddr   memAddr
+		remoteAddr  memAddr
+		localKey    crypto.PublicKey
+		remoteKey   crypto.PublicKey
+		state       tls.ConnectionState
+		reader      *memPipe
+		writer      *memPipe
+		closed      int32
+	}
+	memAddr string
+	// memPipe holds the messages sent in a single direction, messages with a
+	// delay are kept pending until their delivery time
+	memPipe struct {
+		mutex   sync.Mutex
+		cond    *sync.Cond
+		buffer  bytes.Buffer
+		pending []*memMessage
+		wake    chan struct{}
+		closer  chan struct{}
+		closed  bool
+	}
+	memMessage struct {
+		deliverAt time.Time
+		data      []byte
+	}
+)
+
+func (mt *memTransport) Dial(
+	ctx context.Context,
+	address string,
+) (*connection, error) {
+	name := strings.Replace(address, "mem:", "", 1)
+	localKey := mt.peerKey.PublicKey()
+
+	mt.mutex.RLock()
+	localName := mt.name
+	mt.mutex.RUnlock()
+
+	lst, localName, err := mt.switchboard.connect(localKey, localName, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the listening side expects the remote key in the form of a certificate
+	// in order to treat these connections the same as tls ones
+	cert, err := crypto.GenerateTLSCertificate(mt.peerKey)
+	if err != nil {
+		return nil, err
+	}
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	outgoing := newMemPipe()
+	incoming := newMemPipe()
+	remoteKey := lst.key.PublicKey()
+
+	localConn := &memConn{
+		switchboard: mt.switchboard,
+		localAddr:   memAddr(localName),
+		remoteAddr:  memAddr(name),
+		localKey:    localKey,
+		remoteKey:   remoteKey,
+		reader:      incoming,
+		writer:      outgoing,
+	}
+	remoteConn := &memConn{
+		switchboard: mt.switchboard,
+		localAddr:   memAddr(name),
+		remoteAddr:  memAddr(localName),
+		localKey:    remoteKey,
+		remoteKey:   localKey,
+		state: tls.ConnectionState{
+			HandshakeComplete: true,
+			PeerCertificates:  []*x509.Certificate{x509Cert},
+		},
+		reader: outgoing,
+		writer: incoming,
+	}
+
+	select {
+	case lst.conns <- remoteConn:
+	case <-lst.closer:
+		localConn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("mem address %s closed", name)
+	case <-ctx.Done():
+		localConn.Close() // nolint: errcheck
+		return nil, ctx.Err()
+	}
+
+	conn := newConnection(localConn, false)
+	conn.remoteAddress = address
+	conn.localAddress = localConn.localAddr.String()
+	conn.remotePeerKey = remoteKey
+
+	return conn, nil
+}
+
+// Listen registers a new listener on the switchboard; a bind address in the
+// form of "mem:<name>" will be used as is, anything else will be assigned a
+// new unique name.
+func (mt *memTransport) Listen(
+	ctx context.Context,
+	bindAddress string,
+	key crypto.PrivateKey,
+) (net.Listener, error) {
+	name := ""
+	if strings.HasPrefix(bindAddress, "mem:") {
+		name = strings.Replace(bindAddress, "mem:", "", 1)
+	}
+
+	lst := &memListener{
+		switchboard: mt.switchboard,
+		key:         key,
+		conns:       make(chan net.Conn),
+		closer:      make(chan struct{}),
+	}
+
+	name, err := mt.switchboard.register(name, lst)
+	if err != nil {
+		return nil, err
+	}
+
+	lst.name = name
+
+	mt.mutex.Lock()
+	mt.name = name
+	mt.mutex.Unlock()
+
+	return lst, nil
+}
+
+func (ml *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case <-ml.closer:
+		return nil, &net.OpError{
+			Op:   "accept",
+			Net:  "mem",
+			Addr: ml.Addr(),
+			Err:  net.ErrClosed,
+		}
+	}
+}
+
+func (ml *memListener) Close() error {
+	ml.once.Do(func() {
+		close(ml.closer)
+		ml.switchboard.unregister(ml.name)
+	})
+	return nil
+}
+
+func (ml *memListener) Addr() net.Addr {
+	return memAddr(ml.name)
+}
+
+func (mc *memConn) Read(b []byte) (int, error) {
+	return mc.reader.read(b)
+}
+
+func (mc *memConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&mc.closed) == 1 {
+		return 0, io.ErrClosedPipe
+	}
+	drop, delay := mc.switchboard.route(mc.localKey, mc.remoteKey)
+	if drop {
+		return len(b), nil
+	}
+	mc.writer.write(b, delay)
+	return len(b), nil
+}
+
+func (mc *memConn) Close() error {
+	atomic.StoreInt32(&mc.closed, 1)
+	mc.reader.close()
+	mc.writer.close()
+	return nil
+}
+
+func (mc *memConn) LocalAddr() net.Addr {
+	return mc.localAddr
+}
+
+func (mc *memConn) RemoteAddr() net.Addr {
+	return mc.remoteAddr
+}
+
+func (mc *memConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (mc *memConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (mc *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Handshake is a no-op as the switchboard has already exchanged the keys of
+// the two peers.
+func (mc *memConn) Handshake() error {
+	return nil
+}
+
+func (mc *memConn) ConnectionState() tls.ConnectionState {
+	return mc.state
+}
+
+func (ma memAddr) Network() string {
+	return "mem"
+}
+
+func (ma memAddr) String() string {
+	return string(ma)
+}
+
+func newMemPipe() *memPipe {
+	p := &memPipe{
+		wake:   make(chan struct{}, 1),
+		closer: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	go p.deliver()
+	return p
+}
+
+// write queues the message for delivery, similar to tcp connections, writes
+// that happen after the remote end has been closed are silently lost
+func (p *memPipe) write(b []byte, delay time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	if delay <= 0 {
+		p.buffer.Write(b)
+		p.cond.Broadcast()
+		return
+	}
+	m := &memMessage{
+		deliverAt: time.Now().Add(delay),
+		data:      append([]byte{}, b...),
+	}
+	// messages with the same delivery time retain the order they were
+	// written in
+	i := sort.Search(len(p.pending), func(i int) bool {
+		return p.pending[i].deliverAt.After(m.deliverAt)
+	})
+	p.pending = append(p.pending, nil)
+	copy(p.pending[i+1:], p.pending[i:])
+	p.pending[i] = m
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *memPipe) deliver() {
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return
+		}
+		var wait <-chan time.Time
+		if len(p.pending) > 0 {
+			next := p.pending[0]
+			d := time.Until(next.deliverAt)
+			if d <= 0 {
+				p.pending = p.pending[1:]
+				p.buffer.Write(next.data)
+				p.cond.Broadcast()
+				p.mutex.Unlock()
+				continue
+			}
+			wait = time.After(d)
+		}
+		p.mutex.Unlock()
+		select {
+		case <-wait:
+		case <-p.wake:
+		case <-p.closer:
+			return
+		}
+	}
+}
+
+func (p *memPipe) read(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for p.buffer.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buffer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.buffer.Read(b)
+}
+
+func (p *memPipe) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.pending = nil
+	close(p.closer)
+	p.cond.Broadcast()
+}
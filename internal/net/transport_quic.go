@@ -0,0 +1,281 @@
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+)
+
+const (
+	// quicALPN is the application protocol negotiated during the handshake,
+	// quic requires that one is set on both sides.
+	quicALPN = "nimona/1"
+	// quicKeepAlivePeriod is kept well below the usual nat udp mapping
+	// timeouts so that idle connections stay reachable.
+	quicKeepAlivePeriod = 15 * time.Second
+	quicMaxIdleTimeout  = 60 * time.Second
+)
+
+type (
+	quicTransport struct {
+		peerKey crypto.PrivateKey
+		mutex   sync.RWMutex
+		// transport of the last listener, outgoing connections are dialed
+		// through it so that they share the listener's port, which means
+		// that the address other peers observe for us is one they can also
+		// reach us at once our nat has a mapping for them
+		transport *quic.Transport
+	}
+	// quicListener accepts quic connections and the first stream each of
+	// them opens, and exposes the result as a net.Listener
+	quicListener struct {
+		quicTransport *quicTransport
+		transport     *quic.Transport
+		listener      *quic.Listener
+		conns         chan net.Conn
+		closer        chan struct{}
+		once          sync.Once
+	}
+	// quicConn wraps a quic connection and its single bidirectional stream
+	// into a net.Conn
+	quicConn struct {
+		quic.Stream
+		conn quic.Connection
+	}
+)
+
+func newQUICConfig() *quic.Config {
+	return &quic.Config{
+		KeepAlivePeriod: quicKeepAlivePeriod,
+		MaxIdleTimeout:  quicMaxIdleTimeout,
+	}
+}
+
+func (qt *quicTransport) Dial(
+	ctx context.Context,
+	address string,
+) (*connection, error) {
+	cert, err := crypto.GenerateTLSCertificate(
+		qt.peerKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		InsecureSkipVerify: true, // nolint: gosec
+		NextProtos:         []string{quicALPN},
+	}
+	addr := strings.Replace(address, "quic:", "", 1)
+
+	dialCtx := context.New(
+		context.WithParent(ctx),
+		context.WithTimeout(time.Second),
+	)
+	defer dialCtx.Cancel()
+
+	qt.mutex.RLock()
+	transport := qt.transport
+	qt.mutex.RUnlock()
+
+	var qConn quic.Connection
+	if transport != nil {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		qConn, err = transport.Dial(dialCtx, udpAddr, config, newQUICConfig())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		qConn, err = quic.DialAddr(dialCtx, addr, config, newQUICConfig())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stream, err := qConn.OpenStreamSync(dialCtx)
+	if err != nil {
+		qConn.CloseWithError(0, "") // nolint: errcheck
+		return nil, err
+	}
+
+	rawConn := &quicConn{
+		Stream: stream,
+		conn:   qConn,
+	}
+
+	pubKey, err := quicRemotePeerKey(qConn)
+	if err != nil {
+		rawConn.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	conn := newConnection(rawConn, false)
+	conn.remoteAddress = address
+	conn.localAddress = qConn.LocalAddr().String()
+	conn.remotePeerKey = pubKey
+
+	return conn, nil
+}
+
+func (qt *quicTransport) Listen(
+	ctx context.Context,
+	bindAddress string,
+	key crypto.PrivateKey,
+) (net.Listener, error) {
+	cert, err := crypto.GenerateTLSCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true, // nolint: gosec
+		NextProtos:         []string{quicALPN},
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", bindAddress)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	transport := &quic.Transport{
+		Conn: udpConn,
+	}
+	qListener, err := transport.Listen(config, newQUICConfig())
+	if err != nil {
+		transport.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	qt.mutex.Lock()
+	qt.transport = transport
+	qt.mutex.Unlock()
+
+	lst := &quicListener{
+		quicTransport: qt,
+		transport:     transport,
+		listener:      qListener,
+		conns:         make(chan net.Conn),
+		closer:        make(chan struct{}),
+	}
+
+	go lst.serve()
+
+	return lst, nil
+}
+
+func (ql *quicListener) serve() {
+	for {
+		qConn, err := ql.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		// the remote peer can only open its stream after the handshake, so
+		// we wait for it in the background in order to not block others
+		go func() {
+			acceptCtx := context.New(
+				context.WithTimeout(time.Second * 5),
+			)
+			defer acceptCtx.Cancel()
+			stream, err := qConn.AcceptStream(acceptCtx)
+			if err != nil {
+				qConn.CloseWithError(0, "") // nolint: errcheck
+				return
+			}
+			select {
+			case ql.conns <- &quicConn{
+				Stream: stream,
+				conn:   qConn,
+			}:
+			case <-ql.closer:
+				qConn.CloseWithError(0, "") // nolint: errcheck
+			}
+		}()
+	}
+}
+
+func (ql *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ql.conns:
+		return conn, nil
+	case <-ql.closer:
+		return nil, &net.OpError{
+			Op:   "accept",
+			Net:  "udp",
+			Addr: ql.Addr(),
+			Err:  net.ErrClosed,
+		}
+	}
+}
+
+func (ql *quicListener) Close() error {
+	ql.once.Do(func() {
+		close(ql.closer)
+		ql.quicTransport.mutex.Lock()
+		if ql.quicTransport.transport == ql.transport {
+			ql.quicTransport.transport = nil
+		}
+		ql.quicTransport.mutex.Unlock()
+	})
+	ql.listener.Close() // nolint: errcheck
+	return ql.transport.Close()
+}
+
+func (ql *quicListener) Addr() net.Addr {
+	return ql.listener.Addr()
+}
+
+func (qc *quicConn) Close() error {
+	qc.Stream.Close() // nolint: errcheck
+	return qc.conn.CloseWithError(0, "")
+}
+
+func (qc *quicConn) LocalAddr() net.Addr {
+	return qc.conn.LocalAddr()
+}
+
+func (qc *quicConn) RemoteAddr() net.Addr {
+	return qc.conn.RemoteAddr()
+}
+
+// Handshake is a no-op as quic connections are only returned by the listener
+// once their handshake has been completed.
+func (qc *quicConn) Handshake() error {
+	return nil
+}
+
+func (qc *quicConn) ConnectionState() tls.ConnectionState {
+	return qc.conn.ConnectionState().TLS
+}
+
+func quicRemotePeerKey(qConn quic.Connection) (crypto.PublicKey, error) {
+	certs := qConn.ConnectionState().TLS.PeerCertificates
+	if len(certs) != 1 {
+		return crypto.PublicKey{}, fmt.Errorf(
+			"only single certs are currently supported",
+		)
+	}
+	pubKey, ok := certs[0].PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return crypto.PublicKey{}, fmt.Errorf(
+			"only ed25519 keys are currently supported",
+		)
+	}
+	return crypto.NewEd25519PublicKeyFromRaw(pubKey), nil
+}
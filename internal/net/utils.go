@@ -14,7 +14,16 @@ func GetAddresses(
 	includePrivate bool,
 	includeIPV6 bool,
 ) []string {
-	port := l.Addr().(*net.TCPAddr).Port
+	port := 0
+	switch addr := l.Addr().(type) {
+	case memAddr:
+		// in-memory listeners are not bound to any interface
+		return []string{fmt.Sprintf("%s:%s", protocol, addr)}
+	case *net.TCPAddr:
+		port = addr.Port
+	case *net.UDPAddr:
+		port = addr.Port
+	}
 	// TODO log errors
 	addrs, _ := GetLocalPeerAddresses(
 		port,
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"nimona.io/pkg/context"
+)
+
+// Bucket is a token bucket that is refilled at a fixed rate up to its
+// capacity.
+// Waiting for more tokens than the bucket currently holds puts it in debt,
+// which allows taking more tokens than its capacity at once, ie for objects
+// larger than the allowed bytes per second, while still keeping the average
+// rate.
+type Bucket struct {
+	mutex    sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// NewBucket returns a full bucket that is refilled with the given number of
+// tokens per second, and can hold up to one second worth of them
+func NewBucket(rate int) *Bucket {
+	return &Bucket{
+		rate:     float64(rate),
+		capacity: float64(rate),
+		tokens:   float64(rate),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// refill must be called with the mutex held
+func (b *Bucket) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Allow takes n tokens if they are available, requests for more tokens than
+// the bucket's capacity are allowed when it is full
+func (b *Bucket) Allow(n int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	if b.tokens < float64(n) && b.tokens < b.capacity {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Return gives back n tokens that were taken but ended up not being used
+func (b *Bucket) Return(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill()
+	b.tokens += float64(n)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// Wait takes n tokens, waiting until the bucket has been refilled enough to
+// cover them or the context is done, in which case they are given back
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	b.mutex.Lock()
+	b.refill()
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mutex.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.mutex.Lock()
+		b.tokens += float64(n)
+		b.mutex.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+)
+
+func TestBucket_Allow(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(10)
+	b.last = now
+	b.now = func() time.Time {
+		return now
+	}
+
+	// the bucket starts full
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.Allow(1))
+	}
+	assert.False(t, b.Allow(1))
+
+	// and is refilled over time
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.Allow(5))
+	assert.False(t, b.Allow(1))
+
+	// but never above its capacity
+	now = now.Add(time.Hour)
+	assert.True(t, b.Allow(10))
+	assert.False(t, b.Allow(1))
+
+	// requests larger than the capacity are allowed when full
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow(15))
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, b.Allow(1))
+}
+
+func TestBucket_Wait(t *testing.T) {
+	b := NewBucket(100)
+	require.NoError(t, b.Wait(context.New(), 100))
+
+	// the bucket is empty, so we need to wait for it to be refilled
+	start := time.Now()
+	require.NoError(t, b.Wait(context.New(), 20))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// cancelled waits give their tokens back
+	ctx := context.New(
+		context.WithTimeout(10 * time.Millisecond),
+	)
+	require.Error(t, b.Wait(ctx, 1000))
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, b.Allow(10))
+}
@@ -5,12 +5,19 @@ import (
 	"io"
 
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
 )
 
 type Reader interface {
 	Read(p []byte) (n int, err error)
 }
 
+// DataStore returns readers for the data values of stored objects, ie
+// sqlobjectstore.Store
+type DataStore interface {
+	GetData(hash tilde.Digest, path string) (io.ReadCloser, error)
+}
+
 type blobReader struct {
 	total      int
 	chunkIndex int
@@ -18,6 +25,12 @@ type blobReader struct {
 	chunks     []*Chunk
 }
 
+type streamReader struct {
+	store   DataStore
+	chunks  []tilde.Digest
+	current io.ReadCloser
+}
+
 func NewBlob(r io.Reader) (*Blob, []*Chunk, error) {
 	blob := &Blob{}
 	chunks := make([]*Chunk, 0)
@@ -55,6 +68,52 @@ func NewReader(chunks []*Chunk) Reader {
 	}
 }
 
+// NewStreamReader returns a reader for the given chunks that reads their data
+// from the store as it is needed, so that the chunks do not have to be loaded
+// in memory
+func NewStreamReader(store DataStore, chunks []tilde.Digest) io.ReadCloser {
+	return &streamReader{
+		store:  store,
+		chunks: chunks,
+	}
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for {
+		if sr.current == nil {
+			if len(sr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			r, err := sr.store.GetData(sr.chunks[0], "data:d")
+			if err != nil {
+				return 0, err
+			}
+			sr.current = r
+			sr.chunks = sr.chunks[1:]
+		}
+		n, err := sr.current.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		// move on to the next chunk
+		err = sr.current.Close()
+		sr.current = nil
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+}
+
+func (sr *streamReader) Close() error {
+	sr.chunks = nil
+	if sr.current == nil {
+		return nil
+	}
+	err := sr.current.Close()
+	sr.current = nil
+	return err
+}
+
 func (bl *blobReader) Read(p []byte) (n int, err error) {
 	maxBuffer := len(p)
 	dataRead := 0
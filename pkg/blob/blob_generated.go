@@ -5,18 +5,46 @@ package blob
 import (
 	object "nimona.io/pkg/object"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const ChunkType = "nimona.io/Chunk"
 
 type Chunk struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/Chunk"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/Chunk,context=DXHhZmH5tWLEX5dFaLfM2x91yZeTXETBhxL9QCExVUj1"`
 	Data     []byte          `nimona:"data:d"`
 }
 
 const BlobType = "nimona.io/Blob"
 
 type Blob struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/Blob"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/Blob,context=DXHhZmH5tWLEX5dFaLfM2x91yZeTXETBhxL9QCExVUj1"`
 	Chunks   []tilde.Digest  `nimona:"chunks:ar"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("DXHhZmH5tWLEX5dFaLfM2x91yZeTXETBhxL9QCExVUj1")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/blob",
+	Types: []*schema.Type{{
+		Name: "nimona.io/Chunk",
+		Properties: []*schema.Property{{
+			Name: "data",
+			Hint: "d",
+		}},
+	}, {
+		Name: "nimona.io/Blob",
+		Properties: []*schema.Property{{
+			Name:     "chunks",
+			Hint:     "r",
+			Repeated: true,
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
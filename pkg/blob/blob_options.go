@@ -2,6 +2,7 @@ package blob
 
 import (
 	"nimona.io/pkg/objectmanager"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/resolver"
 )
 
@@ -11,6 +12,13 @@ func WithObjectManager(x objectmanager.ObjectManager) func(*manager) {
 	}
 }
 
+// WithObjectStore sets the store that local blobs are opened from
+func WithObjectStore(x objectstore.Store) func(*manager) {
+	return func(r *manager) {
+		r.objectstore = x
+	}
+}
+
 func WithResolver(res resolver.Resolver) func(*manager) {
 	return func(r *manager) {
 		r.resolver = res
@@ -1,17 +1,26 @@
 package blob_test
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
+	"path"
 	"testing"
 
 	"github.com/docker/go-units"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	_ "modernc.org/sqlite"
+
 	"nimona.io/internal/iotest"
 	"nimona.io/pkg/blob"
+	"nimona.io/pkg/blobstore"
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
 )
 
@@ -84,6 +93,34 @@ func Test_blobReader_Read(t *testing.T) {
 	}
 }
 
+func Test_streamReader_Read(t *testing.T) {
+	blobStore, err := blobstore.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	db, err := sql.Open("sqlite", path.Join(t.TempDir(), "sqlite3.db"))
+	require.NoError(t, err)
+	str, err := sqlobjectstore.New(db, sqlobjectstore.WithBlobStore(blobStore))
+	require.NoError(t, err)
+
+	data := make([]byte, 2.5*units.MB)
+	_, err = rand.New(rand.NewSource(0)).Read(data) // nolint: gosec
+	require.NoError(t, err)
+
+	bl, chunks, err := blob.NewBlob(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	for _, ch := range chunks {
+		o, err := object.Marshal(ch)
+		require.NoError(t, err)
+		require.NoError(t, str.Put(o))
+	}
+
+	r := blob.NewStreamReader(str, bl.Chunks)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+}
+
 func TestBlob_Hash(t *testing.T) {
 	c := &blob.Chunk{
 		Data: []byte("foo"),
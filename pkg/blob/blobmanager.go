@@ -13,6 +13,7 @@ import (
 	"nimona.io/pkg/log"
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectmanager"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/resolver"
 	"nimona.io/pkg/tilde"
 )
@@ -30,10 +31,15 @@ type (
 			ctx context.Context,
 			inputPath string,
 		) (*Blob, error)
+		Open(
+			ctx context.Context,
+			hash tilde.Digest,
+		) (io.ReadCloser, error)
 	}
 	manager struct {
 		resolver      resolver.Resolver
 		objectmanager objectmanager.ObjectManager
+		objectstore   objectstore.Store
 		chunkSize     int
 		importWorkers int
 	}
@@ -205,6 +211,34 @@ func (r *manager) Request(
 	return blob, chunks, nil
 }
 
+// Open returns a reader for a blob that exists in the local store, its chunks
+// are streamed from the store as they are read rather than being loaded in
+// memory
+func (r *manager) Open(
+	ctx context.Context,
+	hash tilde.Digest,
+) (io.ReadCloser, error) {
+	if r.objectstore == nil {
+		return nil, errors.Error("no object store was provided")
+	}
+
+	obj, err := r.objectstore.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.Type != BlobType {
+		return nil, errors.Error("object is not a blob")
+	}
+
+	chunksHashes, err := getChunks(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStreamReader(r.objectstore, chunksHashes), nil
+}
+
 func getChunks(o *object.Object) ([]tilde.Digest, error) {
 	b := &Blob{}
 	if err := object.Unmarshal(o, b); err != nil {
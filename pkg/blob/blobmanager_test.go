@@ -1,17 +1,21 @@
 package blob_test
 
 import (
+	"io"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"nimona.io/pkg/blob"
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/memobjectstore"
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectmanager"
 	"nimona.io/pkg/objectmanagermock"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/peer"
 	"nimona.io/pkg/resolver"
 	"nimona.io/pkg/resolvermock"
@@ -133,7 +137,8 @@ func Test_requester_Request(t *testing.T) {
 
 func Test_manager_ImportFromFile(t *testing.T) {
 	chunk0 := &object.Object{
-		Type: blob.ChunkType,
+		Context: blob.SchemaContextDigest,
+		Type:    blob.ChunkType,
 		Data: tilde.Map{
 			"data": tilde.Data(
 				"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14" +
@@ -142,7 +147,8 @@ func Test_manager_ImportFromFile(t *testing.T) {
 		},
 	}
 	chunk1 := &object.Object{
-		Type: blob.ChunkType,
+		Context: blob.SchemaContextDigest,
+		Type:    blob.ChunkType,
 		Data: tilde.Map{
 			"data": tilde.Data(
 				"\n21\n22\n23\n24\n25\n26\n27\n28\n29\n30\n31\n" +
@@ -151,7 +157,8 @@ func Test_manager_ImportFromFile(t *testing.T) {
 		},
 	}
 	chunk2 := &object.Object{
-		Type: blob.ChunkType,
+		Context: blob.SchemaContextDigest,
+		Type:    blob.ChunkType,
 		Data: tilde.Map{
 			"data": tilde.Data(
 				"7\n38\n39\n40\n",
@@ -183,7 +190,8 @@ func Test_manager_ImportFromFile(t *testing.T) {
 				MaxTimes(1)
 			m.EXPECT().
 				Put(gomock.Any(), &object.Object{
-					Type: blob.BlobType,
+					Context: blob.SchemaContextDigest,
+					Type:    blob.BlobType,
 					Data: tilde.Map{
 						"chunks": tilde.DigestArray{
 							chunk0.Hash(),
@@ -220,3 +228,41 @@ func Test_manager_ImportFromFile(t *testing.T) {
 		})
 	}
 }
+
+func Test_manager_Open(t *testing.T) {
+	chunk1 := &blob.Chunk{
+		Data: tilde.Data("ooh wee"),
+	}
+	chunk2 := &blob.Chunk{
+		Data: tilde.Data("ooh lala"),
+	}
+	blob1 := &blob.Blob{
+		Chunks: []tilde.Digest{
+			object.MustMarshal(chunk1).Hash(),
+			object.MustMarshal(chunk2).Hash(),
+		},
+	}
+
+	store := memobjectstore.New()
+	defer store.Close() // nolint: errcheck
+	require.NoError(t, store.Put(object.MustMarshal(chunk1)))
+	require.NoError(t, store.Put(object.MustMarshal(chunk2)))
+	require.NoError(t, store.Put(object.MustMarshal(blob1)))
+
+	ctx := context.Background()
+	r := blob.NewManager(ctx, blob.WithObjectStore(store))
+
+	br, err := r.Open(ctx, object.MustMarshal(blob1).Hash())
+	require.NoError(t, err)
+	defer br.Close() // nolint: errcheck
+
+	b, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, "ooh weeooh lala", string(b))
+
+	_, err = r.Open(ctx, object.MustMarshal(chunk1).Hash())
+	require.Error(t, err)
+
+	_, err = r.Open(ctx, tilde.Digest("foo"))
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+}
@@ -0,0 +1,27 @@
+package blobstore
+
+import (
+	"io"
+
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
+)
+
+const (
+	ErrNotFound      = errors.Error("not found")
+	ErrInvalidDigest = errors.Error("invalid digest")
+)
+
+// Store is a content-addressed store for large values, values are identified
+// by their digest, which is the same as the digest of the equivalent
+// tilde.Data value.
+type Store interface {
+	// Put stores the contents of the reader and returns their digest
+	Put(r io.Reader) (tilde.Digest, error)
+	// Get returns a reader for the value with the given digest, the reader
+	// needs to be closed by the caller
+	Get(digest tilde.Digest) (io.ReadCloser, error)
+	// Remove removes the value with the given digest, removing values that
+	// do not exist is not an error
+	Remove(digest tilde.Digest) error
+}
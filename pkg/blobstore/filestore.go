@@ -0,0 +1,120 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"nimona.io/internal/encoding/base58"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
+)
+
+// FileStore stores values as files, sharded in two levels of directories
+// based on their digest, ie `ab/cd/abcd...`.
+// File names are the hex encoding of the digest as base58 digests are case
+// sensitive and some file systems are not.
+type FileStore struct {
+	path string
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a file store under the given path
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		path: path,
+	}
+	if err := os.MkdirAll(fs.tempPath(), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+	return fs, nil
+}
+
+// Put writes the value to a temporary file while hashing it, and then moves
+// it into place, so partially written values are never visible
+func (fs *FileStore) Put(r io.Reader) (tilde.Digest, error) {
+	f, err := os.CreateTemp(fs.tempPath(), "put-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %w", err)
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		f.Close() // nolint: errcheck
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close() // nolint: errcheck
+		return "", fmt.Errorf("could not sync file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("could not close file: %w", err)
+	}
+
+	sum := h.Sum(nil)
+	digest := tilde.Digest(base58.Encode(sum))
+	dst := fs.filePath(sum)
+
+	// values are immutable, no need to replace existing ones
+	if _, err := os.Stat(dst); err == nil {
+		return digest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return "", fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return "", fmt.Errorf("could not move file: %w", err)
+	}
+
+	return digest, nil
+}
+
+func (fs *FileStore) Get(digest tilde.Digest) (io.ReadCloser, error) {
+	sum, err := digestBytes(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fs.filePath(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+	return f, nil
+}
+
+func (fs *FileStore) Remove(digest tilde.Digest) error {
+	sum, err := digestBytes(digest)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fs.filePath(sum))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove file: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileStore) tempPath() string {
+	return filepath.Join(fs.path, "tmp")
+}
+
+func (fs *FileStore) filePath(sum []byte) string {
+	name := hex.EncodeToString(sum)
+	return filepath.Join(fs.path, name[0:2], name[2:4], name)
+}
+
+func digestBytes(digest tilde.Digest) ([]byte, error) {
+	sum, err := digest.Bytes()
+	if err != nil || len(sum) != sha256.Size {
+		return nil, ErrInvalidDigest
+	}
+	return sum, nil
+}
@@ -0,0 +1,59 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/tilde"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("foo"), 1000)
+
+	digest, err := fs.Put(bytes.NewReader(value))
+	require.NoError(t, err)
+	// digests should match the ones of data values
+	assert.Equal(t, tilde.Data(value).Hash(), digest)
+
+	// putting the same value again should be fine
+	again, err := fs.Put(bytes.NewReader(value))
+	require.NoError(t, err)
+	assert.Equal(t, digest, again)
+
+	r, err := fs.Get(digest)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, value, got)
+
+	// values should be sharded, and no temporary files should be left behind
+	sum, err := digest.Bytes()
+	require.NoError(t, err)
+	name := hex.EncodeToString(sum)
+	assert.FileExists(t, filepath.Join(dir, name[0:2], name[2:4], name))
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+
+	require.NoError(t, fs.Remove(digest))
+	_, err = fs.Get(digest)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// removing missing values should be fine
+	require.NoError(t, fs.Remove(digest))
+
+	_, err = fs.Get("not-a-digest")
+	require.ErrorIs(t, err, ErrInvalidDigest)
+}
@@ -26,13 +26,31 @@ type (
 			ListenOnLocalIPs     bool              `json:"listenLocalIPs" envconfig:"LISTEN_LOCAL"`
 			ListenOnPrivateIPs   bool              `json:"listenPrivateIPs" envconfig:"LISTEN_PRIVATE"`
 			ListenOnExternalPort bool              `json:"listenExternalPort" envconfig:"LISTEN_EXTERNAL_PORT"`
+			RateLimits           struct {
+				Inbound         RateLimit            `json:"inbound" envconfig:"INBOUND"`
+				InboundPerPeer  RateLimit            `json:"inboundPerPeer" envconfig:"INBOUND_PER_PEER"`
+				Outbound        RateLimit            `json:"outbound" envconfig:"OUTBOUND"`
+				OutboundPerPeer RateLimit            `json:"outboundPerPeer" envconfig:"OUTBOUND_PER_PEER"`
+				ObjectTypes     map[string]RateLimit `json:"objectTypes,omitempty" ignored:"true"`
+			} `json:"rateLimits" envconfig:"RATE_LIMITS"`
 		} `json:"peer" envconfig:"PEER"`
+		Storage struct {
+			// QuotaBytes limits the size of the object store, zero values are
+			// not limited
+			QuotaBytes int64 `json:"quotaBytes" envconfig:"QUOTA_BYTES"`
+		} `json:"storage" envconfig:"STORAGE"`
 		Extras map[string]json.RawMessage `json:"extras,omitempty"`
 		extras map[string]interface{}
 		// internal defaults
 		defaultConfigFilename string
 		withoutPersistence    bool
 	}
+	// RateLimit is the number of objects and bytes allowed per second, zero
+	// values are not limited
+	RateLimit struct {
+		Objects int `json:"objects" envconfig:"OBJECTS"`
+		Bytes   int `json:"bytes" envconfig:"BYTES"`
+	}
 )
 
 func New(opts ...Option) (*Config, error) {
@@ -71,6 +71,7 @@ func TestConfigEnvar(t *testing.T) {
 	}
 
 	os.Setenv("NIMONA_EXTRAONE_HELLO", "envar")
+	os.Setenv("NIMONA_PEER_RATE_LIMITS_INBOUND_OBJECTS", "10")
 
 	extraConfig1 := &ExtraCfg{}
 	extraConfig2 := &ExtraCfg{}
@@ -85,4 +86,5 @@ func TestConfigEnvar(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "envar", extraConfig1.Hello)
 	assert.Equal(t, "two", extraConfig2.Hello)
+	assert.Equal(t, 10, h1.Peer.RateLimits.Inbound.Objects)
 }
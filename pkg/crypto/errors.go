@@ -5,4 +5,5 @@ import "nimona.io/pkg/errors"
 const (
 	ErrUnsupportedKeyAlgorithm = errors.Error("key algorithm not supported")
 	ErrInvalidSignature        = errors.Error("invalid signature")
+	ErrInvalidCiphertext       = errors.Error("invalid ciphertext")
 )
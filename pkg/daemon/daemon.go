@@ -6,18 +6,23 @@ import (
 	"path/filepath"
 
 	"nimona.io/internal/net"
+	"nimona.io/pkg/blobstore"
 	"nimona.io/pkg/config"
 	"nimona.io/pkg/configstore"
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/filesharing"
 	hresolver "nimona.io/pkg/hyperspace/resolver"
 	"nimona.io/pkg/keystream"
 	"nimona.io/pkg/network"
+	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectmanager"
 	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/peer"
 	"nimona.io/pkg/resolver"
+	"nimona.io/pkg/search"
 	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/stream"
+	"nimona.io/schema"
 )
 
 type (
@@ -30,6 +35,7 @@ type (
 		ObjectManager() objectmanager.ObjectManager
 		KeyStreamManager() keystream.Manager
 		StreamManager() stream.Manager
+		Search() *search.Index
 		// daemon specific methods
 		Close()
 	}
@@ -37,12 +43,14 @@ type (
 		config          config.Config
 		configstore     configstore.Store
 		configOptions   []config.Option
+		netOptions      []net.Option
 		network         network.Network
 		resolver        resolver.Resolver
 		objectstore     objectstore.Store
 		objectmanager   objectmanager.ObjectManager
 		streammanager   stream.Manager
 		keystreamanager keystream.Manager
+		search          *search.Index
 		// internal
 		listener net.Listener
 	}
@@ -77,24 +85,89 @@ func New(ctx context.Context, opts ...Option) (Daemon, error) {
 		return nil, fmt.Errorf("constructing configstore provider: %w", err)
 	}
 
-	// construct object store
-	db, err := sql.Open("sqlite", filepath.Join(cfg.Path, "object.sqlite"))
+	// construct object store, the search index shares its database so every
+	// connection needs to wait for the others' writes
+	db, err := sql.Open(
+		"sqlite",
+		filepath.Join(cfg.Path, "object.sqlite")+
+			"?_pragma=busy_timeout(5000)",
+	)
 	if err != nil {
 		return nil, fmt.Errorf("opening sql file: %w", err)
 	}
 
-	str, err := sqlobjectstore.New(db)
+	// large values, ie blob chunks, are kept outside of the database
+	blobStore, err := blobstore.NewFileStore(filepath.Join(cfg.Path, "blobs"))
+	if err != nil {
+		return nil, fmt.Errorf("constructing blob store: %w", err)
+	}
+
+	str, err := sqlobjectstore.New(
+		db,
+		sqlobjectstore.WithBlobStore(blobStore),
+		sqlobjectstore.WithQuota(cfg.Storage.QuotaBytes),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("starting sql store: %w", err)
 	}
 
+	// keep the contexts of our own objects, so peers validating them can
+	// fetch them from us
+	for _, c := range schema.Registered() {
+		o, err := object.Marshal(c)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling schema context: %w", err)
+		}
+		if err := str.Put(o); err != nil {
+			return nil, fmt.Errorf("storing schema context: %w", err)
+		}
+		if err := str.Pin(o.Hash()); err != nil {
+			return nil, fmt.Errorf("pinning schema context: %w", err)
+		}
+	}
+
+	// construct search index
+	idx, err := search.New(
+		ctx,
+		db,
+		str,
+		search.WithRule(filesharing.FileType, "name:s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("constructing search index: %w", err)
+	}
+
+	// construct key resolver, for evaluating policies against the key streams
+	// peers have been delegated by
+	keyResolver := keystream.NewKeyResolver(str)
+
 	// construct new network
-	inet := net.New(cfg.Peer.PrivateKey)
+	rl := cfg.Peer.RateLimits
+	networkOptions := []network.Option{
+		network.WithObjectStore(str),
+		network.WithOutbox(str),
+		network.WithKeyResolver(keyResolver),
+		network.WithInboundRateLimit(
+			rateLimit(rl.Inbound),
+			rateLimit(rl.InboundPerPeer),
+		),
+		network.WithOutboundRateLimit(
+			rateLimit(rl.Outbound),
+			rateLimit(rl.OutboundPerPeer),
+		),
+	}
+	for objectType, l := range rl.ObjectTypes {
+		networkOptions = append(
+			networkOptions,
+			network.WithObjectTypeRateLimit(objectType, rateLimit(l)),
+		)
+	}
+	inet := net.New(cfg.Peer.PrivateKey, d.netOptions...)
 	nnet := network.New(
 		ctx,
 		inet,
 		cfg.Peer.PrivateKey,
-		str,
+		networkOptions...,
 	)
 
 	if cfg.Peer.BindAddress != "" {
@@ -133,6 +206,7 @@ func New(ctx context.Context, opts ...Option) (Daemon, error) {
 		nnet,
 		res,
 		str,
+		stream.WithKeyResolver(keyResolver),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("constructing stream manager, %w", err)
@@ -171,6 +245,7 @@ func New(ctx context.Context, opts ...Option) (Daemon, error) {
 		nnet,
 		res,
 		str,
+		objectmanager.WithKeyResolver(keyResolver),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("constructing object manager, %w", err)
@@ -184,10 +259,19 @@ func New(ctx context.Context, opts ...Option) (Daemon, error) {
 	d.objectmanager = man
 	d.keystreamanager = ksm
 	d.streammanager = sm
+	d.search = idx
 
 	return d, nil
 }
 
+// rateLimit converts the configured rate limit to the network's
+func rateLimit(l config.RateLimit) network.RateLimit {
+	return network.RateLimit{
+		Objects: l.Objects,
+		Bytes:   l.Bytes,
+	}
+}
+
 func (d *daemon) Config() config.Config {
 	return d.config
 }
@@ -220,7 +304,12 @@ func (d *daemon) StreamManager() stream.Manager {
 	return d.streammanager
 }
 
+func (d *daemon) Search() *search.Index {
+	return d.search
+}
+
 func (d *daemon) Close() {
+	d.search.Close()
 	if d.listener != nil {
 		d.listener.Close() // nolint: errcheck
 	}
@@ -1,6 +1,9 @@
 package daemon
 
-import "nimona.io/pkg/config"
+import (
+	"nimona.io/internal/net"
+	"nimona.io/pkg/config"
+)
 
 func WithConfigOptions(opts ...config.Option) Option {
 	return func(d *daemon) error {
@@ -8,3 +11,12 @@ func WithConfigOptions(opts ...config.Option) Option {
 		return nil
 	}
 }
+
+// WithNetOptions configures the underlying net, ie allows using an in-memory
+// transport for tests
+func WithNetOptions(opts ...net.Option) Option {
+	return func(d *daemon) error {
+		d.netOptions = opts
+		return nil
+	}
+}
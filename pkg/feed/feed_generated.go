@@ -5,12 +5,13 @@ package feed
 import (
 	object "nimona.io/pkg/object"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const FeedStreamRootType = "stream:nimona.io/feed"
 
 type FeedStreamRoot struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=stream:nimona.io/feed"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=stream:nimona.io/feed,context=FHi6EtPbdJ13NRUcyP4DE8r363e7eQGn6H7pVkCV9e9F"`
 	ObjectType string          `nimona:"objectType:s"`
 	Timestamp  string          `nimona:"timestamp:s"`
 }
@@ -18,7 +19,7 @@ type FeedStreamRoot struct {
 const AddedType = "event:nimona.io/feed.Added"
 
 type Added struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=event:nimona.io/feed.Added"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=event:nimona.io/feed.Added,context=FHi6EtPbdJ13NRUcyP4DE8r363e7eQGn6H7pVkCV9e9F"`
 	ObjectHash []tilde.Digest  `nimona:"objectHash:ar"`
 	Sequence   int64           `nimona:"sequence:i"`
 	Timestamp  string          `nimona:"timestamp:s"`
@@ -27,8 +28,57 @@ type Added struct {
 const RemovedType = "event:nimona.io/feed.Removed"
 
 type Removed struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=event:nimona.io/feed.Removed"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=event:nimona.io/feed.Removed,context=FHi6EtPbdJ13NRUcyP4DE8r363e7eQGn6H7pVkCV9e9F"`
 	ObjectHash []tilde.Digest  `nimona:"objectHash:ar"`
 	Sequence   int64           `nimona:"sequence:i"`
 	Timestamp  string          `nimona:"timestamp:s"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("FHi6EtPbdJ13NRUcyP4DE8r363e7eQGn6H7pVkCV9e9F")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/feed",
+	Types: []*schema.Type{{
+		Name: "stream:nimona.io/feed",
+		Properties: []*schema.Property{{
+			Name: "objectType",
+			Hint: "s",
+		}, {
+			Name: "timestamp",
+			Hint: "s",
+		}},
+	}, {
+		Name: "event:nimona.io/feed.Added",
+		Properties: []*schema.Property{{
+			Name:     "objectHash",
+			Hint:     "r",
+			Repeated: true,
+		}, {
+			Name: "sequence",
+			Hint: "i",
+		}, {
+			Name: "timestamp",
+			Hint: "s",
+		}},
+	}, {
+		Name: "event:nimona.io/feed.Removed",
+		Properties: []*schema.Property{{
+			Name:     "objectHash",
+			Hint:     "r",
+			Repeated: true,
+		}, {
+			Name: "sequence",
+			Hint: "i",
+		}, {
+			Name: "timestamp",
+			Hint: "s",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
@@ -5,12 +5,13 @@ package filesharing
 import (
 	object "nimona.io/pkg/object"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const FileType = "nimona.io/File"
 
 type File struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/File"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/File,context=EPF49M9YaqLL7MysfiqfMTp2JvDzQxtgRWW8uj45j3hW"`
 	Name     string          `nimona:"name:s"`
 	Chunks   []tilde.Digest  `nimona:"chunks:ar"`
 }
@@ -18,14 +19,14 @@ type File struct {
 const TransferDoneType = "nimona.io/TransferDone"
 
 type TransferDone struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferDone"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferDone,context=EPF49M9YaqLL7MysfiqfMTp2JvDzQxtgRWW8uj45j3hW"`
 	Nonce    string          `nimona:"nonce:s"`
 }
 
 const TransferRequestType = "nimona.io/TransferRequest"
 
 type TransferRequest struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferRequest"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferRequest,context=EPF49M9YaqLL7MysfiqfMTp2JvDzQxtgRWW8uj45j3hW"`
 	File     File            `nimona:"file:m"`
 	Nonce    string          `nimona:"nonce:s"`
 }
@@ -33,7 +34,56 @@ type TransferRequest struct {
 const TransferResponseType = "nimona.io/TransferResponse"
 
 type TransferResponse struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferResponse"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/TransferResponse,context=EPF49M9YaqLL7MysfiqfMTp2JvDzQxtgRWW8uj45j3hW"`
 	Nonce    string          `nimona:"nonce:s"`
 	Accepted bool            `nimona:"accepted:b"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("EPF49M9YaqLL7MysfiqfMTp2JvDzQxtgRWW8uj45j3hW")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "filesharing",
+	Types: []*schema.Type{{
+		Name: "nimona.io/File",
+		Properties: []*schema.Property{{
+			Name: "name",
+			Hint: "s",
+		}, {
+			Name:     "chunks",
+			Hint:     "r",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/TransferDone",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/TransferRequest",
+		Properties: []*schema.Property{{
+			Name: "file",
+			Hint: "m",
+			Type: "nimona.io/File",
+		}, {
+			Name: "nonce",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/TransferResponse",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}, {
+			Name: "accepted",
+			Hint: "b",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
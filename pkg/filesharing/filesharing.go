@@ -1,8 +1,8 @@
 package filesharing
 
 import (
-	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -48,6 +48,7 @@ type (
 	fileSharer struct {
 		objmgr         objectmanager.ObjectManager
 		net            network.Network
+		store          blob.DataStore
 		receivedFolder string
 	}
 	Transfer struct {
@@ -56,14 +57,18 @@ type (
 	}
 )
 
+// New returns a Filesharer, the store is where the requested chunks are read
+// from while being written to their file
 func New(
 	objectManager objectmanager.ObjectManager,
 	net network.Network,
+	store blob.DataStore,
 	receivedFolder string,
 ) Filesharer {
 	return &fileSharer{
 		objmgr:         objectManager,
 		net:            net,
+		store:          store,
 		receivedFolder: receivedFolder,
 	}
 }
@@ -179,10 +184,8 @@ func (fsh *fileSharer) RequestFile(
 	*os.File,
 	error,
 ) {
-	// TODO this needs to be improved to not store data in memory
-	// and do all the operations in disk
-	chunks := []*blob.Chunk{}
-
+	// the chunks are put in the store as they arrive rather than being kept
+	// in memory, and are then streamed from it into the file
 	for _, ch := range transfer.Request.File.Chunks {
 		chObj, err := fsh.objmgr.Request(
 			ctx,
@@ -193,12 +196,13 @@ func (fsh *fileSharer) RequestFile(
 			return nil, err
 		}
 
-		chunk := &blob.Chunk{}
-		if err := object.Unmarshal(chObj, chunk); err != nil {
-			return nil, err
+		if chObj.Type != blob.ChunkType {
+			return nil, fmt.Errorf("object %s is not a chunk", ch)
 		}
 
-		chunks = append(chunks, chunk)
+		if err := fsh.objmgr.Put(ctx, chObj); err != nil {
+			return nil, err
+		}
 	}
 
 	_ = os.MkdirAll(fsh.receivedFolder, os.ModePerm)
@@ -210,9 +214,9 @@ func (fsh *fileSharer) RequestFile(
 		return nil, err
 	}
 
-	r := blob.NewReader(chunks)
-	bf := bufio.NewReader(r)
-	if _, err := io.Copy(f, bf); err != nil {
+	r := blob.NewStreamReader(fsh.store, transfer.Request.File.Chunks)
+	defer r.Close() // nolint: errcheck
+	if _, err := io.Copy(f, r); err != nil {
 		return nil, err
 	}
 
@@ -10,6 +10,7 @@ import (
 	"nimona.io/pkg/blob"
 	"nimona.io/pkg/did"
 	"nimona.io/pkg/filesharing"
+	"nimona.io/pkg/memobjectstore"
 	"nimona.io/pkg/tilde"
 
 	"nimona.io/pkg/context"
@@ -67,6 +68,7 @@ func Test_fileSharer_RequestTransfer(t *testing.T) {
 			fsh := filesharing.New(
 				nil,
 				tt.fields.net(t, tt.args.ctx),
+				nil,
 				"",
 			)
 
@@ -137,6 +139,7 @@ func Test_fileSharer_Listen(t *testing.T) {
 			fsh := filesharing.New(
 				nil,
 				tt.fields.net(t, tt.args.ctx),
+				nil,
 				"",
 			)
 
@@ -163,15 +166,17 @@ func Test_fileSharer_RequestFile(t *testing.T) {
 		) network.Network
 	}
 
+	chunk1 := &blob.Chunk{Data: []byte("asdf")}
 	file1 := filesharing.File{
 		Name:   "testfile",
-		Chunks: []tilde.Digest{tilde.Digest("1234")},
+		Chunks: []tilde.Digest{object.MustMarshal(chunk1).Hash()},
 	}
 	req := &filesharing.TransferRequest{
 		File:  file1,
 		Nonce: "1234",
 	}
-	chunk1 := &blob.Chunk{Data: []byte("asdf")}
+	store := memobjectstore.New()
+	defer store.Close() // nolint: errcheck
 
 	type args struct {
 		ctx   context.Context
@@ -199,6 +204,13 @@ func Test_fileSharer_RequestFile(t *testing.T) {
 					mobm := objectmanagermock.NewMockObjectManager(ctrl)
 					mobm.EXPECT().Request(ctx, file1.Chunks[0], gomock.Any()).
 						Return(object.MustMarshal(chunk1), nil)
+					mobm.EXPECT().Put(ctx, gomock.Any()).
+						DoAndReturn(func(
+							_ context.Context,
+							o *object.Object,
+						) error {
+							return store.Put(o)
+						})
 					return mobm
 				},
 				net: func(
@@ -227,7 +239,8 @@ func Test_fileSharer_RequestFile(t *testing.T) {
 			fsh := filesharing.New(
 				tt.fields.objm(t, tt.args.ctx),
 				tt.fields.net(t, tt.args.ctx),
-				"",
+				store,
+				t.TempDir(),
 			)
 
 			events, err := fsh.Listen(tt.args.ctx)
@@ -243,6 +256,10 @@ func Test_fileSharer_RequestFile(t *testing.T) {
 			}
 			assert.NoError(t, err)
 			assert.NotNil(t, file)
+
+			body, err := os.ReadFile(file.Name())
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(chunk1.Data), body)
 		})
 	}
 }
@@ -7,12 +7,13 @@ import (
 	object "nimona.io/pkg/object"
 	peer "nimona.io/pkg/peer"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const AnnouncementType = "nimona.io/hyperspace.Announcement"
 
 type Announcement struct {
-	Metadata         object.Metadata      `nimona:"@metadata:m,type=nimona.io/hyperspace.Announcement"`
+	Metadata         object.Metadata      `nimona:"@metadata:m,type=nimona.io/hyperspace.Announcement,context=6Nvdhkcnoo7NAH3JnwmRmJL32Bsj9W9WXUpekDUTENer"`
 	Version          int64                `nimona:"version:i"`
 	ConnectionInfo   *peer.ConnectionInfo `nimona:"connectionInfo:m"`
 	PeerCapabilities []string             `nimona:"peerCapabilities:as"`
@@ -22,7 +23,7 @@ type Announcement struct {
 const LookupByDIDRequestType = "nimona.io/hyperspace.LookupByDIDRequest"
 
 type LookupByDIDRequest struct {
-	Metadata            object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupByDIDRequest"`
+	Metadata            object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupByDIDRequest,context=6Nvdhkcnoo7NAH3JnwmRmJL32Bsj9W9WXUpekDUTENer"`
 	Nonce               string          `nimona:"nonce:s"`
 	Owner               did.DID         `nimona:"owner:s"`
 	RequireCapabilities []string        `nimona:"requireCapabilities:as"`
@@ -31,7 +32,7 @@ type LookupByDIDRequest struct {
 const LookupByDigestRequestType = "nimona.io/hyperspace.LookupByDigestRequest"
 
 type LookupByDigestRequest struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupByDigestRequest"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupByDigestRequest,context=6Nvdhkcnoo7NAH3JnwmRmJL32Bsj9W9WXUpekDUTENer"`
 	Nonce    string          `nimona:"nonce:s"`
 	Digest   tilde.Digest    `nimona:"digest:r"`
 }
@@ -39,7 +40,71 @@ type LookupByDigestRequest struct {
 const LookupResponseType = "nimona.io/hyperspace.LookupResponse"
 
 type LookupResponse struct {
-	Metadata      object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupResponse"`
+	Metadata      object.Metadata `nimona:"@metadata:m,type=nimona.io/hyperspace.LookupResponse,context=6Nvdhkcnoo7NAH3JnwmRmJL32Bsj9W9WXUpekDUTENer"`
 	Nonce         string          `nimona:"nonce:s"`
 	Announcements []*Announcement `nimona:"announcements:am"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("6Nvdhkcnoo7NAH3JnwmRmJL32Bsj9W9WXUpekDUTENer")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/hyperspace",
+	Types: []*schema.Type{{
+		Name: "nimona.io/hyperspace.Announcement",
+		Properties: []*schema.Property{{
+			Name: "version",
+			Hint: "i",
+		}, {
+			Name: "connectionInfo",
+			Hint: "m",
+		}, {
+			Name:     "peerCapabilities",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "digests",
+			Hint:     "r",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/hyperspace.LookupByDIDRequest",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}, {
+			Name: "owner",
+			Hint: "s",
+		}, {
+			Name:     "requireCapabilities",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/hyperspace.LookupByDigestRequest",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}, {
+			Name: "digest",
+			Hint: "r",
+		}},
+	}, {
+		Name: "nimona.io/hyperspace.LookupResponse",
+		Properties: []*schema.Property{{
+			Name: "nonce",
+			Hint: "s",
+		}, {
+			Name:     "announcements",
+			Hint:     "m",
+			Type:     "nimona.io/hyperspace.Announcement",
+			Repeated: true,
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
@@ -264,7 +264,7 @@ func (p *Provider) handlePeerLookupByDigest(
 		return
 	}
 
-	err = pc.Write(ctx, reso)
+	err = pc.Write(ctx, reso, net.WriteWithPriority(net.PriorityHigh))
 	if err != nil {
 		logger.Debug("could not write lookup response", log.Error(err))
 		return
@@ -344,7 +344,7 @@ func (p *Provider) handlePeerLookupByDID(
 		return
 	}
 
-	err = pc.Write(ctx, reso)
+	err = pc.Write(ctx, reso, net.WriteWithPriority(net.PriorityHigh))
 	if err != nil {
 		logger.Debug("could not write lookup response", log.Error(err))
 		return
@@ -385,6 +385,7 @@ func (p *Provider) distributeAnnouncement(
 				context.WithTimeout(time.Second*3),
 			),
 			annObj,
+			net.WriteWithPriority(net.PriorityHigh),
 		); err != nil {
 			logger.Error(
 				"error announcing self to other provider",
@@ -415,8 +416,9 @@ func (p *Provider) announceSelf() {
 			Metadata: object.Metadata{
 				Owner: p.peerKey.PublicKey().DID(),
 			},
-			Version:   p.announcementVersion,
-			Addresses: p.network.Addresses(),
+			Version:       p.announcementVersion,
+			Addresses:     p.network.Addresses(),
+			ObjectFormats: p.network.ObjectFormats(),
 		},
 		PeerCapabilities: []string{
 			hyperspace.AnnouncementType,
@@ -445,6 +447,7 @@ func (p *Provider) announceSelf() {
 				context.WithTimeout(time.Second*3),
 			),
 			annObj,
+			net.WriteWithPriority(net.PriorityHigh),
 		); err != nil {
 			logger.Error(
 				"error announcing self to other provider",
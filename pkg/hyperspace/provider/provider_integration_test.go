@@ -18,8 +18,10 @@ import (
 )
 
 func TestProvider_handleAnnouncement(t *testing.T) {
+	sb := net.NewSwitchboard(1)
+
 	// net0 is our provider
-	net0, k0 := newPeer(context.New(context.WithCorrelationID("prv0")), t)
+	net0, k0 := newPeer(context.New(context.WithCorrelationID("prv0")), t, sb)
 	pr0 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
 			Owner: k0.PublicKey().DID(),
@@ -28,7 +30,7 @@ func TestProvider_handleAnnouncement(t *testing.T) {
 	}
 
 	// net1 is a normal peer
-	net1, k1 := newPeer(context.New(), t)
+	net1, k1 := newPeer(context.New(), t, sb)
 	pr1 := &hyperspace.Announcement{
 		Metadata: object.Metadata{
 			Owner: k1.PublicKey().DID(),
@@ -62,8 +64,10 @@ func TestProvider_handleAnnouncement(t *testing.T) {
 }
 
 func TestProvider_distributeAnnouncement(t *testing.T) {
+	sb := net.NewSwitchboard(1)
+
 	// net0 is our provider
-	net0, k0 := newPeer(context.New(context.WithCorrelationID("net0")), t)
+	net0, k0 := newPeer(context.New(context.WithCorrelationID("net0")), t, sb)
 	pr0 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
 			Owner: k0.PublicKey().DID(),
@@ -72,7 +76,7 @@ func TestProvider_distributeAnnouncement(t *testing.T) {
 	}
 
 	// net1 is another provider
-	net1, k1 := newPeer(context.New(context.WithCorrelationID("net1")), t)
+	net1, k1 := newPeer(context.New(context.WithCorrelationID("net1")), t, sb)
 	pr1 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
 			Owner: k1.PublicKey().DID(),
@@ -81,7 +85,7 @@ func TestProvider_distributeAnnouncement(t *testing.T) {
 	}
 
 	// net2 is a normal peer
-	net2, k2 := newPeer(context.New(context.WithCorrelationID("net2")), t)
+	net2, k2 := newPeer(context.New(context.WithCorrelationID("net2")), t, sb)
 	pr2 := &hyperspace.Announcement{
 		Metadata: object.Metadata{
 			Owner: k2.PublicKey().DID(),
@@ -169,8 +173,10 @@ func TestProvider_distributeAnnouncement(t *testing.T) {
 }
 
 func TestProvider_handlePeerLookup(t *testing.T) {
+	sb := net.NewSwitchboard(1)
+
 	// net0 is our provider
-	net0, k0 := newPeer(context.New(context.WithCorrelationID("prv0")), t)
+	net0, k0 := newPeer(context.New(context.WithCorrelationID("prv0")), t, sb)
 	pr0 := &hyperspace.Announcement{
 		Metadata: object.Metadata{
 			Owner: k0.PublicKey().DID(),
@@ -184,7 +190,7 @@ func TestProvider_handlePeerLookup(t *testing.T) {
 	}
 
 	// net1 is a normal peer
-	net1, k1 := newPeer(context.New(), t)
+	net1, k1 := newPeer(context.New(), t, sb)
 
 	// construct provider
 	prv, err := New(context.New(), net0, k1, nil)
@@ -261,17 +267,21 @@ func TestProvider_handlePeerLookup(t *testing.T) {
 	assert.ElementsMatch(t, []*hyperspace.Announcement{pr2}, res.Announcements)
 }
 
-func newPeer(ctx context.Context, t *testing.T) (
+func newPeer(ctx context.Context, t *testing.T, sb *net.Switchboard) (
 	net.Network,
 	crypto.PrivateKey,
 ) {
 	k, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
 
-	n := net.New(k)
+	n := net.New(
+		k,
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	)
 	lis, err := n.Listen(
 		ctx,
-		"127.0.0.1:0",
+		"",
 		&net.ListenConfig{
 			BindLocal: true,
 		},
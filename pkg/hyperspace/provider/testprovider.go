@@ -15,13 +15,14 @@ import (
 func NewTestProvider(
 	ctx context.Context,
 	t *testing.T,
+	opts ...net.Option,
 ) (*Provider, *peer.ConnectionInfo) {
 	// construct new key
 	key, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
 
 	// construct new network
-	inet := net.New(key)
+	inet := net.New(key, opts...)
 	nnet := network.New(
 		ctx,
 		inet,
@@ -18,9 +18,9 @@ import (
 	"nimona.io/pkg/keystream"
 	"nimona.io/pkg/log"
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/peer"
 	"nimona.io/pkg/resolver"
-	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
 )
 
@@ -55,7 +55,7 @@ func New(
 	ctx context.Context,
 	network net.Network,
 	peerKey crypto.PrivateKey,
-	str *sqlobjectstore.Store,
+	str objectstore.Store,
 	ksm keystream.Manager,
 	opts ...Option,
 ) resolver.Resolver {
@@ -123,7 +123,7 @@ func New(
 		r.announceSelf()
 
 		// announce on object updates
-		strSub := make(<-chan sqlobjectstore.Event)
+		strSub := make(<-chan objectstore.Event)
 		strCf := func() {}
 		if str != nil {
 			strSub, strCf = str.ListenForUpdates()
@@ -138,10 +138,10 @@ func New(
 				r.announceSelf()
 			case event := <-strSub:
 				switch event.Action {
-				case sqlobjectstore.ObjectInserted:
+				case objectstore.ObjectInserted:
 					r.hashes.Put(event.ObjectHash)
 					r.announceSelf()
-				case sqlobjectstore.ObjectRemoved:
+				case objectstore.ObjectRemoved:
 					r.hashes.Delete(event.ObjectHash)
 					r.announceSelf()
 				}
@@ -241,6 +241,7 @@ func (r *Resolver) lookup(
 			err = conn.Write(
 				ctx,
 				reqObject,
+				net.WriteWithPriority(net.PriorityHigh),
 			)
 			if err != nil {
 				// logger.Debug("could send request to peer", log.Error(err))
@@ -349,6 +350,7 @@ func (r *Resolver) announceSelf() {
 		err = conn.Write(
 			ctx,
 			anno,
+			net.WriteWithPriority(net.PriorityHigh),
 		)
 		if err != nil {
 			logger.Error(
@@ -403,7 +405,8 @@ func (r *Resolver) getLocalPeerAnnouncement() *hyperspace.Announcement {
 			Metadata: object.Metadata{
 				Owner: r.peerKey.PublicKey().DID(),
 			},
-			Addresses: addresses,
+			Addresses:     addresses,
+			ObjectFormats: r.network.ObjectFormats(),
 			// Relays:    relays,
 		},
 		Digests: digests,
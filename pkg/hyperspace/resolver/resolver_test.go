@@ -25,8 +25,10 @@ import (
 )
 
 func TestResolver_Integration(t *testing.T) {
+	sb := net.NewSwitchboard(1)
+
 	// net0 is our provider
-	k0, net0 := newPeer(t)
+	k0, net0 := newPeer(t, sb)
 	pr0 := &hyperspace.Announcement{
 		Metadata: object.Metadata{
 			Owner: k0.PublicKey().DID(),
@@ -41,7 +43,7 @@ func TestResolver_Integration(t *testing.T) {
 	}
 
 	// net1 is a normal peer
-	k1, net1 := newPeer(t)
+	k1, net1 := newPeer(t, sb)
 	pr1 := &hyperspace.Announcement{
 		Metadata: object.Metadata{
 			Owner: k1.PublicKey().DID(),
@@ -159,16 +161,23 @@ func TestResolver_Integration(t *testing.T) {
 	})
 }
 
-func newPeer(t *testing.T) (crypto.PrivateKey, net.Network) {
+func newPeer(
+	t *testing.T,
+	sb *net.Switchboard,
+) (crypto.PrivateKey, net.Network) {
 	k, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
 
 	ctx := context.New()
 
-	n := net.New(k)
+	n := net.New(
+		k,
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	)
 	lis, err := n.Listen(
 		ctx,
-		"127.0.0.1:0",
+		"",
 		&net.ListenConfig{
 			BindLocal: true,
 		},
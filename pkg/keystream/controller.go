@@ -8,7 +8,6 @@ import (
 
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
-	"nimona.io/pkg/did"
 	"nimona.io/pkg/keystore"
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/stream"
@@ -64,8 +63,11 @@ func RestoreController(
 	return c, nil
 }
 
+// NewController creates a new key stream for the given peer key; the inception
+// is signed by the peer and co-signed by the stream's first key so that both
+// can be verified by anyone syncing the stream
 func NewController(
-	owner did.DID,
+	ownerKey crypto.PrivateKey,
 	keyStore keystore.KeyStore,
 	streamManager stream.Manager,
 	delegatorSeal *DelegatorSeal,
@@ -89,8 +91,12 @@ func NewController(
 
 	inceptionEvent := &Inception{
 		Metadata: object.Metadata{
-			Owner:    owner,
+			Owner:    ownerKey.PublicKey().DID(),
 			Sequence: 0,
+			Threshold: object.SignatureThreshold{
+				Keys:      []crypto.PublicKey{k0.PublicKey()},
+				Threshold: 1,
+			},
 		},
 		Version:       Version,
 		Key:           k0.PublicKey(),
@@ -101,10 +107,14 @@ func NewController(
 	if err != nil {
 		return nil, fmt.Errorf("unable to marshal object, %w", err)
 	}
-	err = object.Sign(k0, inceptionObject)
+	err = object.Sign(ownerKey, inceptionObject)
 	if err != nil {
 		return nil, fmt.Errorf("unable to sign object, %w", err)
 	}
+	err = object.CoSign(k0, inceptionObject)
+	if err != nil {
+		return nil, fmt.Errorf("unable to co-sign object, %w", err)
+	}
 
 	streamController, err := streamManager.GetOrCreateController(
 		inceptionObject.Hash(),
@@ -222,6 +232,11 @@ func (c *controller) Delegate(
 		return nil, fmt.Errorf("unable to marshal object, %w", err)
 	}
 
+	err = object.Sign(c.currentPrivateKey, do)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign object, %w", err)
+	}
+
 	d.Metadata.Signature = do.Metadata.Signature
 
 	// TODO: Apply vs Insert?
@@ -28,7 +28,7 @@ func TestController_New(t *testing.T) {
 	// create a controller with empty stores
 	sMgr, err := stream.NewManager(context.New(), nil, nil, sqlStore)
 	require.NoError(t, err)
-	ctrl, err := NewController(k.PublicKey().DID(), sqlStore, sMgr, nil)
+	ctrl, err := NewController(k, sqlStore, sMgr, nil)
 	require.NoError(t, err)
 	require.NotNil(t, ctrl)
 
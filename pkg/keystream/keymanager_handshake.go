@@ -208,10 +208,11 @@ func (m *manager) HandleDelegationRequest(
 	if err != nil {
 		return fmt.Errorf("can't find a controller: %w", err)
 	}
-	// create a new Delegation Offer and send it to the
+	// create a new Delegation Offer and send it to the initiator, the offer
+	// is owned by our peer so that it gets signed when it is sent
 	do := &DelegationOffer{
 		Metadata: object.Metadata{
-			Owner: ks.GetKeyStream().GetDID(),
+			Owner: m.network.GetPeerKey().PublicKey().DID(),
 		},
 		DelegatorSeal: DelegatorSeal{
 			Root:        ks.GetKeyStream().Root,
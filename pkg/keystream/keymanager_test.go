@@ -39,6 +39,7 @@ func TestKeyManager(t *testing.T) {
 	require.NoError(t, err)
 
 	net := &networkmock.MockNetworkSimple{
+		ReturnPeerKey: k1,
 		ReturnConnectionInfo: &peer.ConnectionInfo{
 			Metadata: object.Metadata{
 				Owner: k1.PublicKey().DID(),
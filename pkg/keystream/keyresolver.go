@@ -0,0 +1,206 @@
+package keystream
+
+import (
+	"fmt"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+type (
+	// keyResolver resolves the key streams a peer has been delegated by using
+	// the key streams in the given store
+	keyResolver struct {
+		objectStore objectstore.Store
+	}
+	delegation struct {
+		delegate  *State
+		delegator *State
+	}
+)
+
+// NewKeyResolver returns a resolver for the keys a peer key can act on
+// behalf of; these are the active keys of the peer's own key streams, as
+// long as they have been delegated to, along with the keys of their
+// delegators
+func NewKeyResolver(objectStore objectstore.Store) object.KeyResolver {
+	return &keyResolver{
+		objectStore: objectStore,
+	}
+}
+
+func (r *keyResolver) ResolveKeys(
+	peerKey crypto.PublicKey,
+) ([]crypto.PublicKey, error) {
+	delegations, err := r.getDelegations(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.PublicKey{}
+	for _, d := range delegations {
+		keys = append(keys, d.delegate.ActiveKey, d.delegator.ActiveKey)
+	}
+	return keys, nil
+}
+
+// ResolveIdentities returns the DIDs of the key streams the peer has been
+// delegated by, along with the ones the key is the active key of
+func (r *keyResolver) ResolveIdentities(
+	peerKey crypto.PublicKey,
+) ([]did.DID, error) {
+	delegations, err := r.getDelegations(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	ids := []did.DID{}
+	for _, d := range delegations {
+		ids = append(ids, d.delegator.GetDID())
+	}
+	streams, err := r.getStreams(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range streams {
+		ids = append(ids, s.GetDID())
+	}
+	return ids, nil
+}
+
+// getStreams returns the key streams the given key is the active key of;
+// streams that claim a delegator are only returned if it has delegated to
+// them
+func (r *keyResolver) getStreams(
+	activeKey crypto.PublicKey,
+) ([]*State, error) {
+	reader, err := r.objectStore.Filter(
+		objectstore.FilterByObjectType(InceptionType),
+		objectstore.FilterByData(
+			"k:s",
+			objectstore.DataEqual,
+			tilde.String(activeKey.String()),
+		),
+	)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get inceptions, %w", err)
+	}
+	defer reader.Close()
+
+	streams := []*State{}
+	for {
+		o, err := reader.Read()
+		if err == object.ErrReaderDone {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading inceptions, %w", err)
+		}
+
+		s, err := r.getState(o.Hash())
+		if err != nil || !s.ActiveKey.Equals(activeKey) {
+			continue
+		}
+		if !s.Delegator.IsEmpty() && !r.hasDelegated(s) {
+			continue
+		}
+		streams = append(streams, s)
+	}
+
+	return streams, nil
+}
+
+// hasDelegated checks whether the delegator of the given key stream has
+// delegated to it
+func (r *keyResolver) hasDelegated(s *State) bool {
+	delegator, err := r.getState(s.DelegatorRoot)
+	if err != nil {
+		return false
+	}
+	for _, root := range delegator.DelegateRoots {
+		if root == s.Root {
+			return true
+		}
+	}
+	return false
+}
+
+// getDelegations returns the peer's key streams, as long as they have been
+// delegated to, along with their delegators
+func (r *keyResolver) getDelegations(
+	peerKey crypto.PublicKey,
+) ([]*delegation, error) {
+	// only key streams created by the peer matter
+	reader, err := r.objectStore.Filter(
+		objectstore.FilterByObjectType(InceptionType),
+		objectstore.FilterByOwner(peerKey.DID()),
+	)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get inceptions, %w", err)
+	}
+	defer reader.Close()
+
+	delegations := []*delegation{}
+	for {
+		o, err := reader.Read()
+		if err == object.ErrReaderDone {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading inceptions, %w", err)
+		}
+
+		inc := &Inception{}
+		if err := object.Unmarshal(o, inc); err != nil {
+			continue
+		}
+		if inc.DelegatorSeal == nil {
+			continue
+		}
+
+		delegate, err := r.getState(o.Hash())
+		if err != nil {
+			continue
+		}
+		delegator, err := r.getState(inc.DelegatorSeal.Root)
+		if err != nil {
+			continue
+		}
+
+		// and only if the delegator has actually delegated to them
+		for _, root := range delegator.DelegateRoots {
+			if root != delegate.Root {
+				continue
+			}
+			delegations = append(delegations, &delegation{
+				delegate:  delegate,
+				delegator: delegator,
+			})
+			break
+		}
+	}
+
+	return delegations, nil
+}
+
+func (r *keyResolver) getState(root tilde.Digest) (*State, error) {
+	reader, err := r.objectStore.GetByStream(root)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	s, err := FromStream(reader)
+	if err != nil {
+		return nil, err
+	}
+	if s.ActiveKey.IsEmpty() {
+		return nil, objectstore.ErrNotFound
+	}
+	return s, nil
+}
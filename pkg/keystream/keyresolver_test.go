@@ -0,0 +1,83 @@
+package keystream
+
+import (
+	"database/sql"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/sqlobjectstore"
+	"nimona.io/pkg/stream"
+)
+
+func TestKeyResolver_ResolveKeys(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	sMgr, err := stream.NewManager(context.New(), nil, nil, sqlStore)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	// create an identity, and a key stream for a peer that is delegated by it
+	identity, err := NewController(k0, sqlStore, sMgr, nil)
+	require.NoError(t, err)
+	delegate, err := NewController(
+		k1,
+		sqlStore,
+		sMgr,
+		&DelegatorSeal{
+			Root: identity.GetKeyStream().Root,
+		},
+	)
+	require.NoError(t, err)
+
+	r := NewKeyResolver(sqlStore)
+
+	// the delegation has not been accepted yet
+	keys, err := r.ResolveKeys(k1.PublicKey())
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	_, err = identity.Delegate(DelegateSeal{
+		Root: delegate.GetKeyStream().Root,
+	})
+	require.NoError(t, err)
+
+	keys, err = r.ResolveKeys(k1.PublicKey())
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.True(t, keys[0].Equals(delegate.GetKeyStream().ActiveKey))
+	require.True(t, keys[1].Equals(identity.GetKeyStream().ActiveKey))
+
+	ids, err := r.ResolveIdentities(k1.PublicKey())
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	require.True(t, ids[0].Equals(identity.GetKeyStream().GetDID()))
+
+	// the active keys of the streams act on behalf of the identity
+	ids, err = r.ResolveIdentities(identity.GetKeyStream().ActiveKey)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	require.True(t, ids[0].Equals(identity.GetKeyStream().GetDID()))
+	ids, err = r.ResolveIdentities(delegate.GetKeyStream().ActiveKey)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	require.True(t, ids[0].Equals(identity.GetKeyStream().GetDID()))
+
+	// and the identity's own peer has not been delegated by anyone
+	keys, err = r.ResolveKeys(k0.PublicKey())
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
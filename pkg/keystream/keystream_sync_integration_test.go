@@ -0,0 +1,92 @@
+package keystream_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/internal/net"
+	"nimona.io/pkg/config"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/daemon"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/hyperspace/provider"
+	"nimona.io/pkg/keystream"
+	"nimona.io/pkg/peer"
+	"nimona.io/pkg/tilde"
+)
+
+func TestKeyStream_Sync_Integration(t *testing.T) {
+	sb := net.NewSwitchboard(1)
+	netOpts := []net.Option{
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	}
+
+	_, c0 := provider.NewTestProvider(context.Background(), t, netOpts...)
+
+	k0, err := crypto.PublicKeyFromDID(c0.Metadata.Owner)
+	require.NoError(t, err)
+
+	newDaemon := func() daemon.Daemon {
+		d, err := daemon.New(
+			context.New(),
+			daemon.WithNetOptions(netOpts...),
+			daemon.WithConfigOptions(
+				config.WithDefaultPath(t.TempDir()),
+				config.WithDefaultListenOnLocalIPs(),
+				config.WithDefaultListenOnPrivateIPs(),
+				config.WithDefaultBootstraps([]peer.Shorthand{
+					peer.Shorthand(fmt.Sprintf("%s@%s", k0, c0.Addresses[0])),
+				}),
+			),
+		)
+		require.NoError(t, err)
+		return d
+	}
+
+	d1 := newDaemon()
+	time.Sleep(time.Second)
+	d2 := newDaemon()
+	time.Sleep(time.Second)
+
+	// create a key stream on each peer, and delegate from p1's to p2's
+	c1, err := d1.KeyStreamManager().NewController(nil)
+	require.NoError(t, err)
+	c2, err := d2.KeyStreamManager().NewController(nil)
+	require.NoError(t, err)
+	_, err = c1.Delegate(keystream.DelegateSeal{
+		Root: c2.GetKeyStream().Root,
+	})
+	require.NoError(t, err)
+
+	// fetch the key stream from p2, both its inception and delegation should
+	// get through the network's verification
+	root := c1.GetKeyStream().Root
+	sc, err := d2.StreamManager().GetOrCreateController(root)
+	require.NoError(t, err)
+	n, err := d2.StreamManager().Fetch(context.New(), sc, root)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	r, err := d2.ObjectStore().GetByStream(root)
+	require.NoError(t, err)
+	s, err := keystream.FromStream(r)
+	require.NoError(t, err)
+	require.Equal(t, c1.GetKeyStream().ActiveKey, s.ActiveKey)
+	require.Equal(
+		t,
+		[]tilde.Digest{c2.GetKeyStream().Root},
+		s.DelegateRoots,
+	)
+
+	// and p2 can now resolve the stream's key to its identity
+	ids, err := keystream.NewKeyResolver(d2.ObjectStore()).ResolveIdentities(
+		c1.GetKeyStream().ActiveKey,
+	)
+	require.NoError(t, err)
+	require.Equal(t, []did.DID{c1.GetKeyStream().GetDID()}, ids)
+}
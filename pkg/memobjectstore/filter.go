@@ -0,0 +1,308 @@
+package memobjectstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+// Filter returns the objects that match all of the given filters, it supports
+// the same options and ordering columns as sqlobjectstore
+func (st *Store) Filter(
+	filterOptions ...objectstore.FilterOption,
+) (object.ReadCloser, error) {
+	options := objectstore.NewFilterOptions(filterOptions...)
+	if options.Err != nil {
+		return nil, options.Err
+	}
+
+	orderKey, err := orderKeyFunc(options.Filters.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := false
+	switch strings.ToUpper(options.Filters.OrderDir) {
+	case "ASC":
+	case "DESC":
+		desc = true
+	default:
+		return nil, errors.Merge(
+			objectstore.ErrInvalidFilter,
+			fmt.Errorf("unknown order direction %s", options.Filters.OrderDir),
+		)
+	}
+
+	// less orders entries by their order key, and then by their hash so that
+	// pages are stable
+	less := func(a, b *entry) bool {
+		ka, kb := orderKey(a), orderKey(b)
+		if ka == kb {
+			return a.hash < b.hash
+		}
+		return ka < kb
+	}
+	if desc {
+		asc := less
+		less = func(a, b *entry) bool {
+			return asc(b, a)
+		}
+	}
+
+	st.mutex.RLock()
+
+	var after *entry
+	if options.Filters.After != nil {
+		var ok bool
+		after, ok = st.objects[*options.Filters.After]
+		// cursors of objects we do not have cannot be told apart from the
+		// end of the results otherwise
+		if !ok {
+			st.mutex.RUnlock()
+			return nil, errors.Merge(
+				objectstore.ErrInvalidFilter,
+				fmt.Errorf("page cursor %s not found", options.Filters.After),
+			)
+		}
+	}
+
+	entries := []*entry{}
+	for _, e := range st.objects {
+		if !matches(e, options) {
+			continue
+		}
+		if after != nil && !less(after, e) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	if options.Filters.Offset != nil {
+		offset := *options.Filters.Offset
+		if offset > len(entries) {
+			offset = len(entries)
+		}
+		entries = entries[offset:]
+	}
+
+	if options.Filters.Limit != nil && *options.Filters.Limit < len(entries) {
+		entries = entries[:*options.Filters.Limit]
+	}
+
+	objects := make([]*object.Object, len(entries))
+	for i, e := range entries {
+		objects[i] = object.Copy(e.object)
+	}
+
+	st.mutex.RUnlock()
+
+	if len(objects) == 0 {
+		return nil, objectstore.ErrNotFound
+	}
+
+	errorChan := make(chan error)
+	objectsChan := make(chan *object.Object)
+	closeChan := make(chan struct{})
+
+	reader := object.NewReadCloser(
+		context.TODO(),
+		objectsChan,
+		errorChan,
+		closeChan,
+	)
+
+	go func() {
+		defer close(objectsChan)
+		defer close(errorChan)
+		for _, o := range objects {
+			select {
+			case <-closeChan:
+				return
+			case objectsChan <- o:
+				// all good
+			}
+		}
+	}()
+
+	return reader, nil
+}
+
+// sorted returns all entries in the order they were created in
+func (st *Store) sorted() []*entry {
+	entries := make([]*entry, 0, len(st.objects))
+	for _, e := range st.objects {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sequence < entries[j].sequence
+	})
+	return entries
+}
+
+// orderKeyFunc returns the value entries should be ordered by for the
+// given sqlobjectstore column
+func orderKeyFunc(orderBy string) (func(*entry) int64, error) {
+	switch strings.ToLower(orderBy) {
+	case "created":
+		return func(e *entry) int64 {
+			return e.created.UnixNano()
+		}, nil
+	case "lastaccessed":
+		return func(e *entry) int64 {
+			return e.lastAccessed.UnixNano()
+		}, nil
+	case "metadatadatetime":
+		return func(e *entry) int64 {
+			return e.metadataDatetime
+		}, nil
+	case "sequence":
+		return func(e *entry) int64 {
+			return int64(e.object.Metadata.Sequence)
+		}, nil
+	case "hash":
+		// entries are always ordered by their hash last
+		return func(e *entry) int64 {
+			return 0
+		}, nil
+	}
+	return nil, errors.Merge(
+		objectstore.ErrInvalidFilter,
+		fmt.Errorf("cannot order by %s", orderBy),
+	)
+}
+
+func matches(e *entry, options objectstore.FilterOptions) bool {
+	fs := options.Filters
+	if len(fs.ObjectHashes) > 0 && !containsDigest(fs.ObjectHashes, e.hash) {
+		return false
+	}
+	if len(fs.ContentTypes) > 0 &&
+		!containsString(fs.ContentTypes, e.object.Type) {
+		return false
+	}
+	if len(fs.StreamHashes) > 0 &&
+		!containsDigest(fs.StreamHashes, e.rootHash) {
+		return false
+	}
+	if len(fs.Owners) > 0 && !containsString(fs.Owners, e.owner) {
+		return false
+	}
+	if fs.Pending != nil && *fs.Pending != e.pending {
+		return false
+	}
+	for _, f := range fs.Data {
+		if !matchesData(e.object.Data, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesData checks the value of the filter's path, objects that do not have
+// a value for it never match
+func matchesData(m tilde.Map, f objectstore.DataFilter) bool {
+	var v tilde.Value = m
+	for _, k := range f.Keys {
+		vm, ok := v.(tilde.Map)
+		if !ok {
+			return false
+		}
+		key, hint, _ := tilde.ExtractHint(k) // nolint: errcheck
+		v, ok = vm[key]
+		if !ok || v == nil || v.Hint() != hint {
+			return false
+		}
+	}
+	if _, ok := v.(tilde.Redacted); ok {
+		return false
+	}
+	if f.Operator == objectstore.DataHasPrefix {
+		return strings.HasPrefix(stringValue(v), stringValue(f.Value))
+	}
+	c := compare(v, f.Value)
+	switch f.Operator {
+	case objectstore.DataEqual:
+		return c == 0
+	case objectstore.DataNotEqual:
+		return c != 0
+	case objectstore.DataLessThan:
+		return c < 0
+	case objectstore.DataLessThanOrEqual:
+		return c <= 0
+	case objectstore.DataGreaterThan:
+		return c > 0
+	case objectstore.DataGreaterThanOrEqual:
+		return c >= 0
+	}
+	return false
+}
+
+// compare compares two values with the same hint
+func compare(a, b tilde.Value) int {
+	switch av := a.(type) {
+	case tilde.Bool:
+		bv := b.(tilde.Bool)
+		switch {
+		case av == bv:
+			return 0
+		case !bool(av):
+			return -1
+		}
+		return 1
+	case tilde.Int:
+		return compareOrdered(av, b.(tilde.Int))
+	case tilde.Uint:
+		return compareOrdered(av, b.(tilde.Uint))
+	case tilde.Float:
+		return compareOrdered(av, b.(tilde.Float))
+	}
+	return strings.Compare(stringValue(a), stringValue(b))
+}
+
+func compareOrdered[T tilde.Int | tilde.Uint | tilde.Float](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func stringValue(v tilde.Value) string {
+	switch vv := v.(type) {
+	case tilde.String:
+		return string(vv)
+	case tilde.Digest:
+		return string(vv)
+	}
+	return ""
+}
+
+func containsDigest(hs []tilde.Digest, h tilde.Digest) bool {
+	for _, v := range hs {
+		if v == h {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,619 @@
+package memobjectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+var (
+	defaultTTL = time.Hour * 24 * 7
+	gcInterval = time.Minute
+)
+
+type (
+	// Store is an in-memory objectstore.Store and objectstore.Outbox, for
+	// embedded or ephemeral peers and tests that do not need their objects
+	// to outlive them.
+	// It mirrors the behaviour of sqlobjectstore, including signature
+	// merging, pending objects, relations, and the expiration of objects
+	// that are not reachable from pinned ones.
+	Store struct {
+		mutex     sync.RWMutex
+		objects   map[tilde.Digest]*entry
+		relations map[tilde.Digest]map[relation]struct{}
+		pins      []tilde.Digest
+		outbox    []*outboxEntry
+		// sequence keeps track of the order entries were created in
+		sequence      uint64
+		listeners     map[string]chan objectstore.Event
+		listenersLock sync.RWMutex
+		done          chan struct{}
+		closeOnce     sync.Once
+	}
+	entry struct {
+		hash             tilde.Digest
+		object           *object.Object
+		rootHash         tilde.Digest
+		owner            string
+		created          time.Time
+		lastAccessed     time.Time
+		ttl              time.Duration
+		metadataDatetime int64
+		pending          bool
+		sequence         uint64
+	}
+	// relation between an object and one of its parents, the root object of
+	// a stream is related to the empty digest
+	relation struct {
+		object tilde.Digest
+		parent tilde.Digest
+	}
+	outboxEntry struct {
+		recipient did.DID
+		object    *object.Object
+		created   time.Time
+	}
+)
+
+var (
+	_ objectstore.Store  = (*Store)(nil)
+	_ objectstore.Outbox = (*Store)(nil)
+)
+
+// New returns an empty store, that removes expired objects in the background
+// until it is closed
+func New() *Store {
+	st := &Store{
+		objects:   map[tilde.Digest]*entry{},
+		relations: map[tilde.Digest]map[relation]struct{}{},
+		pins:      []tilde.Digest{},
+		outbox:    []*outboxEntry{},
+		listeners: map[string]chan objectstore.Event{},
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-st.done:
+				return
+			case <-ticker.C:
+				st.gc()
+			}
+		}
+	}()
+
+	return st
+}
+
+// Close stops the store's garbage collection
+func (st *Store) Close() error {
+	st.closeOnce.Do(func() {
+		close(st.done)
+	})
+	return nil
+}
+
+func (st *Store) Get(
+	hash tilde.Digest,
+) (*object.Object, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	e, ok := st.objects[hash]
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+
+	return object.Copy(e.object), nil
+}
+
+// GetData returns a reader for a data value of an object, the path is made
+// up of the hinted keys of the value and its parent maps, ie `data:d`
+func (st *Store) GetData(
+	hash tilde.Digest,
+	path string,
+) (io.ReadCloser, error) {
+	keys, hint, err := objectstore.ParseDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if hint != tilde.DataHint {
+		return nil, errors.Merge(
+			objectstore.ErrInvalidFilter,
+			fmt.Errorf("%s is not data", path),
+		)
+	}
+
+	st.mutex.RLock()
+	e, ok := st.objects[hash]
+	st.mutex.RUnlock()
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+
+	var v tilde.Value = e.object.Data
+	for _, k := range keys {
+		m, _ := v.(tilde.Map)
+		key, _, _ := tilde.ExtractHint(k) // nolint: errcheck
+		v = m[key]
+	}
+	d, ok := v.(tilde.Data)
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(d)), nil
+}
+
+// GetByStream returns the objects of the given stream, ordered by their
+// sequence, without the ones that are still pending co-signatures
+func (st *Store) GetByStream(
+	streamRootHash tilde.Digest,
+) (object.ReadCloser, error) {
+	return st.Filter(
+		objectstore.FilterByStreamHash(streamRootHash),
+		objectstore.FilterByPending(false),
+		objectstore.FilterOrderBy("sequence"),
+		objectstore.FilterOrderDir("ASC"),
+	)
+}
+
+// GetByType returns the objects of the given type, without the ones that are
+// still pending co-signatures
+func (st *Store) GetByType(
+	objectType string,
+) (object.ReadCloser, error) {
+	return st.Filter(
+		objectstore.FilterByObjectType(objectType),
+		objectstore.FilterByPending(false),
+	)
+}
+
+// GetPending returns the objects that do not have enough co-signatures yet
+func (st *Store) GetPending() (object.ReadCloser, error) {
+	return st.Filter(
+		objectstore.FilterByPending(true),
+	)
+}
+
+func (st *Store) Put(
+	obj *object.Object,
+) error {
+	return st.PutWithTTL(obj, defaultTTL)
+}
+
+// PutWithTTL stores the object, if the object already exists its
+// signatures are merged with the ones we already have.
+// Objects are marked as pending until they meet their signature threshold.
+func (st *Store) PutWithTTL(
+	obj *object.Object,
+	ttl time.Duration,
+) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	obj = object.Copy(obj)
+	objHash := obj.Hash()
+	now := time.Now()
+
+	// keep any signatures we already have for the object, and never replace
+	// the object with a redacted copy of it
+	existing, exists := st.objects[objHash]
+	if exists {
+		if object.IsRedacted(obj) && !object.IsRedacted(existing.object) {
+			obj.Data = existing.object.Data
+		}
+		if obj.Metadata.Signature.IsEmpty() {
+			obj.Metadata.Signature = existing.object.Metadata.Signature
+		}
+		if err := object.MergeSignatures(obj, existing.object); err != nil {
+			return fmt.Errorf("could not merge signatures: %w", err)
+		}
+	}
+
+	pending := errors.Is(
+		object.VerifyThreshold(obj),
+		object.ErrThresholdNotMet,
+	)
+
+	// if the object doesn't belong to a stream, the object is its own
+	// stream's root
+	rootHash := obj.Metadata.Root
+	if rootHash.IsEmpty() {
+		rootHash = objHash
+	}
+
+	if exists {
+		existing.object = obj
+		existing.lastAccessed = now
+		existing.pending = pending
+	} else {
+		owner := ""
+		if !obj.Metadata.Owner.IsEmpty() {
+			owner = obj.Metadata.Owner.String()
+		}
+		var metadataDatetime int64
+		dt, err := time.Parse(time.RFC3339, obj.Metadata.Timestamp)
+		if err == nil {
+			metadataDatetime = dt.Unix()
+		}
+		st.sequence++
+		st.objects[objHash] = &entry{
+			hash:             objHash,
+			object:           obj,
+			rootHash:         rootHash,
+			owner:            owner,
+			created:          now,
+			lastAccessed:     now,
+			ttl:              ttl,
+			metadataDatetime: metadataDatetime,
+			pending:          pending,
+			sequence:         st.sequence,
+		}
+	}
+
+	for _, group := range obj.Metadata.Parents {
+		for _, p := range group {
+			st.putRelation(rootHash, objHash, p)
+		}
+	}
+
+	if rootHash == objHash {
+		st.putRelation(rootHash, objHash, tilde.EmptyDigest)
+	}
+
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectInserted,
+		ObjectHash: objHash,
+	})
+
+	return nil
+}
+
+func (st *Store) putRelation(
+	stream tilde.Digest,
+	obj tilde.Digest,
+	parent tilde.Digest,
+) {
+	rs, ok := st.relations[stream]
+	if !ok {
+		rs = map[relation]struct{}{}
+		st.relations[stream] = rs
+	}
+	rs[relation{
+		object: obj,
+		parent: parent,
+	}] = struct{}{}
+}
+
+// GetStreamLeaves returns the objects of the stream that are not the parent
+// of any other object
+func (st *Store) GetStreamLeaves(
+	streamRootHash tilde.Digest,
+) ([]tilde.Digest, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	rs := st.relations[streamRootHash]
+	parents := map[tilde.Digest]struct{}{}
+	for r := range rs {
+		parents[r.parent] = struct{}{}
+	}
+
+	leaves := map[tilde.Digest]struct{}{}
+	for r := range rs {
+		if _, ok := parents[r.object]; ok {
+			continue
+		}
+		leaves[r.object] = struct{}{}
+	}
+
+	hashList := []tilde.Digest{}
+	for h := range leaves {
+		hashList = append(hashList, h)
+	}
+
+	return hashList, nil
+}
+
+// GetRelations returns the objects of the given stream, including its root
+func (st *Store) GetRelations(
+	parent tilde.Digest,
+) ([]tilde.Digest, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	now := time.Now()
+	hashList := []tilde.Digest{}
+	for _, e := range st.sorted() {
+		if e.rootHash != parent {
+			continue
+		}
+		e.lastAccessed = now
+		hashList = append(hashList, e.hash)
+	}
+
+	return hashList, nil
+}
+
+// ListHashes returns the hashes of the stream roots and of the objects that
+// are not part of a stream
+func (st *Store) ListHashes() ([]tilde.Digest, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	hashList := []tilde.Digest{}
+	for _, e := range st.sorted() {
+		if e.rootHash == e.hash {
+			hashList = append(hashList, e.hash)
+		}
+	}
+
+	return hashList, nil
+}
+
+// UpdateTTL updates the TTL of all the objects of the given stream
+func (st *Store) UpdateTTL(
+	hash tilde.Digest,
+	minutes int,
+) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	for _, e := range st.objects {
+		if e.rootHash == hash {
+			e.ttl = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	return nil
+}
+
+func (st *Store) Remove(
+	hash tilde.Digest,
+) error {
+	st.mutex.Lock()
+	st.remove(hash)
+	st.mutex.Unlock()
+
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectRemoved,
+		ObjectHash: hash,
+	})
+
+	return nil
+}
+
+// gc removes the unpinned objects that have not been accessed within their
+// TTL
+func (st *Store) gc() {
+	st.mutex.Lock()
+	now := time.Now()
+	reachable := st.reachable()
+	removed := []tilde.Digest{}
+	for h, e := range st.objects {
+		if _, ok := reachable[h]; ok || e.ttl <= 0 {
+			continue
+		}
+		if e.lastAccessed.Add(e.ttl).Before(now) {
+			st.remove(h)
+			removed = append(removed, h)
+		}
+	}
+	st.mutex.Unlock()
+
+	for _, h := range removed {
+		st.publishUpdate(objectstore.Event{
+			Action:     objectstore.ObjectRemoved,
+			ObjectHash: h,
+		})
+	}
+}
+
+// reachable returns the objects that are reachable from the pinned ones, same
+// as in sqlobjectstore objects reach the objects they refer to and stream
+// roots reach all of the objects in their streams; expects the store to be
+// locked
+func (st *Store) reachable() map[tilde.Digest]struct{} {
+	streams := map[tilde.Digest][]tilde.Digest{}
+	for h, e := range st.objects {
+		if e.rootHash != h {
+			streams[e.rootHash] = append(streams[e.rootHash], h)
+		}
+	}
+	reachable := map[tilde.Digest]struct{}{}
+	queue := append([]tilde.Digest{}, st.pins...)
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if _, ok := reachable[h]; ok {
+			continue
+		}
+		reachable[h] = struct{}{}
+		if e, ok := st.objects[h]; ok {
+			queue = append(queue, objectstore.Refs(e.object)...)
+		}
+		queue = append(queue, streams[h]...)
+	}
+	return reachable
+}
+
+// remove deletes the object and its relations, expects the store to be
+// locked
+func (st *Store) remove(hash tilde.Digest) {
+	delete(st.objects, hash)
+	for stream, rs := range st.relations {
+		for r := range rs {
+			if r.object == hash {
+				delete(rs, r)
+			}
+		}
+		if len(rs) == 0 {
+			delete(st.relations, stream)
+		}
+	}
+}
+
+func (st *Store) Pin(
+	hash tilde.Digest,
+) error {
+	st.mutex.Lock()
+	if !st.isPinned(hash) {
+		st.pins = append(st.pins, hash)
+	}
+	st.mutex.Unlock()
+
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectPinned,
+		ObjectHash: hash,
+	})
+
+	return nil
+}
+
+func (st *Store) GetPinned() ([]tilde.Digest, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	pins := make([]tilde.Digest, len(st.pins))
+	copy(pins, st.pins)
+
+	return pins, nil
+}
+
+func (st *Store) IsPinned(
+	hash tilde.Digest,
+) (bool, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return st.isPinned(hash), nil
+}
+
+func (st *Store) isPinned(hash tilde.Digest) bool {
+	for _, h := range st.pins {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *Store) RemovePin(
+	hash tilde.Digest,
+) error {
+	st.mutex.Lock()
+	for i, h := range st.pins {
+		if h == hash {
+			st.pins = append(st.pins[:i], st.pins[i+1:]...)
+			break
+		}
+	}
+	st.mutex.Unlock()
+
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectUnpinned,
+		ObjectHash: hash,
+	})
+
+	return nil
+}
+
+// ListenForUpdates returns a channel with the store's updates, updates are
+// dropped if the channel's buffer is full
+func (st *Store) ListenForUpdates() (
+	updates <-chan objectstore.Event,
+	cancel func(),
+) {
+	c := make(chan objectstore.Event, 100)
+	st.listenersLock.Lock()
+	defer st.listenersLock.Unlock()
+	id := rand.String(8)
+	st.listeners[id] = c
+	f := func() {
+		st.listenersLock.Lock()
+		defer st.listenersLock.Unlock()
+		delete(st.listeners, id)
+	}
+	return c, f
+}
+
+func (st *Store) publishUpdate(e objectstore.Event) {
+	st.listenersLock.RLock()
+	defer st.listenersLock.RUnlock()
+
+	for _, l := range st.listeners {
+		select {
+		case l <- e:
+		default:
+		}
+	}
+}
+
+func (st *Store) PutOutbox(
+	recipient did.DID,
+	obj *object.Object,
+) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	h := obj.Hash()
+	for _, e := range st.outbox {
+		if e.recipient.Equals(recipient) && e.object.Hash() == h {
+			return nil
+		}
+	}
+
+	st.outbox = append(st.outbox, &outboxEntry{
+		recipient: recipient,
+		object:    object.Copy(obj),
+		created:   time.Now(),
+	})
+
+	return nil
+}
+
+func (st *Store) GetOutbox() ([]*objectstore.OutboxEntry, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	entries := []*objectstore.OutboxEntry{}
+	for _, e := range st.outbox {
+		entries = append(entries, &objectstore.OutboxEntry{
+			Recipient: e.recipient,
+			Object:    object.Copy(e.object),
+			Created:   e.created,
+		})
+	}
+
+	return entries, nil
+}
+
+func (st *Store) RemoveOutbox(
+	recipient did.DID,
+	hash tilde.Digest,
+) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	for i, e := range st.outbox {
+		if e.recipient.Equals(recipient) && e.object.Hash() == hash {
+			st.outbox = append(st.outbox[:i], st.outbox[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,621 @@
+package memobjectstore
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	st := New()
+	t.Cleanup(func() {
+		st.Close() // nolint: errcheck
+	})
+	return st
+}
+
+// hashesOf returns a func that reads the hashes of the objects returned by
+// the store's Filter and Get methods
+func hashesOf(t *testing.T) func(object.ReadCloser, error) []tilde.Digest {
+	return func(reader object.ReadCloser, err error) []tilde.Digest {
+		t.Helper()
+		if errors.Is(err, objectstore.ErrNotFound) {
+			return nil
+		}
+		require.NoError(t, err)
+		os, err := object.ReadAll(reader)
+		require.NoError(t, err)
+		hs := []tilde.Digest{}
+		for _, o := range os {
+			hs = append(hs, o.Hash())
+		}
+		return hs
+	}
+}
+
+func TestStore_PutGet(t *testing.T) {
+	st := newTestStore(t)
+
+	o := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	_, err := st.Get(o.Hash())
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+
+	require.NoError(t, st.Put(o))
+
+	got, err := st.Get(o.Hash())
+	require.NoError(t, err)
+	require.Equal(t, o, got)
+
+	// stored objects should not be affected by changes to the returned ones
+	got.Data["foo"] = tilde.String("baz")
+	got, err = st.Get(o.Hash())
+	require.NoError(t, err)
+	require.Equal(t, o, got)
+
+	require.NoError(t, st.Remove(o.Hash()))
+	_, err = st.Get(o.Hash())
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+}
+
+func TestStore_GetData(t *testing.T) {
+	st := newTestStore(t)
+
+	o := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"data": tilde.Data("foo"),
+			"file": tilde.Map{
+				"data": tilde.Data("bar"),
+			},
+		},
+	}
+	require.NoError(t, st.Put(o))
+
+	readData := func(path string) []byte {
+		t.Helper()
+		r, err := st.GetData(o.Hash(), path)
+		require.NoError(t, err)
+		defer r.Close() // nolint: errcheck
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return b
+	}
+
+	assert.Equal(t, []byte("foo"), readData("data:d"))
+	assert.Equal(t, []byte("bar"), readData("file:m/data:d"))
+
+	_, err := st.GetData(o.Hash(), "missing:d")
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+	_, err = st.GetData(o.Hash(), "file:m")
+	require.ErrorIs(t, err, objectstore.ErrInvalidFilter)
+	_, err = st.GetData("missing", "data:d")
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+}
+
+func TestStore_Pending(t *testing.T) {
+	st := newTestStore(t)
+	hashes := hashesOf(t)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	o := &object.Object{
+		Type: "foo",
+		Metadata: object.Metadata{
+			Threshold: object.SignatureThreshold{
+				Keys: []crypto.PublicKey{
+					k1.PublicKey(),
+					k2.PublicKey(),
+				},
+				Threshold: 2,
+			},
+		},
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	s1 := object.Copy(o)
+	require.NoError(t, object.CoSign(k1, s1))
+	require.NoError(t, st.Put(s1))
+
+	assert.Equal(t, []tilde.Digest{o.Hash()}, hashes(st.GetPending()))
+	assert.Empty(t, hashes(st.GetByType("foo")))
+
+	// the second signature should be merged with the first one
+	s2 := object.Copy(o)
+	require.NoError(t, object.CoSign(k2, s2))
+	require.NoError(t, st.Put(s2))
+
+	assert.Empty(t, hashes(st.GetPending()))
+	assert.Equal(t, []tilde.Digest{o.Hash()}, hashes(st.GetByType("foo")))
+
+	got, err := st.Get(o.Hash())
+	require.NoError(t, err)
+	signed, _ := object.SignatureCount(got)
+	assert.Equal(t, 2, signed)
+}
+
+func TestStore_Relations(t *testing.T) {
+	st := newTestStore(t)
+	hashes := hashesOf(t)
+
+	f00 := &object.Object{
+		Type: "f00",
+		Data: tilde.Map{
+			"f00": tilde.String("f00"),
+		},
+	}
+	f01 := &object.Object{
+		Type: "f01",
+		Metadata: object.Metadata{
+			Root:     f00.Hash(),
+			Sequence: 1,
+			Parents: object.Parents{
+				"*": []tilde.Digest{
+					f00.Hash(),
+				},
+			},
+		},
+	}
+	f02 := &object.Object{
+		Type: "f02",
+		Metadata: object.Metadata{
+			Root:     f00.Hash(),
+			Sequence: 2,
+			Parents: object.Parents{
+				"*": []tilde.Digest{
+					f01.Hash(),
+				},
+			},
+		},
+	}
+	other := &object.Object{
+		Type: "other",
+	}
+
+	require.NoError(t, st.Put(f00))
+
+	leaves, err := st.GetStreamLeaves(f00.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{f00.Hash()}, leaves)
+
+	// put them out of order
+	require.NoError(t, st.Put(f02))
+	require.NoError(t, st.Put(f01))
+	require.NoError(t, st.Put(other))
+
+	leaves, err = st.GetStreamLeaves(f00.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{f02.Hash()}, leaves)
+
+	relations, err := st.GetRelations(f00.Hash())
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]tilde.Digest{f00.Hash(), f02.Hash(), f01.Hash()},
+		relations,
+	)
+
+	assert.Equal(
+		t,
+		[]tilde.Digest{f00.Hash(), f01.Hash(), f02.Hash()},
+		hashes(st.GetByStream(f00.Hash())),
+	)
+
+	roots, err := st.ListHashes()
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{f00.Hash(), other.Hash()}, roots)
+}
+
+func TestStore_GC(t *testing.T) {
+	st := newTestStore(t)
+
+	o := &object.Object{
+		Type: "foo",
+	}
+	p := &object.Object{
+		Type: "bar",
+	}
+	require.NoError(t, st.PutWithTTL(o, time.Minute))
+	require.NoError(t, st.PutWithTTL(p, time.Minute))
+	require.NoError(t, st.Pin(p.Hash()))
+
+	st.gc()
+	_, err := st.Get(o.Hash())
+	require.NoError(t, err)
+
+	// pretend they have not been accessed for a while
+	for _, e := range st.objects {
+		e.lastAccessed = time.Now().Add(-2 * time.Minute)
+	}
+
+	updates, cancel := st.ListenForUpdates()
+	defer cancel()
+
+	st.gc()
+	_, err = st.Get(o.Hash())
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+	_, err = st.Get(p.Hash())
+	require.NoError(t, err)
+
+	select {
+	case e := <-updates:
+		assert.Equal(t, objectstore.ObjectRemoved, e.Action)
+		assert.Equal(t, o.Hash(), e.ObjectHash)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+
+	// updating the ttl should keep the object for longer
+	require.NoError(t, st.RemovePin(p.Hash()))
+	require.NoError(t, st.UpdateTTL(p.Hash(), 5))
+	st.gc()
+	_, err = st.Get(p.Hash())
+	require.NoError(t, err)
+}
+
+func TestStore_GC_Relations(t *testing.T) {
+	st := newTestStore(t)
+
+	r := &object.Object{
+		Type: "foo",
+	}
+	c := &object.Object{
+		Type: "bar",
+		Metadata: object.Metadata{
+			Root:     r.Hash(),
+			Sequence: 1,
+			Parents: object.Parents{
+				"*": []tilde.Digest{
+					r.Hash(),
+				},
+			},
+		},
+	}
+	require.NoError(t, st.Put(r))
+	require.NoError(t, st.PutWithTTL(c, time.Minute))
+
+	leaves, err := st.GetStreamLeaves(r.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{c.Hash()}, leaves)
+
+	st.objects[c.Hash()].lastAccessed = time.Now().Add(-2 * time.Minute)
+	st.gc()
+
+	leaves, err = st.GetStreamLeaves(r.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{r.Hash()}, leaves)
+
+	require.NoError(t, st.Remove(r.Hash()))
+	assert.Empty(t, st.relations)
+}
+
+func TestStore_GC_Reachable(t *testing.T) {
+	st := newTestStore(t)
+
+	c1 := &object.Object{
+		Type: "chunk",
+		Data: tilde.Map{
+			"data": tilde.String("a"),
+		},
+	}
+	c2 := &object.Object{
+		Type: "chunk",
+		Data: tilde.Map{
+			"data": tilde.String("b"),
+		},
+	}
+	blob := &object.Object{
+		Type: "blob",
+		Data: tilde.Map{
+			"chunks": tilde.DigestArray{c1.Hash(), c2.Hash()},
+		},
+	}
+	root := &object.Object{
+		Type: "root",
+	}
+	event := &object.Object{
+		Type: "event",
+		Metadata: object.Metadata{
+			Root: root.Hash(),
+			Parents: object.Parents{
+				"*": tilde.DigestArray{root.Hash()},
+			},
+		},
+	}
+	garbage := &object.Object{
+		Type: "garbage",
+	}
+	os := []*object.Object{c1, c2, blob, root, event, garbage}
+	for _, o := range os {
+		require.NoError(t, st.PutWithTTL(o, time.Minute))
+	}
+	require.NoError(t, st.Pin(blob.Hash()))
+	require.NoError(t, st.Pin(root.Hash()))
+
+	// pretend they have not been accessed for a while
+	for _, e := range st.objects {
+		e.lastAccessed = time.Now().Add(-2 * time.Minute)
+	}
+
+	st.gc()
+	for _, o := range os[:5] {
+		_, err := st.Get(o.Hash())
+		require.NoError(t, err, o.Type)
+	}
+	_, err := st.Get(garbage.Hash())
+	require.ErrorIs(t, err, objectstore.ErrNotFound)
+
+	// once the pins are removed nothing keeps them around
+	require.NoError(t, st.RemovePin(blob.Hash()))
+	require.NoError(t, st.RemovePin(root.Hash()))
+	st.gc()
+	assert.Empty(t, st.objects)
+}
+
+func TestStore_Pinned(t *testing.T) {
+	st := newTestStore(t)
+
+	require.NoError(t, st.Pin("a"))
+	require.NoError(t, st.Pin("b"))
+	require.NoError(t, st.Pin("a"))
+
+	pinned, err := st.IsPinned("a")
+	require.NoError(t, err)
+	assert.True(t, pinned)
+
+	pinned, err = st.IsPinned("x")
+	require.NoError(t, err)
+	assert.False(t, pinned)
+
+	got, err := st.GetPinned()
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{"a", "b"}, got)
+
+	require.NoError(t, st.RemovePin("a"))
+	got, err = st.GetPinned()
+	require.NoError(t, err)
+	assert.Equal(t, []tilde.Digest{"b"}, got)
+}
+
+func TestStore_Filter(t *testing.T) {
+	st := newTestStore(t)
+	hashes := hashesOf(t)
+
+	objects := []*object.Object{}
+	for i := 0; i < 5; i++ {
+		o := &object.Object{
+			Type: []string{"foo", "bar"}[i%2],
+			Metadata: object.Metadata{
+				Timestamp: time.Unix(int64(100-i), 0).Format(time.RFC3339),
+			},
+			Data: tilde.Map{
+				"n":    tilde.Int(i),
+				"body": tilde.String([]string{"hello", "world"}[i%2]),
+				"author": tilde.Map{
+					"name": tilde.String([]string{"a", "b", "c"}[i%3]),
+				},
+			},
+		}
+		require.NoError(t, st.Put(o))
+		objects = append(objects, o)
+	}
+	h := func(is ...int) []tilde.Digest {
+		hs := []tilde.Digest{}
+		for _, i := range is {
+			hs = append(hs, objects[i].Hash())
+		}
+		return hs
+	}
+
+	tests := []struct {
+		name    string
+		options []objectstore.FilterOption
+		want    []tilde.Digest
+		wantErr error
+	}{{
+		name: "type",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByObjectType("bar"),
+		},
+		want: h(1, 3),
+	}, {
+		name: "hash",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByHash(objects[2].Hash(), objects[4].Hash()),
+		},
+		want: h(2, 4),
+	}, {
+		name: "order by datetime",
+		options: []objectstore.FilterOption{
+			objectstore.FilterOrderBy("MetadataDatetime"),
+			objectstore.FilterOrderDir("ASC"),
+		},
+		want: h(4, 3, 2, 1, 0),
+	}, {
+		name: "limit and offset",
+		options: []objectstore.FilterOption{
+			objectstore.FilterOrderBy("MetadataDatetime"),
+			objectstore.FilterOrderDir("DESC"),
+			objectstore.FilterLimit(2, 1),
+		},
+		want: h(1, 2),
+	}, {
+		name: "page",
+		options: []objectstore.FilterOption{
+			objectstore.FilterOrderBy("MetadataDatetime"),
+			objectstore.FilterPage(objects[3].Hash(), 2),
+		},
+		want: h(2, 1),
+	}, {
+		name: "page after missing object",
+		options: []objectstore.FilterOption{
+			objectstore.FilterPage("foo", 2),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}, {
+		name: "data range",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataGreaterThan,
+				tilde.Int(1),
+			),
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataLessThan,
+				tilde.Int(4),
+			),
+		},
+		want: h(2, 3),
+	}, {
+		name: "data prefix",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"body:s",
+				objectstore.DataHasPrefix,
+				tilde.String("wor"),
+			),
+		},
+		want: h(1, 3),
+	}, {
+		name: "nested data",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"author:m/name:s",
+				objectstore.DataNotEqual,
+				tilde.String("a"),
+			),
+		},
+		want: h(1, 2, 4),
+	}, {
+		name: "missing data",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"missing:s",
+				objectstore.DataNotEqual,
+				tilde.String("a"),
+			),
+		},
+		wantErr: objectstore.ErrNotFound,
+	}, {
+		name: "invalid data filter",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataEqual,
+				tilde.String("1"),
+			),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}, {
+		name: "invalid order",
+		options: []objectstore.FilterOption{
+			objectstore.FilterOrderBy("foo"),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := st.Filter(tt.options...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, hashesOf(t)(reader, err))
+		})
+	}
+
+	t.Run("all pages", func(t *testing.T) {
+		got := []tilde.Digest{}
+		after := tilde.Digest("")
+		for {
+			page := hashes(st.Filter(
+				objectstore.FilterOrderDir("DESC"),
+				objectstore.FilterPage(after, 2),
+			))
+			if len(page) == 0 {
+				break
+			}
+			got = append(got, page...)
+			after = page[len(page)-1]
+		}
+		all := hashes(st.Filter(objectstore.FilterOrderDir("DESC")))
+		assert.Len(t, all, 5)
+		assert.Equal(t, all, got)
+	})
+}
+
+func TestStore_ListenForUpdates(t *testing.T) {
+	st := newTestStore(t)
+
+	updates, cancel := st.ListenForUpdates()
+	defer cancel()
+
+	o := &object.Object{
+		Type: "foo",
+	}
+	require.NoError(t, st.Put(o))
+	require.NoError(t, st.Pin(o.Hash()))
+	require.NoError(t, st.RemovePin(o.Hash()))
+	require.NoError(t, st.Remove(o.Hash()))
+
+	for _, action := range []objectstore.EventAction{
+		objectstore.ObjectInserted,
+		objectstore.ObjectPinned,
+		objectstore.ObjectUnpinned,
+		objectstore.ObjectRemoved,
+	} {
+		select {
+		case e := <-updates:
+			assert.Equal(t, action, e.Action)
+			assert.Equal(t, o.Hash(), e.ObjectHash)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for update")
+		}
+	}
+}
+
+func TestStore_Outbox(t *testing.T) {
+	st := newTestStore(t)
+
+	k, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	recipient := k.PublicKey().DID()
+
+	o := &object.Object{
+		Type: "foo",
+	}
+	require.NoError(t, st.PutOutbox(recipient, o))
+	require.NoError(t, st.PutOutbox(recipient, o))
+
+	entries, err := st.GetOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, recipient, entries[0].Recipient)
+	assert.Equal(t, o.Hash(), entries[0].Object.Hash())
+
+	require.NoError(t, st.RemoveOutbox(recipient, o.Hash()))
+	entries, err = st.GetOutbox()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
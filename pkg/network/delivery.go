@@ -0,0 +1,432 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+// Objects sent with SendWithReliableDelivery are wrapped in a DeliveryRequest
+// that the recipient answers with a signed DeliveryAck once it has published
+// the object to its inboxes.
+//
+// Every attempt goes through the same direct, lookup and relay paths a normal
+// Send does, and attempts are retried with an exponential backoff until the
+// object is acknowledged or we run out of attempts. Recipients only publish
+// the first copy of every object they receive from each sender, but they ack
+// all of them.
+// If the network has an outbox, objects are kept in it until acknowledged and
+// retried in the background, including after the network is restarted.
+
+const (
+	deliveryDefaultAttempts = 5
+	deliveryDefaultTimeout  = time.Second
+	// deliveryMaxBackoff caps how long to wait between attempts
+	deliveryMaxBackoff = 30 * time.Second
+	// deliveryDedupDuration is how long recipients remember the objects they
+	// have already published
+	deliveryDedupDuration = time.Hour
+	// outboxRetryInterval is how often pending outbox objects are retried
+	outboxRetryInterval = time.Minute
+	// outboxMaxAge is how long objects are kept in the outbox before giving
+	// up on them
+	outboxMaxAge = 7 * 24 * time.Hour
+)
+
+const (
+	// DeliveryAcknowledged objects have been acknowledged by the recipient
+	DeliveryAcknowledged DeliveryStatus = "acknowledged"
+	// DeliveryQueued objects have not been acknowledged yet, but are kept in
+	// the outbox and will be retried in the background
+	DeliveryQueued DeliveryStatus = "queued"
+	// DeliveryFailed objects have not been acknowledged and will not be
+	// retried
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+var (
+	objDeliveryAckedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_delivery_acked_total",
+			Help: "Total number of (top level) objects acknowledged",
+		},
+	)
+	objDeliveryRetriedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_delivery_retried_total",
+			Help: "Total number of delivery attempts that had to be retried",
+		},
+	)
+	objDeliveryFailedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_delivery_failed_total",
+			Help: "Total number of (top level) objects never acknowledged",
+		},
+	)
+)
+
+type (
+	// DeliveryStatus of an object sent with SendWithReliableDelivery
+	DeliveryStatus string
+	// DeliveryResult is filled in by Send for objects sent with
+	// SendWithReliableDelivery
+	DeliveryResult struct {
+		Status   DeliveryStatus
+		Attempts int
+		// Ack is the recipient's signed acknowledgement, only set for
+		// acknowledged objects
+		Ack *object.Object
+	}
+	// deliveries keeps track of the objects currently being delivered, so
+	// that the outbox does not retry them at the same time
+	deliveries struct {
+		mutex    sync.Mutex
+		inflight map[string]int
+	}
+)
+
+func (d *deliveries) start(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.inflight[key]++
+	return d.inflight[key] == 1
+}
+
+func (d *deliveries) done(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.inflight[key]--
+	if d.inflight[key] <= 0 {
+		delete(d.inflight, key)
+	}
+}
+
+func deliveryKey(id did.DID, hash tilde.Digest) string {
+	return id.String() + "/" + hash.String()
+}
+
+// sendReliably keeps the object in the outbox, if we have one, and attempts
+// to deliver it until it is acknowledged
+func (w *network) sendReliably(
+	ctx context.Context,
+	o *object.Object,
+	id did.DID,
+	opt *sendOptions,
+) error {
+	result := opt.delivery
+	result.Status = DeliveryFailed
+
+	// the object needs to be signed before it is stored, so that its hash
+	// does not change
+	if k := w.peerKey; !k.IsEmpty() {
+		if err := object.SignDeep(k, o); err != nil {
+			return err
+		}
+	}
+
+	rSub, err := w.subscribeForResponse(o, opt)
+	if err != nil {
+		return err
+	}
+
+	key := deliveryKey(id, o.Hash())
+	w.deliveries.start(key)
+	defer w.deliveries.done(key)
+
+	if w.outbox != nil {
+		if err := w.outbox.PutOutbox(id, o); err != nil {
+			return fmt.Errorf("error adding object to outbox: %w", err)
+		}
+	}
+
+	if err := w.deliver(ctx, o, id, opt, result); err != nil {
+		if w.outbox != nil {
+			result.Status = DeliveryQueued
+		}
+		return err
+	}
+
+	if rSub == nil {
+		return nil
+	}
+	return w.waitForResponse(ctx, rSub, opt)
+}
+
+// deliver attempts to deliver the object until it is acknowledged or we run
+// out of attempts, acknowledged objects are removed from the outbox
+func (w *network) deliver(
+	ctx context.Context,
+	o *object.Object,
+	id did.DID,
+	opt *sendOptions,
+	result *DeliveryResult,
+) error {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.deliver"),
+		log.String("recipient", id.String()),
+		log.String("object.hash", o.Hash().String()),
+	)
+
+	var err error
+	backoff := w.deliveryTimeout
+	for attempt := 1; attempt <= w.deliveryAttempts; attempt++ {
+		if attempt > 1 {
+			objDeliveryRetriedCounter.Inc()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-w.closer:
+				return ErrDeliveryNotAcknowledged
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > deliveryMaxBackoff {
+				backoff = deliveryMaxBackoff
+			}
+		}
+
+		result.Attempts++
+		var ack *object.Object
+		ack, err = w.attemptDelivery(ctx, o, id, opt)
+		if err != nil {
+			logger.Debug(
+				"delivery attempt failed",
+				log.Int("attempt", attempt),
+				log.Error(err),
+			)
+			continue
+		}
+
+		objDeliveryAckedCounter.Inc()
+		result.Status = DeliveryAcknowledged
+		result.Ack = ack
+		if w.outbox != nil {
+			// nolint: errcheck
+			w.outbox.RemoveOutbox(id, o.Hash())
+		}
+		return nil
+	}
+
+	if w.outbox == nil {
+		objDeliveryFailedCounter.Inc()
+	}
+	if err == nil {
+		return ErrDeliveryNotAcknowledged
+	}
+	return errors.Merge(ErrDeliveryNotAcknowledged, err)
+}
+
+// attemptDelivery sends the object wrapped in a DeliveryRequest and waits
+// for its acknowledgement
+func (w *network) attemptDelivery(
+	ctx context.Context,
+	o *object.Object,
+	id did.DID,
+	opt *sendOptions,
+) (*object.Object, error) {
+	req := &DeliveryRequest{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID: rand.String(8),
+		Payload:   o,
+	}
+	reqo, err := object.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ackSub := w.Subscribe(
+		FilterByObjectType(DeliveryAckType),
+		FilterByRequestID(req.RequestID),
+	)
+	defer ackSub.Cancel()
+
+	sendOpts := []SendOption{
+		SendWithPriority(opt.priority),
+	}
+	if opt.connectionInfo != nil {
+		sendOpts = append(sendOpts, SendWithConnectionInfo(opt.connectionInfo))
+	}
+	if opt.mailboxTTL > 0 {
+		sendOpts = append(sendOpts, SendWithMailbox(opt.mailboxTTL))
+	}
+	if err := w.Send(ctx, reqo, id, sendOpts...); err != nil {
+		return nil, err
+	}
+
+	hash := o.Hash()
+	acks := ackSub.Channel()
+	t := time.NewTimer(w.deliveryTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+			return nil, ErrDeliveryNotAcknowledged
+		case e := <-acks:
+			if err := verifyDeliveryAck(e.Payload, id, hash); err != nil {
+				log.DefaultLogger.Warn(
+					"ignoring invalid DeliveryAck",
+					log.String("sender", e.Sender.String()),
+					log.Error(err),
+				)
+				continue
+			}
+			return e.Payload, nil
+		}
+	}
+}
+
+// verifyDeliveryAck checks that the ack has been signed by the recipient and
+// is for the given object, acks for key streams can come from any of their
+// peers
+func verifyDeliveryAck(
+	o *object.Object,
+	recipient did.DID,
+	hash tilde.Digest,
+) error {
+	ack := &DeliveryAck{}
+	if err := object.Unmarshal(o, ack); err != nil {
+		return err
+	}
+	if ack.Metadata.Owner.IsEmpty() {
+		return ErrInvalidDeliveryAck
+	}
+	if err := object.Verify(o); err != nil {
+		return errors.Merge(ErrInvalidDeliveryAck, err)
+	}
+	if recipient.IdentityType == did.IdentityTypePeer &&
+		!ack.Metadata.Owner.Equals(recipient) {
+		return ErrInvalidDeliveryAck
+	}
+	if ack.ObjectHash != hash {
+		return ErrInvalidDeliveryAck
+	}
+	return nil
+}
+
+// handleDeliveryRequest publishes the object to our inboxes, unless we have
+// already done so, and acknowledges it
+func (w *network) handleDeliveryRequest(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleDeliveryRequest"),
+		log.String("sender", e.Sender.String()),
+	)
+
+	// the request might have come through a relay, so the only way to know
+	// who sent it is by its signature
+	if err := object.Verify(e.Payload); err != nil {
+		logger.Warn("error verifying DeliveryRequest", log.Error(err))
+		return
+	}
+
+	req := &DeliveryRequest{}
+	if err := object.Unmarshal(e.Payload, req); err != nil {
+		logger.Warn("error decoding DeliveryRequest", log.Error(err))
+		return
+	}
+	if req.Payload == nil || req.Metadata.Owner.IsEmpty() {
+		logger.Warn("ignoring incomplete DeliveryRequest")
+		return
+	}
+
+	sender := req.Metadata.Owner
+	hash := req.Payload.Hash()
+	dedupKey := "delivery/" + deliveryKey(sender, hash)
+	if err := w.deduplist.Add(
+		dedupKey,
+		struct{}{},
+		deliveryDedupDuration,
+	); err == nil {
+		w.inboxes.Publish(&Envelope{
+			Sender:  sender,
+			Payload: req.Payload,
+		})
+	}
+
+	ack := &DeliveryAck{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID:  req.RequestID,
+		ObjectHash: hash,
+	}
+	acko, err := object.Marshal(ack)
+	if err != nil {
+		logger.Warn("error marshaling DeliveryAck", log.Error(err))
+		return
+	}
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			acko,
+			sender,
+			SendWithPriority(PriorityHigh),
+		)
+		if err != nil {
+			logger.Warn("error sending DeliveryAck", log.Error(err))
+		}
+	}()
+}
+
+// processOutbox retries delivering the objects in the outbox until the
+// network is closed
+func (w *network) processOutbox(outbox objectstore.Outbox) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.processOutbox"),
+	)
+	for {
+		entries, err := outbox.GetOutbox()
+		if err != nil {
+			logger.Warn("error getting outbox entries", log.Error(err))
+		}
+		for _, e := range entries {
+			if time.Since(e.Created) > outboxMaxAge {
+				objDeliveryFailedCounter.Inc()
+				// nolint: errcheck
+				outbox.RemoveOutbox(e.Recipient, e.Object.Hash())
+				continue
+			}
+			key := deliveryKey(e.Recipient, e.Object.Hash())
+			if !w.deliveries.start(key) {
+				w.deliveries.done(key)
+				continue
+			}
+			go func(e *objectstore.OutboxEntry) {
+				defer w.deliveries.done(key)
+				// nolint: errcheck
+				w.deliver(
+					context.New(),
+					e.Object,
+					e.Recipient,
+					&sendOptions{
+						priority: PriorityLow,
+					},
+					&DeliveryResult{},
+				)
+			}(e)
+		}
+		select {
+		case <-w.closer:
+			return
+		case <-time.After(outboxRetryInterval):
+		}
+	}
+}
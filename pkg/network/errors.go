@@ -23,4 +23,49 @@ const (
 	// cannot be unmarshalled into given struct
 	ErrUnableToUnmarshalIntoResponse = errors.Error("unable to unmarshal into" +
 		" given response")
+	// ErrRelayUnavailable is returned by peers that do not relay objects
+	ErrRelayUnavailable = errors.Error("relay unavailable")
+	// ErrRelayDenied is returned by relays for peers they have been
+	// configured to not relay objects for
+	ErrRelayDenied = errors.Error("relay denied")
+	// ErrRelayNoReservation is returned by relays when the recipient does
+	// not have an active reservation with them
+	ErrRelayNoReservation = errors.Error("relay reservation missing")
+	// ErrRelayReservationsFull is returned by relays that cannot accept any
+	// more reservations
+	ErrRelayReservationsFull = errors.Error("relay reservations full")
+	// ErrRelayQuotaExceeded is returned by relays when either the sender or
+	// the recipient has used up their quota
+	ErrRelayQuotaExceeded = errors.Error("relay quota exceeded")
+	// ErrRelayRecipientUnreachable is returned by relays that do not have a
+	// connection to the recipient
+	ErrRelayRecipientUnreachable = errors.Error("relay recipient unreachable")
+	// ErrMailboxUnavailable is returned by peers that do not keep objects
+	// for others
+	ErrMailboxUnavailable = errors.Error("mailbox unavailable")
+	// ErrMailboxFull is returned by mailboxes that do not have enough space
+	// left for the deposited object
+	ErrMailboxFull = errors.Error("mailbox full")
+	// ErrDeliveryNotAcknowledged is returned when objects sent with reliable
+	// delivery have not been acknowledged by their recipient
+	ErrDeliveryNotAcknowledged = errors.Error("delivery not acknowledged")
+	// ErrInvalidDeliveryAck is returned for acks that have not been signed
+	// by the recipient or are for a different object
+	ErrInvalidDeliveryAck = errors.Error("invalid delivery acknowledgement")
+	// ErrCallFailed wraps the errors returned by remote handlers
+	ErrCallFailed = errors.Error("call failed")
+	// ErrCallTimedOut is returned when a call does not complete in time
+	ErrCallTimedOut = errors.Error("call timed out")
+	// ErrCallNoResponse is returned by Call when the remote handler did not
+	// write a response
+	ErrCallNoResponse = errors.Error("call did not return a response")
+	// ErrCallUnhandled is returned by peers that do not handle calls with
+	// the given request type
+	ErrCallUnhandled = errors.Error("call not handled")
+	// ErrCallLimitExceeded is returned by peers that are already handling
+	// too many calls
+	ErrCallLimitExceeded = errors.Error("too many concurrent calls")
+	// ErrRateLimited is returned when objects could not be sent before the
+	// context was done, as they were being held back by the rate limits
+	ErrRateLimited = errors.Error("rate limited")
 )
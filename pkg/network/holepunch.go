@@ -0,0 +1,251 @@
+package network
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+)
+
+// Peers that can only reach each other via a relay try to establish a direct
+// connection by punching holes through their nats.
+//
+// The peer that had to use the relay sends it a HolePunchRequest, the relay
+// then sends a HolePunchSync to both peers containing the address it observes
+// for the other one. Both peers start dialing each other as soon as they get
+// it, which opens their nats for each other's attempts.
+// Successful punches leave a direct connection behind in the underlying net,
+// which is what later sends to the same peer will be using.
+
+const (
+	// holePunchTimeout is how long peers will keep dialing each other
+	holePunchTimeout = 5 * time.Second
+	// holePunchBackoff is how long to wait before asking a relay to
+	// coordinate another hole punch with the same peer
+	holePunchBackoff = time.Minute
+)
+
+var (
+	objHolePunchSuccessCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_hole_punch_success_total",
+			Help: "Total number of direct connections established via a relay",
+		},
+	)
+	objHolePunchFailedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_hole_punch_failed_total",
+			Help: "Total number of failed hole punching attempts",
+		},
+	)
+)
+
+// punchViaRelay asks the relay to coordinate a hole punch between us and the
+// recipient, unless we have recently done so already
+func (w *network) punchViaRelay(
+	relay *peer.ConnectionInfo,
+	recipient crypto.PublicKey,
+) {
+	err := w.holePunches.Add(
+		recipient.String(),
+		struct{}{},
+		holePunchBackoff,
+	)
+	if err != nil {
+		return
+	}
+
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.punchViaRelay"),
+		log.String("relay", relay.Metadata.Owner.String()),
+		log.String("recipient", recipient.String()),
+	)
+
+	req := &HolePunchRequest{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID:     rand.String(8),
+		Recipient:     recipient,
+		ObjectFormats: w.net.ObjectFormats(),
+	}
+	reqo, err := object.Marshal(req)
+	if err != nil {
+		logger.Warn("error marshaling HolePunchRequest", log.Error(err))
+		return
+	}
+
+	// the relay is the only one we accept a sync for our request from
+	w.holePunchRequests.Set(
+		req.RequestID,
+		relay.Metadata.Owner.String(),
+		cache.DefaultExpiration,
+	)
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			reqo,
+			relay.Metadata.Owner,
+			SendWithConnectionInfo(relay),
+			SendWithPriority(PriorityHigh),
+		)
+		if err != nil {
+			logger.Warn("error sending HolePunchRequest", log.Error(err))
+		}
+	}()
+}
+
+// handleHolePunchRequest is called on relays, it lets both peers know the
+// address we observe for the other, as long as the recipient has a
+// reservation and we have a connection to both
+func (w *network) handleHolePunchRequest(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleHolePunchRequest"),
+		log.String("sender", e.Sender.String()),
+	)
+
+	req := &HolePunchRequest{}
+	if err := object.Unmarshal(e.Payload, req); err != nil {
+		logger.Warn("error decoding HolePunchRequest", log.Error(err))
+		return
+	}
+
+	// only peers with a reservation can be reached through us
+	if w.relay == nil || !w.relay.hasReservation(req.Recipient.DID()) {
+		logger.Debug("recipient does not have a reservation")
+		return
+	}
+
+	senderKey, err := crypto.PublicKeyFromDID(e.Sender)
+	if err != nil {
+		logger.Warn("error getting sender's key", log.Error(err))
+		return
+	}
+
+	senderConn, err := w.net.GetConnection(*senderKey)
+	if err != nil {
+		logger.Debug("no connection to sender", log.Error(err))
+		return
+	}
+
+	recipientConn, err := w.net.GetConnection(req.Recipient)
+	if err != nil {
+		logger.Debug("no connection to recipient", log.Error(err))
+		return
+	}
+
+	syncs := []*HolePunchSync{{
+		RequestID:     req.RequestID,
+		Peer:          *senderKey,
+		Addresses:     []string{senderConn.RemoteAddr()},
+		ObjectFormats: req.ObjectFormats,
+	}, {
+		RequestID:     req.RequestID,
+		Peer:          req.Recipient,
+		Addresses:     []string{recipientConn.RemoteAddr()},
+		ObjectFormats: w.relay.getObjectFormats(req.Recipient.DID()),
+	}}
+	recipients := []crypto.PublicKey{
+		req.Recipient,
+		*senderKey,
+	}
+
+	// both syncs are sent at the same time so that the peers start punching
+	// at about the same time
+	for i, s := range syncs {
+		s.Metadata = object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		}
+		so, err := object.Marshal(s)
+		if err != nil {
+			logger.Warn("error marshaling HolePunchSync", log.Error(err))
+			continue
+		}
+		go func(k crypto.PublicKey, so *object.Object) {
+			err := w.Send(
+				context.New(
+					context.WithTimeout(time.Second),
+				),
+				so,
+				k.DID(),
+				SendWithPriority(PriorityHigh),
+			)
+			if err != nil {
+				logger.Warn(
+					"error sending HolePunchSync",
+					log.String("recipient", k.String()),
+					log.Error(err),
+				)
+			}
+		}(recipients[i], so)
+	}
+}
+
+// handleHolePunchSync starts punching, syncs are only accepted from our own
+// relays or from the relay we have asked to coordinate the punch
+func (w *network) handleHolePunchSync(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleHolePunchSync"),
+		log.String("relay", e.Sender.String()),
+	)
+
+	s := &HolePunchSync{}
+	if err := object.Unmarshal(e.Payload, s); err != nil {
+		logger.Warn("error decoding HolePunchSync", log.Error(err))
+		return
+	}
+
+	allowed := false
+	if relay, ok := w.holePunchRequests.Get(s.RequestID); ok {
+		allowed = relay.(string) == e.Sender.String()
+		w.holePunchRequests.Delete(s.RequestID)
+	}
+	for _, relay := range w.GetRelays() {
+		if relay.Metadata.Owner.Equals(e.Sender) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		logger.Debug("ignoring HolePunchSync from unknown relay")
+		return
+	}
+
+	logger = logger.With(
+		log.String("peer", s.Peer.String()),
+		log.Strings("addresses", s.Addresses),
+	)
+
+	go func() {
+		_, err := w.net.Punch(
+			context.New(
+				context.WithTimeout(holePunchTimeout),
+			),
+			&peer.ConnectionInfo{
+				Metadata: object.Metadata{
+					Owner: s.Peer.DID(),
+				},
+				Addresses:     s.Addresses,
+				ObjectFormats: s.ObjectFormats,
+			},
+		)
+		if err != nil {
+			objHolePunchFailedCounter.Inc()
+			logger.Debug("unable to punch hole", log.Error(err))
+			return
+		}
+		objHolePunchSuccessCounter.Inc()
+		logger.Info("established direct connection")
+	}()
+}
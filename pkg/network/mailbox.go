@@ -0,0 +1,537 @@
+package network
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+)
+
+// Objects for peers that are offline can be left with one of their relays,
+// if it also acts as a mailbox.
+//
+// Senders deposit the same encrypted DataForwardEnvelope they would have
+// relayed, which the mailbox keeps until it expires or the recipient connects
+// to it again, ie when it announces itself or renews its reservation. Every
+// delivery is acknowledged by the recipient, after which the mailbox lets the
+// sender know with a MailboxReceipt.
+// Only peers that have had a reservation with the relay within the mailbox's
+// max ttl can receive deposits, and the relay's deny list applies to both
+// senders and recipients.
+// Mailboxes are only kept in memory.
+
+const (
+	mailboxDefaultMaxTTL            = 7 * 24 * time.Hour
+	mailboxDefaultMaxRecipientBytes = 16 << 20
+	mailboxDefaultMaxSenderBytes    = 4 << 20
+	mailboxDefaultMaxBytes          = 256 << 20
+	// mailboxRedeliveryInterval is how long to wait for a delivery to be
+	// acknowledged before attempting it again
+	mailboxRedeliveryInterval = 30 * time.Second
+)
+
+var (
+	mailboxObjectsGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nimona_exchange_mailbox_objects",
+			Help: "Number of objects currently held in mailboxes",
+		},
+	)
+	mailboxBytesGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nimona_exchange_mailbox_bytes",
+			Help: "Number of bytes currently held in mailboxes",
+		},
+	)
+	objMailboxDepositedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_mailbox_deposited_total",
+			Help: "Total number of objects deposited in mailboxes",
+		},
+	)
+	objMailboxDeliveredCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_mailbox_delivered_total",
+			Help: "Total number of mailbox objects acknowledged by recipients",
+		},
+	)
+	objMailboxExpiredCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_mailbox_expired_total",
+			Help: "Total number of mailbox objects that expired undelivered",
+		},
+	)
+	objSendDepositedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_send_deposited_total",
+			Help: "Total number of (top level) objects left in a mailbox",
+		},
+	)
+)
+
+type (
+	// MailboxOption for customizing WithMailbox
+	MailboxOption func(*mailbox)
+	// mailbox holds the objects deposited for each recipient
+	mailbox struct {
+		mutex             sync.Mutex
+		maxTTL            time.Duration
+		maxRecipientBytes int
+		maxSenderBytes    int
+		maxBytes          int
+		bytes             int
+		objects           map[string][]*mailboxObject
+		// recipients are the peers that can receive deposits, along with
+		// when they last got a reservation
+		recipients map[string]time.Time
+	}
+	mailboxObject struct {
+		// id is chosen by the mailbox and used for delivering the object,
+		// the request id is the sender's one
+		id          string
+		requestID   string
+		sender      did.DID
+		deposit     *MailboxDepositRequest
+		size        int
+		expires     time.Time
+		deliveredAt time.Time
+	}
+)
+
+func newMailbox(opts ...MailboxOption) *mailbox {
+	m := &mailbox{
+		maxTTL:            mailboxDefaultMaxTTL,
+		maxRecipientBytes: mailboxDefaultMaxRecipientBytes,
+		maxSenderBytes:    mailboxDefaultMaxSenderBytes,
+		maxBytes:          mailboxDefaultMaxBytes,
+		objects:           map[string][]*mailboxObject{},
+		recipients:        map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// register allows the peer to receive deposits, it is called every time the
+// peer gets a reservation
+func (m *mailbox) register(recipient did.DID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recipients[recipient.String()] = time.Now()
+}
+
+// deposit stores the object until it expires, the ttl is capped to the
+// mailbox's maximum one
+func (m *mailbox) deposit(
+	sender did.DID,
+	req *MailboxDepositRequest,
+	size int,
+) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+
+	if _, ok := m.recipients[req.Recipient.DID().String()]; !ok {
+		return ErrRelayNoReservation
+	}
+
+	k := req.Recipient.String()
+	recipientBytes := 0
+	for _, o := range m.objects[k] {
+		recipientBytes += o.size
+	}
+	senderBytes := 0
+	for _, objs := range m.objects {
+		for _, o := range objs {
+			if o.sender == sender {
+				senderBytes += o.size
+			}
+		}
+	}
+	if recipientBytes+size > m.maxRecipientBytes ||
+		senderBytes+size > m.maxSenderBytes ||
+		m.bytes+size > m.maxBytes {
+		return ErrMailboxFull
+	}
+
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+	if ttl <= 0 || ttl > m.maxTTL {
+		ttl = m.maxTTL
+	}
+
+	m.objects[k] = append(m.objects[k], &mailboxObject{
+		id:        rand.String(16),
+		requestID: req.RequestID,
+		sender:    sender,
+		deposit:   req,
+		size:      size,
+		expires:   now.Add(ttl),
+	})
+	m.bytes += size
+	m.updateGauges()
+	return nil
+}
+
+// undelivered returns the objects for the recipient that have not been
+// delivered recently, and marks them as being delivered
+func (m *mailbox) undelivered(recipient crypto.PublicKey) []*mailboxObject {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+
+	objs := []*mailboxObject{}
+	for _, o := range m.objects[recipient.String()] {
+		if now.Sub(o.deliveredAt) < mailboxRedeliveryInterval {
+			continue
+		}
+		o.deliveredAt = now
+		objs = append(objs, o)
+	}
+	return objs
+}
+
+// acknowledge removes the object with the given delivery id from the
+// recipient's mailbox and returns it
+func (m *mailbox) acknowledge(
+	recipient crypto.PublicKey,
+	id string,
+) *mailboxObject {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	k := recipient.String()
+	for i, o := range m.objects[k] {
+		if o.id != id {
+			continue
+		}
+		m.objects[k] = append(m.objects[k][:i], m.objects[k][i+1:]...)
+		if len(m.objects[k]) == 0 {
+			delete(m.objects, k)
+		}
+		m.bytes -= o.size
+		m.updateGauges()
+		return o
+	}
+	return nil
+}
+
+// expire removes expired objects, and the recipients that have not had a
+// reservation for longer than the max ttl; must be called with the mutex held
+func (m *mailbox) expire(now time.Time) {
+	for k, registered := range m.recipients {
+		if now.Sub(registered) > m.maxTTL {
+			delete(m.recipients, k)
+		}
+	}
+	for k, objs := range m.objects {
+		kept := objs[:0]
+		for _, o := range objs {
+			if now.After(o.expires) {
+				m.bytes -= o.size
+				objMailboxExpiredCounter.Inc()
+				continue
+			}
+			kept = append(kept, o)
+		}
+		if len(kept) == 0 {
+			delete(m.objects, k)
+			continue
+		}
+		m.objects[k] = kept
+	}
+	m.updateGauges()
+}
+
+// updateGauges must be called with the mutex held
+func (m *mailbox) updateGauges() {
+	n := 0
+	for _, objs := range m.objects {
+		n += len(objs)
+	}
+	mailboxObjectsGauge.Set(float64(n))
+	mailboxBytesGauge.Set(float64(m.bytes))
+}
+
+// depositInMailbox leaves the object with the relay for the recipient to
+// pick up once it is back online
+func (w *network) depositInMailbox(
+	ctx context.Context,
+	relay *peer.ConnectionInfo,
+	recipient crypto.PublicKey,
+	o *object.Object,
+	ttl time.Duration,
+) error {
+	df, err := w.wrapInDataForward(o, recipient)
+	if err != nil {
+		return err
+	}
+	req := &MailboxDepositRequest{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID:  rand.String(8),
+		Recipient:  recipient,
+		ObjectHash: o.Hash(),
+		Envelope:   df.Payload,
+		ExpiresIn:  int64(ttl / time.Second),
+	}
+	reqo, err := object.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res := &MailboxDepositResponse{}
+	err = w.Send(
+		ctx,
+		reqo,
+		relay.Metadata.Owner,
+		SendWithConnectionInfo(relay),
+		SendWithResponse(res, time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	if !res.Success {
+		return errors.Error(res.Error)
+	}
+	return nil
+}
+
+// handleMailboxDepositRequest stores the deposited object and attempts to
+// deliver it right away in case the recipient is connected to us
+func (w *network) handleMailboxDepositRequest(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleMailboxDepositRequest"),
+		log.String("sender", e.Sender.String()),
+	)
+
+	req := &MailboxDepositRequest{}
+	if err := object.Unmarshal(e.Payload, req); err != nil {
+		logger.Warn("error decoding MailboxDepositRequest", log.Error(err))
+		return
+	}
+
+	var err error = ErrMailboxUnavailable
+	switch {
+	case w.mailbox == nil || w.relay == nil || req.Envelope == nil:
+	case w.relay.denies(e.Sender, req.Recipient.DID()):
+		err = ErrRelayDenied
+	default:
+		b, _ := json.Marshal(req.Envelope) // nolint: errcheck
+		err = w.mailbox.deposit(e.Sender, req, len(b))
+	}
+
+	res := &MailboxDepositResponse{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID: req.RequestID,
+		Success:   err == nil,
+	}
+	if err != nil {
+		logger.Info("refusing mailbox deposit", log.Error(err))
+		res.Error = err.Error()
+	} else {
+		objMailboxDepositedCounter.Inc()
+	}
+
+	reso, err := object.Marshal(res)
+	if err != nil {
+		logger.Warn("error marshaling MailboxDepositResponse", log.Error(err))
+		return
+	}
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			reso,
+			e.Sender,
+			SendWithPriority(PriorityHigh),
+		)
+		if err != nil {
+			logger.Warn(
+				"error sending MailboxDepositResponse",
+				log.Error(err),
+			)
+		}
+		if res.Success {
+			w.deliverMailbox(req.Recipient)
+		}
+	}()
+}
+
+// deliverMailbox sends the recipient any objects waiting for it, as long as
+// we already have a connection to it
+func (w *network) deliverMailbox(recipient crypto.PublicKey) {
+	if w.mailbox == nil {
+		return
+	}
+	if _, err := w.net.GetConnection(recipient); err != nil {
+		return
+	}
+
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.deliverMailbox"),
+		log.String("recipient", recipient.String()),
+	)
+
+	for _, o := range w.mailbox.undelivered(recipient) {
+		d := &MailboxDelivery{
+			Metadata: object.Metadata{
+				Owner: w.peerKey.PublicKey().DID(),
+			},
+			RequestID: o.id,
+			Envelope:  o.deposit.Envelope,
+		}
+		do, err := object.Marshal(d)
+		if err != nil {
+			logger.Warn("error marshaling MailboxDelivery", log.Error(err))
+			continue
+		}
+		err = w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			do,
+			recipient.DID(),
+			SendWithPriority(PriorityLow),
+		)
+		if err != nil {
+			logger.Debug("error sending MailboxDelivery", log.Error(err))
+			return
+		}
+	}
+}
+
+// handleMailboxDelivery publishes the delivered object to our inboxes same
+// as relayed ones, and acknowledges it
+func (w *network) handleMailboxDelivery(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleMailboxDelivery"),
+		log.String("mailbox", e.Sender.String()),
+	)
+
+	d := &MailboxDelivery{}
+	if err := object.Unmarshal(e.Payload, d); err != nil {
+		logger.Warn("error decoding MailboxDelivery", log.Error(err))
+		return
+	}
+
+	// deliveries can be repeated if our acknowledgement did not make it
+	dedupKey := e.Sender.String() + "/mailbox/" + d.RequestID
+	if _, ok := w.deduplist.Get(dedupKey); !ok && d.Envelope != nil {
+		o, sender, err := w.openDataForwardEnvelope(d.Envelope)
+		if err != nil {
+			logger.Warn("error opening MailboxDelivery", log.Error(err))
+			return
+		}
+		w.deduplist.Set(dedupKey, struct{}{}, time.Hour)
+		if w.verifyInbound(sender.DID(), o) {
+			w.inboxes.Publish(&Envelope{
+				Sender:  sender.DID(),
+				Payload: o,
+			})
+		}
+	}
+
+	ack := &MailboxDeliveryAck{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID: d.RequestID,
+	}
+	acko, err := object.Marshal(ack)
+	if err != nil {
+		logger.Warn("error marshaling MailboxDeliveryAck", log.Error(err))
+		return
+	}
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			acko,
+			e.Sender,
+			SendWithPriority(PriorityHigh),
+		)
+		if err != nil {
+			logger.Warn("error sending MailboxDeliveryAck", log.Error(err))
+		}
+	}()
+}
+
+// handleMailboxDeliveryAck removes the object from the mailbox and lets its
+// sender know that it has been delivered
+func (w *network) handleMailboxDeliveryAck(e *Envelope) {
+	if w.mailbox == nil {
+		return
+	}
+
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleMailboxDeliveryAck"),
+		log.String("recipient", e.Sender.String()),
+	)
+
+	ack := &MailboxDeliveryAck{}
+	if err := object.Unmarshal(e.Payload, ack); err != nil {
+		logger.Warn("error decoding MailboxDeliveryAck", log.Error(err))
+		return
+	}
+
+	recipient, err := crypto.PublicKeyFromDID(e.Sender)
+	if err != nil {
+		logger.Warn("error getting recipient's key", log.Error(err))
+		return
+	}
+
+	o := w.mailbox.acknowledge(*recipient, ack.RequestID)
+	if o == nil {
+		return
+	}
+	objMailboxDeliveredCounter.Inc()
+
+	r := &MailboxReceipt{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID:  o.requestID,
+		Recipient:  *recipient,
+		ObjectHash: o.deposit.ObjectHash,
+	}
+	ro, err := object.Marshal(r)
+	if err != nil {
+		logger.Warn("error marshaling MailboxReceipt", log.Error(err))
+		return
+	}
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			ro,
+			o.sender,
+		)
+		if err != nil {
+			logger.Debug("error sending MailboxReceipt", log.Error(err))
+		}
+	}()
+}
@@ -1,12 +1,8 @@
 package network
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	crand "crypto/rand"
 	"encoding/json"
 	"fmt"
-	"io"
 	"sort"
 	"strconv"
 	"strings"
@@ -65,6 +61,12 @@ var (
 			Help: "Total number of objects relayed on behalf of others",
 		},
 	)
+	objUnverifiedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_unverified_total",
+			Help: "Total number of objects dropped for invalid signatures",
+		},
+	)
 	objRelayedFailedCounter = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "nimona_exchange_object_relayed_failed_total",
@@ -114,6 +116,23 @@ type (
 		RegisterRelays(...*peer.ConnectionInfo)
 		GetPeerKey() crypto.PrivateKey
 		GetConnectionInfo() *peer.ConnectionInfo
+		Handle(
+			requestType string,
+			handler Handler,
+		)
+		Call(
+			ctx context.Context,
+			id did.DID,
+			request interface{},
+			response interface{},
+			opts ...CallOption,
+		) error
+		CallStream(
+			ctx context.Context,
+			id did.DID,
+			request interface{},
+			opts ...CallOption,
+		) (object.ReadCloser, error)
 		Close() error
 	}
 	// Option for customizing New
@@ -132,7 +151,32 @@ type (
 		relays     []*peer.ConnectionInfo
 		closeFns   []closeFn
 		closeMutex sync.Mutex
+		closeOnce  sync.Once
+		closer     chan struct{}
 		store      objectstore.Store
+		// keyResolver is used to find the key streams peers requesting
+		// objects have been delegated by
+		keyResolver object.KeyResolver
+		// relay is only set when we are relaying objects for others, and
+		// reservations holds the expiry of the ones relays have given us
+		relay        *relay
+		reservations map[string]time.Time
+		mailbox      *mailbox
+		// outbox keeps objects sent with reliable delivery until they are
+		// acknowledged
+		outbox           objectstore.Outbox
+		deliveries       *deliveries
+		deliveryAttempts int
+		deliveryTimeout  time.Duration
+		// handlers for calls, by their request type
+		handlers     map[string]Handler
+		handlersLock sync.RWMutex
+		callLimits   *callLimits
+		// holePunches holds the peers we have recently tried to punch a
+		// hole to, and holePunchRequests the relays coordinating them
+		holePunches       *cache.Cache
+		holePunchRequests *cache.Cache
+		rateLimits        *rateLimits
 	}
 	// closeFn are functions that will be called during the network's Close
 	closeFn func() error
@@ -143,7 +187,7 @@ func New(
 	ctx context.Context,
 	nnet net.Network,
 	peerKey crypto.PrivateKey,
-	stores ...objectstore.Store,
+	opts ...Option,
 ) Network {
 	w := &network{
 		inboxes:    NewEnvelopePubSub(),
@@ -155,8 +199,39 @@ func New(
 		closeMutex: sync.Mutex{},
 		peerKey:    peerKey,
 		net:        nnet,
+
+		reservations: map[string]time.Time{},
+		closer:       make(chan struct{}),
+
+		holePunches:       cache.New(holePunchBackoff, time.Minute),
+		holePunchRequests: cache.New(holePunchTimeout, time.Minute),
+
+		deliveries: &deliveries{
+			inflight: map[string]int{},
+		},
+		deliveryAttempts: deliveryDefaultAttempts,
+		deliveryTimeout:  deliveryDefaultTimeout,
+
+		handlers: map[string]Handler{},
+		callLimits: &callLimits{
+			maxPerPeer: callDefaultMaxPerPeer,
+			maxTotal:   callDefaultMaxTotal,
+			active:     map[string]int{},
+		},
+		rateLimits: newRateLimits(),
 	}
 
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	w.closeFns = append(w.closeFns, func() error {
+		w.closeOnce.Do(func() {
+			close(w.closer)
+		})
+		return nil
+	})
+
 	if w.peerKey.IsEmpty() {
 		k, err := crypto.NewEd25519PrivateKey()
 		if err != nil {
@@ -168,11 +243,17 @@ func New(
 	subFilters := []string{
 		DataForwardRequestType,
 		DataForwardEnvelopeType,
+		RelayReservationRequestType,
+		MailboxDepositRequestType,
+		MailboxDeliveryType,
+		MailboxDeliveryAckType,
+		HolePunchRequestType,
+		HolePunchSyncType,
+		DeliveryRequestType,
+		CallRequestType,
 	}
 
-	// TODO(geoah): move to functional options
-	if len(stores) == 1 {
-		w.store = stores[0]
+	if w.store != nil {
 		subFilters = append(subFilters, object.RequestType)
 	}
 
@@ -185,6 +266,10 @@ func New(
 
 	w.net.RegisterConnectionHandler(w.handleConnection)
 
+	if w.outbox != nil {
+		go w.processOutbox(w.outbox)
+	}
+
 	return w
 }
 
@@ -274,11 +359,19 @@ func (w *network) Listen(
 
 	// TODO consider if we should be erroring if there are no addresses, but
 	// a upnp port was provided in the config options.
-	if len(listener.Addresses()) == 0 {
+	// TODO port mappings are currently only created for tcp
+	tcpAddress := ""
+	for _, address := range listener.Addresses() {
+		if strings.HasPrefix(address, "tcps:") {
+			tcpAddress = address
+			break
+		}
+	}
+	if tcpAddress == "" {
 		return listener, nil
 	}
 	localPort, err := strconv.ParseInt(
-		strings.Split(listener.Addresses()[0], ":")[2], 10, 64,
+		strings.Split(tcpAddress, ":")[2], 10, 64,
 	)
 	if err != nil || localPort == 0 {
 		return nil, errors.Merge(
@@ -320,6 +413,8 @@ func (w *network) handleConnection(
 	conn net.Connection,
 ) {
 	remotePeerKey := conn.RemotePeerKey()
+	// peers connecting to us might have objects waiting for them
+	go w.deliverMailbox(remotePeerKey)
 	reader := conn.Read(context.Background())
 	go func() {
 		for {
@@ -357,6 +452,14 @@ func (w *network) handleConnection(
 				log.String("payload", payload.Type),
 			)
 
+			if !w.admitInbound(remotePeerKey, payload) {
+				continue
+			}
+
+			if !w.verifyInbound(remotePeerKey.DID(), payload) {
+				continue
+			}
+
 			w.inboxes.Publish(&Envelope{
 				Sender:  remotePeerKey.DID(),
 				Payload: payload,
@@ -404,7 +507,6 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				log.String("payload", e.Payload.Type),
 			)
 
-		// TODO verify signature
 		logger.Debug("handling object")
 		switch e.Payload.Type {
 		case object.RequestType:
@@ -424,6 +526,11 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				Object:    obj,
 				Found:     obj != nil,
 			}
+			if obj != nil && !w.canRead(e.Sender, obj) {
+				res.Object = nil
+				res.Found = false
+				res.Forbidden = true
+			}
 			// nolint: errcheck // TODO: probably fix
 			go w.Send(
 				context.New(
@@ -434,7 +541,8 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 			)
 		case DataForwardRequestType:
 			// forward requests are just decoded to get the recipient and their
-			// payload is sent to them
+			// payload is sent to them, as long as they have a reservation
+			// with us and neither them or the sender are over their quota
 			fwd := &DataForwardRequest{}
 			if err := object.Unmarshal(e.Payload, fwd); err != nil {
 				logger.Warn(
@@ -444,21 +552,46 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				continue
 			}
 
-			// the way we create the peer is a hack to make sure that we only
-			// try to send this to an existing connection and not bothering
-			// with dialing the peer.
-			err := w.Send(
-				context.New(
-					context.WithTimeout(time.Second),
-				),
-				fwd.Payload,
-				fwd.Recipient.DID(),
-			)
+			var err error = ErrRelayUnavailable
+			if w.relay != nil {
+				size := 0
+				if fwd.Payload != nil {
+					b, _ := json.Marshal(fwd.Payload) // nolint: errcheck
+					size = len(b)
+				}
+				err = w.relay.admit(e.Sender, fwd.Recipient.DID(), size)
+			}
 
 			if err != nil {
-				objRelayedFailedCounter.Inc()
+				objRelayedRefusedCounter.Inc()
+				logger.Info(
+					"refusing to relay object",
+					log.String("requestID", fwd.RequestID),
+					log.String("recipient", fwd.Recipient.String()),
+					log.Error(err),
+				)
 			} else {
-				objRelayedSuccessCounter.Inc()
+				// the way we create the peer is a hack to make sure that we
+				// only try to send this to an existing connection and not
+				// bothering with dialing the peer.
+				sendErr := w.Send(
+					context.New(
+						context.WithTimeout(time.Second),
+					),
+					fwd.Payload,
+					fwd.Recipient.DID(),
+				)
+				if sendErr != nil {
+					objRelayedFailedCounter.Inc()
+					logger.Warn(
+						"error sending DataForwardEnvelope",
+						log.String("requestID", fwd.RequestID),
+						log.Error(sendErr),
+					)
+					err = ErrRelayRecipientUnreachable
+				} else {
+					objRelayedSuccessCounter.Inc()
+				}
 			}
 
 			df := &DataForwardResponse{
@@ -468,6 +601,9 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				RequestID: fwd.RequestID,
 				Success:   err == nil,
 			}
+			if err != nil {
+				df.Error = err.Error()
+			}
 			dfo, err := object.Marshal(df)
 			if err != nil {
 				logger.Warn(
@@ -477,13 +613,13 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				)
 				continue
 			}
-			if resErr := w.Send(
+			if err := w.Send(
 				context.New(
 					context.WithTimeout(time.Second),
 				),
 				dfo,
 				e.Sender,
-			); resErr != nil {
+			); err != nil {
 				logger.Warn(
 					"error sending DataForwardResponse",
 					log.String("requestID", fwd.RequestID),
@@ -492,48 +628,37 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 				continue
 			}
 
-			if err != nil {
-				logger.Warn(
-					"error sending DataForwardEnvelope",
-					log.String("requestID", fwd.RequestID),
-					log.Error(err),
-				)
-				continue
-			}
+		case RelayReservationRequestType:
+			w.handleRelayReservationRequest(e)
+
+		case MailboxDepositRequestType:
+			w.handleMailboxDepositRequest(e)
+
+		case MailboxDeliveryType:
+			w.handleMailboxDelivery(e)
+
+		case MailboxDeliveryAckType:
+			w.handleMailboxDeliveryAck(e)
+
+		case HolePunchRequestType:
+			w.handleHolePunchRequest(e)
+
+		case HolePunchSyncType:
+			w.handleHolePunchSync(e)
+
+		case DeliveryRequestType:
+			w.handleDeliveryRequest(e)
+
+		case CallRequestType:
+			w.handleCallRequest(e)
 
 		case DataForwardEnvelopeType:
 			// envelopes contain relayed objects, so we decode them and publish
 			// them to our inboxes
-			fwd := &DataForwardEnvelope{}
-			if err := object.Unmarshal(e.Payload, fwd); err != nil {
-				logger.Warn(
-					"error decoding DataForwardEnvelope",
-					log.Error(err),
-				)
-				continue
-			}
-
-			// if the data are encrypted we should first decrypt them
-			if !fwd.Sender.IsEmpty() {
-				ss, err := crypto.CalculateSharedKey(
-					w.peerKey,
-					fwd.Sender,
-				)
-				if err != nil {
-					continue
-				}
-				fwd.Data, err = decrypt(fwd.Data, ss)
-				if err != nil {
-					continue
-				}
-			}
-
-			// unmarshal payload
-			o := &object.Object{}
-			err := json.Unmarshal(fwd.Data, o)
+			o, sender, err := w.openDataForwardEnvelope(e.Payload)
 			if err != nil {
 				logger.Warn(
-					"error decoding DataForwardEnvelope's payload",
+					"error opening DataForwardEnvelope",
 					log.Error(err),
 				)
 				continue
@@ -541,14 +666,17 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 
 			logger.Info(
 				"got relayed object",
-				log.String("sender", fwd.Sender.String()),
+				log.String("sender", sender.String()),
 				log.String("relay", e.Sender.String()),
 				log.String("payload.type", o.Type),
-				log.String("data", string(fwd.Data)),
 			)
 
+			if !w.verifyInbound(sender.DID(), o) {
+				continue
+			}
+
 			w.inboxes.Publish(&Envelope{
-				Sender:  fwd.Sender.DID(),
+				Sender:  sender.DID(),
 				Payload: o,
 			})
 			continue
@@ -556,6 +684,75 @@ func (w *network) handleObjects(sub EnvelopeSubscription) {
 	}
 }
 
+// verifyInbound checks the signatures of an object we received and any of
+// its nested objects, objects that fail are never published to subscribers
+func (w *network) verifyInbound(sender did.DID, o *object.Object) bool {
+	report := object.VerifyDeep(o, w.keyResolver)
+	if err := report.Err(); err != nil {
+		objUnverifiedCounter.Inc()
+		for _, f := range report.Failures {
+			log.DefaultLogger.Named("network").Warn(
+				"unable to verify object",
+				log.String("method", "network.verifyInbound"),
+				log.String("sender", sender.String()),
+				log.String("path", f.Path),
+				log.String("type", f.Type),
+				log.Error(f.Error),
+			)
+		}
+		return false
+	}
+	return true
+}
+
+// canRead checks whether the object's policies allow the given peer to read
+// it, failing closed if they cannot be evaluated
+func (w *network) canRead(sender did.DID, o *object.Object) bool {
+	subject := crypto.PublicKey{}
+	if k, err := crypto.PublicKeyFromDID(sender); err == nil {
+		subject = *k
+	}
+	ok, err := objectstore.CanRead(w.store, w.keyResolver, subject, o)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+// openDataForwardEnvelope decrypts the envelope if needed and returns the
+// object it contains along with its sender
+func (w *network) openDataForwardEnvelope(
+	env *object.Object,
+) (*object.Object, crypto.PublicKey, error) {
+	fwd := &DataForwardEnvelope{}
+	if err := object.Unmarshal(env, fwd); err != nil {
+		return nil, crypto.PublicKey{}, err
+	}
+
+	// if the data are encrypted we should first decrypt them
+	if !fwd.Sender.IsEmpty() {
+		ss, err := crypto.CalculateSharedKey(
+			w.peerKey,
+			fwd.Sender,
+		)
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+		fwd.Data, err = crypto.Decrypt(fwd.Data, ss)
+		if err != nil {
+			return nil, crypto.PublicKey{}, err
+		}
+	}
+
+	// unmarshal payload
+	o := &object.Object{}
+	if err := json.Unmarshal(fwd.Data, o); err != nil {
+		return nil, crypto.PublicKey{}, err
+	}
+
+	return o, fwd.Sender, nil
+}
+
 // Send an object to the given peer.
 // Before sending, we'll go through the root object as well as any embedded
 func (w *network) Send(
@@ -568,6 +765,17 @@ func (w *network) Send(
 		return ErrCannotSendToSelf
 	}
 
+	opt := &sendOptions{
+		priority: PriorityNormal,
+	}
+	for _, r := range opts {
+		r(opt)
+	}
+
+	if opt.delivery != nil {
+		return w.sendReliably(ctx, o, id, opt)
+	}
+
 	if id.IdentityType == did.IdentityTypeKeyStream {
 		cs, err := w.lookup(ctx, id)
 		if err != nil {
@@ -595,11 +803,6 @@ func (w *network) Send(
 		return nil
 	}
 
-	opt := &sendOptions{}
-	for _, r := range opts {
-		r(opt)
-	}
-
 	dedupKey := fmt.Sprintf(
 		"%s/%s/%s",
 		ctx.CorrelationID(),
@@ -620,22 +823,9 @@ func (w *network) Send(
 		}
 	}
 
-	var rSub EnvelopeSubscription
-	if opt.waitForResponse != nil {
-		rIDVal, ok := o.Data["requestID"]
-		if !ok {
-			return errors.Error("cannot wait for response without a request id")
-		}
-		rID, ok := rIDVal.(tilde.String)
-		if !ok {
-			return errors.Error("cannot wait for response with an invalid request id")
-		}
-		if rID == "" {
-			return errors.Error("cannot wait for response with empty request id")
-		}
-		rSub = w.Subscribe(
-			FilterByRequestID(string(rID)),
-		)
+	rSub, err := w.subscribeForResponse(o, opt)
+	if err != nil {
+		return err
 	}
 
 	// sendViaRelay:
@@ -663,6 +853,7 @@ func (w *network) Send(
 			dfo,
 			relayConnInfo.Metadata.Owner,
 			SendWithConnectionInfo(relayConnInfo),
+			SendWithPriority(opt.priority),
 		)
 		if err != nil {
 			return err
@@ -689,10 +880,15 @@ func (w *network) Send(
 			return err
 		}
 		if !res.Success {
-			return fmt.Errorf(
+			err := fmt.Errorf(
 				"relay %v wasn't able to delivery object",
 				relayConnInfo.Addresses,
 			)
+			if res.Error != "" {
+				// relays let us know why through one of our relay errors
+				return errors.Merge(err, errors.Error(res.Error))
+			}
+			return err
 		}
 		return nil
 	}
@@ -777,7 +973,14 @@ func (w *network) Send(
 	// attempt to write the object
 	sent := false
 	if c != nil {
-		err = c.Write(ctx, o)
+		err = w.rateLimits.waitOutbound(ctx, c.RemotePeerKey(), o)
+		if err != nil {
+			if rSub != nil {
+				rSub.Cancel()
+			}
+			return errors.Merge(ErrRateLimited, err)
+		}
+		err = c.Write(ctx, o, net.WriteWithPriority(opt.priority))
 		if err != nil {
 			objSendFailedCounter.Inc()
 			errs = multierror.Append(
@@ -802,6 +1005,31 @@ func (w *network) Send(
 			}
 			sent = true
 			objSendRelayedCounter.Inc()
+			// try to get a direct connection for next time
+			w.punchViaRelay(relay, *recipientPublicKey)
+			break
+		}
+	}
+
+	// and if the recipient is offline, leave it in one of their mailboxes
+	if !sent && opt.mailboxTTL > 0 && recipientPublicKey != nil {
+		for _, relay := range relays {
+			err := w.depositInMailbox(
+				ctx,
+				relay,
+				*recipientPublicKey,
+				o,
+				opt.mailboxTTL,
+			)
+			if err != nil {
+				errs = multierror.Append(
+					errs,
+					fmt.Errorf("error depositing in mailbox: %w", err),
+				)
+				continue
+			}
+			sent = true
+			objSendDepositedCounter.Inc()
 			break
 		}
 	}
@@ -817,13 +1045,47 @@ func (w *network) Send(
 		return nil
 	}
 
+	return w.waitForResponse(ctx, rSub, opt)
+}
+
+// subscribeForResponse subscribes to the response of the object if we have
+// been asked to wait for one
+func (w *network) subscribeForResponse(
+	o *object.Object,
+	opt *sendOptions,
+) (EnvelopeSubscription, error) {
+	if opt.waitForResponse == nil {
+		return nil, nil
+	}
+	rIDVal, ok := o.Data["requestID"]
+	if !ok {
+		return nil, errors.Error("cannot wait for response without a request id")
+	}
+	rID, ok := rIDVal.(tilde.String)
+	if !ok {
+		return nil, errors.Error(
+			"cannot wait for response with an invalid request id",
+		)
+	}
+	if rID == "" {
+		return nil, errors.Error("cannot wait for response with empty request id")
+	}
+	return w.Subscribe(
+		FilterByRequestID(string(rID)),
+	), nil
+}
+
+func (w *network) waitForResponse(
+	ctx context.Context,
+	rSub EnvelopeSubscription,
+	opt *sendOptions,
+) error {
 	rT := time.NewTimer(opt.waitForResponseTimeout)
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-rT.C:
-		// TODO should we return an error if no response came?
-		return nil
+		return ErrWaitingForResponseTimedOut
 	case e := <-rSub.Channel():
 		if err := object.Unmarshal(e.Payload, opt.waitForResponse); err != nil {
 			return errors.Merge(
@@ -835,38 +1097,6 @@ func (w *network) Send(
 	return nil
 }
 
-func encrypt(data []byte, key []byte) ([]byte, error) {
-	block, _ := aes.NewCipher(key)
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(crand.Reader, nonce); err != nil {
-		return nil, err
-	}
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
-}
-
-func decrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-	nonceSize := gcm.NonceSize()
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, err
-	}
-	return plaintext, nil
-}
-
 func (w *network) wrapInDataForward(
 	o *object.Object,
 	recipient crypto.PublicKey,
@@ -885,7 +1115,7 @@ func (w *network) wrapInDataForward(
 		return nil, err
 	}
 	// encrypt payload
-	ep, err := encrypt(payload, ss)
+	ep, err := crypto.Encrypt(payload, ss)
 	if err != nil {
 		return nil, err
 	}
@@ -944,24 +1174,37 @@ func (w *network) GetConnectionInfo() *peer.ConnectionInfo {
 		Metadata: object.Metadata{
 			Owner: w.peerKey.PublicKey().DID(),
 		},
-		Addresses: w.GetAddresses(),
-		Relays:    w.GetRelays(),
-		ObjectFormats: []string{
-			"json",
-		},
+		Addresses:     w.GetAddresses(),
+		Relays:        w.GetRelays(),
+		ObjectFormats: w.net.ObjectFormats(),
 	}
 }
 
+// GetRelays returns the relays that have given us an active reservation
 func (w *network) GetRelays() []*peer.ConnectionInfo {
 	w.relayLock.RLock()
 	defer w.relayLock.RUnlock()
-	return w.relays
+	now := time.Now()
+	relays := []*peer.ConnectionInfo{}
+	for _, r := range w.relays {
+		expires, ok := w.reservations[r.Metadata.Owner.String()]
+		if ok && now.Before(expires) {
+			relays = append(relays, r)
+		}
+	}
+	return relays
 }
 
+// RegisterRelays adds relays that others can reach us through, reservations
+// are requested and renewed in the background and relays are only returned
+// by GetRelays while they have one active
 func (w *network) RegisterRelays(relays ...*peer.ConnectionInfo) {
 	w.relayLock.Lock()
-	defer w.relayLock.Unlock()
 	w.relays = append(w.relays, relays...)
+	w.relayLock.Unlock()
+	for _, r := range relays {
+		go w.maintainReservation(r)
+	}
 }
 
 // Close all listeners created in this network as well as remove all nat
@@ -5,12 +5,14 @@ package network
 import (
 	crypto "nimona.io/pkg/crypto"
 	object "nimona.io/pkg/object"
+	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const DataForwardRequestType = "nimona.io/network.DataForwardRequest"
 
 type DataForwardRequest struct {
-	Metadata  object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.DataForwardRequest"`
+	Metadata  object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.DataForwardRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
 	RequestID string           `nimona:"requestID:s"`
 	Recipient crypto.PublicKey `nimona:"recipient:s"`
 	Payload   *object.Object   `nimona:"payload:m"`
@@ -19,7 +21,7 @@ type DataForwardRequest struct {
 const DataForwardEnvelopeType = "nimona.io/network.DataForwardEnvelope"
 
 type DataForwardEnvelope struct {
-	Metadata object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.DataForwardEnvelope"`
+	Metadata object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.DataForwardEnvelope,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
 	Sender   crypto.PublicKey `nimona:"sender:s"`
 	Data     []byte           `nimona:"data:d"`
 }
@@ -27,7 +29,328 @@ type DataForwardEnvelope struct {
 const DataForwardResponseType = "nimona.io/network.DataForwardResponse"
 
 type DataForwardResponse struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.DataForwardResponse"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.DataForwardResponse,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
 	RequestID string          `nimona:"requestID:s"`
 	Success   bool            `nimona:"success:b"`
+	Error     string          `nimona:"error:s"`
+}
+
+const RelayReservationRequestType = "nimona.io/network.RelayReservationRequest"
+
+type RelayReservationRequest struct {
+	Metadata      object.Metadata `nimona:"@metadata:m,type=nimona.io/network.RelayReservationRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID     string          `nimona:"requestID:s"`
+	ObjectFormats []string        `nimona:"objectFormats:as"`
+}
+
+const RelayReservationResponseType = "nimona.io/network.RelayReservationResponse"
+
+type RelayReservationResponse struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.RelayReservationResponse,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Success   bool            `nimona:"success:b"`
+	Error     string          `nimona:"error:s"`
+	ExpiresIn int64           `nimona:"expiresIn:i"`
+}
+
+const HolePunchRequestType = "nimona.io/network.HolePunchRequest"
+
+type HolePunchRequest struct {
+	Metadata      object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.HolePunchRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID     string           `nimona:"requestID:s"`
+	Recipient     crypto.PublicKey `nimona:"recipient:s"`
+	ObjectFormats []string         `nimona:"objectFormats:as"`
+}
+
+const HolePunchSyncType = "nimona.io/network.HolePunchSync"
+
+type HolePunchSync struct {
+	Metadata      object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.HolePunchSync,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID     string           `nimona:"requestID:s"`
+	Peer          crypto.PublicKey `nimona:"peer:s"`
+	Addresses     []string         `nimona:"addresses:as"`
+	ObjectFormats []string         `nimona:"objectFormats:as"`
+}
+
+const MailboxDepositRequestType = "nimona.io/network.MailboxDepositRequest"
+
+type MailboxDepositRequest struct {
+	Metadata   object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.MailboxDepositRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID  string           `nimona:"requestID:s"`
+	Recipient  crypto.PublicKey `nimona:"recipient:s"`
+	ObjectHash tilde.Digest     `nimona:"objectHash:r"`
+	Envelope   *object.Object   `nimona:"envelope:m"`
+	ExpiresIn  int64            `nimona:"expiresIn:i"`
+}
+
+const MailboxDepositResponseType = "nimona.io/network.MailboxDepositResponse"
+
+type MailboxDepositResponse struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.MailboxDepositResponse,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Success   bool            `nimona:"success:b"`
+	Error     string          `nimona:"error:s"`
+}
+
+const MailboxDeliveryType = "nimona.io/network.MailboxDelivery"
+
+type MailboxDelivery struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.MailboxDelivery,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Envelope  *object.Object  `nimona:"envelope:m"`
+}
+
+const MailboxDeliveryAckType = "nimona.io/network.MailboxDeliveryAck"
+
+type MailboxDeliveryAck struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.MailboxDeliveryAck,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+}
+
+const MailboxReceiptType = "nimona.io/network.MailboxReceipt"
+
+type MailboxReceipt struct {
+	Metadata   object.Metadata  `nimona:"@metadata:m,type=nimona.io/network.MailboxReceipt,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID  string           `nimona:"requestID:s"`
+	Recipient  crypto.PublicKey `nimona:"recipient:s"`
+	ObjectHash tilde.Digest     `nimona:"objectHash:r"`
+}
+
+const DeliveryRequestType = "nimona.io/network.DeliveryRequest"
+
+type DeliveryRequest struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.DeliveryRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Payload   *object.Object  `nimona:"payload:m"`
+}
+
+const DeliveryAckType = "nimona.io/network.DeliveryAck"
+
+type DeliveryAck struct {
+	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/network.DeliveryAck,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID  string          `nimona:"requestID:s"`
+	ObjectHash tilde.Digest    `nimona:"objectHash:r"`
+}
+
+const CallRequestType = "nimona.io/network.CallRequest"
+
+type CallRequest struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.CallRequest,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Payload   *object.Object  `nimona:"payload:m"`
+}
+
+const CallResponseType = "nimona.io/network.CallResponse"
+
+type CallResponse struct {
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/network.CallResponse,context=AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD"`
+	RequestID string          `nimona:"requestID:s"`
+	Sequence  int64           `nimona:"sequence:i"`
+	Done      bool            `nimona:"done:b"`
+	Payload   *object.Object  `nimona:"payload:m"`
+	Error     string          `nimona:"error:s"`
+}
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("AYomjy1eVDPWfoJ8YT2Bd1ypFSBHQRESduET3JZfkLnD")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/network",
+	Types: []*schema.Type{{
+		Name: "nimona.io/network.DataForwardRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "recipient",
+			Hint: "s",
+		}, {
+			Name: "payload",
+			Hint: "m",
+		}},
+	}, {
+		Name: "nimona.io/network.DataForwardEnvelope",
+		Properties: []*schema.Property{{
+			Name: "sender",
+			Hint: "s",
+		}, {
+			Name: "data",
+			Hint: "d",
+		}},
+	}, {
+		Name: "nimona.io/network.DataForwardResponse",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "success",
+			Hint: "b",
+		}, {
+			Name: "error",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/network.RelayReservationRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name:     "objectFormats",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/network.RelayReservationResponse",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "success",
+			Hint: "b",
+		}, {
+			Name: "error",
+			Hint: "s",
+		}, {
+			Name: "expiresIn",
+			Hint: "i",
+		}},
+	}, {
+		Name: "nimona.io/network.HolePunchRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "recipient",
+			Hint: "s",
+		}, {
+			Name:     "objectFormats",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/network.HolePunchSync",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "peer",
+			Hint: "s",
+		}, {
+			Name:     "addresses",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "objectFormats",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/network.MailboxDepositRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "recipient",
+			Hint: "s",
+		}, {
+			Name: "objectHash",
+			Hint: "r",
+		}, {
+			Name: "envelope",
+			Hint: "m",
+		}, {
+			Name: "expiresIn",
+			Hint: "i",
+		}},
+	}, {
+		Name: "nimona.io/network.MailboxDepositResponse",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "success",
+			Hint: "b",
+		}, {
+			Name: "error",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/network.MailboxDelivery",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "envelope",
+			Hint: "m",
+		}},
+	}, {
+		Name: "nimona.io/network.MailboxDeliveryAck",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/network.MailboxReceipt",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "recipient",
+			Hint: "s",
+		}, {
+			Name: "objectHash",
+			Hint: "r",
+		}},
+	}, {
+		Name: "nimona.io/network.DeliveryRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "payload",
+			Hint: "m",
+		}},
+	}, {
+		Name: "nimona.io/network.DeliveryAck",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "objectHash",
+			Hint: "r",
+		}},
+	}, {
+		Name: "nimona.io/network.CallRequest",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "payload",
+			Hint: "m",
+		}},
+	}, {
+		Name: "nimona.io/network.CallResponse",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "sequence",
+			Hint: "i",
+		}, {
+			Name: "done",
+			Hint: "b",
+		}, {
+			Name: "payload",
+			Hint: "m",
+		}, {
+			Name: "error",
+			Hint: "s",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
 }
@@ -1,11 +1,15 @@
 package network
 
 import (
+	"database/sql"
+	"fmt"
+	"path"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/patrickmn/go-cache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -18,6 +22,7 @@ import (
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectstoremock"
 	"nimona.io/pkg/peer"
+	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
 )
 
@@ -28,14 +33,15 @@ func TestNetwork_SimpleConnection(t *testing.T) {
 	k2, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
 
-	n1 := New(context.Background(), net.New(k1), k1)
-	n2 := New(context.Background(), net.New(k2), k2)
+	sb := net.NewSwitchboard(1)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
 
-	l1, err := n1.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	l1, err := n1.Listen(context.Background(), "mem:n1", ListenOnLocalIPs)
 	require.NoError(t, err)
 	defer l1.Close()
 
-	l2, err := n2.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
 	require.NoError(t, err)
 	defer l2.Close()
 
@@ -216,6 +222,74 @@ func TestNetwork_SimpleConnection(t *testing.T) {
 	})
 }
 
+func TestNetwork_DropUnverifiedObjects(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l2.Close()
+
+	s2 := n2.Subscribe(
+		FilterByObjectType("foo"),
+	)
+
+	send := func(o *object.Object) {
+		err := n1.Send(
+			context.Background(),
+			o,
+			n2.GetPeerKey().PublicKey().DID(),
+			SendWithConnectionInfo(
+				&peer.ConnectionInfo{
+					Metadata: object.Metadata{
+						Owner: n2.GetPeerKey().PublicKey().DID(),
+					},
+					Addresses: n2.GetAddresses(),
+				},
+			),
+		)
+		require.NoError(t, err)
+	}
+
+	// an object whose data were changed after it was signed
+	badObj := &object.Object{
+		Type: "foo",
+		Metadata: object.Metadata{
+			Owner: k3.PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+	require.NoError(t, object.Sign(k3, badObj))
+	badObj.Data["foo"] = tilde.String("baz")
+	send(badObj)
+
+	goodObj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("qux"),
+		},
+	}
+	send(goodObj)
+
+	// objects are received in order, so if the first one we get is the
+	// good one, the bad one was dropped
+	env, err := s2.Next()
+	require.NoError(t, err)
+	assert.Equal(t, goodObj, env.Payload)
+}
+
 func TestNetwork_Relay(t *testing.T) {
 	k0, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
@@ -226,43 +300,684 @@ func TestNetwork_Relay(t *testing.T) {
 	k2, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
 
-	n0 := New(context.Background(), net.New(k0), k0)
-	time.Sleep(time.Millisecond * 500)
-	n1 := New(context.Background(), net.New(k1), k1)
-	time.Sleep(time.Millisecond * 500)
-	n2 := New(context.Background(), net.New(k2), k2)
-	time.Sleep(time.Millisecond * 500)
-
-	l0, err := n0.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	sb := net.NewSwitchboard(1)
+	n0 := New(context.Background(), newMemNet(sb, k0), k0, WithRelay())
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+
+	l0, err := n0.Listen(context.Background(), "mem:n0", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l0.Close()
+
+	p0 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n0.GetPeerKey().PublicKey().DID(),
+		},
+		Addresses: n0.GetAddresses(),
+	}
+
+	// n1 and n2 can only be reached through n0 once they have reservations
+	n1.RegisterRelays(p0)
+	n2.RegisterRelays(p0)
+	requireReservations(t, n1, n2)
+
+	p1 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n1.GetPeerKey().PublicKey().DID(),
+		},
+		Addresses: n1.GetAddresses(),
+		Relays: []*peer.ConnectionInfo{
+			p0,
+		},
+	}
+
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: n2.GetPeerKey().PublicKey().DID(),
+		},
+		Addresses: n2.GetAddresses(),
+		Relays: []*peer.ConnectionInfo{
+			p0,
+		},
+	}
+
+	testObj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	testObjFromP1 := &object.Object{
+		Type: "foo",
+		Metadata: object.Metadata{
+			Owner: n1.GetPeerKey().PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	testObjFromP2 := &object.Object{
+		Type: "foo",
+		Metadata: object.Metadata{
+			Owner: n2.GetPeerKey().PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	// send from p1 to p0
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p0.Metadata.Owner,
+		SendWithConnectionInfo(p0),
+	)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 500)
+
+	// send from p2 to p0
+	err = n2.Send(
+		context.Background(),
+		testObj,
+		p0.Metadata.Owner,
+		SendWithConnectionInfo(p0),
+	)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 500)
+
+	// now we should be able to send from p1 to p2
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+	err = n1.Send(
+		context.Background(),
+		testObjFromP1,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+	)
+	require.NoError(t, err)
+
+	env, err := sub.Next()
+	require.NoError(t, err)
+
+	require.NotNil(t, sub)
+	assert.Equal(t,
+		testObjFromP1.Metadata.Signature,
+		env.Payload.Metadata.Signature,
+	)
+
+	time.Sleep(time.Millisecond * 500)
+
+	// send from p2 to p1
+	sub = n1.Subscribe(FilterByObjectType("foo"))
+
+	err = n2.Send(
+		context.Background(),
+		testObjFromP2,
+		p1.Metadata.Owner,
+		SendWithConnectionInfo(p1),
+	)
+	require.NoError(t, err)
+
+	env, err = sub.Next()
+	require.NoError(t, err)
+
+	require.NotNil(t, sub)
+	assert.Equal(t,
+		testObjFromP2.Metadata.Signature,
+		env.Payload.Metadata.Signature,
+	)
+}
+
+func TestNetwork_RelayPartitioned(t *testing.T) {
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n0 := New(context.Background(), newMemNet(sb, k0), k0, WithRelay())
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+
+	for i, n := range []Network{n0, n1, n2} {
+		l, err := n.Listen(
+			context.Background(),
+			fmt.Sprintf("mem:n%d", i),
+			ListenOnLocalIPs,
+		)
+		require.NoError(t, err)
+		defer l.Close()
+	}
+
+	// n1 and n2 can both reach n0, but not each other
+	sb.Partition(
+		[]crypto.PublicKey{k1.PublicKey()},
+		[]crypto.PublicKey{k2.PublicKey()},
+	)
+
+	p0 := n0.GetConnectionInfo()
+	n2.RegisterRelays(p0)
+	requireReservations(t, n2)
+	p2 := n2.GetConnectionInfo()
+	require.Equal(t, []*peer.ConnectionInfo{p0}, p2.Relays)
+
+	testObj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	// connect both peers to the relay
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p0.Metadata.Owner,
+		SendWithConnectionInfo(p0),
+	)
+	require.NoError(t, err)
+	err = n2.Send(
+		context.Background(),
+		testObj,
+		p0.Metadata.Owner,
+		SendWithConnectionInfo(p0),
+	)
+	require.NoError(t, err)
+
+	// dialing n2 directly fails, so the object should go through n0
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+	)
+	require.NoError(t, err)
+
+	select {
+	case env := <-sub.Channel():
+		assert.Equal(t, testObj, env.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for relayed object")
+	}
+}
+
+func TestNetwork_RelayReservations(t *testing.T) {
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n0 := New(
+		context.Background(),
+		newMemNet(sb, k0),
+		k0,
+		WithRelay(
+			RelayWithQuota(1<<20, 2),
+			RelayWithDenyList(k3.PublicKey().DID()),
+		),
+	)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+	n3 := New(context.Background(), newMemNet(sb, k3), k3)
+
+	for i, n := range []Network{n0, n1, n2, n3} {
+		l, err := n.Listen(
+			context.Background(),
+			fmt.Sprintf("mem:n%d", i),
+			ListenOnLocalIPs,
+		)
+		require.NoError(t, err)
+		defer l.Close()
+	}
+
+	// n1 can only reach n2 and n3 through n0
+	sb.Partition(
+		[]crypto.PublicKey{k1.PublicKey()},
+		[]crypto.PublicKey{k2.PublicKey(), k3.PublicKey()},
+	)
+
+	p0 := n0.GetConnectionInfo()
+	p2 := n2.GetConnectionInfo()
+	p2.Relays = []*peer.ConnectionInfo{p0}
+
+	testObj := func(i int) *object.Object {
+		return &object.Object{
+			Type: "foo",
+			Data: tilde.Map{
+				"foo": tilde.Int(i),
+			},
+		}
+	}
+
+	// connect both peers to the relay
+	for _, n := range []Network{n1, n2} {
+		err = n.Send(
+			context.Background(),
+			testObj(0),
+			p0.Metadata.Owner,
+			SendWithConnectionInfo(p0),
+		)
+		require.NoError(t, err)
+	}
+
+	// n2 does not have a reservation yet
+	err = n1.Send(
+		context.Background(),
+		testObj(1),
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+	)
+	require.ErrorIs(t, err, ErrRelayNoReservation)
+
+	// relays are only advertised once they have given us a reservation
+	require.Empty(t, n2.GetConnectionInfo().Relays)
+	n2.RegisterRelays(p0)
+	requireReservations(t, n2)
+	require.Len(t, n2.GetConnectionInfo().Relays, 1)
+
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+	for i := 2; i <= 3; i++ {
+		err = n1.Send(
+			context.Background(),
+			testObj(i),
+			p2.Metadata.Owner,
+			SendWithConnectionInfo(p2),
+		)
+		require.NoError(t, err)
+		env, err := sub.Next()
+		require.NoError(t, err)
+		assert.Equal(t, testObj(i).Data, env.Payload.Data)
+	}
+
+	// both n1 and n2 have now used up their quota
+	err = n1.Send(
+		context.Background(),
+		testObj(4),
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+	)
+	require.ErrorIs(t, err, ErrRelayQuotaExceeded)
+
+	// n3 is denied, so it should never get a reservation
+	n3.RegisterRelays(p0)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, n3.GetRelays())
+
+	// and peers that are not relays do not give out reservations
+	n3.RegisterRelays(n2.GetConnectionInfo())
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, n3.GetRelays())
+}
+
+func TestRelay_UsageExpiration(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	r := newRelay(RelayWithReservationTTL(100 * time.Millisecond))
+	_, err = r.reserve(k2.PublicKey().DID(), nil)
+	require.NoError(t, err)
+	require.NoError(t, r.admit(
+		k1.PublicKey().DID(),
+		k2.PublicKey().DID(),
+		10,
+	))
+	assert.Len(t, r.usage, 2)
+
+	// usage from past periods should be dropped along with the reservations
+	time.Sleep(150 * time.Millisecond)
+	_, err = r.reserve(k2.PublicKey().DID(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, r.usage)
+}
+
+func TestNetwork_Mailbox(t *testing.T) {
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n0 := New(
+		context.Background(),
+		newMemNet(sb, k0),
+		k0,
+		WithRelay(),
+		WithMailbox(),
+	)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+
+	l0, err := n0.Listen(context.Background(), "mem:n0", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l0.Close()
+
+	p0 := n0.GetConnectionInfo()
+
+	// n2 is offline, and was last seen with n0 as its relay
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: k2.PublicKey().DID(),
+		},
+		Addresses: []string{"mem:n2"},
+		Relays:    []*peer.ConnectionInfo{p0},
+	}
+
+	testObj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	// without a mailbox sending should fail
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+	)
+	require.Error(t, err)
+
+	// and peers that are not mailboxes should refuse deposits
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(&peer.ConnectionInfo{
+			Metadata: p2.Metadata,
+			Relays: []*peer.ConnectionInfo{
+				n1.GetConnectionInfo(),
+			},
+		}),
+		SendWithMailbox(time.Minute),
+	)
+	require.Error(t, err)
+
+	// n0 should refuse deposits for peers that never had a reservation
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+		SendWithMailbox(time.Minute),
+	)
+	require.ErrorContains(t, err, ErrRelayNoReservation.Error())
+
+	// but n2 had one before going offline
+	n0.(*network).mailbox.register(k2.PublicKey().DID())
+
+	receipts := n1.Subscribe(FilterByObjectType(MailboxReceiptType))
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+		SendWithMailbox(time.Minute),
+	)
+	require.NoError(t, err)
+
+	// once n2 connects to n0 it should get the object
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+	n2.RegisterRelays(p0)
+
+	select {
+	case env := <-sub.Channel():
+		assert.Equal(t, testObj.Data, env.Payload.Data)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for mailbox object")
+	}
+
+	// and n1 should be notified of the delivery
+	select {
+	case env := <-receipts.Channel():
+		r := &MailboxReceipt{}
+		require.NoError(t, object.Unmarshal(env.Payload, r))
+		assert.Equal(t, k2.PublicKey(), r.Recipient)
+		assert.Equal(t, testObj.Hash(), r.ObjectHash)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for mailbox receipt")
+	}
+}
+
+func TestNetwork_MailboxLimits(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	m := newMailbox(
+		MailboxWithMaxSize(100, 150),
+		MailboxWithMaxSenderSize(120),
+		MailboxWithMaxTTL(100*time.Millisecond),
+	)
+
+	deposit := func(sender, recipient crypto.PublicKey, size int) error {
+		return m.deposit(
+			sender.DID(),
+			&MailboxDepositRequest{
+				RequestID: "1",
+				Recipient: recipient,
+				ExpiresIn: 60,
+			},
+			size,
+		)
+	}
+
+	// recipients need to have had a reservation
+	require.ErrorIs(
+		t,
+		deposit(k1.PublicKey(), k2.PublicKey(), 10),
+		ErrRelayNoReservation,
+	)
+	m.register(k1.PublicKey().DID())
+	m.register(k2.PublicKey().DID())
+
+	// each recipient can only use up to 100 bytes
+	require.NoError(t, deposit(k1.PublicKey(), k1.PublicKey(), 60))
+	require.ErrorIs(
+		t,
+		deposit(k3.PublicKey(), k1.PublicKey(), 50),
+		ErrMailboxFull,
+	)
+
+	// each sender up to 120
+	require.NoError(t, deposit(k1.PublicKey(), k2.PublicKey(), 50))
+	require.ErrorIs(
+		t,
+		deposit(k1.PublicKey(), k2.PublicKey(), 20),
+		ErrMailboxFull,
+	)
+
+	// and all of them up to 150
+	require.NoError(t, deposit(k3.PublicKey(), k2.PublicKey(), 30))
+	require.ErrorIs(
+		t,
+		deposit(k3.PublicKey(), k2.PublicKey(), 20),
+		ErrMailboxFull,
+	)
+
+	// objects are acknowledged by the mailbox's delivery ids, so deposits
+	// with the same request id do not collide
+	objs := m.undelivered(k2.PublicKey())
+	require.Len(t, objs, 2)
+	require.Empty(t, m.undelivered(k2.PublicKey()))
+	require.Equal(t, objs[0].requestID, objs[1].requestID)
+	require.NotEqual(t, objs[0].id, objs[1].id)
+	require.Nil(t, m.acknowledge(k2.PublicKey(), objs[0].requestID))
+	o := m.acknowledge(k2.PublicKey(), objs[1].id)
+	require.NotNil(t, o)
+	require.Equal(t, k3.PublicKey().DID(), o.sender)
+	require.NoError(t, deposit(k3.PublicKey(), k2.PublicKey(), 20))
+
+	// and ttls are capped to the mailbox's one
+	time.Sleep(150 * time.Millisecond)
+	require.Empty(t, m.undelivered(k1.PublicKey()))
+	require.Empty(t, m.undelivered(k2.PublicKey()))
+	require.Zero(t, m.bytes)
+
+	// as are registrations
+	require.ErrorIs(
+		t,
+		deposit(k1.PublicKey(), k2.PublicKey(), 10),
+		ErrRelayNoReservation,
+	)
+}
+
+func TestNetwork_ReliableDelivery(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n1 := New(
+		context.Background(),
+		newMemNet(sb, k1),
+		k1,
+		WithDeliveryRetries(5, 100*time.Millisecond),
+	)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+
+	p2 := &peer.ConnectionInfo{
+		Metadata: object.Metadata{
+			Owner: k2.PublicKey().DID(),
+		},
+		Addresses: []string{"mem:n2"},
+	}
+
+	testObj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	// n2 only starts listening after the first attempt has failed
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			l2.Close() // nolint: errcheck
+		})
+	}()
+
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+	res := &DeliveryResult{}
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+		SendWithReliableDelivery(res),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, DeliveryAcknowledged, res.Status)
+	assert.Greater(t, res.Attempts, 1)
+	require.NotNil(t, res.Ack)
+	assert.Equal(t, k2.PublicKey().DID(), res.Ack.Metadata.Owner)
+
+	env, err := sub.Next()
+	require.NoError(t, err)
+	assert.Equal(t, testObj, env.Payload)
+	assert.Equal(t, k1.PublicKey().DID(), env.Sender)
+
+	// sending the same object again should be acknowledged, but not
+	// published a second time
+	res = &DeliveryResult{}
+	err = n1.Send(
+		context.New(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
+		SendWithReliableDelivery(res),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, DeliveryAcknowledged, res.Status)
+	assert.Equal(t, 1, res.Attempts)
+	select {
+	case <-sub.Channel():
+		t.Fatal("object should not have been published again")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// unreachable peers should fail after all attempts
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	res = &DeliveryResult{}
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		k3.PublicKey().DID(),
+		SendWithReliableDelivery(res),
+	)
+	require.ErrorIs(t, err, ErrDeliveryNotAcknowledged)
+	assert.Equal(t, DeliveryFailed, res.Status)
+	assert.Equal(t, 5, res.Attempts)
+	assert.Nil(t, res.Ack)
+}
+
+func TestNetwork_ReliableDeliveryOutbox(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite", path.Join(t.TempDir(), "sqlite3.db"))
 	require.NoError(t, err)
-	defer l0.Close()
+	str, err := sqlobjectstore.New(db)
+	require.NoError(t, err)
+	defer str.Close()
 
-	p0 := &peer.ConnectionInfo{
+	p2 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
-			Owner: n0.GetPeerKey().PublicKey().DID(),
+			Owner: k2.PublicKey().DID(),
 		},
-		Addresses: n0.GetAddresses(),
+		Addresses: []string{"mem:n2"},
 	}
-
-	p1 := &peer.ConnectionInfo{
-		Metadata: object.Metadata{
-			Owner: n1.GetPeerKey().PublicKey().DID(),
-		},
-		Addresses: n1.GetAddresses(),
-		Relays: []*peer.ConnectionInfo{
-			p0,
+	resolver := &testResolver{
+		peers: map[string][]*peer.ConnectionInfo{
+			p2.Metadata.Owner.String(): {p2},
 		},
 	}
 
-	p2 := &peer.ConnectionInfo{
-		Metadata: object.Metadata{
-			Owner: n2.GetPeerKey().PublicKey().DID(),
-		},
-		Addresses: n2.GetAddresses(),
-		Relays: []*peer.ConnectionInfo{
-			p0,
-		},
-	}
+	sb := net.NewSwitchboard(1)
+	n1 := New(
+		context.Background(),
+		newMemNet(sb, k1),
+		k1,
+		WithOutbox(str),
+		WithDeliveryRetries(1, 100*time.Millisecond),
+	)
+	n1.RegisterResolver(resolver)
 
 	testObj := &object.Object{
 		Type: "foo",
@@ -271,27 +986,396 @@ func TestNetwork_Relay(t *testing.T) {
 		},
 	}
 
-	testObjFromP1 := &object.Object{
-		Type: "foo",
+	// n2 is offline, so the object should be kept in the outbox
+	res := &DeliveryResult{}
+	err = n1.Send(
+		context.Background(),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithReliableDelivery(res),
+	)
+	require.ErrorIs(t, err, ErrDeliveryNotAcknowledged)
+	assert.Equal(t, DeliveryQueued, res.Status)
+
+	entries, err := str.GetOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, p2.Metadata.Owner, entries[0].Recipient)
+	require.NoError(t, n1.Close())
+
+	// once n2 is online, restarting n1 should deliver it
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l2.Close()
+	sub := n2.Subscribe(FilterByObjectType("foo"))
+
+	n1 = New(
+		context.Background(),
+		newMemNet(sb, k1),
+		k1,
+		WithOutbox(str),
+		WithDeliveryRetries(3, 100*time.Millisecond),
+	)
+	n1.RegisterResolver(resolver)
+	defer n1.Close()
+
+	select {
+	case env := <-sub.Channel():
+		assert.Equal(t, testObj.Data, env.Payload.Data)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for outbox object")
+	}
+
+	require.Eventually(t, func() bool {
+		entries, err := str.GetOutbox()
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNetwork_Call(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1)
+	n2 := New(
+		context.Background(),
+		newMemNet(sb, k2),
+		k2,
+		WithCallLimits(1, 10),
+	)
+
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l2.Close()
+
+	p2 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
-			Owner: n1.GetPeerKey().PublicKey().DID(),
-		},
-		Data: tilde.Map{
-			"foo": tilde.String("bar"),
+			Owner: k2.PublicKey().DID(),
 		},
+		Addresses: n2.GetAddresses(),
 	}
 
-	testObjFromP2 := &object.Object{
-		Type: "foo",
+	newObj := func(objType string) *object.Object {
+		return &object.Object{
+			Type: objType,
+			Data: tilde.Map{
+				"foo": tilde.String("bar"),
+			},
+		}
+	}
+
+	unblock := make(chan struct{})
+	n2.Handle(
+		object.RequestType,
+		func(ctx context.Context, c *IncomingCall, rw ResponseWriter) error {
+			req := &object.Request{}
+			if err := c.Decode(req); err != nil {
+				return err
+			}
+			if req.ObjectHash.IsEmpty() {
+				return ErrNotFound
+			}
+			return rw.Write(&object.Response{
+				RequestID: req.RequestID,
+				Found:     true,
+			})
+		},
+	)
+	n2.Handle(
+		"stream",
+		func(ctx context.Context, c *IncomingCall, rw ResponseWriter) error {
+			for i := 0; i < 3; i++ {
+				o := newObj("item")
+				o.Data["i"] = tilde.Int(i)
+				if err := rw.Write(o); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	n2.Handle(
+		"block",
+		func(ctx context.Context, c *IncomingCall, rw ResponseWriter) error {
+			select {
+			case <-unblock:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	)
+
+	t.Run("typed request and response", func(t *testing.T) {
+		res := &object.Response{}
+		err := n1.Call(
+			context.New(),
+			p2.Metadata.Owner,
+			&object.Request{
+				RequestID:  "1",
+				ObjectHash: newObj("foo").Hash(),
+			},
+			res,
+			CallWithConnectionInfo(p2),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "1", res.RequestID)
+		assert.True(t, res.Found)
+	})
+
+	t.Run("remote error", func(t *testing.T) {
+		err := n1.Call(
+			context.New(),
+			p2.Metadata.Owner,
+			&object.Request{
+				RequestID: "2",
+			},
+			&object.Response{},
+			CallWithConnectionInfo(p2),
+		)
+		require.ErrorIs(t, err, ErrCallFailed)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("unhandled request type", func(t *testing.T) {
+		err := n1.Call(
+			context.New(),
+			p2.Metadata.Owner,
+			newObj("foo"),
+			nil,
+			CallWithConnectionInfo(p2),
+		)
+		require.ErrorIs(t, err, ErrCallUnhandled)
+	})
+
+	t.Run("streaming responses", func(t *testing.T) {
+		r, err := n1.CallStream(
+			context.New(),
+			p2.Metadata.Owner,
+			newObj("stream"),
+			CallWithConnectionInfo(p2),
+		)
+		require.NoError(t, err)
+		defer r.Close()
+		os, err := object.ReadAll(r)
+		require.NoError(t, err)
+		require.Len(t, os, 3)
+		for i, o := range os {
+			assert.Equal(t, tilde.Int(i), o.Data["i"])
+		}
+	})
+
+	t.Run("timeout and concurrent call limit", func(t *testing.T) {
+		err := n1.Call(
+			context.New(),
+			p2.Metadata.Owner,
+			newObj("block"),
+			nil,
+			CallWithConnectionInfo(p2),
+			CallWithTimeout(100*time.Millisecond),
+		)
+		require.ErrorIs(t, err, ErrCallTimedOut)
+
+		// n2 is still handling the previous call
+		err = n1.Call(
+			context.New(),
+			p2.Metadata.Owner,
+			newObj("block"),
+			nil,
+			CallWithConnectionInfo(p2),
+		)
+		require.ErrorIs(t, err, ErrCallLimitExceeded)
+
+		close(unblock)
+		require.Eventually(t, func() bool {
+			err := n1.Call(
+				context.New(),
+				p2.Metadata.Owner,
+				newObj("block"),
+				nil,
+				CallWithConnectionInfo(p2),
+			)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestNetwork_RateLimit(t *testing.T) {
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	n1 := New(
+		context.Background(),
+		newMemNet(sb, k1),
+		k1,
+		WithOutboundRateLimit(RateLimit{}, RateLimit{Objects: 10}),
+	)
+	n2 := New(
+		context.Background(),
+		newMemNet(sb, k2),
+		k2,
+		WithInboundRateLimit(RateLimit{}, RateLimit{Objects: 100}),
+		WithObjectTypeRateLimit("limited", RateLimit{Objects: 2}),
+		WithRateLimitBlocklist(3, time.Minute),
+	)
+
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
+	require.NoError(t, err)
+	defer l2.Close()
+
+	p2 := &peer.ConnectionInfo{
 		Metadata: object.Metadata{
-			Owner: n2.GetPeerKey().PublicKey().DID(),
+			Owner: k2.PublicKey().DID(),
 		},
+		Addresses: n2.GetAddresses(),
+	}
+
+	newObj := func(objType string, i int) *object.Object {
+		return &object.Object{
+			Type: objType,
+			Data: tilde.Map{
+				"i": tilde.Int(i),
+			},
+		}
+	}
+
+	sub := n2.Subscribe(FilterByObjectType("limited"))
+	defer sub.Cancel()
+
+	// the outbound limit holds back objects until there is room for them
+	for i := 0; i < 10; i++ {
+		err := n1.Send(
+			context.Background(),
+			newObj("foo", i),
+			k2.PublicKey().DID(),
+			SendWithConnectionInfo(p2),
+		)
+		require.NoError(t, err)
+	}
+	ctx := context.New(context.WithTimeout(50 * time.Millisecond))
+	defer ctx.Cancel()
+	err = n1.Send(
+		ctx,
+		newObj("foo", 10),
+		k2.PublicKey().DID(),
+		SendWithConnectionInfo(p2),
+	)
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	// objects over the inbound limits are dropped, and peers that keep
+	// sending them get blocked
+	for i := 0; i < 5; i++ {
+		err := n1.Send(
+			context.Background(),
+			newObj("limited", i),
+			k2.PublicKey().DID(),
+			SendWithConnectionInfo(p2),
+		)
+		require.NoError(t, err)
+	}
+	nn2 := n2.(*network)
+	require.Eventually(t, func() bool {
+		_, err := nn2.net.GetConnection(k1.PublicKey())
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	received := 0
+	objects := sub.Channel()
+	for {
+		select {
+		case <-objects:
+			received++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	require.Equal(t, 2, received)
+}
+
+func TestRateLimits_Limiter(t *testing.T) {
+	l := newLimiter(RateLimit{Objects: 2, Bytes: 100})
+
+	// objects that go over the bytes limit should not use up objects
+	assert.True(t, l.allow(80))
+	assert.False(t, l.allow(80))
+	assert.True(t, l.allow(10))
+
+	// rejected objects should give back what they were allowed
+	l = newLimiter(RateLimit{Objects: 1})
+	assert.True(t, l.allow(0))
+	l.refund(0)
+	assert.True(t, l.allow(0))
+	assert.False(t, l.allow(0))
+}
+
+func TestRateLimits_PeerExpiration(t *testing.T) {
+	r := newRateLimits()
+	r.peers = cache.New(100*time.Millisecond, time.Minute)
+
+	// limiters that keep being used should not expire
+	pl := r.peer("in/foo", RateLimit{Objects: 1})
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		assert.Same(t, pl, r.peer("in/foo", RateLimit{Objects: 1}))
+	}
+
+	// but idle ones should
+	time.Sleep(150 * time.Millisecond)
+	assert.NotSame(t, pl, r.peer("in/foo", RateLimit{Objects: 1}))
+}
+
+func TestNetwork_HolePunch(t *testing.T) {
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sb := net.NewSwitchboard(1)
+	nn1 := newMemNet(sb, k1)
+	n0 := New(context.Background(), newMemNet(sb, k0), k0, WithRelay())
+	n1 := New(context.Background(), nn1, k1)
+	n2 := New(context.Background(), newMemNet(sb, k2), k2)
+
+	for i, n := range []Network{n0, n1, n2} {
+		l, err := n.Listen(
+			context.Background(),
+			fmt.Sprintf("mem:n%d", i),
+			ListenOnLocalIPs,
+		)
+		require.NoError(t, err)
+		defer l.Close()
+	}
+
+	// n1 and n2 can both reach n0, but not each other until they punch
+	sb.SetBehindNAT(k1.PublicKey(), true)
+	sb.SetBehindNAT(k2.PublicKey(), true)
+
+	p0 := n0.GetConnectionInfo()
+	n2.RegisterRelays(p0)
+	requireReservations(t, n2)
+	p2 := n2.GetConnectionInfo()
+
+	testObj := &object.Object{
+		Type: "foo",
 		Data: tilde.Map{
 			"foo": tilde.String("bar"),
 		},
 	}
 
-	// send from p1 to p0
+	// connect both peers to the relay
 	err = n1.Send(
 		context.Background(),
 		testObj,
@@ -299,9 +1383,6 @@ func TestNetwork_Relay(t *testing.T) {
 		SendWithConnectionInfo(p0),
 	)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 500)
-
-	// send from p2 to p0
 	err = n2.Send(
 		context.Background(),
 		testObj,
@@ -309,48 +1390,64 @@ func TestNetwork_Relay(t *testing.T) {
 		SendWithConnectionInfo(p0),
 	)
 	require.NoError(t, err)
-	time.Sleep(time.Millisecond * 500)
 
-	// now we should be able to send from p1 to p2
+	// the first object has to go through n0
+	syncs := n1.Subscribe(FilterByObjectType(HolePunchSyncType))
 	sub := n2.Subscribe(FilterByObjectType("foo"))
 	err = n1.Send(
 		context.Background(),
-		testObjFromP1,
+		testObj,
 		p2.Metadata.Owner,
 		SendWithConnectionInfo(p2),
 	)
 	require.NoError(t, err)
 
-	env, err := sub.Next()
-	require.NoError(t, err)
+	select {
+	case env := <-sub.Channel():
+		assert.Equal(t, testObj, env.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for relayed object")
+	}
 
-	require.NotNil(t, sub)
-	assert.Equal(t,
-		testObjFromP1.Metadata.Signature,
-		env.Payload.Metadata.Signature,
-	)
+	// n0 should let n1 know the formats n2 supports, so that the punched
+	// connection can use them
+	select {
+	case env := <-syncs.Channel():
+		s := &HolePunchSync{}
+		require.NoError(t, object.Unmarshal(env.Payload, s))
+		assert.Equal(t, k2.PublicKey(), s.Peer)
+		assert.Equal(t, n2.GetConnectionInfo().ObjectFormats, s.ObjectFormats)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for hole punch sync")
+	}
 
-	time.Sleep(time.Millisecond * 500)
+	// but n0 should help them establish a direct connection
+	require.Eventually(t, func() bool {
+		_, err := nn1.GetConnection(k2.PublicKey())
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
 
-	// send from p2 to p1
-	sub = n1.Subscribe(FilterByObjectType("foo"))
+	// which should be used from now on, even if n0 is no longer reachable
+	sb.Partition(
+		[]crypto.PublicKey{k0.PublicKey()},
+		[]crypto.PublicKey{k1.PublicKey(), k2.PublicKey()},
+	)
 
-	err = n2.Send(
+	sub = n2.Subscribe(FilterByObjectType("foo"))
+	err = n1.Send(
 		context.Background(),
-		testObjFromP2,
-		p1.Metadata.Owner,
-		SendWithConnectionInfo(p1),
+		testObj,
+		p2.Metadata.Owner,
+		SendWithConnectionInfo(p2),
 	)
 	require.NoError(t, err)
 
-	env, err = sub.Next()
-	require.NoError(t, err)
-
-	require.NotNil(t, sub)
-	assert.Equal(t,
-		testObjFromP2.Metadata.Signature,
-		env.Payload.Metadata.Signature,
-	)
+	select {
+	case env := <-sub.Channel():
+		assert.Equal(t, testObj, env.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for direct object")
+	}
 }
 
 func Test_network_lookup(t *testing.T) {
@@ -462,9 +1559,10 @@ func Test_network_lookup(t *testing.T) {
 func BenchmarkNetworkSendToSinglePeer(b *testing.B) {
 	k1, err := crypto.NewEd25519PrivateKey()
 	require.NoError(b, err)
-	n1 := New(context.Background(), net.New(k1), k1).(*network)
+	sb := net.NewSwitchboard(1)
+	n1 := New(context.Background(), newMemNet(sb, k1), k1).(*network)
 
-	l1, err := n1.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	l1, err := n1.Listen(context.Background(), "mem:n1", ListenOnLocalIPs)
 	require.NoError(b, err)
 	defer l1.Close()
 
@@ -473,7 +1571,7 @@ func BenchmarkNetworkSendToSinglePeer(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		k2, err := crypto.NewEd25519PrivateKey()
 		require.NoError(b, err)
-		n2 := New(context.Background(), net.New(k2), k2).(*network)
+		n2 := New(context.Background(), newMemNet(sb, k2), k2).(*network)
 		err = n2.Send(
 			context.Background(),
 			&object.Object{
@@ -514,14 +1612,25 @@ func TestNetwork_RequestRespond(t *testing.T) {
 	s1 := objectstoremock.NewMockStore(gomock.NewController(t))
 	s2 := objectstoremock.NewMockStore(gomock.NewController(t))
 
-	n1 := New(context.Background(), net.New(k1), k1, s1)
-	n2 := New(context.Background(), net.New(k2), k2, s2)
+	sb := net.NewSwitchboard(1)
+	n1 := New(
+		context.Background(),
+		newMemNet(sb, k1),
+		k1,
+		WithObjectStore(s1),
+	)
+	n2 := New(
+		context.Background(),
+		newMemNet(sb, k2),
+		k2,
+		WithObjectStore(s2),
+	)
 
-	l1, err := n1.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	l1, err := n1.Listen(context.Background(), "mem:n1", ListenOnLocalIPs)
 	require.NoError(t, err)
 	defer l1.Close()
 
-	l2, err := n2.Listen(context.Background(), "127.0.0.1:0", ListenOnLocalIPs)
+	l2, err := n2.Listen(context.Background(), "mem:n2", ListenOnLocalIPs)
 	require.NoError(t, err)
 	defer l2.Close()
 
@@ -549,6 +1658,47 @@ func TestNetwork_RequestRespond(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, testObj, res.Object)
 	require.Equal(t, true, res.Found)
+
+	// requests that cannot be verified are ignored
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	err = n2.Send(
+		context.New(),
+		object.MustMarshal(&object.Request{
+			Metadata: object.Metadata{
+				Owner: k3.PublicKey().DID(),
+			},
+			RequestID:  "bar",
+			ObjectHash: testObj.Hash(),
+		}),
+		n1.GetConnectionInfo().Metadata.Owner,
+		SendWithConnectionInfo(n1.GetConnectionInfo()),
+		SendWithResponse(&object.Response{}, 100*time.Millisecond),
+	)
+	require.Error(t, err)
+}
+
+// newMemNet constructs a net that can only reach peers on the same
+// switchboard
+// requireReservations waits for all networks to have been given reservations
+// by the relays they have registered
+func requireReservations(t *testing.T, ns ...Network) {
+	require.Eventually(t, func() bool {
+		for _, n := range ns {
+			if len(n.GetRelays()) == 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func newMemNet(sb *net.Switchboard, k crypto.PrivateKey) net.Network {
+	return net.New(
+		k,
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	)
 }
 
 type testResolver struct {
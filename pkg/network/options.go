@@ -3,6 +3,10 @@ package network
 import (
 	"time"
 
+	"nimona.io/internal/net"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/peer"
 )
 
@@ -11,7 +15,19 @@ type (
 		connectionInfo         *peer.ConnectionInfo
 		waitForResponse        interface{}
 		waitForResponseTimeout time.Duration
+		priority               Priority
+		mailboxTTL             time.Duration
+		delivery               *DeliveryResult
 	}
+	// Priority allows objects to overtake others going to the same peer,
+	// only peers supporting multiplexed connections take it into account
+	Priority = net.Priority
+)
+
+const (
+	PriorityHigh   = net.PriorityHigh
+	PriorityNormal = net.PriorityNormal
+	PriorityLow    = net.PriorityLow
 )
 
 func SendWithConnectionInfo(c *peer.ConnectionInfo) func(*sendOptions) {
@@ -32,3 +48,188 @@ func SendWithResponse(
 		w.waitForResponseTimeout = t
 	}
 }
+
+func SendWithPriority(p Priority) func(*sendOptions) {
+	return func(w *sendOptions) {
+		w.priority = p
+	}
+}
+
+// SendWithMailbox allows leaving the object in the mailbox of one of the
+// recipient's relays if it cannot be reached, the mailbox will keep it for up
+// to the given duration
+func SendWithMailbox(ttl time.Duration) func(*sendOptions) {
+	return func(w *sendOptions) {
+		w.mailboxTTL = ttl
+	}
+}
+
+// SendWithReliableDelivery waits for the recipient to acknowledge the object
+// and retries sending it until it does, the outcome is reported in the given
+// result which can be nil
+func SendWithReliableDelivery(r *DeliveryResult) func(*sendOptions) {
+	return func(w *sendOptions) {
+		if r == nil {
+			r = &DeliveryResult{}
+		}
+		w.delivery = r
+	}
+}
+
+// WithObjectStore allows the network to respond to object requests using the
+// objects in the given store
+func WithObjectStore(s objectstore.Store) Option {
+	return func(w *network) {
+		w.store = s
+	}
+}
+
+// WithKeyResolver allows the policies of the objects in the store to be
+// evaluated against the key streams of the peers requesting them
+func WithKeyResolver(r object.KeyResolver) Option {
+	return func(w *network) {
+		w.keyResolver = r
+	}
+}
+
+// WithOutbox persists objects sent with reliable delivery until they are
+// acknowledged, and retries them in the background
+func WithOutbox(o objectstore.Outbox) Option {
+	return func(w *network) {
+		w.outbox = o
+	}
+}
+
+// WithDeliveryRetries sets how many times objects sent with reliable
+// delivery are attempted, and how long to wait for their acknowledgement;
+// the wait between attempts starts at the same duration and doubles every time
+func WithDeliveryRetries(attempts int, timeout time.Duration) Option {
+	return func(w *network) {
+		w.deliveryAttempts = attempts
+		w.deliveryTimeout = timeout
+	}
+}
+
+// WithCallLimits limits the number of calls that can be handled at the same
+// time for any single peer, as well as for all of them
+func WithCallLimits(perPeer int, total int) Option {
+	return func(w *network) {
+		w.callLimits.maxPerPeer = perPeer
+		w.callLimits.maxTotal = total
+	}
+}
+
+// WithInboundRateLimit limits the objects we receive overall, as well as
+// from any single peer
+func WithInboundRateLimit(global RateLimit, perPeer RateLimit) Option {
+	return func(w *network) {
+		w.rateLimits.setInbound(global, perPeer)
+	}
+}
+
+// WithOutboundRateLimit limits the objects we send overall, as well as to
+// any single peer
+func WithOutboundRateLimit(global RateLimit, perPeer RateLimit) Option {
+	return func(w *network) {
+		w.rateLimits.setOutbound(global, perPeer)
+	}
+}
+
+// WithObjectTypeRateLimit limits the objects of the given type we receive
+// and send, each direction is limited separately
+func WithObjectTypeRateLimit(objectType string, l RateLimit) Option {
+	return func(w *network) {
+		w.rateLimits.setObjectType(objectType, l)
+	}
+}
+
+// WithRateLimitBlocklist sets how many objects peers can send us over the
+// inbound rate limits within a minute before they are blocked, and for how
+// long they will be blocked for
+func WithRateLimitBlocklist(violations int, d time.Duration) Option {
+	return func(w *network) {
+		w.rateLimits.blockAfter = violations
+		w.rateLimits.blockFor = d
+	}
+}
+
+// WithRelay enables relaying objects for peers that have requested a
+// reservation with us
+func WithRelay(opts ...RelayOption) Option {
+	return func(w *network) {
+		w.relay = newRelay(opts...)
+	}
+}
+
+// RelayWithReservationTTL sets how long reservations last before they need
+// to be renewed, quotas are also reset on the same interval
+func RelayWithReservationTTL(d time.Duration) RelayOption {
+	return func(r *relay) {
+		r.reservationTTL = d
+	}
+}
+
+// RelayWithMaxReservations limits the number of active reservations
+func RelayWithMaxReservations(n int) RelayOption {
+	return func(r *relay) {
+		r.maxReservations = n
+	}
+}
+
+// RelayWithQuota limits the bytes and number of objects relayed to or from
+// any single peer during each reservation period
+func RelayWithQuota(bytes int, messages int) RelayOption {
+	return func(r *relay) {
+		r.maxBytes = bytes
+		r.maxMessages = messages
+	}
+}
+
+// RelayWithAllowList only allows the given peers to make reservations
+func RelayWithAllowList(ids ...did.DID) RelayOption {
+	return func(r *relay) {
+		for _, id := range ids {
+			r.allowList[id.String()] = struct{}{}
+		}
+	}
+}
+
+// RelayWithDenyList refuses to relay objects to or from the given peers
+func RelayWithDenyList(ids ...did.DID) RelayOption {
+	return func(r *relay) {
+		for _, id := range ids {
+			r.denyList[id.String()] = struct{}{}
+		}
+	}
+}
+
+// WithMailbox enables keeping objects for offline peers until they reconnect
+func WithMailbox(opts ...MailboxOption) Option {
+	return func(w *network) {
+		w.mailbox = newMailbox(opts...)
+	}
+}
+
+// MailboxWithMaxTTL caps how long deposited objects are kept for
+func MailboxWithMaxTTL(d time.Duration) MailboxOption {
+	return func(m *mailbox) {
+		m.maxTTL = d
+	}
+}
+
+// MailboxWithMaxSize limits the bytes kept for any single recipient, as well
+// as for all of them
+func MailboxWithMaxSize(recipientBytes int, totalBytes int) MailboxOption {
+	return func(m *mailbox) {
+		m.maxRecipientBytes = recipientBytes
+		m.maxBytes = totalBytes
+	}
+}
+
+// MailboxWithMaxSenderSize limits the bytes any single sender can have in the
+// mailbox, across all recipients
+func MailboxWithMaxSenderSize(senderBytes int) MailboxOption {
+	return func(m *mailbox) {
+		m.maxSenderBytes = senderBytes
+	}
+}
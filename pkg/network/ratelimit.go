@@ -0,0 +1,271 @@
+package network
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/ratelimit"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+)
+
+// Objects are rate limited both when they are received and when they are
+// sent, overall, per remote peer, and per object type.
+// Inbound objects over the global limit are held back until there is room
+// for them, which in turn holds back the connections they are read from.
+// Inbound objects over the peer or type limits are dropped instead, and
+// peers that keep going over them are blocked for a while.
+// Outbound objects always wait until there is room for them.
+
+const (
+	rateLimitDefaultBlockAfter = 100
+	rateLimitDefaultBlockFor   = 5 * time.Minute
+	// rateLimitViolationsWindow is how long violations are remembered for
+	rateLimitViolationsWindow = time.Minute
+)
+
+var objRateLimitedCounter = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "nimona_exchange_object_rate_limited_total",
+		Help: "Total number of objects dropped for going over a rate limit",
+	},
+)
+
+type (
+	// RateLimit is the number of objects and bytes allowed per second, zero
+	// values are not limited
+	RateLimit struct {
+		Objects int
+		Bytes   int
+	}
+	// limiter enforces a RateLimit, nil limiters do not limit anything
+	limiter struct {
+		objects *ratelimit.Bucket
+		bytes   *ratelimit.Bucket
+	}
+	// rateLimits holds the limiters for both directions, limiters for peers
+	// are created the first time we see them and expire once they have not
+	// been used for a while
+	rateLimits struct {
+		mutex           sync.Mutex
+		inbound         *limiter
+		outbound        *limiter
+		inboundPerPeer  RateLimit
+		outboundPerPeer RateLimit
+		inboundTypes    map[string]*limiter
+		outboundTypes   map[string]*limiter
+		peers           *cache.Cache
+		violations      *cache.Cache
+		blockAfter      int
+		blockFor        time.Duration
+		// limitsBytes is set when any of the limits needs to know the size
+		// of the objects
+		limitsBytes bool
+	}
+)
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{
+		inboundTypes:  map[string]*limiter{},
+		outboundTypes: map[string]*limiter{},
+		peers:         cache.New(time.Minute, time.Minute),
+		violations:    cache.New(rateLimitViolationsWindow, time.Minute),
+		blockAfter:    rateLimitDefaultBlockAfter,
+		blockFor:      rateLimitDefaultBlockFor,
+	}
+}
+
+func newLimiter(l RateLimit) *limiter {
+	if l.Objects <= 0 && l.Bytes <= 0 {
+		return nil
+	}
+	r := &limiter{}
+	if l.Objects > 0 {
+		r.objects = ratelimit.NewBucket(l.Objects)
+	}
+	if l.Bytes > 0 {
+		r.bytes = ratelimit.NewBucket(l.Bytes)
+	}
+	return r
+}
+
+// allow takes an object and its bytes if both are available, if either of
+// them is not, nothing is taken
+func (l *limiter) allow(size int) bool {
+	if l == nil {
+		return true
+	}
+	if l.objects != nil && !l.objects.Allow(1) {
+		return false
+	}
+	if l.bytes != nil && !l.bytes.Allow(size) {
+		if l.objects != nil {
+			l.objects.Return(1)
+		}
+		return false
+	}
+	return true
+}
+
+// refund gives back an object and its bytes that were allowed but ended up
+// being rejected by another limiter
+func (l *limiter) refund(size int) {
+	if l == nil {
+		return
+	}
+	if l.objects != nil {
+		l.objects.Return(1)
+	}
+	if l.bytes != nil {
+		l.bytes.Return(size)
+	}
+}
+
+func (l *limiter) wait(ctx context.Context, size int) error {
+	if l == nil {
+		return nil
+	}
+	if l.objects != nil {
+		if err := l.objects.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if l.bytes != nil {
+		if err := l.bytes.Wait(ctx, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rateLimits) setInbound(global, perPeer RateLimit) {
+	r.inbound = newLimiter(global)
+	r.inboundPerPeer = perPeer
+	r.limitsBytes = r.limitsBytes || global.Bytes > 0 || perPeer.Bytes > 0
+}
+
+func (r *rateLimits) setOutbound(global, perPeer RateLimit) {
+	r.outbound = newLimiter(global)
+	r.outboundPerPeer = perPeer
+	r.limitsBytes = r.limitsBytes || global.Bytes > 0 || perPeer.Bytes > 0
+}
+
+func (r *rateLimits) setObjectType(objectType string, l RateLimit) {
+	r.inboundTypes[objectType] = newLimiter(l)
+	r.outboundTypes[objectType] = newLimiter(l)
+	r.limitsBytes = r.limitsBytes || l.Bytes > 0
+}
+
+// size returns the size of the object, as long as it is needed
+func (r *rateLimits) size(o *object.Object) int {
+	if !r.limitsBytes {
+		return 0
+	}
+	b, _ := json.Marshal(o) // nolint: errcheck
+	return len(b)
+}
+
+// peer returns the limiter for the given peer and direction, or nil if peers
+// are not limited
+func (r *rateLimits) peer(key string, l RateLimit) *limiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if v, ok := r.peers.Get(key); ok {
+		// push back the expiration of limiters that are still in use
+		r.peers.SetDefault(key, v)
+		return v.(*limiter)
+	}
+	pl := newLimiter(l)
+	if pl == nil {
+		return nil
+	}
+	r.peers.SetDefault(key, pl)
+	return pl
+}
+
+// admitInbound waits for the global limit, and checks whether the object
+// is within the limits of its sender and type
+func (r *rateLimits) admitInbound(
+	ctx context.Context,
+	sender crypto.PublicKey,
+	o *object.Object,
+) (bool, error) {
+	size := r.size(o)
+	if err := r.inbound.wait(ctx, size); err != nil {
+		return false, err
+	}
+	pl := r.peer("in/"+sender.String(), r.inboundPerPeer)
+	if !pl.allow(size) {
+		return false, nil
+	}
+	if !r.inboundTypes[o.Type].allow(size) {
+		pl.refund(size)
+		return false, nil
+	}
+	return true, nil
+}
+
+// waitOutbound waits until the object is within all of the outbound limits
+func (r *rateLimits) waitOutbound(
+	ctx context.Context,
+	recipient crypto.PublicKey,
+	o *object.Object,
+) error {
+	size := r.size(o)
+	if err := r.outbound.wait(ctx, size); err != nil {
+		return err
+	}
+	pl := r.peer("out/"+recipient.String(), r.outboundPerPeer)
+	if err := pl.wait(ctx, size); err != nil {
+		return err
+	}
+	return r.outboundTypes[o.Type].wait(ctx, size)
+}
+
+// violation records that the peer went over its limits, and returns whether
+// it has done so often enough to be blocked
+func (r *rateLimits) violation(sender crypto.PublicKey) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := sender.String()
+	n := 1
+	if v, ok := r.violations.Get(key); ok {
+		n += v.(int)
+	}
+	if n >= r.blockAfter {
+		r.violations.Delete(key)
+		return true
+	}
+	r.violations.SetDefault(key, n)
+	return false
+}
+
+// admitInbound checks the object against the inbound rate limits, and
+// blocks peers that keep going over them
+func (w *network) admitInbound(
+	sender crypto.PublicKey,
+	o *object.Object,
+) bool {
+	ok, err := w.rateLimits.admitInbound(context.New(), sender, o)
+	if err != nil || ok {
+		return ok
+	}
+	objRateLimitedCounter.Inc()
+	if !w.rateLimits.violation(sender) {
+		return false
+	}
+	log.DefaultLogger.Named("network").Info(
+		"blocking peer for going over rate limits",
+		log.String("method", "network.admitInbound"),
+		log.String("remote.publicKey", sender.String()),
+	)
+	w.net.BlockPeer(sender, w.rateLimits.blockFor)
+	return false
+}
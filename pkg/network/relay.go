@@ -0,0 +1,348 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+)
+
+// Peers that are not directly reachable ask relays to reserve a slot for
+// them, and only advertise the relays that have done so in their connection
+// info. Relays only forward objects to peers with an active reservation, and
+// account for every object they forward against the quotas of both its
+// sender and its recipient.
+
+const (
+	relayDefaultReservationTTL  = 10 * time.Minute
+	relayDefaultMaxReservations = 128
+	relayDefaultMaxBytes        = 16 << 20
+	relayDefaultMaxMessages     = 1024
+	// relayReservationRetry is how long to wait before asking a relay for a
+	// reservation again after it failed to give us one
+	relayReservationRetry = 30 * time.Second
+)
+
+var (
+	relayReservationsGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nimona_exchange_relay_reservations",
+			Help: "Number of active reservations for relaying objects",
+		},
+	)
+	objRelayedRefusedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_relayed_refused_total",
+			Help: "Total number of objects refused to relay on behalf of others",
+		},
+	)
+	objRelayedBytesCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_object_relayed_bytes_total",
+			Help: "Total number of bytes relayed on behalf of others",
+		},
+	)
+)
+
+type (
+	// RelayOption for customizing WithRelay
+	RelayOption func(*relay)
+	// relay keeps track of the reservations and the usage of the peers we
+	// are relaying objects for
+	relay struct {
+		mutex           sync.Mutex
+		reservationTTL  time.Duration
+		maxReservations int
+		maxBytes        int
+		maxMessages     int
+		allowList       map[string]struct{}
+		denyList        map[string]struct{}
+		reservations    map[string]time.Time
+		// objectFormats are the formats each peer with a reservation
+		// supports, they are passed on when coordinating hole punches
+		objectFormats map[string][]string
+		usage         map[string]*relayUsage
+	}
+	// relayUsage is how much of its quota a peer has used since the start of
+	// the current period, periods last as long as reservations do
+	relayUsage struct {
+		start    time.Time
+		bytes    int
+		messages int
+	}
+)
+
+func newRelay(opts ...RelayOption) *relay {
+	r := &relay{
+		reservationTTL:  relayDefaultReservationTTL,
+		maxReservations: relayDefaultMaxReservations,
+		maxBytes:        relayDefaultMaxBytes,
+		maxMessages:     relayDefaultMaxMessages,
+		allowList:       map[string]struct{}{},
+		denyList:        map[string]struct{}{},
+		reservations:    map[string]time.Time{},
+		objectFormats:   map[string][]string{},
+		usage:           map[string]*relayUsage{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// reserve creates or renews the reservation of the given peer and returns
+// how long it will last
+func (r *relay) reserve(
+	id did.DID,
+	objectFormats []string,
+) (time.Duration, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isDenied(id) {
+		return 0, ErrRelayDenied
+	}
+	if len(r.allowList) > 0 {
+		if _, ok := r.allowList[id.String()]; !ok {
+			return 0, ErrRelayDenied
+		}
+	}
+
+	now := time.Now()
+	for k, expires := range r.reservations {
+		if now.After(expires) {
+			delete(r.reservations, k)
+			delete(r.objectFormats, k)
+		}
+	}
+	for k, u := range r.usage {
+		if now.Sub(u.start) > r.reservationTTL {
+			delete(r.usage, k)
+		}
+	}
+
+	_, renewal := r.reservations[id.String()]
+	if !renewal && len(r.reservations) >= r.maxReservations {
+		return 0, ErrRelayReservationsFull
+	}
+
+	r.reservations[id.String()] = now.Add(r.reservationTTL)
+	r.objectFormats[id.String()] = objectFormats
+	relayReservationsGauge.Set(float64(len(r.reservations)))
+	return r.reservationTTL, nil
+}
+
+// hasReservation checks whether the peer has an active reservation
+func (r *relay) hasReservation(id did.DID) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	expires, ok := r.reservations[id.String()]
+	return ok && time.Now().Before(expires)
+}
+
+// getObjectFormats returns the formats the peer with the given reservation
+// supports
+func (r *relay) getObjectFormats(id did.DID) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.objectFormats[id.String()]
+}
+
+// admit checks whether an object of the given size can be relayed from the
+// sender to the recipient, and if so accounts for it against both of their
+// quotas
+func (r *relay) admit(sender, recipient did.DID, size int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isDenied(sender) || r.isDenied(recipient) {
+		return ErrRelayDenied
+	}
+
+	now := time.Now()
+	expires, ok := r.reservations[recipient.String()]
+	if !ok || now.After(expires) {
+		return ErrRelayNoReservation
+	}
+
+	usages := []*relayUsage{
+		r.getUsage(sender, now),
+		r.getUsage(recipient, now),
+	}
+	for _, u := range usages {
+		if u.messages+1 > r.maxMessages || u.bytes+size > r.maxBytes {
+			return ErrRelayQuotaExceeded
+		}
+	}
+	for _, u := range usages {
+		u.messages++
+		u.bytes += size
+	}
+
+	objRelayedBytesCounter.Add(float64(size))
+	return nil
+}
+
+// getUsage returns the usage of the peer for the current period; must be
+// called with the mutex held
+func (r *relay) getUsage(id did.DID, now time.Time) *relayUsage {
+	u, ok := r.usage[id.String()]
+	if !ok || now.Sub(u.start) > r.reservationTTL {
+		u = &relayUsage{
+			start: now,
+		}
+		r.usage[id.String()] = u
+	}
+	return u
+}
+
+// denies checks whether any of the given peers are on the deny list
+func (r *relay) denies(ids ...did.DID) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, id := range ids {
+		if r.isDenied(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDenied must be called with the mutex held
+func (r *relay) isDenied(id did.DID) bool {
+	_, denied := r.denyList[id.String()]
+	return denied
+}
+
+// handleRelayReservationRequest grants or refuses reservations
+func (w *network) handleRelayReservationRequest(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleRelayReservationRequest"),
+		log.String("sender", e.Sender.String()),
+	)
+
+	req := &RelayReservationRequest{}
+	if err := object.Unmarshal(e.Payload, req); err != nil {
+		logger.Warn("error decoding RelayReservationRequest", log.Error(err))
+		return
+	}
+
+	res := &RelayReservationResponse{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID: req.RequestID,
+	}
+
+	var ttl time.Duration
+	var err error = ErrRelayUnavailable
+	if w.relay != nil {
+		ttl, err = w.relay.reserve(e.Sender, req.ObjectFormats)
+	}
+	if err != nil {
+		logger.Info("refusing relay reservation", log.Error(err))
+		res.Error = err.Error()
+	} else {
+		res.Success = true
+		res.ExpiresIn = int64(ttl / time.Second)
+		if w.mailbox != nil {
+			w.mailbox.register(e.Sender)
+		}
+	}
+
+	reso, err := object.Marshal(res)
+	if err != nil {
+		logger.Warn("error marshaling RelayReservationResponse", log.Error(err))
+		return
+	}
+
+	go func() {
+		err := w.Send(
+			context.New(
+				context.WithTimeout(time.Second),
+			),
+			reso,
+			e.Sender,
+			SendWithPriority(PriorityHigh),
+		)
+		if err != nil {
+			logger.Warn(
+				"error sending RelayReservationResponse",
+				log.Error(err),
+			)
+		}
+	}()
+}
+
+// maintainReservation keeps asking the relay for a reservation, renewing it
+// halfway through its duration, until the network is closed
+func (w *network) maintainReservation(relay *peer.ConnectionInfo) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.maintainReservation"),
+		log.String("relay", relay.Metadata.Owner.String()),
+	)
+	for {
+		wait := relayReservationRetry
+		ttl, err := w.requestReservation(relay)
+		switch {
+		case err != nil:
+			logger.Warn("unable to get relay reservation", log.Error(err))
+		case ttl > time.Second:
+			wait = ttl / 2
+		}
+		select {
+		case <-w.closer:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (w *network) requestReservation(
+	relay *peer.ConnectionInfo,
+) (time.Duration, error) {
+	req := &RelayReservationRequest{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID:     rand.String(8),
+		ObjectFormats: w.net.ObjectFormats(),
+	}
+	reqo, err := object.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+
+	res := &RelayReservationResponse{}
+	err = w.Send(
+		context.New(
+			context.WithTimeout(5*time.Second),
+		),
+		reqo,
+		relay.Metadata.Owner,
+		SendWithConnectionInfo(relay),
+		SendWithResponse(res, 5*time.Second),
+		SendWithPriority(PriorityHigh),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if !res.Success {
+		return 0, errors.Error(res.Error)
+	}
+
+	ttl := time.Duration(res.ExpiresIn) * time.Second
+	w.relayLock.Lock()
+	w.reservations[relay.Metadata.Owner.String()] = time.Now().Add(ttl)
+	w.relayLock.Unlock()
+	return ttl, nil
+}
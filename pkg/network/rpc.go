@@ -0,0 +1,484 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/log"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/peer"
+)
+
+// Calls wrap their request in a CallRequest, which is dispatched to the
+// handler registered for the request's type. Handlers can write any number of
+// responses, each one is sent back in a CallResponse with an increasing
+// sequence, and the last one is marked as done and carries the error the
+// handler returned, if any.
+//
+// The last response a handler writes is held back until the handler returns,
+// so that calls with a single response only need a single CallResponse.
+
+const (
+	callDefaultTimeout    = 5 * time.Second
+	callDefaultMaxPerPeer = 32
+	callDefaultMaxTotal   = 256
+	// callHandlerTimeout is how long handlers have to respond to a call
+	callHandlerTimeout = time.Minute
+	// callResponseTimeout is how long sending each response can take
+	callResponseTimeout = 5 * time.Second
+)
+
+var (
+	callHandledCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_call_handled_total",
+			Help: "Total number of calls handled",
+		},
+	)
+	callRefusedCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_exchange_call_refused_total",
+			Help: "Total number of calls refused, or without a handler",
+		},
+	)
+)
+
+type (
+	// Handler responds to calls for the request type it was registered for,
+	// returned errors are sent to the caller
+	Handler func(
+		ctx context.Context,
+		call *IncomingCall,
+		rw ResponseWriter,
+	) error
+	// IncomingCall is a call received from another peer
+	IncomingCall struct {
+		Sender  did.DID
+		Request *object.Object
+	}
+	// ResponseWriter sends responses back to the caller, responses can be
+	// either objects or structs that can be marshaled into one
+	ResponseWriter interface {
+		Write(response interface{}) error
+	}
+	// CallOption for customizing Call and CallStream
+	CallOption  func(*callOptions)
+	callOptions struct {
+		connectionInfo *peer.ConnectionInfo
+		timeout        time.Duration
+	}
+	// callLimits keeps track of the calls being handled, overall and per
+	// peer
+	callLimits struct {
+		mutex      sync.Mutex
+		maxPerPeer int
+		maxTotal   int
+		total      int
+		active     map[string]int
+	}
+	responseWriter struct {
+		mutex     sync.Mutex
+		network   *network
+		requestID string
+		recipient did.DID
+		sequence  int64
+		pending   *object.Object
+	}
+)
+
+// CallWithConnectionInfo allows calling peers that cannot be looked up
+func CallWithConnectionInfo(c *peer.ConnectionInfo) CallOption {
+	return func(o *callOptions) {
+		o.connectionInfo = c
+	}
+}
+
+// CallWithTimeout sets how long to wait for the call to complete, it
+// defaults to 5 seconds
+func CallWithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// IncomingCall decodes the call's request into the given struct
+func (c *IncomingCall) Decode(v interface{}) error {
+	return object.Unmarshal(c.Request, v)
+}
+
+func (l *callLimits) acquire(id did.DID) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.total >= l.maxTotal || l.active[id.String()] >= l.maxPerPeer {
+		return false
+	}
+	l.total++
+	l.active[id.String()]++
+	return true
+}
+
+func (l *callLimits) release(id did.DID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total--
+	l.active[id.String()]--
+	if l.active[id.String()] <= 0 {
+		delete(l.active, id.String())
+	}
+}
+
+// toObject returns objects as they are, and marshals anything else
+func toObject(v interface{}) (*object.Object, error) {
+	if o, ok := v.(*object.Object); ok {
+		return o, nil
+	}
+	return object.Marshal(v)
+}
+
+// Handle registers the handler for calls with requests of the given type,
+// replacing any previous one
+func (w *network) Handle(requestType string, handler Handler) {
+	w.handlersLock.Lock()
+	defer w.handlersLock.Unlock()
+	w.handlers[requestType] = handler
+}
+
+// Call sends the request to the given peer and decodes its first response
+// into the given response, which can be nil if we do not care about it.
+// Errors returned by the remote handler are wrapped in ErrCallFailed.
+func (w *network) Call(
+	ctx context.Context,
+	id did.DID,
+	request interface{},
+	response interface{},
+	opts ...CallOption,
+) error {
+	r, err := w.CallStream(ctx, id, request, opts...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	o, err := r.Read()
+	if err == object.ErrReaderDone {
+		if response != nil {
+			return ErrCallNoResponse
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the handler might still have failed after writing the response
+	if _, err := r.Read(); err != nil && err != object.ErrReaderDone {
+		return err
+	}
+
+	switch res := response.(type) {
+	case nil:
+		return nil
+	case *object.Object:
+		*res = *o
+		return nil
+	default:
+		if err := object.Unmarshal(o, res); err != nil {
+			return errors.Merge(ErrUnableToUnmarshalIntoResponse, err)
+		}
+		return nil
+	}
+}
+
+// CallStream sends the request to the given peer and returns a reader for
+// all of its responses.
+// Errors returned by the remote handler are wrapped in ErrCallFailed and are
+// returned by the reader after any responses written before them.
+func (w *network) CallStream(
+	ctx context.Context,
+	id did.DID,
+	request interface{},
+	opts ...CallOption,
+) (object.ReadCloser, error) {
+	opt := &callOptions{
+		timeout: callDefaultTimeout,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	reqo, err := toObject(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &CallRequest{
+		Metadata: object.Metadata{
+			Owner: w.peerKey.PublicKey().DID(),
+		},
+		RequestID: rand.String(12),
+		Payload:   reqo,
+	}
+	cro, err := object.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	callCtx := context.New(
+		context.WithParent(ctx),
+		context.WithTimeout(opt.timeout),
+	)
+
+	sub := w.Subscribe(
+		FilterByObjectType(CallResponseType),
+		FilterByRequestID(req.RequestID),
+	)
+
+	sendOpts := []SendOption{}
+	if opt.connectionInfo != nil {
+		sendOpts = append(sendOpts, SendWithConnectionInfo(opt.connectionInfo))
+	}
+	if err := w.Send(callCtx, cro, id, sendOpts...); err != nil {
+		sub.Cancel()
+		callCtx.Cancel()
+		return nil, err
+	}
+
+	objects := make(chan *object.Object)
+	errs := make(chan error)
+	// the reader's close is non blocking, so the closer needs to be able to
+	// hold it until we get to it
+	closer := make(chan struct{}, 1)
+
+	go func() {
+		defer close(objects)
+		defer callCtx.Cancel()
+		defer sub.Cancel()
+
+		fail := func(err error) {
+			select {
+			case errs <- err:
+			case <-closer:
+			}
+		}
+
+		// responses might arrive out of order when relayed
+		pending := map[int64]*CallResponse{}
+		next := int64(0)
+		responses := sub.Channel()
+		for {
+			select {
+			case <-closer:
+				return
+			case <-callCtx.Done():
+				if ctx.Err() != nil {
+					fail(ctx.Err())
+					return
+				}
+				fail(ErrCallTimedOut)
+				return
+			case e := <-responses:
+				res := &CallResponse{}
+				if err := verifyCallResponse(e.Payload, id, res); err != nil {
+					log.DefaultLogger.Warn(
+						"ignoring invalid CallResponse",
+						log.String("sender", e.Sender.String()),
+						log.Error(err),
+					)
+					continue
+				}
+				pending[res.Sequence] = res
+			}
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if res.Payload != nil {
+					select {
+					case objects <- res.Payload:
+					case <-closer:
+						return
+					case <-callCtx.Done():
+						fail(ErrCallTimedOut)
+						return
+					}
+				}
+				if res.Error != "" {
+					fail(errors.Merge(ErrCallFailed, errors.Error(res.Error)))
+					return
+				}
+				if res.Done {
+					return
+				}
+			}
+		}
+	}()
+
+	return object.NewReadCloser(
+		context.New(),
+		objects,
+		errs,
+		closer,
+	), nil
+}
+
+// verifyCallResponse checks that the response has been signed by the peer
+// we called, responses from key streams can come from any of their peers
+func verifyCallResponse(
+	o *object.Object,
+	callee did.DID,
+	res *CallResponse,
+) error {
+	if err := object.Unmarshal(o, res); err != nil {
+		return err
+	}
+	if res.Metadata.Owner.IsEmpty() {
+		return object.ErrMissingSignature
+	}
+	if err := object.Verify(o); err != nil {
+		return err
+	}
+	if callee.IdentityType == did.IdentityTypePeer &&
+		!res.Metadata.Owner.Equals(callee) {
+		return object.ErrInvalidSigner
+	}
+	return nil
+}
+
+// handleCallRequest dispatches the call to its handler, as long as neither
+// the caller or us are over the limit of concurrent calls
+func (w *network) handleCallRequest(e *Envelope) {
+	logger := log.DefaultLogger.Named("network").With(
+		log.String("method", "network.handleCallRequest"),
+		log.String("sender", e.Sender.String()),
+	)
+
+	// the request might have come through a relay, so the only way to know
+	// who sent it is by its signature
+	if err := object.Verify(e.Payload); err != nil {
+		logger.Warn("error verifying CallRequest", log.Error(err))
+		return
+	}
+
+	req := &CallRequest{}
+	if err := object.Unmarshal(e.Payload, req); err != nil {
+		logger.Warn("error decoding CallRequest", log.Error(err))
+		return
+	}
+	if req.Metadata.Owner.IsEmpty() {
+		logger.Warn("ignoring CallRequest without an owner")
+		return
+	}
+
+	sender := req.Metadata.Owner
+	rw := &responseWriter{
+		network:   w,
+		requestID: req.RequestID,
+		recipient: sender,
+	}
+
+	var handler Handler
+	if req.Payload != nil {
+		w.handlersLock.RLock()
+		handler = w.handlers[req.Payload.Type]
+		w.handlersLock.RUnlock()
+	}
+	if handler == nil {
+		callRefusedCounter.Inc()
+		go rw.close(ErrCallUnhandled) // nolint: errcheck
+		return
+	}
+
+	if !w.callLimits.acquire(sender) {
+		callRefusedCounter.Inc()
+		logger.Info("refusing call, too many concurrent calls")
+		go rw.close(ErrCallLimitExceeded) // nolint: errcheck
+		return
+	}
+
+	go func() {
+		defer w.callLimits.release(sender)
+		ctx := context.New(
+			context.WithTimeout(callHandlerTimeout),
+		)
+		defer ctx.Cancel()
+		err := handler(
+			ctx,
+			&IncomingCall{
+				Sender:  sender,
+				Request: req.Payload,
+			},
+			rw,
+		)
+		callHandledCounter.Inc()
+		if err := rw.close(err); err != nil {
+			logger.Warn("error sending CallResponse", log.Error(err))
+		}
+	}()
+}
+
+// Write sends the previous response, and holds on to this one until either
+// the next one is written or the handler returns
+func (rw *responseWriter) Write(response interface{}) error {
+	o, err := toObject(response)
+	if err != nil {
+		return err
+	}
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	if rw.pending != nil {
+		if err := rw.send(rw.pending, false, nil); err != nil {
+			return err
+		}
+	}
+	rw.pending = o
+	return nil
+}
+
+// close sends the last response along with the handler's error
+func (rw *responseWriter) close(err error) error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.send(rw.pending, true, err)
+}
+
+// send must be called with the mutex held
+func (rw *responseWriter) send(
+	o *object.Object,
+	done bool,
+	handlerErr error,
+) error {
+	res := &CallResponse{
+		Metadata: object.Metadata{
+			Owner: rw.network.peerKey.PublicKey().DID(),
+		},
+		RequestID: rw.requestID,
+		Sequence:  rw.sequence,
+		Done:      done,
+		Payload:   o,
+	}
+	if handlerErr != nil {
+		res.Error = handlerErr.Error()
+	}
+	rw.sequence++
+	reso, err := object.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return rw.network.Send(
+		context.New(
+			context.WithTimeout(callResponseTimeout),
+		),
+		reso,
+		rw.recipient,
+	)
+}
@@ -78,6 +78,45 @@ func (m *MockNetwork) EXPECT() *MockNetworkMockRecorder {
 	return m.recorder
 }
 
+// Call mocks base method.
+func (m *MockNetwork) Call(ctx context.Context, id did.DID, request, response interface{}, opts ...network.CallOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, id, request, response}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Call", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Call indicates an expected call of Call.
+func (mr *MockNetworkMockRecorder) Call(ctx, id, request, response interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, id, request, response}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Call", reflect.TypeOf((*MockNetwork)(nil).Call), varargs...)
+}
+
+// CallStream mocks base method.
+func (m *MockNetwork) CallStream(ctx context.Context, id did.DID, request interface{}, opts ...network.CallOption) (object.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, id, request}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CallStream", varargs...)
+	ret0, _ := ret[0].(object.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CallStream indicates an expected call of CallStream.
+func (mr *MockNetworkMockRecorder) CallStream(ctx, id, request interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, id, request}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CallStream", reflect.TypeOf((*MockNetwork)(nil).CallStream), varargs...)
+}
+
 // Close mocks base method.
 func (m *MockNetwork) Close() error {
 	m.ctrl.T.Helper()
@@ -148,6 +187,18 @@ func (mr *MockNetworkMockRecorder) GetRelays() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRelays", reflect.TypeOf((*MockNetwork)(nil).GetRelays))
 }
 
+// Handle mocks base method.
+func (m *MockNetwork) Handle(requestType string, handler network.Handler) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Handle", requestType, handler)
+}
+
+// Handle indicates an expected call of Handle.
+func (mr *MockNetworkMockRecorder) Handle(requestType, handler interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Handle", reflect.TypeOf((*MockNetwork)(nil).Handle), requestType, handler)
+}
+
 // Listen mocks base method.
 func (m *MockNetwork) Listen(ctx context.Context, bindAddress string, options ...network.ListenOption) (net.Listener, error) {
 	m.ctrl.T.Helper()
@@ -122,6 +122,31 @@ func (m *MockNetworkSimple) GetRelays() []*peer.ConnectionInfo {
 func (m *MockNetworkSimple) RegisterRelays(relays ...*peer.ConnectionInfo) {
 }
 
+func (m *MockNetworkSimple) Handle(
+	requestType string,
+	handler network.Handler,
+) {
+}
+
+func (m *MockNetworkSimple) Call(
+	ctx context.Context,
+	id did.DID,
+	request interface{},
+	response interface{},
+	opts ...network.CallOption,
+) error {
+	panic("not implemented")
+}
+
+func (m *MockNetworkSimple) CallStream(
+	ctx context.Context,
+	id did.DID,
+	request interface{},
+	opts ...network.CallOption,
+) (object.ReadCloser, error) {
+	panic("not implemented")
+}
+
 func (m *MockNetworkSimple) Close() error {
 	return nil
 }
@@ -0,0 +1,235 @@
+package object
+
+import (
+	"encoding/json"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/errors"
+)
+
+// Objects can be encrypted for a number of recipients, either peers that are
+// identified by their public keys, or groups that share a symmetric key.
+// The object is encrypted with a random content key, which is in turn
+// encrypted for each of the recipients.
+// Encrypted objects keep a copy of the original object's metadata, so they
+// can still be part of streams, but the original object's type and data are
+// only available to its recipients.
+
+const (
+	EncryptedType = "nimona.io/object.Encrypted"
+
+	ErrNotEncrypted = errors.Error("object is not encrypted")
+	ErrNoRecipients = errors.Error("no recipients")
+	ErrNotRecipient = errors.Error("not a recipient of the object")
+	// ErrInvalidEnvelope is returned when the metadata of an encrypted object
+	// does not match the object it contains
+	ErrInvalidEnvelope = errors.Error("invalid encrypted envelope")
+)
+
+type (
+	// Encrypted is the envelope of an encrypted object
+	Encrypted struct {
+		Metadata  Metadata            `nimona:"@metadata:m,type=nimona.io/object.Encrypted"`
+		Keys      []EncryptedKey      `nimona:"keys:am"`
+		GroupKeys []EncryptedGroupKey `nimona:"groupKeys:am"`
+		Data      []byte              `nimona:"data:d"`
+	}
+	// EncryptedKey is the content key, encrypted for a single peer with a
+	// shared key derived from an ephemeral sender key
+	EncryptedKey struct {
+		Recipient crypto.PublicKey `nimona:"recipient:s"`
+		Sender    crypto.PublicKey `nimona:"sender:s"`
+		Key       []byte           `nimona:"key:d"`
+	}
+	// EncryptedGroupKey is the content key, encrypted with a group's key
+	EncryptedGroupKey struct {
+		KeyID string `nimona:"keyID:s"`
+		Key   []byte `nimona:"key:d"`
+	}
+	// EncryptOption for configuring the recipients of Encrypt
+	EncryptOption  func(*encryptOptions)
+	encryptOptions struct {
+		recipients []crypto.PublicKey
+		groupKeys  map[string][]byte
+	}
+	// DecryptOption for configuring the keys Decrypt can use
+	DecryptOption  func(*decryptOptions)
+	decryptOptions struct {
+		privateKeys []crypto.PrivateKey
+		groupKeys   map[string][]byte
+	}
+)
+
+// EncryptFor adds peers as recipients of the encrypted object
+func EncryptFor(recipients ...crypto.PublicKey) EncryptOption {
+	return func(opts *encryptOptions) {
+		opts.recipients = append(opts.recipients, recipients...)
+	}
+}
+
+// EncryptWithGroupKey allows anyone with the given group key to decrypt the
+// object
+func EncryptWithGroupKey(keyID string, key []byte) EncryptOption {
+	return func(opts *encryptOptions) {
+		opts.groupKeys[keyID] = key
+	}
+}
+
+// DecryptWithPrivateKey decrypts objects that have been encrypted for the
+// key's public key
+func DecryptWithPrivateKey(k crypto.PrivateKey) DecryptOption {
+	return func(opts *decryptOptions) {
+		opts.privateKeys = append(opts.privateKeys, k)
+	}
+}
+
+// DecryptWithGroupKey decrypts objects that have been encrypted with the
+// given group key
+func DecryptWithGroupKey(keyID string, key []byte) DecryptOption {
+	return func(opts *decryptOptions) {
+		opts.groupKeys[keyID] = key
+	}
+}
+
+// IsEncrypted returns whether the object is an encrypted envelope
+func IsEncrypted(o *Object) bool {
+	return o != nil && o.Type == EncryptedType
+}
+
+// Encrypt returns an encrypted envelope of the object for the given
+// recipients.
+// The envelope's metadata is a copy of the object's metadata, without its
+// signatures; the envelope needs to be signed on its own.
+func Encrypt(o *Object, opts ...EncryptOption) (*Object, error) {
+	options := &encryptOptions{
+		groupKeys: map[string][]byte{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if len(options.recipients) == 0 && len(options.groupKeys) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	payload, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// encrypt the object with a random content key
+	ck, err := crypto.NewSymmetricKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.Encrypt(payload, ck)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Encrypted{
+		Metadata:  o.Metadata,
+		Keys:      []EncryptedKey{},
+		GroupKeys: []EncryptedGroupKey{},
+		Data:      data,
+	}
+	e.Metadata.Signature = Signature{}
+	e.Metadata.Signatures = nil
+
+	// and encrypt the content key for each of the recipients
+	for _, r := range options.recipients {
+		ek, ss, err := crypto.CalculateEphemeralSharedKey(r)
+		if err != nil {
+			return nil, err
+		}
+		k, err := crypto.Encrypt(ck, ss)
+		if err != nil {
+			return nil, err
+		}
+		e.Keys = append(e.Keys, EncryptedKey{
+			Recipient: r,
+			Sender:    ek.PublicKey(),
+			Key:       k,
+		})
+	}
+	for id, gk := range options.groupKeys {
+		k, err := crypto.Encrypt(ck, gk)
+		if err != nil {
+			return nil, err
+		}
+		e.GroupKeys = append(e.GroupKeys, EncryptedGroupKey{
+			KeyID: id,
+			Key:   k,
+		})
+	}
+
+	return Marshal(e)
+}
+
+// Decrypt returns the original object of an encrypted envelope, using any
+// of the given keys
+func Decrypt(o *Object, opts ...DecryptOption) (*Object, error) {
+	if !IsEncrypted(o) {
+		return nil, ErrNotEncrypted
+	}
+	options := &decryptOptions{
+		groupKeys: map[string][]byte{},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	e := &Encrypted{}
+	if err := Unmarshal(o, e); err != nil {
+		return nil, err
+	}
+
+	ck, err := e.contentKey(options)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := crypto.Decrypt(e.Data, ck)
+	if err != nil {
+		return nil, err
+	}
+	r := &Object{}
+	if err := json.Unmarshal(payload, r); err != nil {
+		return nil, err
+	}
+
+	// the envelope cannot move the object to a different stream, owner, or
+	// place in the stream
+	if !r.Metadata.Root.Equal(e.Metadata.Root) ||
+		r.Metadata.Owner != e.Metadata.Owner ||
+		r.Metadata.Sequence != e.Metadata.Sequence ||
+		!r.Metadata.Parents.Equal(e.Metadata.Parents) {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return r, nil
+}
+
+// contentKey finds a key the content key has been encrypted for, and
+// decrypts it
+func (e *Encrypted) contentKey(options *decryptOptions) ([]byte, error) {
+	for _, k := range e.Keys {
+		for _, pk := range options.privateKeys {
+			if !pk.PublicKey().Equals(k.Recipient) {
+				continue
+			}
+			ss, err := crypto.CalculateSharedKey(pk, k.Sender)
+			if err != nil {
+				return nil, err
+			}
+			return crypto.Decrypt(k.Key, ss)
+		}
+	}
+	for _, k := range e.GroupKeys {
+		gk, ok := options.groupKeys[k.KeyID]
+		if !ok {
+			continue
+		}
+		return crypto.Decrypt(k.Key, gk)
+	}
+	return nil, ErrNotRecipient
+}
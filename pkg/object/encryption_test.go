@@ -0,0 +1,102 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/tilde"
+)
+
+func TestEncrypt(t *testing.T) {
+	k0 := mustGenerateKey(t)
+	k1 := mustGenerateKey(t)
+	k2 := mustGenerateKey(t)
+	gk, err := crypto.NewSymmetricKey()
+	require.NoError(t, err)
+
+	o := mustSign(t, k0, &Object{
+		Type: "test/secret",
+		Metadata: Metadata{
+			Owner:    k0.PublicKey().DID(),
+			Root:     tilde.Digest("root"),
+			Sequence: 2,
+			Parents: Parents{
+				"*": tilde.DigestArray{"p0", "p1"},
+			},
+		},
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	})
+
+	e, err := Encrypt(
+		o,
+		EncryptFor(k0.PublicKey(), k1.PublicKey()),
+		EncryptWithGroupKey("g0", gk),
+	)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(e))
+	require.Equal(t, o.Metadata.Root, e.Metadata.Root)
+	require.Equal(t, o.Metadata.Sequence, e.Metadata.Sequence)
+	require.True(t, e.Metadata.Signature.IsEmpty())
+	require.NotContains(t, e.Data, "foo")
+
+	// survives marshaling
+	b, err := e.MarshalJSON()
+	require.NoError(t, err)
+	e = &Object{}
+	require.NoError(t, e.UnmarshalJSON(b))
+
+	t.Run("recipient", func(t *testing.T) {
+		d, err := Decrypt(e, DecryptWithPrivateKey(k1))
+		require.NoError(t, err)
+		require.Equal(t, o.Hash(), d.Hash())
+		require.NoError(t, Verify(d))
+	})
+
+	t.Run("group", func(t *testing.T) {
+		d, err := Decrypt(e, DecryptWithGroupKey("g0", gk))
+		require.NoError(t, err)
+		require.Equal(t, o.Hash(), d.Hash())
+	})
+
+	t.Run("not a recipient", func(t *testing.T) {
+		_, err := Decrypt(
+			e,
+			DecryptWithPrivateKey(k2),
+			DecryptWithGroupKey("g1", gk),
+		)
+		require.ErrorIs(t, err, ErrNotRecipient)
+	})
+
+	t.Run("wrong group key", func(t *testing.T) {
+		wk, err := crypto.NewSymmetricKey()
+		require.NoError(t, err)
+		_, err = Decrypt(e, DecryptWithGroupKey("g0", wk))
+		require.ErrorIs(t, err, crypto.ErrInvalidCiphertext)
+	})
+
+	t.Run("moved envelope", func(t *testing.T) {
+		enc := &Encrypted{}
+		require.NoError(t, Unmarshal(e, enc))
+		enc.Metadata.Parents = Parents{
+			"*": tilde.DigestArray{"p0"},
+		}
+		m, err := Marshal(enc)
+		require.NoError(t, err)
+		_, err = Decrypt(m, DecryptWithPrivateKey(k1))
+		require.ErrorIs(t, err, ErrInvalidEnvelope)
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		_, err := Decrypt(o, DecryptWithPrivateKey(k0))
+		require.ErrorIs(t, err, ErrNotEncrypted)
+	})
+
+	t.Run("no recipients", func(t *testing.T) {
+		_, err := Encrypt(o)
+		require.ErrorIs(t, err, ErrNoRecipients)
+	})
+}
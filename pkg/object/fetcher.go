@@ -19,3 +19,10 @@ type (
 		tilde.Digest,
 	) (*Object, error)
 )
+
+func (f GetterFunc) Get(
+	ctx context.Context,
+	h tilde.Digest,
+) (*Object, error) {
+	return f(ctx, h)
+}
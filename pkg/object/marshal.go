@@ -72,6 +72,13 @@ func Marshal(in interface{}) (*Object, error) {
 		}
 	}
 
+	if t, ok := m["@context"]; ok {
+		if tt, ok := t.(tilde.Digest); ok {
+			o.Context = tt
+			delete(m, "@context")
+		}
+	}
+
 	if o.Type == "" {
 		tr, ok := in.(Typer)
 		if ok {
@@ -11,12 +11,15 @@ type (
 	// TODO: add authors, contributors, license, copyright
 	// TODO: add version
 	Metadata struct {
-		Owner     did.DID      `nimona:"owner:s"`
-		Parents   Parents      `nimona:"parents:m"`
-		Policies  Policies     `nimona:"policies:am"`
-		Root      tilde.Digest `nimona:"root:r"`
-		Sequence  uint64       `nimona:"sequence:u,omitzero"`
-		Signature Signature    `nimona:"_signature:m"`
-		Timestamp string       `nimona:"timestamp:s"`
+		Owner     did.DID            `nimona:"owner:s"`
+		Parents   Parents            `nimona:"parents:m"`
+		Policies  Policies           `nimona:"policies:am"`
+		Root      tilde.Digest       `nimona:"root:r"`
+		Sequence  uint64             `nimona:"sequence:u,omitzero"`
+		Signature Signature          `nimona:"_signature:m"`
+		Timestamp string             `nimona:"timestamp:s"`
+		Threshold SignatureThreshold `nimona:"threshold:m"`
+		// Signatures are co-signatures, see CoSign
+		Signatures []Signature `nimona:"_signatures:am"`
 	}
 )
@@ -0,0 +1,183 @@
+package object
+
+import (
+	"fmt"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
+)
+
+// Objects can require being signed by a number of keys before they are
+// considered valid, using a signature threshold.
+// Co-signatures are not part of the object's hash, so they can be added
+// incrementally without changing it.
+
+const (
+	ErrThresholdNotMet = errors.Error("signature threshold not met")
+)
+
+type (
+	// SignatureThreshold requires an object to be signed by at least
+	// Threshold of the given keys
+	SignatureThreshold struct {
+		Keys      []crypto.PublicKey `nimona:"keys:as"`
+		Threshold uint64             `nimona:"threshold:u,omitzero"`
+	}
+)
+
+// IsEmpty returns whether the threshold does not require any signatures
+func (t SignatureThreshold) IsEmpty() bool {
+	return t.Threshold == 0
+}
+
+// CoSign adds a co-signature to the object, replacing any previous one by
+// the same key
+func CoSign(k crypto.PrivateKey, o *Object) error {
+	s, err := NewSignature(k, o)
+	if err != nil {
+		return err
+	}
+	o.Metadata.Signatures = mergeSignatures(
+		o.Metadata.Signatures,
+		[]Signature{s},
+	)
+	return nil
+}
+
+// MergeSignatures adds the co-signatures of the given objects to the first
+// one, all objects need to have the same hash.
+// Co-signatures that are not valid are ignored.
+func MergeSignatures(o *Object, others ...*Object) error {
+	h := o.Hash()
+	b, err := h.Bytes()
+	if err != nil {
+		return fmt.Errorf("unable to get bytes from hash, %w", err)
+	}
+	for _, other := range others {
+		if !other.Hash().Equal(h) {
+			return fmt.Errorf("cannot merge signatures of different objects")
+		}
+		valid := []Signature{}
+		for _, s := range other.Metadata.Signatures {
+			if s.IsEmpty() || s.Key.Verify(b, s.X) != nil {
+				continue
+			}
+			valid = append(valid, s)
+		}
+		o.Metadata.Signatures = mergeSignatures(
+			o.Metadata.Signatures,
+			valid,
+		)
+	}
+	return nil
+}
+
+// VerifyThreshold checks that all of the object's co-signatures are valid,
+// and that enough of them belong to the keys required by its threshold.
+// The object's own signature counts towards the threshold as well.
+func VerifyThreshold(o *Object) error {
+	return verifyThreshold(o.Hash(), o.Metadata, o.Metadata.Threshold)
+}
+
+// VerifyThresholdFor is like VerifyThreshold, but checks the object against
+// the given threshold instead of its own, ie one required by a policy
+func VerifyThresholdFor(o *Object, t SignatureThreshold) error {
+	return verifyThreshold(o.Hash(), o.Metadata, t)
+}
+
+// SignatureCount returns how many of the keys required by the object's
+// threshold have signed it, and how many are required
+func SignatureCount(o *Object) (signed, required int) {
+	t := o.Metadata.Threshold
+	return len(thresholdSigners(o.Metadata, t)), int(t.Threshold)
+}
+
+func verifyThreshold(
+	h tilde.Digest,
+	meta Metadata,
+	t SignatureThreshold,
+) error {
+	if t.IsEmpty() {
+		return nil
+	}
+
+	b, err := h.Bytes()
+	if err != nil {
+		return fmt.Errorf("unable to get bytes from hash, %w", err)
+	}
+
+	if !meta.Signature.IsEmpty() {
+		if err := meta.Signature.Key.Verify(b, meta.Signature.X); err != nil {
+			return err
+		}
+	}
+	for _, s := range meta.Signatures {
+		if err := s.Key.Verify(b, s.X); err != nil {
+			return err
+		}
+	}
+
+	if uint64(len(thresholdSigners(meta, t))) < t.Threshold {
+		return ErrThresholdNotMet
+	}
+
+	return nil
+}
+
+// thresholdSigners returns the keys of the threshold that have signed the
+// object, without verifying their signatures
+func thresholdSigners(
+	meta Metadata,
+	t SignatureThreshold,
+) []crypto.PublicKey {
+	sigs := append([]Signature{meta.Signature}, meta.Signatures...)
+	signers := []crypto.PublicKey{}
+	for _, k := range uniqueKeys(t.Keys) {
+		for _, s := range sigs {
+			if s.IsEmpty() || !s.Key.Equals(k) {
+				continue
+			}
+			signers = append(signers, k)
+			break
+		}
+	}
+	return signers
+}
+
+// uniqueKeys returns the given keys without any duplicates, so that a key
+// listed more than once is only counted once
+func uniqueKeys(ks []crypto.PublicKey) []crypto.PublicKey {
+	r := []crypto.PublicKey{}
+	for _, k := range ks {
+		dup := false
+		for _, rk := range r {
+			if rk.Equals(k) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			r = append(r, k)
+		}
+	}
+	return r
+}
+
+func mergeSignatures(a, b []Signature) []Signature {
+	r := append([]Signature{}, a...)
+	for _, s := range b {
+		replaced := false
+		for i := range r {
+			if r[i].Key.Equals(s.Key) {
+				r[i] = s
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			r = append(r, s)
+		}
+	}
+	return r
+}
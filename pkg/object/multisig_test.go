@@ -0,0 +1,108 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/tilde"
+)
+
+func TestCoSign(t *testing.T) {
+	k0 := mustGenerateKey(t)
+	k1 := mustGenerateKey(t)
+	k2 := mustGenerateKey(t)
+	k3 := mustGenerateKey(t)
+
+	newObject := func() *Object {
+		return &Object{
+			Type: "test/shared",
+			Metadata: Metadata{
+				Threshold: SignatureThreshold{
+					Keys: []crypto.PublicKey{
+						k0.PublicKey(),
+						k1.PublicKey(),
+						k2.PublicKey(),
+					},
+					Threshold: 2,
+				},
+			},
+			Data: tilde.Map{
+				"foo": tilde.String("bar"),
+			},
+		}
+	}
+
+	o := newObject()
+	h := o.Hash()
+
+	require.ErrorIs(t, VerifyThreshold(o), ErrThresholdNotMet)
+	require.ErrorIs(t, Verify(o), ErrThresholdNotMet)
+
+	// co-signatures do not change the hash
+	require.NoError(t, CoSign(k0, o))
+	require.Equal(t, h, o.Hash())
+	require.ErrorIs(t, VerifyThreshold(o), ErrThresholdNotMet)
+
+	// keys that are not part of the threshold do not count
+	require.NoError(t, CoSign(k3, o))
+	require.ErrorIs(t, VerifyThreshold(o), ErrThresholdNotMet)
+
+	// signing twice only counts once
+	require.NoError(t, CoSign(k0, o))
+	signed, required := SignatureCount(o)
+	require.Equal(t, 1, signed)
+	require.Equal(t, 2, required)
+
+	require.NoError(t, CoSign(k1, o))
+	require.NoError(t, VerifyThreshold(o))
+	require.NoError(t, Verify(o))
+
+	// other thresholds can be checked as well, ie the ones of policies
+	require.ErrorIs(t, VerifyThresholdFor(o, SignatureThreshold{
+		Keys:      []crypto.PublicKey{k1.PublicKey(), k2.PublicKey()},
+		Threshold: 2,
+	}), ErrThresholdNotMet)
+	require.NoError(t, VerifyThresholdFor(o, SignatureThreshold{
+		Keys:      []crypto.PublicKey{k0.PublicKey(), k3.PublicKey()},
+		Threshold: 2,
+	}))
+
+	// and keys listed more than once only count once
+	require.ErrorIs(t, VerifyThresholdFor(o, SignatureThreshold{
+		Keys:      []crypto.PublicKey{k1.PublicKey(), k1.PublicKey()},
+		Threshold: 2,
+	}), ErrThresholdNotMet)
+
+	// survives marshaling
+	b, err := o.MarshalJSON()
+	require.NoError(t, err)
+	u := &Object{}
+	require.NoError(t, u.UnmarshalJSON(b))
+	require.Equal(t, h, u.Hash())
+	require.Len(t, u.Metadata.Signatures, 3)
+	require.NoError(t, VerifyThreshold(u))
+
+	// invalid co-signatures fail verification
+	u.Metadata.Signatures[0].X = []byte("foo")
+	require.ErrorIs(t, VerifyThreshold(u), crypto.ErrInvalidSignature)
+
+	t.Run("merge signatures", func(t *testing.T) {
+		o0 := newObject()
+		require.NoError(t, CoSign(k0, o0))
+		o1 := newObject()
+		require.NoError(t, CoSign(k1, o1))
+		o2 := newObject()
+		require.NoError(t, CoSign(k2, o2))
+		o2.Metadata.Signatures[0].X = []byte("foo")
+
+		require.NoError(t, MergeSignatures(o0, o1, o2))
+		require.Len(t, o0.Metadata.Signatures, 2)
+		require.NoError(t, VerifyThreshold(o0))
+
+		o3 := newObject()
+		o3.Data["foo"] = tilde.String("baz")
+		require.Error(t, MergeSignatures(o0, o3))
+	})
+}
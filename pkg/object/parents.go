@@ -8,6 +8,26 @@ type (
 	Parents map[string]tilde.DigestArray
 )
 
+// Equal checks whether both have the same groups, with the same parents in
+// the same order
+func (ps Parents) Equal(other Parents) bool {
+	if len(ps) != len(other) {
+		return false
+	}
+	for k, ip := range ps {
+		op, ok := other[k]
+		if !ok || len(ip) != len(op) {
+			return false
+		}
+		for i := range ip {
+			if !ip[i].Equal(op[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (ps Parents) All() []tilde.Digest {
 	var unique []tilde.Digest
 
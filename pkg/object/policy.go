@@ -1,7 +1,15 @@
 package object
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
 )
 
 type (
@@ -11,36 +19,95 @@ type (
 	EvaluationResult string
 
 	// Policy for object metadata
+	// Subjects, identities and groups all describe who the policy applies
+	// to, policies without any of them apply to everyone.
+	// Resources can be glob patterns.
+	// Threshold requires objects the policy applies to to be signed by at
+	// least that many of its subjects, using co-signatures; identities and
+	// groups cannot be part of a threshold.
 	Policy struct {
-		Name      string             `nimona:"name:s"`
-		Type      PolicyType         `nimona:"type:s"`
-		Subjects  []crypto.PublicKey `nimona:"subjects:as"`
-		Resources []string           `nimona:"resources:as"`
-		Actions   []PolicyAction     `nimona:"actions:as"`
-		Effect    PolicyEffect       `nimona:"effect:s"`
+		Name       string             `nimona:"name:s"`
+		Type       PolicyType         `nimona:"type:s"`
+		Subjects   []crypto.PublicKey `nimona:"subjects:as"`
+		Identities []did.DID          `nimona:"identities:as"`
+		Groups     []string           `nimona:"groups:as"`
+		Resources  []string           `nimona:"resources:as"`
+		Actions    []PolicyAction     `nimona:"actions:as"`
+		Conditions PolicyConditions   `nimona:"conditions:m"`
+		Effect     PolicyEffect       `nimona:"effect:s"`
+		Threshold  uint64             `nimona:"threshold:u,omitzero"`
+	}
+
+	// PolicyConditions limit when a policy applies, times are in RFC3339
+	PolicyConditions struct {
+		NotBefore string `nimona:"notBefore:s"`
+		NotAfter  string `nimona:"notAfter:s"`
 	}
 
 	// Policies
 	Policies []Policy
 
+	// KeyResolver returns the keys and identities the given key can act on
+	// behalf of, ie the key streams it has been delegated by or is the active
+	// key of
+	KeyResolver interface {
+		ResolveKeys(crypto.PublicKey) ([]crypto.PublicKey, error)
+		ResolveIdentities(crypto.PublicKey) ([]did.DID, error)
+	}
+
+	// PolicyQuery is what policies are evaluated against, the subject is
+	// described by both its keys and the identities it can act on behalf of
+	PolicyQuery struct {
+		Keys       []crypto.PublicKey
+		Identities []did.DID
+		Resource   string
+		Action     PolicyAction
+		// Time defaults to now
+		Time time.Time
+	}
+
+	// PolicyDecision is the result of evaluating policies, along with why
+	// each of the policies did or did not apply
+	PolicyDecision struct {
+		Result EvaluationResult
+		Trace  []PolicyTrace
+	}
+
+	// PolicyTrace explains the outcome of a single policy
+	PolicyTrace struct {
+		Index   int
+		Name    string
+		Effect  PolicyEffect
+		Matched bool
+		Applied bool
+		Reason  string
+	}
+
 	// evaluation state
 	evaluation struct {
 		// target
-		subject  crypto.PublicKey
-		resource string
-		action   PolicyAction
+		query  PolicyQuery
+		groups map[string][]did.DID
 		// result
 		explicitMatches int
 		effect          EvaluationResult
+		trace           []PolicyTrace
 	}
 )
 
 const (
 	// Policy types
 	SignaturePolicy PolicyType = "signature"
+	// GroupPolicy defines a group of identities named after the policy, that
+	// other policies can refer to, group policies do not grant or deny
+	// anything on their own
+	GroupPolicy PolicyType = "group"
 
 	// Policy actions
-	ReadAction PolicyAction = "read"
+	ReadAction   PolicyAction = "read"
+	WriteAction  PolicyAction = "write"
+	AppendAction PolicyAction = "append"
+	AdminAction  PolicyAction = "admin"
 
 	// Policy effects
 	AllowEffect PolicyEffect = "allow"
@@ -49,21 +116,49 @@ const (
 	// Policy Evaluation results
 	Deny  EvaluationResult = "deny"
 	Allow EvaluationResult = "allow"
+
+	// ErrForbidden is returned when the policies of an object do not allow
+	// the requested action
+	ErrForbidden = errors.Error("forbidden")
+	// ErrInvalidPolicy is returned for policies that can never be met
+	ErrInvalidPolicy = errors.Error("invalid policy")
 )
 
+// Validate checks that the policies can be met, ie that policies with a
+// threshold only apply to subjects, and have enough of them
+func (ps Policies) Validate() error {
+	for i, p := range ps {
+		if p.Threshold == 0 {
+			continue
+		}
+		if len(p.Identities) > 0 || len(p.Groups) > 0 {
+			return errors.Merge(
+				ErrInvalidPolicy,
+				fmt.Errorf(
+					"policy %d has a threshold and identities or groups",
+					i,
+				),
+			)
+		}
+		if uint64(len(uniqueKeys(p.Subjects))) < p.Threshold {
+			return errors.Merge(
+				ErrInvalidPolicy,
+				fmt.Errorf(
+					"policy %d has fewer subjects than its threshold",
+					i,
+				),
+			)
+		}
+	}
+	return nil
+}
+
 func (p Policy) Evaluate(
 	subject crypto.PublicKey,
 	resource string,
 	action PolicyAction,
 ) EvaluationResult {
-	e := &evaluation{
-		subject:  subject,
-		resource: resource,
-		action:   action,
-		effect:   Allow,
-	}
-	e.Process(p)
-	return e.effect
+	return Policies{p}.Evaluate(subject, resource, action)
 }
 
 func (ps Policies) Evaluate(
@@ -71,60 +166,285 @@ func (ps Policies) Evaluate(
 	resource string,
 	action PolicyAction,
 ) EvaluationResult {
+	return ps.EvaluateKeys(
+		[]crypto.PublicKey{subject},
+		resource,
+		action,
+	)
+}
+
+// EvaluateKeys evaluates the policies for a subject that can use any of the
+// given keys, policies match the subject if they match any of its keys
+func (ps Policies) EvaluateKeys(
+	subjects []crypto.PublicKey,
+	resource string,
+	action PolicyAction,
+) EvaluationResult {
+	return ps.Query(PolicyQuery{
+		Keys:     subjects,
+		Resource: resource,
+		Action:   action,
+	}).Result
+}
+
+// NewPolicyQuery returns a query for the given key, as well as the keys and
+// identities it can act on behalf of if a resolver is given.
+// Subjects without a key only match policies that apply to everyone.
+func NewPolicyQuery(
+	r KeyResolver,
+	subject crypto.PublicKey,
+	resource string,
+	action PolicyAction,
+) (PolicyQuery, error) {
+	q := PolicyQuery{
+		Keys:     []crypto.PublicKey{},
+		Resource: resource,
+		Action:   action,
+	}
+	if subject.IsEmpty() {
+		return q, nil
+	}
+	q.Keys = append(q.Keys, subject)
+	if r == nil {
+		return q, nil
+	}
+	ks, err := r.ResolveKeys(subject)
+	if err != nil {
+		return q, err
+	}
+	q.Keys = append(q.Keys, ks...)
+	ids, err := r.ResolveIdentities(subject)
+	if err != nil {
+		return q, err
+	}
+	q.Identities = ids
+	return q, nil
+}
+
+// Query evaluates the policies, and explains how it came to its decision.
+// Policies are evaluated in order, and each one that matches overrides the
+// previous ones unless they matched more explicitly.
+func (ps Policies) Query(q PolicyQuery) *PolicyDecision {
+	if q.Time.IsZero() {
+		q.Time = time.Now()
+	}
 	e := &evaluation{
-		subject:  subject,
-		resource: resource,
-		action:   action,
-		effect:   Allow,
+		query:  q,
+		groups: map[string][]did.DID{},
+		effect: Allow,
 	}
 	for _, p := range ps {
-		e.Process(p)
+		if p.Type == GroupPolicy {
+			e.groups[p.Name] = append(e.groups[p.Name], p.Identities...)
+		}
+	}
+	for i, p := range ps {
+		if p.Type == GroupPolicy {
+			continue
+		}
+		e.process(i, p)
+	}
+	return &PolicyDecision{
+		Result: e.effect,
+		Trace:  e.trace,
 	}
-	return e.effect
 }
 
-func (e *evaluation) Process(
-	p Policy,
-) {
-	explicitMatches := 0
-	subjectMatches := len(p.Subjects) == 0
-	if len(p.Subjects) > 0 {
-		for _, s := range p.Subjects {
-			if e.subject.Equals(s) {
-				explicitMatches++
-				subjectMatches = true
-				break
-			}
+// Applied returns the index of the policy the decision was based on, or -1
+// if no policy matched
+func (d *PolicyDecision) Applied() int {
+	for i := len(d.Trace) - 1; i >= 0; i-- {
+		if d.Trace[i].Applied {
+			return d.Trace[i].Index
+		}
+	}
+	return -1
+}
+
+// Explain returns a human readable explanation of the decision
+func (d *PolicyDecision) Explain() string {
+	for i := len(d.Trace) - 1; i >= 0; i-- {
+		t := d.Trace[i]
+		if !t.Applied {
+			continue
 		}
+		return fmt.Sprintf(
+			"%s by policy %s",
+			d.Result,
+			t.label(),
+		)
+	}
+	return fmt.Sprintf("%s as no policy matched", d.Result)
+}
+
+// String returns the explanation for each of the policies
+func (d *PolicyDecision) String() string {
+	b := &strings.Builder{}
+	b.WriteString(d.Explain())
+	for _, t := range d.Trace {
+		fmt.Fprintf(b, "\n- %s: %s", t.label(), t.Reason)
+	}
+	return b.String()
+}
+
+func (t PolicyTrace) label() string {
+	if t.Name != "" {
+		return fmt.Sprintf("#%d (%s)", t.Index, t.Name)
+	}
+	return fmt.Sprintf("#%d", t.Index)
+}
+
+func (e *evaluation) process(i int, p Policy) {
+	t := PolicyTrace{
+		Index:  i,
+		Name:   p.Name,
+		Effect: p.Effect,
+	}
+	defer func() {
+		e.trace = append(e.trace, t)
+	}()
+
+	if reason, ok := e.conditionsMatch(p.Conditions); !ok {
+		t.Reason = reason
+		return
+	}
+
+	explicitMatches := 0
+
+	subjectMatches, explicit := e.subjectMatches(p)
+	if !subjectMatches {
+		t.Reason = "subject does not match"
+		return
+	}
+	if explicit {
+		explicitMatches++
 	}
 
 	resourceMatches := len(p.Resources) == 0
-	if len(p.Resources) > 0 {
-		for _, s := range p.Resources {
-			if e.resource == s {
-				explicitMatches++
-				resourceMatches = true
-				break
-			}
+	for _, r := range p.Resources {
+		if resourceMatch(r, e.query.Resource) {
+			explicitMatches++
+			resourceMatches = true
+			break
 		}
 	}
+	if !resourceMatches {
+		t.Reason = "resource does not match"
+		return
+	}
 
 	actionMatches := len(p.Actions) == 0
-	if len(p.Actions) > 0 {
-		for _, s := range p.Actions {
-			if e.action == s {
-				explicitMatches++
-				actionMatches = true
-				break
+	for _, a := range p.Actions {
+		if e.query.Action == a {
+			explicitMatches++
+			actionMatches = true
+			break
+		}
+	}
+	if !actionMatches {
+		t.Reason = "action does not match"
+		return
+	}
+
+	t.Matched = true
+	if explicitMatches < e.explicitMatches {
+		t.Reason = "matched, but a previous policy was more explicit"
+		return
+	}
+
+	t.Applied = true
+	t.Reason = fmt.Sprintf("matched, %s", p.Effect)
+	e.effect = EvaluationResult(p.Effect)
+	e.explicitMatches = explicitMatches
+	// policies applying after this one did not override it
+	for j := range e.trace {
+		if e.trace[j].Applied {
+			e.trace[j].Applied = false
+			e.trace[j].Reason = "matched, but overridden by a later policy"
+		}
+	}
+}
+
+// subjectMatches returns whether the policy applies to the subject, and
+// whether it did so explicitly
+func (e *evaluation) subjectMatches(p Policy) (matches, explicit bool) {
+	if len(p.Subjects) == 0 &&
+		len(p.Identities) == 0 &&
+		len(p.Groups) == 0 {
+		return true, false
+	}
+	for _, s := range p.Subjects {
+		for _, k := range e.query.Keys {
+			if k.Equals(s) {
+				return true, true
+			}
+		}
+	}
+	if e.hasIdentity(p.Identities) {
+		return true, true
+	}
+	for _, g := range p.Groups {
+		if e.hasIdentity(e.groups[g]) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// hasIdentity checks the subject's identities, as well as the identities of
+// its keys
+func (e *evaluation) hasIdentity(ids []did.DID) bool {
+	for _, id := range ids {
+		for _, i := range e.query.Identities {
+			if i.Equals(id) {
+				return true
+			}
+		}
+		for _, k := range e.query.Keys {
+			if k.DID().Equals(id) {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	policyMatched := subjectMatches && resourceMatches && actionMatches
-	if policyMatched {
-		if explicitMatches >= e.explicitMatches {
-			e.effect = EvaluationResult(p.Effect)
-			e.explicitMatches = explicitMatches
+// conditionsMatch checks that the policy applies at the query's time,
+// policies with invalid conditions never apply
+func (e *evaluation) conditionsMatch(c PolicyConditions) (string, bool) {
+	if c.NotBefore != "" {
+		t, err := time.Parse(time.RFC3339, c.NotBefore)
+		if err != nil {
+			return "invalid not before condition", false
+		}
+		if e.query.Time.Before(t) {
+			return "not valid before " + c.NotBefore, false
+		}
+	}
+	if c.NotAfter != "" {
+		t, err := time.Parse(time.RFC3339, c.NotAfter)
+		if err != nil {
+			return "invalid not after condition", false
 		}
+		if e.query.Time.After(t) {
+			return "expired at " + c.NotAfter, false
+		}
+	}
+	return "", true
+}
+
+// resourceMatch matches resources either exactly or as glob patterns, using
+// the same separators as object type filters
+func resourceMatch(pattern, resource string) bool {
+	if pattern == resource {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[{") {
+		return false
+	}
+	g, err := glob.Compile(pattern, '.', '/', '#')
+	if err != nil {
+		return false
 	}
+	return g.Match(resource)
 }
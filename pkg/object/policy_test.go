@@ -5,12 +5,14 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
 	"nimona.io/pkg/tilde"
 )
 
@@ -286,6 +288,216 @@ func TestPolicy_Evaluate_Table2(t *testing.T) {
 	})
 }
 
+func TestPolicies_EvaluateKeys(t *testing.T) {
+	s0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	s1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	s2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	policies := Policies{{
+		Effect:  DenyEffect,
+		Actions: []PolicyAction{ReadAction},
+	}, {
+		Effect:   AllowEffect,
+		Subjects: []crypto.PublicKey{s1.PublicKey()},
+		Actions:  []PolicyAction{ReadAction},
+	}}
+
+	keys := func(ks ...crypto.PrivateKey) []crypto.PublicKey {
+		pks := []crypto.PublicKey{}
+		for _, k := range ks {
+			pks = append(pks, k.PublicKey())
+		}
+		return pks
+	}
+
+	assert.Equal(t, Deny, policies.EvaluateKeys(keys(), "r0", ReadAction))
+	assert.Equal(t, Deny, policies.EvaluateKeys(keys(s0), "r0", ReadAction))
+	assert.Equal(t, Allow, policies.EvaluateKeys(keys(s1), "r0", ReadAction))
+	assert.Equal(t, Allow, policies.EvaluateKeys(keys(s0, s1), "r0", ReadAction))
+	assert.Equal(t, Deny, policies.EvaluateKeys(keys(s0, s2), "r0", ReadAction))
+}
+
+func TestPolicies_Validate(t *testing.T) {
+	s0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	s1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	require.NoError(t, Policies{{
+		Subjects:  []crypto.PublicKey{s0.PublicKey(), s1.PublicKey()},
+		Threshold: 2,
+	}, {
+		Identities: []did.DID{s0.PublicKey().DID()},
+	}}.Validate())
+
+	// thresholds can only be met by the subjects' keys
+	require.ErrorIs(t, Policies{{
+		Subjects:   []crypto.PublicKey{s0.PublicKey(), s1.PublicKey()},
+		Identities: []did.DID{s0.PublicKey().DID()},
+		Threshold:  2,
+	}}.Validate(), ErrInvalidPolicy)
+	require.ErrorIs(t, Policies{{
+		Subjects:  []crypto.PublicKey{s0.PublicKey(), s1.PublicKey()},
+		Groups:    []string{"admins"},
+		Threshold: 2,
+	}}.Validate(), ErrInvalidPolicy)
+
+	// and there need to be enough distinct ones
+	require.ErrorIs(t, Policies{{
+		Subjects:  []crypto.PublicKey{s0.PublicKey(), s0.PublicKey()},
+		Threshold: 2,
+	}}.Validate(), ErrInvalidPolicy)
+}
+
+func TestPolicies_Query(t *testing.T) {
+	s0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	s1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	id0 := did.MustParse("did:nimona:keystream:id0")
+	id1 := did.MustParse("did:nimona:keystream:id1")
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	policies := Policies{{
+		Name:       "editors",
+		Type:       GroupPolicy,
+		Identities: []did.DID{*id0},
+	}, {
+		Name:    "deny all",
+		Effect:  DenyEffect,
+		Actions: []PolicyAction{ReadAction, WriteAction},
+	}, {
+		Name:      "editors can write posts",
+		Groups:    []string{"editors"},
+		Resources: []string{"posts.*"},
+		Actions:   []PolicyAction{ReadAction, WriteAction},
+		Effect:    AllowEffect,
+	}, {
+		Name:       "guests can read posts for a while",
+		Identities: []did.DID{*id1, s1.PublicKey().DID()},
+		Resources:  []string{"posts.*"},
+		Actions:    []PolicyAction{ReadAction},
+		Conditions: PolicyConditions{
+			NotBefore: "2021-01-01T00:00:00Z",
+			NotAfter:  "2021-12-31T00:00:00Z",
+		},
+		Effect: AllowEffect,
+	}}
+
+	tests := []struct {
+		name    string
+		query   PolicyQuery
+		want    EvaluationResult
+		explain string
+	}{{
+		name: "group member can write",
+		query: PolicyQuery{
+			Keys:       []crypto.PublicKey{s0.PublicKey()},
+			Identities: []did.DID{*id0},
+			Resource:   "posts.comment",
+			Action:     WriteAction,
+		},
+		want:    Allow,
+		explain: "allow by policy #2 (editors can write posts)",
+	}, {
+		name: "group member cannot write other resources",
+		query: PolicyQuery{
+			Identities: []did.DID{*id0},
+			Resource:   "profile",
+			Action:     WriteAction,
+		},
+		want:    Deny,
+		explain: "deny by policy #1 (deny all)",
+	}, {
+		name: "guest can read while valid",
+		query: PolicyQuery{
+			Identities: []did.DID{*id1},
+			Resource:   "posts.comment",
+			Action:     ReadAction,
+			Time:       now,
+		},
+		want:    Allow,
+		explain: "allow by policy #3 (guests can read posts for a while)",
+	}, {
+		name: "guest peer key can read while valid",
+		query: PolicyQuery{
+			Keys:     []crypto.PublicKey{s1.PublicKey()},
+			Resource: "posts.comment",
+			Action:   ReadAction,
+			Time:     now,
+		},
+		want:    Allow,
+		explain: "allow by policy #3 (guests can read posts for a while)",
+	}, {
+		name: "guest cannot read after expiry",
+		query: PolicyQuery{
+			Identities: []did.DID{*id1},
+			Resource:   "posts.comment",
+			Action:     ReadAction,
+			Time:       now.AddDate(1, 0, 0),
+		},
+		want:    Deny,
+		explain: "deny by policy #1 (deny all)",
+	}, {
+		name: "guest cannot write",
+		query: PolicyQuery{
+			Identities: []did.DID{*id1},
+			Resource:   "posts.comment",
+			Action:     WriteAction,
+			Time:       now,
+		},
+		want:    Deny,
+		explain: "deny by policy #1 (deny all)",
+	}, {
+		name: "nobody matches admin",
+		query: PolicyQuery{
+			Identities: []did.DID{*id0},
+			Resource:   "posts.comment",
+			Action:     AdminAction,
+		},
+		want:    Allow,
+		explain: "allow as no policy matched",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policies.Query(tt.query)
+			assert.Equal(t, tt.want, got.Result)
+			assert.Equal(t, tt.explain, got.Explain())
+			// group policies are not part of the trace
+			assert.Len(t, got.Trace, 3)
+		})
+	}
+
+	t.Run("trace reasons", func(t *testing.T) {
+		got := policies.Query(PolicyQuery{
+			Identities: []did.DID{*id1},
+			Resource:   "posts.comment",
+			Action:     ReadAction,
+			Time:       now.AddDate(1, 0, 0),
+		})
+		assert.Equal(t, []PolicyTrace{{
+			Index:   1,
+			Name:    "deny all",
+			Effect:  DenyEffect,
+			Matched: true,
+			Applied: true,
+			Reason:  "matched, deny",
+		}, {
+			Index:  2,
+			Name:   "editors can write posts",
+			Effect: AllowEffect,
+			Reason: "subject does not match",
+		}, {
+			Index:  3,
+			Name:   "guests can read posts for a while",
+			Effect: AllowEffect,
+			Reason: "expired at 2021-12-31T00:00:00Z",
+		}}, got.Trace)
+	})
+}
+
 func TestPolicy_Marshal(t *testing.T) {
 	k0, err := crypto.NewEd25519PrivateKey()
 	require.NoError(t, err)
@@ -294,11 +506,19 @@ func TestPolicy_Marshal(t *testing.T) {
 	p0 := k0.PublicKey()
 	p1 := k1.PublicKey()
 	p := &Policy{
-		Type:      SignaturePolicy,
-		Subjects:  []crypto.PublicKey{p0, p1},
-		Resources: []string{"foo", "bar"},
-		Actions:   []PolicyAction{ReadAction, "foo", "bar"},
-		Effect:    AllowEffect,
+		Type:     SignaturePolicy,
+		Subjects: []crypto.PublicKey{p0, p1},
+		Identities: []did.DID{
+			*did.MustParse("did:nimona:keystream:foo"),
+		},
+		Groups:    []string{"foo"},
+		Resources: []string{"foo", "bar.*"},
+		Actions:   []PolicyAction{ReadAction, WriteAction, "foo"},
+		Conditions: PolicyConditions{
+			NotBefore: "2021-01-01T00:00:00Z",
+			NotAfter:  "2021-12-31T00:00:00Z",
+		},
+		Effect: AllowEffect,
 	}
 
 	m, err := marshalStruct(tilde.MapHint, reflect.ValueOf(p))
@@ -0,0 +1,175 @@
+package object
+
+import (
+	"strings"
+
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
+)
+
+// Since the values of an object are hashed independently, any of them can be
+// replaced with its digest without changing the object's hash, or
+// invalidating its signatures.
+// This allows sharing objects without some of their fields, and proving that
+// a value is part of an object without sharing the rest of it.
+// Paths are the keys of nested maps, separated by `/`; arrays cannot be
+// traversed.
+
+const (
+	ErrInvalidPath  = errors.Error("invalid path")
+	ErrInvalidProof = errors.Error("invalid proof")
+	// ErrRedacted is returned where the full object is needed, but some of
+	// its values have been redacted
+	ErrRedacted = errors.Error("object has been redacted")
+)
+
+// Proof proves that the value at a path is part of an object, it contains
+// the object's map where everything other than the value and the maps
+// leading to it have been redacted
+type Proof struct {
+	Path string
+	Map  tilde.Map
+}
+
+// Redact returns a copy of the object where the values of the given data
+// paths have been replaced with their digests
+func Redact(o *Object, paths ...string) (*Object, error) {
+	r := Copy(o)
+	for _, path := range paths {
+		ks := strings.Split(path, "/")
+		m := r.Data
+		for _, k := range ks[:len(ks)-1] {
+			mm, ok := m[k].(tilde.Map)
+			if !ok {
+				return nil, errors.Merge(
+					ErrInvalidPath,
+					errors.Error(path+" is not a map"),
+				)
+			}
+			m = mm
+		}
+		k := ks[len(ks)-1]
+		v, ok := m[k]
+		if !ok || v == nil || v.Hash().IsEmpty() {
+			return nil, errors.Merge(
+				ErrInvalidPath,
+				errors.Error(path+" does not exist"),
+			)
+		}
+		m[k] = tilde.Redact(v)
+	}
+	return r, nil
+}
+
+// IsRedacted returns whether any of the object's values have been redacted
+func IsRedacted(o *Object) bool {
+	return isRedacted(o.Data)
+}
+
+func isRedacted(v tilde.Value) bool {
+	switch vv := v.(type) {
+	case tilde.Redacted:
+		return true
+	case tilde.Map:
+		for _, iv := range vv {
+			if isRedacted(iv) {
+				return true
+			}
+		}
+	case tilde.MapArray:
+		for _, iv := range vv {
+			if isRedacted(iv) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prove returns a proof that the value at the given path is part of the
+// object, the path is relative to the object's map so metadata can be proven
+// as well, ie `@metadata/owner`
+func Prove(o *Object, path string) (*Proof, error) {
+	m, err := o.MarshalMap()
+	if err != nil {
+		return nil, err
+	}
+	pm, err := prove(m, strings.Split(path, "/"))
+	if err != nil {
+		return nil, errors.Merge(
+			ErrInvalidPath,
+			errors.Error(path+": "+err.Error()),
+		)
+	}
+	return &Proof{
+		Path: path,
+		Map:  pm,
+	}, nil
+}
+
+func prove(m tilde.Map, ks []string) (tilde.Map, error) {
+	k := ks[0]
+	v, ok := m[k]
+	if !ok || v == nil || v.Hash().IsEmpty() {
+		return nil, errors.Error("does not exist")
+	}
+	r := tilde.Map{}
+	for ik, iv := range m {
+		// hidden and empty values are not part of the hash
+		if strings.HasPrefix(ik, "_") || iv == nil || iv.Hash().IsEmpty() {
+			continue
+		}
+		r[ik] = tilde.Redact(iv)
+	}
+	if len(ks) == 1 {
+		r[k] = v
+		return r, nil
+	}
+	vm, ok := v.(tilde.Map)
+	if !ok {
+		return nil, errors.Error("is not a map")
+	}
+	pm, err := prove(vm, ks[1:])
+	if err != nil {
+		return nil, err
+	}
+	r[k] = pm
+	return r, nil
+}
+
+// Verify checks that the proof is valid for an object with the given digest,
+// and returns the proven value
+func (p *Proof) Verify(d tilde.Digest) (tilde.Value, error) {
+	if p.Map.Hash() != d {
+		return nil, errors.Merge(
+			ErrInvalidProof,
+			errors.Error("digest mismatch"),
+		)
+	}
+	ks := strings.Split(p.Path, "/")
+	m := p.Map
+	for _, k := range ks[:len(ks)-1] {
+		mm, ok := m[k].(tilde.Map)
+		if !ok {
+			return nil, errors.Merge(
+				ErrInvalidProof,
+				errors.Error(p.Path+" has been redacted"),
+			)
+		}
+		m = mm
+	}
+	v, ok := m[ks[len(ks)-1]]
+	if !ok || v == nil {
+		return nil, errors.Merge(
+			ErrInvalidProof,
+			errors.Error(p.Path+" does not exist"),
+		)
+	}
+	if _, ok := v.(tilde.Redacted); ok {
+		return nil, errors.Merge(
+			ErrInvalidProof,
+			errors.Error(p.Path+" has been redacted"),
+		)
+	}
+	return v, nil
+}
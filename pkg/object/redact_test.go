@@ -0,0 +1,106 @@
+package object
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/tilde"
+)
+
+func TestRedact(t *testing.T) {
+	k := mustGenerateKey(t)
+
+	type Address struct {
+		City string `nimona:"city:s"`
+	}
+	type Contact struct {
+		Metadata Metadata `nimona:"@metadata:m,type=test/contact"`
+		Name     string   `nimona:"name:s"`
+		Phone    string   `nimona:"phone:s"`
+		Address  *Address `nimona:"address:m"`
+	}
+
+	o, err := Marshal(&Contact{
+		Metadata: Metadata{
+			Owner: k.PublicKey().DID(),
+		},
+		Name:  "foo",
+		Phone: "123",
+		Address: &Address{
+			City: "bar",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, Sign(k, o))
+	require.False(t, IsRedacted(o))
+
+	t.Run("redact fields", func(t *testing.T) {
+		r, err := Redact(o, "phone", "address/city")
+		require.NoError(t, err)
+		require.True(t, IsRedacted(r))
+		require.False(t, IsRedacted(o))
+		require.Equal(t, o.Hash(), r.Hash())
+		require.NoError(t, Verify(r))
+
+		// redactions survive being sent around
+		b, err := json.Marshal(r)
+		require.NoError(t, err)
+		u := &Object{}
+		require.NoError(t, json.Unmarshal(b, u))
+		require.Equal(t, o.Hash(), u.Hash())
+		require.NoError(t, Verify(u))
+
+		// but they cannot be mistaken for the full object
+		c := &Contact{}
+		require.ErrorIs(t, Unmarshal(u, c), ErrRedacted)
+	})
+
+	t.Run("redact missing fields", func(t *testing.T) {
+		_, err := Redact(o, "email")
+		require.ErrorIs(t, err, ErrInvalidPath)
+		_, err = Redact(o, "name/foo")
+		require.ErrorIs(t, err, ErrInvalidPath)
+	})
+
+	t.Run("prove field", func(t *testing.T) {
+		p, err := Prove(o, "address/city")
+		require.NoError(t, err)
+
+		// the proof should not leak other values
+		b, err := json.Marshal(p.Map)
+		require.NoError(t, err)
+		require.NotContains(t, string(b), "123")
+
+		v, err := p.Verify(o.Hash())
+		require.NoError(t, err)
+		require.Equal(t, tilde.String("bar"), v)
+
+		// proofs only work for the digest they were made for
+		_, err = p.Verify("foo")
+		require.ErrorIs(t, err, ErrInvalidProof)
+
+		// and cannot be tampered with
+		p.Map["address"].(tilde.Map)["city"] = tilde.String("baz")
+		_, err = p.Verify(o.Hash())
+		require.ErrorIs(t, err, ErrInvalidProof)
+	})
+
+	t.Run("prove metadata", func(t *testing.T) {
+		p, err := Prove(o, "@metadata/owner")
+		require.NoError(t, err)
+		v, err := p.Verify(o.Hash())
+		require.NoError(t, err)
+		require.Equal(t, tilde.String(k.PublicKey().DID().String()), v)
+	})
+
+	t.Run("prove redacted field", func(t *testing.T) {
+		r, err := Redact(o, "phone")
+		require.NoError(t, err)
+		p, err := Prove(r, "phone")
+		require.NoError(t, err)
+		_, err = p.Verify(o.Hash())
+		require.ErrorIs(t, err, ErrInvalidProof)
+	})
+}
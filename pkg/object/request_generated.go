@@ -21,4 +21,5 @@ type Response struct {
 	RequestID string   `nimona:"requestID:s"`
 	Object    *Object  `nimona:"object:m"`
 	Found     bool     `nimona:"found:b"`
+	Forbidden bool     `nimona:"forbidden:b"`
 }
@@ -173,6 +173,9 @@ func unmarshalMapToMap(h tilde.Hint, m tilde.Map, v reflect.Value) error {
 
 func unmarshalAny(h tilde.Hint, v tilde.Value, target reflect.Value) error {
 	switch vv := v.(type) {
+	case tilde.Redacted:
+		// redacted values cannot be restored
+		return ErrRedacted
 	case tilde.Digest:
 		if vv.IsEmpty() {
 			return nil
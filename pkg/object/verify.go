@@ -2,20 +2,68 @@ package object
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
 	"nimona.io/pkg/did"
 	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
 )
 
 const (
 	ErrInvalidSigner    = errors.Error("signer does not match owner")
 	ErrMissingSignature = errors.Error("missing signature")
 	ErrCouldNotVerify   = errors.Error("could not verify signature")
+	ErrInvalidDelegator = errors.Error("signer not delegated by delegator")
 )
 
-// Verify object
-// TODO should this verify nested objects as well?
+type (
+	// VerificationFailure describes a nested object that could not be
+	// verified, the path of the top level object is empty
+	VerificationFailure struct {
+		Path  string
+		Type  string
+		Error error
+	}
+	// VerificationReport is the result of VerifyDeep
+	VerificationReport struct {
+		Verified int
+		Failures []VerificationFailure
+	}
+)
+
+// Verify object, nested objects are not verified; use VerifyDeep for that
 func Verify(o *Object) error {
+	if err := VerifySignature(o); err != nil {
+		return err
+	}
+
+	if err := VerifyThreshold(o); err != nil {
+		return err
+	}
+
+	sig := o.Metadata.Signature
+	own := o.Metadata.Owner
+
+	// if there is no owner, we're fine
+	if own == did.Empty {
+		return nil
+	}
+
+	// check if the owner matches the signer
+	if own == sig.Key.DID() {
+		return nil
+	}
+
+	// or, error out
+	return ErrInvalidSigner
+}
+
+// VerifySignature checks that the object's signature is valid, without
+// checking that it has been signed by its owner.
+// Owned objects are still required to be signed.
+func VerifySignature(o *Object) error {
 	if o == nil {
 		return errors.Error("no object")
 	}
@@ -46,23 +94,166 @@ func Verify(o *Object) error {
 	}
 
 	// verify the signature
-	if err := sig.Key.Verify(
+	return sig.Key.Verify(
 		h,
 		sig.X,
-	); err != nil {
+	)
+}
+
+// VerifyDeep verifies the signatures of the object and all of its nested
+// objects, and returns a report of the ones that failed.
+// Signatures with a delegator are only valid if the delegator has delegated
+// to the signer's key, which is checked using the given key resolver; owners
+// can be either the signer or its delegator.
+func VerifyDeep(o *Object, r KeyResolver) *VerificationReport {
+	report := &VerificationReport{
+		Failures: []VerificationFailure{},
+	}
+	if o == nil {
+		report.Failures = append(report.Failures, VerificationFailure{
+			Error: errors.Error("no object"),
+		})
+		return report
+	}
+	m, err := o.MarshalMap()
+	if err != nil {
+		report.Failures = append(report.Failures, VerificationFailure{
+			Type:  o.Type,
+			Error: err,
+		})
+		return report
+	}
+	traverse("", m, func(path string, v interface{}) bool {
+		mm, ok := v.(tilde.Map)
+		if !ok || !isObject(mm) {
+			return true
+		}
+		// signatures are objects as well, but are not part of the hash
+		if isHiddenPath(path) {
+			return true
+		}
+		if err := verifyMap(mm, r); err != nil {
+			t, _ := mm["@type"].(tilde.String)
+			report.Failures = append(report.Failures, VerificationFailure{
+				Path:  path,
+				Type:  string(t),
+				Error: err,
+			})
+			return true
+		}
+		report.Verified++
+		return true
+	})
+	sort.Slice(report.Failures, func(i, j int) bool {
+		return report.Failures[i].Path < report.Failures[j].Path
+	})
+	return report
+}
+
+// Err returns an error describing the first failure, if any
+func (r *VerificationReport) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	f := r.Failures[0]
+	if f.Path == "" {
+		return f.Error
+	}
+	return errors.Merge(
+		errors.Error("nested object "+f.Path),
+		f.Error,
+	)
+}
+
+func verifyMap(m tilde.Map, r KeyResolver) error {
+	meta := &Metadata{}
+	if mm, ok := m["@metadata"].(tilde.Map); ok {
+		err := unmarshalMap(tilde.MapHint, mm, reflect.ValueOf(meta))
+		if err != nil {
+			return err
+		}
+	}
+
+	sig := meta.Signature
+	own := meta.Owner
+
+	// verify the co-signatures, if required
+	if err := verifyThreshold(m.Hash(), *meta, meta.Threshold); err != nil {
 		return err
 	}
 
-	// if there is no owner, we're fine
-	if own == did.Empty {
+	// if there is no owner and no signature, we're fine
+	if sig.IsEmpty() && own == did.Empty {
 		return nil
 	}
 
-	// check if the owner matches the signer
-	if own == sig.Key.DID() {
+	// if there is an owner, we should have a signature
+	if sig.IsEmpty() {
+		return ErrMissingSignature
+	}
+
+	// verify the signature
+	h, err := m.Hash().Bytes()
+	if err != nil {
+		return fmt.Errorf("unable to get bytes from hash, %w", err)
+	}
+	if err := sig.Key.Verify(h, sig.X); err != nil {
+		return err
+	}
+
+	// verify that the delegator has delegated to the signer
+	if !sig.Delegator.IsEmpty() {
+		if r == nil {
+			return ErrCouldNotVerify
+		}
+		ids, err := r.ResolveIdentities(sig.Key)
+		if err != nil {
+			return errors.Merge(ErrCouldNotVerify, err)
+		}
+		delegated := false
+		for _, id := range ids {
+			if id.Equals(sig.Delegator) {
+				delegated = true
+				break
+			}
+		}
+		if !delegated {
+			return ErrInvalidDelegator
+		}
+	}
+
+	// check if the owner matches the signer or its delegator
+	switch {
+	case own == did.Empty,
+		own == sig.Key.DID(),
+		!sig.Delegator.IsEmpty() && own == sig.Delegator:
 		return nil
 	}
 
-	// or, error out
+	// or if the signer is one of the owner's keys, ie the active key of the
+	// owner's key stream
+	if r != nil {
+		ids, err := r.ResolveIdentities(sig.Key)
+		if err != nil {
+			return errors.Merge(ErrCouldNotVerify, err)
+		}
+		for _, id := range ids {
+			if id.Equals(own) {
+				return nil
+			}
+		}
+	}
+
 	return ErrInvalidSigner
 }
+
+// isHiddenPath checks whether any of the path's keys are hidden, ie start
+// with an underscore
+func isHiddenPath(path string) bool {
+	for _, k := range strings.Split(path, "/") {
+		if strings.HasPrefix(k, "_") {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
 	"nimona.io/pkg/tilde"
 )
 
@@ -116,3 +117,135 @@ func mustSign(t *testing.T, k crypto.PrivateKey, o *Object) *Object {
 	o.Metadata.Signature = sig
 	return o
 }
+
+type testKeyResolver map[string][]did.DID
+
+func (r testKeyResolver) ResolveKeys(
+	k crypto.PublicKey,
+) ([]crypto.PublicKey, error) {
+	return nil, nil
+}
+
+func (r testKeyResolver) ResolveIdentities(
+	k crypto.PublicKey,
+) ([]did.DID, error) {
+	return r[k.String()], nil
+}
+
+func TestVerifyDeep(t *testing.T) {
+	k0 := mustGenerateKey(t)
+	k1 := mustGenerateKey(t)
+	k2 := mustGenerateKey(t)
+	id := did.MustParse("did:nimona:keystream:id")
+
+	resolver := testKeyResolver{
+		k2.PublicKey().String(): []did.DID{*id},
+	}
+
+	// newParent returns an object signed by k0 containing the given object
+	newParent := func(nested *Object) *Object {
+		m, err := nested.MarshalMap()
+		require.NoError(t, err)
+		return mustSign(t, k0, &Object{
+			Type: "test/parent",
+			Metadata: Metadata{
+				Owner: k0.PublicKey().DID(),
+			},
+			Data: tilde.Map{
+				"nested": m,
+				"list": tilde.MapArray{
+					m,
+				},
+			},
+		})
+	}
+
+	// newNested returns an object owned by the given owner, signed by k
+	newNested := func(owner did.DID, k crypto.PrivateKey) *Object {
+		return mustSign(t, k, &Object{
+			Type: "test/nested",
+			Metadata: Metadata{
+				Owner: owner,
+			},
+			Data: tilde.Map{
+				"foo": tilde.String("bar"),
+			},
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		o := newParent(newNested(k1.PublicKey().DID(), k1))
+		r := VerifyDeep(o, nil)
+		require.NoError(t, r.Err())
+		require.Equal(t, 3, r.Verified)
+		require.Empty(t, r.Failures)
+	})
+
+	t.Run("invalid nested signer", func(t *testing.T) {
+		o := newParent(newNested(k1.PublicKey().DID(), k2))
+		r := VerifyDeep(o, nil)
+		require.Error(t, r.Err())
+		require.ErrorIs(t, r.Err(), ErrInvalidSigner)
+		require.Equal(t, 1, r.Verified)
+		require.Equal(t, []VerificationFailure{{
+			Path:  "list/0",
+			Type:  "test/nested",
+			Error: ErrInvalidSigner,
+		}, {
+			Path:  "nested",
+			Type:  "test/nested",
+			Error: ErrInvalidSigner,
+		}}, r.Failures)
+	})
+
+	t.Run("tampered nested object", func(t *testing.T) {
+		n := newNested(k1.PublicKey().DID(), k1)
+		n.Data["foo"] = tilde.String("baz")
+		r := VerifyDeep(newParent(n), nil)
+		require.Len(t, r.Failures, 2)
+		require.Equal(t, "list/0", r.Failures[0].Path)
+		require.Equal(t, "nested", r.Failures[1].Path)
+		require.ErrorIs(t, r.Failures[0].Error, crypto.ErrInvalidSignature)
+	})
+
+	t.Run("tampered parent", func(t *testing.T) {
+		o := newParent(newNested(k1.PublicKey().DID(), k1))
+		o.Data["foo"] = tilde.String("bar")
+		r := VerifyDeep(o, nil)
+		require.Len(t, r.Failures, 1)
+		require.Equal(t, "", r.Failures[0].Path)
+		require.ErrorIs(t, r.Err(), crypto.ErrInvalidSignature)
+	})
+
+	t.Run("delegated signature", func(t *testing.T) {
+		n := newNested(*id, k2)
+		n.Metadata.Signature.Delegator = *id
+		o := newParent(n)
+
+		r := VerifyDeep(o, resolver)
+		require.NoError(t, r.Err())
+		require.Equal(t, 3, r.Verified)
+
+		// delegations cannot be checked without a resolver
+		r = VerifyDeep(o, nil)
+		require.ErrorIs(t, r.Err(), ErrCouldNotVerify)
+	})
+
+	t.Run("delegated signature, not delegated", func(t *testing.T) {
+		n := newNested(*id, k1)
+		n.Metadata.Signature.Delegator = *id
+		r := VerifyDeep(newParent(n), resolver)
+		require.ErrorIs(t, r.Err(), ErrInvalidDelegator)
+	})
+
+	t.Run("signed by a key of the owner", func(t *testing.T) {
+		o := newParent(newNested(*id, k2))
+		r := VerifyDeep(o, resolver)
+		require.NoError(t, r.Err())
+		require.Equal(t, 3, r.Verified)
+
+		// the owner's keys cannot be resolved without a resolver
+		r = VerifyDeep(o, nil)
+		require.ErrorIs(t, r.Err(), ErrInvalidSigner)
+	})
+}
@@ -5,6 +5,7 @@ import (
 
 	"nimona.io/internal/rand"
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
 	"nimona.io/pkg/did"
 	"nimona.io/pkg/errors"
 	"nimona.io/pkg/log"
@@ -14,12 +15,16 @@ import (
 	"nimona.io/pkg/resolver"
 	"nimona.io/pkg/stream"
 	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
 )
 
 const (
 	ErrDone        = errors.Error("done")
 	ErrTimeout     = errors.Error("request timed out")
 	ErrMissingRoot = errors.Error("missing root")
+	// ErrInvalidObject is returned when an object does not conform to its
+	// schema
+	ErrInvalidObject = errors.Error("invalid object")
 )
 
 //go:generate mockgen -destination=../objectmanagermock/objectmanagermock_generated.go -package=objectmanagermock -source=objectmanager.go
@@ -48,6 +53,8 @@ type (
 		pubsub        ObjectPubSub
 		newRequestID  func() string
 		subscriptions *SubscriptionsMap
+		keyResolver   object.KeyResolver
+		validator     *schema.Validator
 	}
 	Option func(*manager)
 )
@@ -61,6 +68,7 @@ func New(
 	net network.Network,
 	res resolver.Resolver,
 	str objectstore.Store,
+	opts ...Option,
 ) ObjectManager {
 	m := &manager{
 		newRequestID: func() string {
@@ -73,6 +81,10 @@ func New(
 		objectstore:   str,
 	}
 
+	for _, opt := range opts {
+		opt(m)
+	}
+
 	logger := log.
 		FromContext(ctx).
 		Named("objectmanager").
@@ -136,6 +148,10 @@ func (m *manager) Request(
 				// errCh <- err // TODO not sure about this one
 				continue
 			}
+			if res.Forbidden {
+				errCh <- object.ErrForbidden
+				return
+			}
 			objCh <- res.Object
 			return
 		}
@@ -297,6 +313,36 @@ func (m *manager) handleObjectRequest(
 		return err
 	}
 
+	canRead, err := objectstore.CanRead(
+		m.objectstore,
+		m.keyResolver,
+		m.subjectKey(env.Sender),
+		obj,
+	)
+	if err != nil || !canRead {
+		logger.Info(
+			"refusing object request, forbidden by policies",
+			log.String("sender", env.Sender.String()),
+			log.Error(err),
+		)
+		resp.Forbidden = true
+		ro, err := object.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		if sErr := m.network.Send(
+			ctx,
+			ro,
+			env.Sender,
+		); sErr != nil {
+			logger.Info(
+				"error sending forbidden response",
+				log.Error(sErr),
+			)
+		}
+		return nil
+	}
+
 	resp.Object = object.Copy(obj)
 
 	ro, err := object.Marshal(resp)
@@ -325,11 +371,35 @@ func (m *manager) handleObjectRequest(
 	return nil
 }
 
+// subjectKey returns the key of the given peer, or an empty key for ids that
+// are not peer keys, which only match policies that apply to everyone
+func (m *manager) subjectKey(id did.DID) crypto.PublicKey {
+	k, err := crypto.PublicKeyFromDID(id)
+	if err != nil {
+		return crypto.PublicKey{}
+	}
+	return *k
+}
+
 // Put stores a given object as-is, and announces it to any subscribers.
+// If the manager has a validator, objects that do not conform to their
+// schema are not stored.
 func (m *manager) Put(
 	ctx context.Context,
 	o *object.Object,
 ) error {
+	if object.IsRedacted(o) {
+		return errors.Merge(ErrInvalidObject, object.ErrRedacted)
+	}
+	if m.validator != nil {
+		r, err := m.validator.Validate(ctx, o)
+		if err != nil {
+			return fmt.Errorf("unable to validate object: %w", err)
+		}
+		if err := r.Err(); err != nil {
+			return errors.Merge(ErrInvalidObject, err)
+		}
+	}
 	// add to store
 	if err := m.storeObject(ctx, o); err != nil {
 		return err
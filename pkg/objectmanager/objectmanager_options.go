@@ -1,5 +1,26 @@
 package objectmanager
 
+import (
+	"nimona.io/pkg/object"
+	"nimona.io/schema"
+)
+
+// WithKeyResolver allows the policies of the objects in the store to be
+// evaluated against the key streams of the peers requesting them
+func WithKeyResolver(r object.KeyResolver) Option {
+	return func(m *manager) {
+		m.keyResolver = r
+	}
+}
+
+// WithValidator validates objects against their schema contexts before
+// they are stored
+func WithValidator(v *schema.Validator) Option {
+	return func(m *manager) {
+		m.validator = v
+	}
+}
+
 // import (
 // 	"nimona.io/pkg/network"
 // 	"nimona.io/pkg/objectstore"
@@ -21,6 +21,7 @@ import (
 	"nimona.io/pkg/resolver"
 	"nimona.io/pkg/resolvermock"
 	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
 )
 
 func TestManager_Request(t *testing.T) {
@@ -129,6 +130,21 @@ func TestManager_handleObjectRequest(t *testing.T) {
 			"asdf": f00m,
 		},
 	}
+	f02 := &object.Object{
+		Metadata: object.Metadata{
+			Policies: object.Policies{{
+				Actions: []object.PolicyAction{object.ReadAction},
+				Effect:  object.DenyEffect,
+			}, {
+				Subjects: []crypto.PublicKey{peerKey.PublicKey()},
+				Actions:  []object.PolicyAction{object.ReadAction},
+				Effect:   object.AllowEffect,
+			}},
+		},
+		Data: tilde.Map{
+			"f02": tilde.String("f02"),
+		},
+	}
 
 	type fields struct {
 		storeHandler   func(*testing.T) objectstore.Store
@@ -278,6 +294,71 @@ func TestManager_handleObjectRequest(t *testing.T) {
 				},
 			),
 		},
+		{
+			name: "object forbidden by policies, return forbidden response",
+			fields: fields{
+				storeHandler: func(t *testing.T) objectstore.Store {
+					m := objectstoremock.NewMockStore(gomock.NewController(t))
+					m.EXPECT().Get(f02.Hash()).Return(object.Copy(f02), nil)
+					return m
+				},
+				networkHandler: func(
+					t *testing.T,
+					ctx context.Context,
+					wg *sync.WaitGroup,
+					want *object.Object,
+				) network.Network {
+					m := networkmock.NewMockNetwork(gomock.NewController(t))
+					m.EXPECT().GetPeerKey().Return(peerKey)
+					m.EXPECT().Subscribe(gomock.Any()).Return(
+						&networkmock.MockSubscriptionSimple{
+							Objects: []*network.Envelope{{
+								Sender: peer1Key.PublicKey().DID(),
+								Payload: object.MustMarshal(
+									&object.Request{
+										RequestID:  "8",
+										ObjectHash: f02.Hash(),
+									},
+								),
+							}},
+						},
+					)
+					m.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+						DoAndReturn(func(
+							ctx context.Context,
+							obj *object.Object,
+							id did.DID,
+							opts ...network.SendOption,
+						) error {
+							assert.Equal(t, want, obj)
+							wg.Done()
+							return nil
+						})
+					return m
+				},
+				resolver: func(t *testing.T) resolver.Resolver {
+					m := resolvermock.NewMockResolver(
+						gomock.NewController(t),
+					)
+					return m
+				},
+			},
+			args: args{
+				ctx:      context.Background(),
+				rootHash: f00.Hash(),
+				peer:     peer1,
+			},
+			want: object.MustMarshal(
+				&object.Response{
+					Metadata: object.Metadata{
+						Owner: peerKey.PublicKey().DID(),
+					},
+					Object:    nil,
+					RequestID: "8",
+					Forbidden: true,
+				},
+			),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -325,11 +406,35 @@ func TestManager_Put(t *testing.T) {
 			"nested-simple": testObjectSimpleMap,
 		},
 	}
+	testSchema, err := object.Marshal(&schema.Context{
+		Name: "foo",
+		Types: []*schema.Type{{
+			Name: "foo",
+			Properties: []*schema.Property{{
+				Name:     "foo",
+				Hint:     tilde.IntHint,
+				Required: true,
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	testObjectInvalid := object.Copy(testObjectSimple)
+	testObjectInvalid.Context = testSchema.Hash()
+	testObjectRedacted, err := object.Redact(testObjectSimple, "foo")
+	require.NoError(t, err)
+	testValidator := schema.NewValidator(
+		object.GetterFunc(
+			func(_ context.Context, _ tilde.Digest) (*object.Object, error) {
+				return testSchema, nil
+			},
+		),
+	)
 
 	type fields struct {
 		store                 func(*testing.T) objectstore.Store
 		network               func(*testing.T) network.Network
 		resolver              func(*testing.T) resolver.Resolver
+		options               []Option
 		receivedSubscriptions []*object.Object
 	}
 	type args struct {
@@ -406,6 +511,69 @@ func TestManager_Put(t *testing.T) {
 			o: object.Copy(testObjectComplex),
 		},
 		want: testObjectComplex,
+	}, {
+		name: "should fail, object does not match its schema",
+		fields: fields{
+			store: func(t *testing.T) objectstore.Store {
+				m := objectstoremock.NewMockStore(
+					gomock.NewController(t),
+				)
+				return m
+			},
+			network: func(t *testing.T) network.Network {
+				m := &networkmock.MockNetworkSimple{
+					ReturnPeerKey: peerKey,
+					SendCalls:     []error{},
+					SubscribeCalls: []network.EnvelopeSubscription{
+						&networkmock.MockSubscriptionSimple{},
+					},
+				}
+				return m
+			},
+			resolver: func(t *testing.T) resolver.Resolver {
+				m := resolvermock.NewMockResolver(
+					gomock.NewController(t),
+				)
+				return m
+			},
+			options: []Option{
+				WithValidator(testValidator),
+			},
+		},
+		args: args{
+			o: testObjectInvalid,
+		},
+		wantErr: true,
+	}, {
+		name: "should fail, object has been redacted",
+		fields: fields{
+			store: func(t *testing.T) objectstore.Store {
+				m := objectstoremock.NewMockStore(
+					gomock.NewController(t),
+				)
+				return m
+			},
+			network: func(t *testing.T) network.Network {
+				m := &networkmock.MockNetworkSimple{
+					ReturnPeerKey: peerKey,
+					SendCalls:     []error{},
+					SubscribeCalls: []network.EnvelopeSubscription{
+						&networkmock.MockSubscriptionSimple{},
+					},
+				}
+				return m
+			},
+			resolver: func(t *testing.T) resolver.Resolver {
+				m := resolvermock.NewMockResolver(
+					gomock.NewController(t),
+				)
+				return m
+			},
+		},
+		args: args{
+			o: testObjectRedacted,
+		},
+		wantErr: true,
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -414,6 +582,7 @@ func TestManager_Put(t *testing.T) {
 				tt.fields.network(t),
 				tt.fields.resolver(t),
 				tt.fields.store(t),
+				tt.fields.options...,
 			)
 			err := m.Put(
 				context.Background(),
@@ -0,0 +1,289 @@
+package objectstore
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gobwas/glob"
+
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/tilde"
+)
+
+// FilterOptions
+type (
+	FilterOption  func(*FilterOptions)
+	FilterOptions struct {
+		// Filters are used to perform db queries for these filters
+		// TODO find a better name for this
+		Filters struct {
+			ObjectHashes []tilde.Digest
+			StreamHashes []tilde.Digest
+			ContentTypes []string
+			Owners       []string
+			OrderBy      string
+			OrderDir     string
+			Limit        *int
+			Offset       *int
+			Pending      *bool
+			Data         []DataFilter
+			After        *tilde.Digest
+		}
+		// Err holds the first invalid filter, if any, stores should return
+		// it instead of filtering
+		Err error
+	}
+	// DataOperator is used to compare the value of a data path
+	DataOperator string
+	// DataFilter compares the value of a data path with the given one
+	DataFilter struct {
+		Path string
+		// Keys are the hinted keys of the path
+		Keys     []string
+		Operator DataOperator
+		Value    tilde.Value
+	}
+)
+
+const (
+	DataEqual              DataOperator = "="
+	DataNotEqual           DataOperator = "!="
+	DataLessThan           DataOperator = "<"
+	DataLessThanOrEqual    DataOperator = "<="
+	DataGreaterThan        DataOperator = ">"
+	DataGreaterThanOrEqual DataOperator = ">="
+	DataHasPrefix          DataOperator = "prefix"
+)
+
+const ErrInvalidFilter = errors.Error("invalid filter")
+
+// NewFilterOptions applies the given options, it is meant to be used by the
+// stores' Filter methods
+func NewFilterOptions(filterOptions ...FilterOption) FilterOptions {
+	options := &FilterOptions{
+		Filters: struct {
+			ObjectHashes []tilde.Digest
+			StreamHashes []tilde.Digest
+			ContentTypes []string
+			Owners       []string
+			OrderBy      string
+			OrderDir     string
+			Limit        *int
+			Offset       *int
+			Pending      *bool
+			Data         []DataFilter
+			After        *tilde.Digest
+		}{
+			ObjectHashes: []tilde.Digest{},
+			StreamHashes: []tilde.Digest{},
+			ContentTypes: []string{},
+			Owners:       []string{},
+			OrderBy:      "Created",
+			OrderDir:     "ASC",
+		},
+	}
+	for _, filterOption := range filterOptions {
+		filterOption(options)
+	}
+	return *options
+}
+
+func FilterOrderBy(orderBy string) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.OrderBy = orderBy
+	}
+}
+
+func FilterOrderDir(orderDir string) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.OrderDir = orderDir
+	}
+}
+
+// FilterLimit limits the number of objects returned, skipping the first
+// offset ones.
+//
+// Deprecated: offsets skip or repeat objects when objects are being added or
+// removed, and get slower the further they go; use FilterPage instead.
+func FilterLimit(limit, offset int) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.Limit = &limit
+		opts.Filters.Offset = &offset
+	}
+}
+
+func FilterByHash(hs ...tilde.Digest) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.ObjectHashes = append(opts.Filters.ObjectHashes, hs...)
+	}
+}
+
+func FilterByOwner(owners ...did.DID) FilterOption {
+	hs := []string{}
+	for _, owner := range owners {
+		hs = append(hs, owner.String())
+	}
+	return func(opts *FilterOptions) {
+		opts.Filters.Owners = append(opts.Filters.Owners, hs...)
+	}
+}
+
+func FilterByStreamHash(hs ...tilde.Digest) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.StreamHashes = append(opts.Filters.StreamHashes, hs...)
+	}
+}
+
+func FilterByObjectType(typePatterns ...string) FilterOption {
+	patterns := make([]glob.Glob, len(typePatterns))
+	for i, typePattern := range typePatterns {
+		g, err := glob.Compile(typePattern, '.', '/', '#')
+		if err != nil {
+			panic(fmt.Errorf("invalid pattern: %w", err))
+		}
+		patterns[i] = g
+	}
+	return func(opts *FilterOptions) {
+		opts.Filters.ContentTypes = append(opts.Filters.ContentTypes, typePatterns...)
+	}
+}
+
+// FilterByPending filters objects on whether they are still pending
+// co-signatures to meet their signature threshold
+func FilterByPending(pending bool) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.Pending = &pending
+	}
+}
+
+// FilterPage returns up to size objects that come after the object with the
+// given hash, in the order of the filter.
+// An empty hash returns the first page, and the hash of the last object of
+// each page can be used to get the next one.
+// Stores return ErrInvalidFilter if they do not have the object with the
+// given hash, and ErrNotFound once there are no more objects.
+func FilterPage(after tilde.Digest, size int) FilterOption {
+	return func(opts *FilterOptions) {
+		opts.Filters.Limit = &size
+		opts.Filters.Offset = nil
+		opts.Filters.After = nil
+		if !after.IsEmpty() {
+			opts.Filters.After = &after
+		}
+	}
+}
+
+// FilterByData filters objects on the value of one of their data paths.
+// Paths are the hinted keys of nested maps separated by `/`, ie
+// `address:m/city:s`, and the value must have the same hint as the path.
+// Only bool, int, uint, float, string and digest values can be compared, and
+// DataHasPrefix only works for strings and digests.
+// Objects that do not have a value for the path never match.
+func FilterByData(
+	path string,
+	operator DataOperator,
+	value tilde.Value,
+) FilterOption {
+	f, err := NewDataFilter(path, operator, value)
+	return func(opts *FilterOptions) {
+		if err != nil {
+			if opts.Err == nil {
+				opts.Err = err
+			}
+			return
+		}
+		opts.Filters.Data = append(opts.Filters.Data, *f)
+	}
+}
+
+// NewDataFilter validates and returns a data filter
+func NewDataFilter(
+	path string,
+	operator DataOperator,
+	value tilde.Value,
+) (*DataFilter, error) {
+	keys, hint, err := ParseDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil || value.Hint() != hint {
+		return nil, errors.Merge(
+			ErrInvalidFilter,
+			fmt.Errorf("value of %s must have hint %s", path, hint),
+		)
+	}
+	switch hint {
+	case tilde.BoolHint,
+		tilde.IntHint,
+		tilde.UintHint,
+		tilde.FloatHint,
+		tilde.StringHint,
+		tilde.DigestHint:
+	default:
+		return nil, errors.Merge(
+			ErrInvalidFilter,
+			fmt.Errorf("values with hint %s cannot be compared", hint),
+		)
+	}
+	switch operator {
+	case DataEqual,
+		DataNotEqual,
+		DataLessThan,
+		DataLessThanOrEqual,
+		DataGreaterThan,
+		DataGreaterThanOrEqual:
+	case DataHasPrefix:
+		if hint != tilde.StringHint && hint != tilde.DigestHint {
+			return nil, errors.Merge(
+				ErrInvalidFilter,
+				fmt.Errorf("prefix cannot be used with hint %s", hint),
+			)
+		}
+	default:
+		return nil, errors.Merge(
+			ErrInvalidFilter,
+			fmt.Errorf("unknown operator %s", operator),
+		)
+	}
+	return &DataFilter{
+		Path:     path,
+		Keys:     keys,
+		Operator: operator,
+		Value:    value,
+	}, nil
+}
+
+// ParseDataPath returns the hinted keys of a data path, and the hint of its
+// value
+func ParseDataPath(path string) ([]string, tilde.Hint, error) {
+	if path == "" {
+		return nil, "", errors.Merge(
+			ErrInvalidFilter,
+			errors.Error("missing path"),
+		)
+	}
+	ks := strings.Split(path, "/")
+	hint := tilde.Hint("")
+	for i, k := range ks {
+		_, h, err := tilde.ExtractHint(k)
+		if err != nil {
+			return nil, "", errors.Merge(ErrInvalidFilter, err)
+		}
+		if i < len(ks)-1 && h != tilde.MapHint {
+			return nil, "", errors.Merge(
+				ErrInvalidFilter,
+				fmt.Errorf("%s in %s is not a map", k, path),
+			)
+		}
+		if strings.ContainsAny(k, `"\`) || !utf8.ValidString(k) {
+			return nil, "", errors.Merge(
+				ErrInvalidFilter,
+				fmt.Errorf("invalid key %s in %s", k, path),
+			)
+		}
+		hint = h
+	}
+	return ks, hint, nil
+}
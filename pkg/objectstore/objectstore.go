@@ -1,6 +1,7 @@
 package objectstore
 
 import (
+	"io"
 	"time"
 
 	"nimona.io/pkg/errors"
@@ -21,6 +22,7 @@ type (
 	}
 	Store interface {
 		Get(hash tilde.Digest) (*object.Object, error)
+		GetData(hash tilde.Digest, path string) (io.ReadCloser, error)
 		GetByType(string) (object.ReadCloser, error)
 		GetByStream(tilde.Digest) (object.ReadCloser, error)
 		Put(*object.Object) error
@@ -30,5 +32,24 @@ type (
 		IsPinned(tilde.Digest) (bool, error)
 		GetPinned() ([]tilde.Digest, error)
 		RemovePin(tilde.Digest) error
+		GetRelations(streamRootHash tilde.Digest) ([]tilde.Digest, error)
+		ListHashes() ([]tilde.Digest, error)
+		UpdateTTL(hash tilde.Digest, minutes int) error
+		Remove(tilde.Digest) error
+		Filter(...FilterOption) (object.ReadCloser, error)
+		ListenForUpdates() (updates <-chan Event, cancel func())
 	}
+	EventAction string
+	// Event is published by stores when their objects change
+	Event struct {
+		Action     EventAction
+		ObjectHash tilde.Digest
+	}
+)
+
+const (
+	ObjectInserted EventAction = "objectInserted"
+	ObjectRemoved  EventAction = "objectRemoved"
+	ObjectPinned   EventAction = "objectPinned"
+	ObjectUnpinned EventAction = "objectUnpinned"
 )
@@ -0,0 +1,24 @@
+package objectstore
+
+import (
+	"time"
+
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+type (
+	// OutboxEntry is an object waiting to be acknowledged by its recipient
+	OutboxEntry struct {
+		Recipient did.DID
+		Object    *object.Object
+		Created   time.Time
+	}
+	// Outbox persists objects until their recipients acknowledge them
+	Outbox interface {
+		PutOutbox(recipient did.DID, obj *object.Object) error
+		GetOutbox() ([]*OutboxEntry, error)
+		RemoveOutbox(recipient did.DID, hash tilde.Digest) error
+	}
+)
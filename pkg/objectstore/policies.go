@@ -0,0 +1,62 @@
+package objectstore
+
+import (
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/object"
+)
+
+// GetPolicies returns the policies that apply to the given object, objects
+// in a stream inherit the policies of the stream's root which come before
+// their own
+func GetPolicies(g Getter, o *object.Object) (object.Policies, error) {
+	if o.Metadata.Root.IsEmpty() {
+		return o.Metadata.Policies, nil
+	}
+	root, err := g.Get(o.Metadata.Root)
+	if err != nil {
+		return nil, err
+	}
+	ps := object.Policies{}
+	ps = append(ps, root.Metadata.Policies...)
+	ps = append(ps, o.Metadata.Policies...)
+	return ps, nil
+}
+
+// Evaluate evaluates the object's policies for the given action, against
+// the given key as well as the keys and identities it can act on behalf of.
+// The object's type is used as the resource the policies are evaluated for.
+func Evaluate(
+	g Getter,
+	r object.KeyResolver,
+	subject crypto.PublicKey,
+	o *object.Object,
+	action object.PolicyAction,
+) (*object.PolicyDecision, error) {
+	ps, err := GetPolicies(g, o)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return ps.Query(object.PolicyQuery{}), nil
+	}
+	q, err := object.NewPolicyQuery(r, subject, o.Type, action)
+	if err != nil {
+		return nil, err
+	}
+	return ps.Query(q), nil
+}
+
+// CanRead evaluates whether the given key, or any of the keys and identities
+// it can act on behalf of, is allowed to read the object
+func CanRead(
+	g Getter,
+	r object.KeyResolver,
+	subject crypto.PublicKey,
+	o *object.Object,
+) (bool, error) {
+	d, err := Evaluate(g, r, subject, o, object.ReadAction)
+	if err != nil {
+		return false, err
+	}
+	return d.Result == object.Allow, nil
+}
@@ -0,0 +1,42 @@
+package objectstore
+
+import (
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+// Refs returns the digests the object refers to, ie its parents, its
+// stream's root, and any digests in its data such as a blob's chunks.
+// Stores keep the objects reachable through them from pinned objects.
+func Refs(obj *object.Object) []tilde.Digest {
+	rs := []tilde.Digest{}
+	if !obj.Metadata.Root.IsEmpty() {
+		rs = append(rs, obj.Metadata.Root)
+	}
+	for _, group := range obj.Metadata.Parents {
+		rs = append(rs, group...)
+	}
+	return appendDataRefs(rs, obj.Data)
+}
+
+func appendDataRefs(rs []tilde.Digest, v tilde.Value) []tilde.Digest {
+	switch vv := v.(type) {
+	case tilde.Digest:
+		if !vv.IsEmpty() {
+			rs = append(rs, vv)
+		}
+	case tilde.DigestArray:
+		for _, d := range vv {
+			rs = appendDataRefs(rs, d)
+		}
+	case tilde.Map:
+		for _, mv := range vv {
+			rs = appendDataRefs(rs, mv)
+		}
+	case tilde.MapArray:
+		for _, m := range vv {
+			rs = appendDataRefs(rs, m)
+		}
+	}
+	return rs
+}
@@ -5,11 +5,13 @@
 package objectstoremock
 
 import (
+	io "io"
 	reflect "reflect"
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	object "nimona.io/pkg/object"
+	objectstore "nimona.io/pkg/objectstore"
 	tilde "nimona.io/pkg/tilde"
 )
 
@@ -74,6 +76,25 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 	return m.recorder
 }
 
+// Filter mocks base method.
+func (m *MockStore) Filter(arg0 ...objectstore.FilterOption) (object.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range arg0 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Filter", varargs...)
+	ret0, _ := ret[0].(object.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Filter indicates an expected call of Filter.
+func (mr *MockStoreMockRecorder) Filter(arg0 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Filter", reflect.TypeOf((*MockStore)(nil).Filter), arg0...)
+}
+
 // Get mocks base method.
 func (m *MockStore) Get(hash tilde.Digest) (*object.Object, error) {
 	m.ctrl.T.Helper()
@@ -119,6 +140,21 @@ func (mr *MockStoreMockRecorder) GetByType(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByType", reflect.TypeOf((*MockStore)(nil).GetByType), arg0)
 }
 
+// GetData mocks base method.
+func (m *MockStore) GetData(hash tilde.Digest, path string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetData", hash, path)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetData indicates an expected call of GetData.
+func (mr *MockStoreMockRecorder) GetData(hash, path interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetData", reflect.TypeOf((*MockStore)(nil).GetData), hash, path)
+}
+
 // GetPinned mocks base method.
 func (m *MockStore) GetPinned() ([]tilde.Digest, error) {
 	m.ctrl.T.Helper()
@@ -134,6 +170,21 @@ func (mr *MockStoreMockRecorder) GetPinned() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPinned", reflect.TypeOf((*MockStore)(nil).GetPinned))
 }
 
+// GetRelations mocks base method.
+func (m *MockStore) GetRelations(streamRootHash tilde.Digest) ([]tilde.Digest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRelations", streamRootHash)
+	ret0, _ := ret[0].([]tilde.Digest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRelations indicates an expected call of GetRelations.
+func (mr *MockStoreMockRecorder) GetRelations(streamRootHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRelations", reflect.TypeOf((*MockStore)(nil).GetRelations), streamRootHash)
+}
+
 // GetStreamLeaves mocks base method.
 func (m *MockStore) GetStreamLeaves(streamRootHash tilde.Digest) ([]tilde.Digest, error) {
 	m.ctrl.T.Helper()
@@ -164,6 +215,36 @@ func (mr *MockStoreMockRecorder) IsPinned(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPinned", reflect.TypeOf((*MockStore)(nil).IsPinned), arg0)
 }
 
+// ListHashes mocks base method.
+func (m *MockStore) ListHashes() ([]tilde.Digest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListHashes")
+	ret0, _ := ret[0].([]tilde.Digest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListHashes indicates an expected call of ListHashes.
+func (mr *MockStoreMockRecorder) ListHashes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListHashes", reflect.TypeOf((*MockStore)(nil).ListHashes))
+}
+
+// ListenForUpdates mocks base method.
+func (m *MockStore) ListenForUpdates() (<-chan objectstore.Event, func()) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListenForUpdates")
+	ret0, _ := ret[0].(<-chan objectstore.Event)
+	ret1, _ := ret[1].(func())
+	return ret0, ret1
+}
+
+// ListenForUpdates indicates an expected call of ListenForUpdates.
+func (mr *MockStoreMockRecorder) ListenForUpdates() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListenForUpdates", reflect.TypeOf((*MockStore)(nil).ListenForUpdates))
+}
+
 // Pin mocks base method.
 func (m *MockStore) Pin(arg0 tilde.Digest) error {
 	m.ctrl.T.Helper()
@@ -206,6 +287,20 @@ func (mr *MockStoreMockRecorder) PutWithTTL(arg0, arg1 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutWithTTL", reflect.TypeOf((*MockStore)(nil).PutWithTTL), arg0, arg1)
 }
 
+// Remove mocks base method.
+func (m *MockStore) Remove(arg0 tilde.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Remove", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Remove indicates an expected call of Remove.
+func (mr *MockStoreMockRecorder) Remove(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockStore)(nil).Remove), arg0)
+}
+
 // RemovePin mocks base method.
 func (m *MockStore) RemovePin(arg0 tilde.Digest) error {
 	m.ctrl.T.Helper()
@@ -219,3 +314,17 @@ func (mr *MockStoreMockRecorder) RemovePin(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemovePin", reflect.TypeOf((*MockStore)(nil).RemovePin), arg0)
 }
+
+// UpdateTTL mocks base method.
+func (m *MockStore) UpdateTTL(hash tilde.Digest, minutes int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTTL", hash, minutes)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateTTL indicates an expected call of UpdateTTL.
+func (mr *MockStoreMockRecorder) UpdateTTL(hash, minutes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTTL", reflect.TypeOf((*MockStore)(nil).UpdateTTL), hash, minutes)
+}
@@ -4,14 +4,49 @@ package peer
 
 import (
 	object "nimona.io/pkg/object"
+	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const ConnectionInfoType = "nimona.io/peer.ConnectionInfo"
 
 type ConnectionInfo struct {
-	Metadata      object.Metadata   `nimona:"@metadata:m,type=nimona.io/peer.ConnectionInfo"`
+	Metadata      object.Metadata   `nimona:"@metadata:m,type=nimona.io/peer.ConnectionInfo,context=7QhcW57EKyEjsdZfXx2w5EUt9Uwf53AEakqZT44Zzscu"`
 	Version       int64             `nimona:"version:i"`
 	Addresses     []string          `nimona:"addresses:as"`
 	Relays        []*ConnectionInfo `nimona:"relays:am"`
 	ObjectFormats []string          `nimona:"objectFormats:as"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("7QhcW57EKyEjsdZfXx2w5EUt9Uwf53AEakqZT44Zzscu")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/peer",
+	Types: []*schema.Type{{
+		Name: "nimona.io/peer.ConnectionInfo",
+		Properties: []*schema.Property{{
+			Name: "version",
+			Hint: "i",
+		}, {
+			Name:     "addresses",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "relays",
+			Hint:     "m",
+			Type:     "nimona.io/peer.ConnectionInfo",
+			Repeated: true,
+		}, {
+			Name:     "objectFormats",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
@@ -0,0 +1,33 @@
+package search
+
+type (
+	SearchOption  func(*SearchOptions)
+	SearchOptions struct {
+		ObjectTypes []string
+		Limit       *int
+	}
+)
+
+func newSearchOptions(searchOptions ...SearchOption) SearchOptions {
+	options := &SearchOptions{
+		ObjectTypes: []string{},
+	}
+	for _, searchOption := range searchOptions {
+		searchOption(options)
+	}
+	return *options
+}
+
+// FilterByObjectType only returns objects of the given types
+func FilterByObjectType(objectTypes ...string) SearchOption {
+	return func(opts *SearchOptions) {
+		opts.ObjectTypes = append(opts.ObjectTypes, objectTypes...)
+	}
+}
+
+// FilterLimit limits the number of objects returned to the most relevant ones
+func FilterLimit(limit int) SearchOption {
+	return func(opts *SearchOptions) {
+		opts.Limit = &limit
+	}
+}
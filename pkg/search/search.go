@@ -0,0 +1,412 @@
+package search
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/sqlobjectstore"
+	"nimona.io/pkg/tilde"
+)
+
+const (
+	ErrInvalidRule  = errors.Error("invalid rule")
+	ErrInvalidQuery = errors.Error("invalid query")
+)
+
+// nolint: lll
+var tables = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS SearchIndex USING fts5(Hash UNINDEXED, Type UNINDEXED, Content, tokenize='unicode61 remove_diacritics 2');`,
+	`CREATE TABLE IF NOT EXISTS SearchRules (Rules TEXT);`,
+}
+
+type (
+	// Index is a full-text search index over the objects of an
+	// sqlobjectstore, it lives in the same database as the store using an
+	// FTS5 table, and is kept up to date by a hook on the store's writes.
+	// Only the objects that have extraction rules for their type are indexed.
+	Index struct {
+		db    *sql.DB
+		store *sqlobjectstore.Store
+		// rules are the data paths that are indexed for each type
+		rules     map[string][]string
+		lock      sync.Mutex
+		close     func()
+		closeOnce sync.Once
+	}
+	Option func(*Index)
+	// hook updates the index as objects are put to or removed from the store
+	hook struct {
+		idx *Index
+	}
+)
+
+// New creates the index's tables if needed, and starts indexing the objects
+// inserted to the store.
+// If the rules have changed since the last time the index was used, the
+// index is rebuilt.
+func New(
+	ctx context.Context,
+	db *sql.DB,
+	store *sqlobjectstore.Store,
+	opts ...Option,
+) (*Index, error) {
+	idx := &Index{
+		db:    db,
+		store: store,
+		rules: map[string][]string{},
+	}
+
+	for _, opt := range opts {
+		opt(idx)
+	}
+
+	for objectType, paths := range idx.rules {
+		for _, path := range paths {
+			if err := validatePath(path); err != nil {
+				return nil, errors.Merge(
+					ErrInvalidRule,
+					fmt.Errorf("%s: %w", objectType, err),
+				)
+			}
+		}
+	}
+
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			return nil, fmt.Errorf("could not create tables: %w", err)
+		}
+	}
+
+	// hook into the store before rebuilding so that no objects are missed
+	cancel := store.AddHook(&hook{idx: idx})
+	idx.close = cancel
+
+	rules, err := json.Marshal(idx.rules)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	lastRules := ""
+	err = db.QueryRow("SELECT Rules FROM SearchRules").Scan(&lastRules)
+	if err != nil && err != sql.ErrNoRows {
+		cancel()
+		return nil, fmt.Errorf("could not get rules: %w", err)
+	}
+	if lastRules != string(rules) {
+		if err := idx.Rebuild(); err != nil {
+			cancel()
+			return nil, err
+		}
+		if err := idx.putRules(string(rules)); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	return idx, nil
+}
+
+// WithRule indexes the given data paths of the objects of the given type.
+// Paths are the hinted keys of nested maps separated by `/`, ie
+// `address:m/city:s`, and can point to strings or arrays of strings.
+func WithRule(objectType string, paths ...string) Option {
+	return func(idx *Index) {
+		idx.rules[objectType] = append(idx.rules[objectType], paths...)
+	}
+}
+
+// Close stops the index from following the store's writes
+func (idx *Index) Close() {
+	idx.closeOnce.Do(idx.close)
+}
+
+// Rebuild clears the index and indexes all the objects in the store again.
+// The lock is only held while updating the index, as the store's hook needs
+// it while the store is locked.
+func (idx *Index) Rebuild() error {
+	idx.lock.Lock()
+	_, err := idx.db.Exec("DELETE FROM SearchIndex")
+	idx.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not clear index: %w", err)
+	}
+
+	if len(idx.rules) == 0 {
+		return nil
+	}
+
+	types := []string{}
+	for objectType := range idx.rules {
+		types = append(types, objectType)
+	}
+
+	reader, err := idx.store.Filter(
+		objectstore.FilterByObjectType(types...),
+	)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get objects: %w", err)
+	}
+	defer reader.Close()
+
+	for {
+		o, err := reader.Read()
+		if errors.Is(err, object.ErrReaderDone) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read objects: %w", err)
+		}
+		idx.lock.Lock()
+		err = idx.put(o)
+		idx.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Search returns the indexed objects that match all the words in the query,
+// ordered by relevance.
+// The last word is treated as a prefix, so that partial queries can be used
+// while typing.
+func (idx *Index) Search(
+	query string,
+	searchOptions ...SearchOption,
+) (object.ReadCloser, error) {
+	options := newSearchOptions(searchOptions...)
+
+	match := matchQuery(query)
+	if match == "" {
+		return nil, errors.Merge(
+			ErrInvalidQuery,
+			errors.Error("missing terms"),
+		)
+	}
+
+	where := "WHERE SearchIndex MATCH ? "
+	whereArgs := []interface{}{match}
+
+	if len(options.ObjectTypes) > 0 {
+		qs := strings.Repeat(",?", len(options.ObjectTypes))[1:]
+		where += "AND Type IN (" + qs + ") "
+		for _, t := range options.ObjectTypes {
+			whereArgs = append(whereArgs, t)
+		}
+	}
+
+	where += "ORDER BY rank "
+
+	if options.Limit != nil {
+		where += fmt.Sprintf("LIMIT %d ", *options.Limit)
+	}
+
+	idx.lock.Lock()
+	// nolint: gosec
+	rows, err := idx.db.Query(
+		"SELECT Hash FROM SearchIndex "+where,
+		whereArgs...,
+	)
+	if err != nil {
+		idx.lock.Unlock()
+		return nil, fmt.Errorf("could not query: %w", err)
+	}
+
+	hashes := []tilde.Digest{}
+	for rows.Next() {
+		hash := ""
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close() // nolint: errcheck
+			idx.lock.Unlock()
+			return nil, fmt.Errorf("could not scan: %w", err)
+		}
+		hashes = append(hashes, tilde.Digest(hash))
+	}
+	rows.Close() // nolint: errcheck
+	idx.lock.Unlock()
+
+	if len(hashes) == 0 {
+		return nil, objectstore.ErrNotFound
+	}
+
+	errorChan := make(chan error)
+	objectsChan := make(chan *object.Object)
+	closeChan := make(chan struct{})
+
+	reader := object.NewReadCloser(
+		context.TODO(),
+		objectsChan,
+		errorChan,
+		closeChan,
+	)
+
+	go func() {
+		defer close(objectsChan)
+		defer close(errorChan)
+		for _, hash := range hashes {
+			o, err := idx.store.Get(hash)
+			// the object might have been removed since it was found
+			if errors.Is(err, objectstore.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				errorChan <- err
+				return
+			}
+			select {
+			case <-closeChan:
+				return
+			case objectsChan <- o:
+				// all good
+			}
+		}
+	}()
+
+	return reader, nil
+}
+
+func (h *hook) ObjectPut(o *object.Object) error {
+	h.idx.lock.Lock()
+	defer h.idx.lock.Unlock()
+	return h.idx.put(o)
+}
+
+func (h *hook) ObjectRemoved(hash tilde.Digest) error {
+	return h.idx.remove(hash)
+}
+
+// put replaces the indexed content of an object, it expects the lock to be
+// held
+func (idx *Index) put(o *object.Object) error {
+	paths, ok := idx.rules[o.Type]
+	if !ok {
+		return nil
+	}
+
+	h := o.Hash().String()
+	if _, err := idx.db.Exec(
+		"DELETE FROM SearchIndex WHERE Hash=?",
+		h,
+	); err != nil {
+		return fmt.Errorf("could not remove from index: %w", err)
+	}
+
+	content := extract(o.Data, paths)
+	if content == "" {
+		return nil
+	}
+
+	if _, err := idx.db.Exec(
+		"INSERT INTO SearchIndex (Hash, Type, Content) VALUES (?, ?, ?)",
+		h,
+		o.Type,
+		content,
+	); err != nil {
+		return fmt.Errorf("could not add to index: %w", err)
+	}
+
+	return nil
+}
+
+func (idx *Index) remove(hash tilde.Digest) error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if _, err := idx.db.Exec(
+		"DELETE FROM SearchIndex WHERE Hash=?",
+		hash.String(),
+	); err != nil {
+		return fmt.Errorf("could not remove from index: %w", err)
+	}
+
+	return nil
+}
+
+func (idx *Index) putRules(rules string) error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM SearchRules"); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return fmt.Errorf("could not remove rules: %w", err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO SearchRules (Rules) VALUES (?)",
+		rules,
+	); err != nil {
+		tx.Rollback() // nolint: errcheck
+		return fmt.Errorf("could not add rules: %w", err)
+	}
+	return tx.Commit()
+}
+
+func validatePath(path string) error {
+	ks := strings.Split(path, "/")
+	for i, k := range ks {
+		_, h, err := tilde.ExtractHint(k)
+		if err != nil {
+			return err
+		}
+		switch {
+		case i < len(ks)-1 && h != tilde.MapHint:
+			return fmt.Errorf("%s in %s is not a map", k, path)
+		case i == len(ks)-1 &&
+			h != tilde.StringHint &&
+			h != tilde.StringArrayHint:
+			return fmt.Errorf("%s is not a string", path)
+		}
+	}
+	return nil
+}
+
+// extract returns the text of the values of the given paths, paths that do
+// not exist or that have been redacted are ignored
+func extract(m tilde.Map, paths []string) string {
+	ts := []string{}
+	for _, path := range paths {
+		ks := strings.Split(path, "/")
+		mm := m
+		for _, k := range ks[:len(ks)-1] {
+			key, _, _ := tilde.ExtractHint(k) // nolint: errcheck
+			mm, _ = mm[key].(tilde.Map)
+		}
+		key, _, _ := tilde.ExtractHint(ks[len(ks)-1]) // nolint: errcheck
+		switch v := mm[key].(type) {
+		case tilde.String:
+			ts = append(ts, string(v))
+		case tilde.StringArray:
+			for _, s := range v {
+				ts = append(ts, string(s))
+			}
+		}
+	}
+	return strings.TrimSpace(strings.Join(ts, "\n"))
+}
+
+// matchQuery converts a user's query into an FTS5 one, quoting each word so
+// that they cannot be parsed as operators
+func matchQuery(query string) string {
+	ws := strings.Fields(query)
+	if len(ws) == 0 {
+		return ""
+	}
+	qs := make([]string, len(ws))
+	for i, w := range ws {
+		qs[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	qs[len(qs)-1] += "*"
+	return strings.Join(qs, " ")
+}
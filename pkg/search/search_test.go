@@ -0,0 +1,193 @@
+package search
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "modernc.org/sqlite"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/sqlobjectstore"
+	"nimona.io/pkg/tilde"
+)
+
+func newTestStore(t *testing.T) (*sql.DB, *sqlobjectstore.Store) {
+	t.Helper()
+	db, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "sqlite3.db")+"?_pragma=busy_timeout(5000)",
+	)
+	require.NoError(t, err)
+	str, err := sqlobjectstore.New(db)
+	require.NoError(t, err)
+	return db, str
+}
+
+func search(
+	t *testing.T,
+	idx *Index,
+	query string,
+	opts ...SearchOption,
+) []tilde.Digest {
+	t.Helper()
+	reader, err := idx.Search(query, opts...)
+	if err == objectstore.ErrNotFound {
+		return nil
+	}
+	require.NoError(t, err)
+	got, err := object.ReadAll(reader)
+	require.NoError(t, err)
+	hs := []tilde.Digest{}
+	for _, o := range got {
+		hs = append(hs, o.Hash())
+	}
+	return hs
+}
+
+func TestIndex(t *testing.T) {
+	db, str := newTestStore(t)
+
+	// objects that exist before the index are indexed on creation
+	file := &object.Object{
+		Type: "file",
+		Data: tilde.Map{
+			"name": tilde.String("holiday photos.zip"),
+		},
+	}
+	require.NoError(t, str.Put(file))
+
+	idx, err := New(
+		context.New(),
+		db,
+		str,
+		WithRule("file", "name:s"),
+		WithRule("message", "body:s", "author:m/name:s", "tags:as"),
+	)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	assert.Equal(t, []tilde.Digest{file.Hash()}, search(t, idx, "holiday"))
+
+	message := func(body, author string, tags ...string) *object.Object {
+		return &object.Object{
+			Type: "message",
+			Data: tilde.Map{
+				"body": tilde.String(body),
+				"author": tilde.Map{
+					"name": tilde.String(author),
+				},
+				"tags": tilde.StringArray(func() []tilde.String {
+					ts := []tilde.String{}
+					for _, tag := range tags {
+						ts = append(ts, tilde.String(tag))
+					}
+					return ts
+				}()),
+			},
+		}
+	}
+	m1 := message("see you on holiday", "alice")
+	m2 := message("holiday holiday holiday", "bob", "Café")
+	m3 := message("nothing to see here", "alice")
+	ignored := &object.Object{
+		Type: "other",
+		Data: tilde.Map{
+			"body": tilde.String("holiday"),
+		},
+	}
+	for _, o := range []*object.Object{m1, m2, m3, ignored} {
+		require.NoError(t, str.Put(o))
+	}
+
+	// objects are indexed as they are added to the store
+	assert.Len(t, search(t, idx, "holiday"), 3)
+
+	t.Run("ranked", func(t *testing.T) {
+		got := search(
+			t,
+			idx,
+			"holiday",
+			FilterByObjectType("message"),
+		)
+		assert.Equal(t, []tilde.Digest{m2.Hash(), m1.Hash()}, got)
+	})
+
+	t.Run("nested paths and arrays", func(t *testing.T) {
+		got := search(t, idx, "alice")
+		assert.ElementsMatch(t, []tilde.Digest{m1.Hash(), m3.Hash()}, got)
+		got = search(t, idx, "cafe")
+		assert.Equal(t, []tilde.Digest{m2.Hash()}, got)
+	})
+
+	t.Run("all terms, last one as prefix", func(t *testing.T) {
+		got := search(t, idx, "alice hol")
+		assert.Equal(t, []tilde.Digest{m1.Hash()}, got)
+	})
+
+	t.Run("operators are not parsed", func(t *testing.T) {
+		got := search(t, idx, `alice OR "bob`)
+		assert.Empty(t, got)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		got := search(t, idx, "holiday", FilterLimit(1))
+		assert.Len(t, got, 1)
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		_, err := idx.Search("  ")
+		require.ErrorIs(t, err, ErrInvalidQuery)
+	})
+
+	t.Run("removed objects", func(t *testing.T) {
+		require.NoError(t, str.Remove(m3.Hash()))
+		assert.Empty(t, search(t, idx, "nothing"))
+	})
+
+	t.Run("bursts", func(t *testing.T) {
+		// more objects than the store's updates can buffer
+		for i := 0; i < 250; i++ {
+			require.NoError(t, str.Put(
+				message(fmt.Sprintf("burst %d", i), "carol"),
+			))
+		}
+		assert.Len(t, search(t, idx, "burst"), 250)
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		_, err := db.Exec("DELETE FROM SearchIndex")
+		require.NoError(t, err)
+		assert.Empty(t, search(t, idx, "holiday"))
+		require.NoError(t, idx.Rebuild())
+		assert.Len(t, search(t, idx, "holiday"), 3)
+	})
+
+	t.Run("rebuild when rules change", func(t *testing.T) {
+		idx.Close()
+		idx, err := New(
+			context.New(),
+			db,
+			str,
+			WithRule("other", "body:s"),
+		)
+		require.NoError(t, err)
+		defer idx.Close()
+		got := search(t, idx, "holiday")
+		assert.Equal(t, []tilde.Digest{ignored.Hash()}, got)
+	})
+}
+
+func TestIndex_InvalidRule(t *testing.T) {
+	db, str := newTestStore(t)
+	for _, p := range []string{"name", "name:i", "author:s/name:s"} {
+		_, err := New(context.New(), db, str, WithRule("file", p))
+		require.ErrorIs(t, err, ErrInvalidRule)
+	}
+}
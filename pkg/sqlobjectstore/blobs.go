@@ -0,0 +1,309 @@
+package sqlobjectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+// Data values in the bodies of objects are base64 encoded, which for large
+// values, ie blob chunks, doubles their size and bloats the database.
+// When a blob store has been configured, these values are moved out of the
+// body and into the blob store, leaving empty values in their place.
+// The Blobs table keeps the path of each value that was moved, and its digest
+// so that it can be put back when the object is retrieved.
+
+var defaultBlobThreshold = 64 * 1024
+
+// blobRef is a value that was moved to the blob store
+type blobRef struct {
+	digest tilde.Digest
+	size   int
+}
+
+// offload moves the object's large data values to the blob store, and returns
+// the object that should be stored in their place together with the values
+// that were moved by their path
+func (st *Store) offload(
+	obj *object.Object,
+) (*object.Object, map[string]blobRef, error) {
+	if st.blobStore == nil {
+		return obj, nil, nil
+	}
+
+	blobs := map[string]blobRef{}
+	data, err := st.offloadMap(obj.Data, "", blobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(blobs) == 0 {
+		return obj, nil, nil
+	}
+
+	stored := *obj
+	stored.Data = data
+	return &stored, blobs, nil
+}
+
+// offloadMap returns a copy of the map without its large data values, or nil
+// if nothing needed to be moved
+func (st *Store) offloadMap(
+	m tilde.Map,
+	prefix string,
+	blobs map[string]blobRef,
+) (tilde.Map, error) {
+	var r tilde.Map
+	for k, v := range m {
+		var nv tilde.Value
+		switch vv := v.(type) {
+		case tilde.Data:
+			if len(vv) < st.blobThreshold {
+				continue
+			}
+			digest, err := st.blobStore.Put(bytes.NewReader(vv))
+			if err != nil {
+				return nil, fmt.Errorf("could not put blob: %w", err)
+			}
+			blobs[prefix+k+":"+string(tilde.DataHint)] = blobRef{
+				digest: digest,
+				size:   len(vv),
+			}
+			nv = tilde.Data{}
+		case tilde.Map:
+			nm, err := st.offloadMap(
+				vv,
+				prefix+k+":"+string(tilde.MapHint)+"/",
+				blobs,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if nm == nil {
+				continue
+			}
+			nv = nm
+		default:
+			continue
+		}
+		if r == nil {
+			r = make(tilde.Map, len(m))
+			for ck, cv := range m {
+				r[ck] = cv
+			}
+		}
+		r[k] = nv
+	}
+	return r, nil
+}
+
+// putBlobs keeps the digests of the object's offloaded values, it expects the
+// objects lock to be held
+func (st *Store) putBlobs(
+	hash tilde.Digest,
+	blobs map[string]blobRef,
+) error {
+	for path, blob := range blobs {
+		if _, err := st.db.Exec(
+			"INSERT OR REPLACE INTO Blobs (Hash, Path, Digest, Size) "+
+				"VALUES (?, ?, ?, ?)",
+			hash.String(),
+			path,
+			blob.digest.String(),
+			blob.size,
+		); err != nil {
+			return fmt.Errorf("could not insert to blobs table: %w", err)
+		}
+	}
+	return nil
+}
+
+// getBlobs returns the object's offloaded values by their path, it expects
+// the objects lock to be held
+func (st *Store) getBlobs(
+	hash tilde.Digest,
+) (map[string]blobRef, error) {
+	rows, err := st.db.Query(
+		"SELECT Path, Digest, Size FROM Blobs WHERE Hash=?",
+		hash.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query blobs: %w", err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	blobs := map[string]blobRef{}
+	for rows.Next() {
+		path, digest, size := "", "", 0
+		if err := rows.Scan(&path, &digest, &size); err != nil {
+			return nil, fmt.Errorf("could not scan blob: %w", err)
+		}
+		blobs[path] = blobRef{
+			digest: tilde.Digest(digest),
+			size:   size,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// restore puts the object's offloaded values back in place, it expects the
+// objects lock to be held
+func (st *Store) restore(
+	hash tilde.Digest,
+	obj *object.Object,
+) error {
+	blobs, err := st.getBlobs(hash)
+	if err != nil {
+		return err
+	}
+	if len(blobs) == 0 {
+		return nil
+	}
+	if st.blobStore == nil {
+		return errors.Error("object has blobs but no blob store was provided")
+	}
+
+	for path, blob := range blobs {
+		digest := blob.digest
+		r, err := st.blobStore.Get(digest)
+		if err != nil {
+			return fmt.Errorf("could not get blob %s: %w", digest, err)
+		}
+		// the size is known, so the value is read in place rather than
+		// being buffered and copied over
+		b := make([]byte, blob.size)
+		_, err = io.ReadFull(r, b)
+		r.Close() // nolint: errcheck
+		if err != nil {
+			return fmt.Errorf("could not read blob %s: %w", digest, err)
+		}
+		m := obj.Data
+		ks := strings.Split(path, "/")
+		for _, k := range ks[:len(ks)-1] {
+			key, _, _ := tilde.ExtractHint(k) // nolint: errcheck
+			m, _ = m[key].(tilde.Map)
+		}
+		if m == nil {
+			return fmt.Errorf("could not find path %s of blob %s", path, digest)
+		}
+		key, _, _ := tilde.ExtractHint(ks[len(ks)-1]) // nolint: errcheck
+		m[key] = tilde.Data(b)
+	}
+
+	return nil
+}
+
+// GetData returns a reader for a data value of an object, values that have
+// been moved to the blob store are streamed from it without being loaded in
+// memory.
+// The path is made up of the hinted keys of the value and its parent maps,
+// ie `data:d` or `file:m/data:d`.
+func (st *Store) GetData(
+	hash tilde.Digest,
+	path string,
+) (io.ReadCloser, error) {
+	keys, hint, err := objectstore.ParseDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if hint != tilde.DataHint {
+		return nil, errors.Merge(
+			objectstore.ErrInvalidFilter,
+			fmt.Errorf("%s is not data", path),
+		)
+	}
+
+	st.tableLockObjects.Lock()
+	blobs, err := st.getBlobs(hash)
+	if err != nil {
+		st.tableLockObjects.Unlock()
+		return nil, err
+	}
+	if blob, ok := blobs[strings.Join(keys, "/")]; ok {
+		st.tableLockObjects.Unlock()
+		if st.blobStore == nil {
+			return nil, errors.Error("no blob store was provided")
+		}
+		return st.blobStore.Get(blob.digest)
+	}
+	obj, err := st.getBody(hash)
+	st.tableLockObjects.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var v tilde.Value = obj.Data
+	for _, k := range keys {
+		m, _ := v.(tilde.Map)
+		key, _, _ := tilde.ExtractHint(k) // nolint: errcheck
+		v = m[key]
+	}
+	d, ok := v.(tilde.Data)
+	if !ok {
+		return nil, objectstore.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(d)), nil
+}
+
+// removeOrphanBlobs forgets the offloaded values of objects that no longer
+// exist, and removes the values that no other object refers to from the blob
+// store, it expects the objects lock to be held
+func (st *Store) removeOrphanBlobs() error {
+	rows, err := st.db.Query(
+		"SELECT DISTINCT Digest FROM Blobs " +
+			"WHERE Hash NOT IN (SELECT Hash FROM Objects)",
+	)
+	if err != nil {
+		return fmt.Errorf("could not query blobs: %w", err)
+	}
+	digests := []string{}
+	for rows.Next() {
+		digest := ""
+		if err := rows.Scan(&digest); err != nil {
+			rows.Close() // nolint: errcheck
+			return fmt.Errorf("could not scan blob: %w", err)
+		}
+		digests = append(digests, digest)
+	}
+	rows.Close() // nolint: errcheck
+
+	if len(digests) == 0 {
+		return nil
+	}
+
+	if _, err := st.db.Exec(
+		"DELETE FROM Blobs WHERE Hash NOT IN (SELECT Hash FROM Objects)",
+	); err != nil {
+		return fmt.Errorf("could not delete blobs: %w", err)
+	}
+
+	if st.blobStore == nil {
+		return nil
+	}
+
+	for _, digest := range digests {
+		refs := 0
+		if err := st.db.QueryRow(
+			"SELECT COUNT(*) FROM Blobs WHERE Digest=?",
+			digest,
+		).Scan(&refs); err != nil {
+			return fmt.Errorf("could not count blob references: %w", err)
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := st.blobStore.Remove(tilde.Digest(digest)); err != nil {
+			return fmt.Errorf("could not remove blob: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package sqlobjectstore
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+// dataExpression returns the sql expression that extracts the value of the
+// given data path from an object's body.
+// The path is inlined in the expression rather than being passed as an
+// argument, so that queries can make use of the indexes on it.
+func dataExpression(path string) (string, tilde.Hint, error) {
+	ks, hint, err := objectstore.ParseDataPath(path)
+	if err != nil {
+		return "", "", err
+	}
+	jp := "$"
+	for _, k := range ks {
+		jp += `."` + k + `"`
+	}
+	return "json_extract(Body, " + quote(jp) + ")", hint, nil
+}
+
+// quote returns the given string as an sql string literal
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// dataWhere returns the where clause and arguments for a data filter
+func dataWhere(f objectstore.DataFilter) (string, []interface{}) {
+	// the filter's path has already been validated
+	expression, _, _ := dataExpression(f.Path) // nolint: errcheck
+	v := sqlValue(f.Value)
+	if f.Operator == objectstore.DataHasPrefix {
+		s := v.(string)
+		return "substr(" + expression + ", 1, ?) = ? ",
+			[]interface{}{utf8.RuneCountInString(s), s}
+	}
+	return expression + " " + string(f.Operator) + " ? ",
+		[]interface{}{v}
+}
+
+// sqlValue converts a value to the type json_extract returns for it
+func sqlValue(value tilde.Value) interface{} {
+	switch v := value.(type) {
+	case tilde.Bool:
+		return bool(v)
+	case tilde.Int:
+		return int64(v)
+	case tilde.Uint:
+		// sqlite stores large uints as floats
+		if int64(v) < 0 {
+			return float64(v)
+		}
+		return int64(v)
+	case tilde.Float:
+		return float64(v)
+	case tilde.String:
+		return string(v)
+	case tilde.Digest:
+		return string(v)
+	}
+	return nil
+}
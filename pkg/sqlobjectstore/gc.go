@@ -0,0 +1,435 @@
+package sqlobjectstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+// Objects are kept for as long as they are reachable from a pinned object, or
+// until their TTL has passed since they were last accessed.
+// An object reaches the objects it refers to, ie its parents, its stream's
+// root, and any digests in its data such as a blob's chunks, which are kept
+// in the Refs table.
+// Stream roots also reach all of the objects in their streams.
+// When a quota has been set, the least recently accessed unreachable objects
+// are removed until the store fits in it, even if their TTL has not passed.
+// Objects without a TTL are never removed.
+
+var (
+	promGCRunsCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_objectstore_gc_runs_total",
+			Help: "Total number of object store garbage collections",
+		},
+	)
+	promGCRemovedCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nimona_objectstore_gc_removed_objects_total",
+			Help: "Total number of objects removed by garbage collection",
+		},
+		[]string{"reason"},
+	)
+	promGCFreedBytesCounter = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nimona_objectstore_gc_freed_bytes_total",
+			Help: "Total number of bytes freed by garbage collection",
+		},
+	)
+	promGCDurationHistogram = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "nimona_objectstore_gc_duration_seconds",
+			Help:    "Duration of object store garbage collections",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+	)
+	promUsedBytesGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nimona_objectstore_used_bytes",
+			Help: "Number of bytes used by objects and blobs",
+		},
+	)
+)
+
+var (
+	// gcInterval is how often the garbage collector runs, its first run is
+	// also delayed by it so that it does not compete with the store's users
+	// while they are starting up
+	gcInterval = time.Minute
+	// indexRefsBatchSize is the number of objects indexRefs indexes while
+	// holding the objects lock
+	indexRefsBatchSize = 100
+)
+
+// reachable is prepended to queries that need the hashes of the objects that
+// should not be removed
+const reachable = `
+WITH RECURSIVE Reachable(Hash) AS (
+	SELECT Hash FROM Pins
+	UNION
+	SELECT Refs.Ref FROM Refs
+		JOIN Reachable ON Refs.Hash = Reachable.Hash
+	UNION
+	SELECT Objects.Hash FROM Objects
+		JOIN Reachable ON Objects.RootHash = Reachable.Hash
+)
+`
+
+type (
+	// GCReport describes the objects removed by a garbage collection, or the
+	// ones that would have been removed for dry runs
+	GCReport struct {
+		// Expired are the unreachable objects whose TTL has passed
+		Expired []tilde.Digest
+		// Evicted are the unreachable objects that were removed, least
+		// recently accessed first, to fit in the quota
+		Evicted []tilde.Digest
+		// FreedBytes is the size of the removed objects and of the blobs
+		// only they referred to
+		FreedBytes int64
+		// UsedBytes is the size of the store after the collection
+		UsedBytes int64
+	}
+	// gcAccount keeps track of how many bytes removing objects frees
+	gcAccount struct {
+		st      *Store
+		removed map[tilde.Digest]struct{}
+		// blobRefs are the number of references to each blob
+		blobRefs map[string]int
+	}
+)
+
+// GC removes the objects that are not reachable from any of the pinned
+// objects, and whose TTL has passed or that do not fit in the quota.
+// When dryRun is set nothing is removed, but the report is still returned.
+func (st *Store) GC(dryRun bool) (*GCReport, error) {
+	start := time.Now()
+
+	if err := st.indexRefs(); err != nil {
+		return nil, err
+	}
+
+	st.tableLockObjects.Lock()
+	defer st.tableLockObjects.Unlock()
+
+	used, err := st.usage()
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := st.newGCAccount()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{
+		Expired: []tilde.Digest{},
+		Evicted: []tilde.Digest{},
+	}
+
+	expired, err := st.queryHashes(
+		reachable+`
+		SELECT Hash FROM Objects
+		WHERE Hash NOT IN (SELECT Hash FROM Reachable)
+			AND TTL > 0
+			AND LastAccessed + TTL < ?
+		ORDER BY LastAccessed, Hash`,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get expired objects: %w", err)
+	}
+
+	for _, hash := range expired {
+		freed, err := acc.remove(hash)
+		if err != nil {
+			return nil, err
+		}
+		report.Expired = append(report.Expired, hash)
+		report.FreedBytes += freed
+	}
+
+	if st.quota > 0 && used-report.FreedBytes > st.quota {
+		candidates, err := st.queryHashes(
+			reachable + `
+			SELECT Hash FROM Objects
+			WHERE Hash NOT IN (SELECT Hash FROM Reachable)
+				AND TTL > 0
+			ORDER BY LastAccessed, Hash`,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not get unreachable objects: %w", err)
+		}
+		for _, hash := range candidates {
+			if used-report.FreedBytes <= st.quota {
+				break
+			}
+			if _, ok := acc.removed[hash]; ok {
+				continue
+			}
+			freed, err := acc.remove(hash)
+			if err != nil {
+				return nil, err
+			}
+			report.Evicted = append(report.Evicted, hash)
+			report.FreedBytes += freed
+		}
+	}
+
+	report.UsedBytes = used - report.FreedBytes
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := st.removeObjects(report.Expired); err != nil {
+		return nil, err
+	}
+	if err := st.removeObjects(report.Evicted); err != nil {
+		return nil, err
+	}
+	if err := st.removeOrphans(); err != nil {
+		return nil, err
+	}
+
+	for _, hashes := range [][]tilde.Digest{report.Expired, report.Evicted} {
+		for _, hash := range hashes {
+			if err := st.objectRemoved(hash); err != nil {
+				return nil, err
+			}
+			st.publishUpdate(objectstore.Event{
+				Action:     objectstore.ObjectRemoved,
+				ObjectHash: hash,
+			})
+		}
+	}
+
+	promGCRunsCounter.Inc()
+	promGCRemovedCounter.
+		WithLabelValues("expired").
+		Add(float64(len(report.Expired)))
+	promGCRemovedCounter.
+		WithLabelValues("evicted").
+		Add(float64(len(report.Evicted)))
+	promGCFreedBytesCounter.Add(float64(report.FreedBytes))
+	promGCDurationHistogram.Observe(time.Since(start).Seconds())
+	promUsedBytesGauge.Set(float64(report.UsedBytes))
+
+	return report, nil
+}
+
+// putRefs replaces the references of the object, it expects the objects lock
+// to be held
+func (st *Store) putRefs(hash tilde.Digest, obj *object.Object) error {
+	if _, err := st.db.Exec(
+		"DELETE FROM Refs WHERE Hash=?",
+		hash.String(),
+	); err != nil {
+		return fmt.Errorf("could not delete refs: %w", err)
+	}
+	for _, ref := range objectstore.Refs(obj) {
+		if _, err := st.db.Exec(
+			"INSERT OR IGNORE INTO Refs (Hash, Ref) VALUES (?, ?)",
+			hash.String(),
+			ref.String(),
+		); err != nil {
+			return fmt.Errorf("could not insert to refs table: %w", err)
+		}
+	}
+	return nil
+}
+
+// indexRefs adds the references of objects that were stored before the Refs
+// table existed.
+// Objects are indexed in batches, and the objects lock is only held for each
+// batch so that large stores are not blocked for the whole pass.
+func (st *Store) indexRefs() error {
+	for {
+		n, err := st.indexRefsBatch()
+		if err != nil {
+			return err
+		}
+		if n < indexRefsBatchSize {
+			return nil
+		}
+	}
+}
+
+// indexRefsBatch indexes the references of the next batch of objects, and
+// returns how many there were
+func (st *Store) indexRefsBatch() (int, error) {
+	st.tableLockObjects.Lock()
+	defer st.tableLockObjects.Unlock()
+
+	hashes, err := st.queryHashes(
+		"SELECT Hash FROM Objects WHERE RefsIndexed=0 LIMIT ?",
+		indexRefsBatchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not get objects to index: %w", err)
+	}
+	for _, hash := range hashes {
+		obj, err := st.getBody(hash)
+		if err != nil {
+			return 0, err
+		}
+		if err := st.putRefs(hash, obj); err != nil {
+			return 0, err
+		}
+		if _, err := st.db.Exec(
+			"UPDATE Objects SET RefsIndexed=1 WHERE Hash=?",
+			hash.String(),
+		); err != nil {
+			return 0, fmt.Errorf("could not update object: %w", err)
+		}
+	}
+	return len(hashes), nil
+}
+
+// usage returns the size of the object bodies and of the blobs they refer to,
+// it expects the objects lock to be held
+func (st *Store) usage() (int64, error) {
+	bodies := int64(0)
+	if err := st.db.QueryRow(
+		"SELECT COALESCE(SUM(LENGTH(CAST(Body AS BLOB))), 0) FROM Objects",
+	).Scan(&bodies); err != nil {
+		return 0, fmt.Errorf("could not get objects size: %w", err)
+	}
+	blobs := int64(0)
+	if err := st.db.QueryRow(
+		"SELECT COALESCE(SUM(Size), 0) FROM " +
+			"(SELECT MAX(Size) AS Size FROM Blobs GROUP BY Digest)",
+	).Scan(&blobs); err != nil {
+		return 0, fmt.Errorf("could not get blobs size: %w", err)
+	}
+	return bodies + blobs, nil
+}
+
+func (st *Store) newGCAccount() (*gcAccount, error) {
+	rows, err := st.db.Query(
+		"SELECT Digest, COUNT(*) FROM Blobs GROUP BY Digest",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not count blob references: %w", err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	acc := &gcAccount{
+		st:       st,
+		removed:  map[tilde.Digest]struct{}{},
+		blobRefs: map[string]int{},
+	}
+	for rows.Next() {
+		digest, refs := "", 0
+		if err := rows.Scan(&digest, &refs); err != nil {
+			return nil, fmt.Errorf("could not scan blob references: %w", err)
+		}
+		acc.blobRefs[digest] = refs
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not count blob references: %w", err)
+	}
+	return acc, nil
+}
+
+// remove marks the object as removed, and returns the number of bytes this
+// frees, including the blobs no other object refers to
+func (acc *gcAccount) remove(hash tilde.Digest) (int64, error) {
+	acc.removed[hash] = struct{}{}
+
+	freed := int64(0)
+	if err := acc.st.db.QueryRow(
+		"SELECT LENGTH(CAST(Body AS BLOB)) FROM Objects WHERE Hash=?",
+		hash.String(),
+	).Scan(&freed); err != nil {
+		return 0, fmt.Errorf("could not get object size: %w", err)
+	}
+
+	rows, err := acc.st.db.Query(
+		"SELECT Digest, Size FROM Blobs WHERE Hash=?",
+		hash.String(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not get object blobs: %w", err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	for rows.Next() {
+		digest, size := "", int64(0)
+		if err := rows.Scan(&digest, &size); err != nil {
+			return 0, fmt.Errorf("could not scan blob: %w", err)
+		}
+		acc.blobRefs[digest]--
+		if acc.blobRefs[digest] == 0 {
+			freed += size
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not get object blobs: %w", err)
+	}
+
+	return freed, nil
+}
+
+// removeObjects deletes the given objects, it expects the objects lock to be
+// held
+func (st *Store) removeObjects(hashes []tilde.Digest) error {
+	// sqlite limits the number of variables per statement
+	const batchSize = 500
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > batchSize {
+			n = batchSize
+		}
+		// nolint: gosec
+		if _, err := st.db.Exec(
+			"DELETE FROM Objects WHERE Hash IN ("+
+				strings.Repeat(",?", n)[1:]+
+				")",
+			ahtoai(hashes[:n])...,
+		); err != nil {
+			return fmt.Errorf("could not delete objects: %w", err)
+		}
+		hashes = hashes[n:]
+	}
+	return nil
+}
+
+// removeOrphans removes the references and blobs of objects that no longer
+// exist, it expects the objects lock to be held
+func (st *Store) removeOrphans() error {
+	if _, err := st.db.Exec(
+		"DELETE FROM Refs WHERE Hash NOT IN (SELECT Hash FROM Objects)",
+	); err != nil {
+		return fmt.Errorf("could not delete refs: %w", err)
+	}
+	return st.removeOrphanBlobs()
+}
+
+func (st *Store) queryHashes(
+	query string,
+	args ...interface{},
+) ([]tilde.Digest, error) {
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() // nolint: errcheck
+
+	hashes := []tilde.Digest{}
+	for rows.Next() {
+		hash := ""
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, tilde.Digest(hash))
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,58 @@
+package sqlobjectstore
+
+import (
+	"nimona.io/internal/rand"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+// Updates are published on buffered channels and are dropped when listeners
+// cannot keep up, which is fine for notifications but not for anything that
+// needs to mirror the store's contents.
+// Hooks are called as part of putting and removing objects instead, so they
+// never miss any changes.
+
+type (
+	// Hook is notified of the objects that are put to or removed from the
+	// store, while the store's lock is held; hooks cannot call back into the
+	// store, and errors are returned to the caller of the store's method
+	Hook interface {
+		ObjectPut(*object.Object) error
+		ObjectRemoved(tilde.Digest) error
+	}
+)
+
+// AddHook registers a hook, and returns a function that removes it
+func (st *Store) AddHook(h Hook) (remove func()) {
+	st.hooksLock.Lock()
+	defer st.hooksLock.Unlock()
+	id := rand.String(8)
+	st.hooks[id] = h
+	return func() {
+		st.hooksLock.Lock()
+		defer st.hooksLock.Unlock()
+		delete(st.hooks, id)
+	}
+}
+
+func (st *Store) objectPut(obj *object.Object) error {
+	st.hooksLock.RLock()
+	defer st.hooksLock.RUnlock()
+	for _, h := range st.hooks {
+		if err := h.ObjectPut(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *Store) objectRemoved(hash tilde.Digest) error {
+	st.hooksLock.RLock()
+	defer st.hooksLock.RUnlock()
+	for _, h := range st.hooks {
+		if err := h.ObjectRemoved(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
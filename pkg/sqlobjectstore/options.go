@@ -0,0 +1,32 @@
+package sqlobjectstore
+
+import (
+	"nimona.io/pkg/blobstore"
+)
+
+type Option func(*Store)
+
+// WithBlobStore moves data values larger than the blob threshold out of the
+// database and into the given blob store, keeping only their digests
+func WithBlobStore(blobStore blobstore.Store) Option {
+	return func(st *Store) {
+		st.blobStore = blobStore
+	}
+}
+
+// WithBlobThreshold sets the size in bytes from which data values are moved
+// to the blob store
+func WithBlobThreshold(bytes int) Option {
+	return func(st *Store) {
+		st.blobThreshold = bytes
+	}
+}
+
+// WithQuota sets the number of bytes objects and their blobs can use, when
+// the store grows beyond it, garbage collection removes the least recently
+// accessed objects that are not reachable from a pinned object
+func WithQuota(bytes int64) Option {
+	return func(st *Store) {
+		st.quota = bytes
+	}
+}
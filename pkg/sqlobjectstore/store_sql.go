@@ -1,6 +1,7 @@
 package sqlobjectstore
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -12,8 +13,10 @@ import (
 	_ "modernc.org/sqlite"
 
 	"nimona.io/internal/rand"
+	"nimona.io/pkg/blobstore"
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
 	"nimona.io/pkg/errors"
 	"nimona.io/pkg/migration"
 	"nimona.io/pkg/object"
@@ -48,6 +51,15 @@ var migrations = []string{
 	`CREATE TABLE IF NOT EXISTS Keys (PublicKeyDigest TEXT NOT NULL PRIMARY KEY);`,
 	`ALTER TABLE Keys ADD PrivateKey TEXT;`,
 	`ALTER TABLE Objects ADD Sequence INT;`,
+	`CREATE TABLE IF NOT EXISTS Outbox (Recipient TEXT NOT NULL, Hash TEXT NOT NULL, PRIMARY KEY (Recipient, Hash));`,
+	`ALTER TABLE Outbox ADD Body TEXT;`,
+	`ALTER TABLE Outbox ADD Created INT;`,
+	`ALTER TABLE Objects ADD Pending INT DEFAULT 0;`,
+	`CREATE TABLE IF NOT EXISTS Blobs (Hash TEXT NOT NULL, Path TEXT NOT NULL, Digest TEXT NOT NULL, PRIMARY KEY (Hash, Path));`,
+	`CREATE INDEX Blobs_Digest_idx ON Blobs(Digest);`,
+	`ALTER TABLE Blobs ADD Size INT DEFAULT 0;`,
+	`CREATE TABLE IF NOT EXISTS Refs (Hash TEXT NOT NULL, Ref TEXT NOT NULL, PRIMARY KEY (Hash, Ref));`,
+	`ALTER TABLE Objects ADD RefsIndexed INT DEFAULT 0;`,
 }
 
 var defaultTTL = time.Hour * 24 * 7
@@ -62,38 +74,51 @@ var defaultTTL = time.Hour * 24 * 7
 type (
 	Store struct {
 		db               *sql.DB
-		listeners        map[string]chan Event
+		listeners        map[string]chan objectstore.Event
 		listenersLock    sync.RWMutex
+		hooks            map[string]Hook
+		hooksLock        sync.RWMutex
 		tableLockObjects sync.Mutex
 		tableLockPins    sync.Mutex
 		tableLockKeys    sync.Mutex
+		tableLockOutbox  sync.Mutex
+		blobStore        blobstore.Store
+		blobThreshold    int
+		quota            int64
+		done             chan struct{}
+		closeOnce        sync.Once
 	}
-	EventAction string
-	Event       struct {
-		Action     EventAction
-		ObjectHash tilde.Digest
-	}
-)
-
-const (
-	ObjectInserted EventAction = "objectInserted"
-	ObjectRemoved  EventAction = "objectRemoved"
-	ObjectPinned   EventAction = "objectPinned"
-	ObjectUnpinned EventAction = "objectUnpinned"
 )
 
+// New returns a store backed by the given sqlite database, the database is
+// limited to a single connection.
 func New(
 	db *sql.DB,
+	opts ...Option,
 ) (*Store, error) {
 	ndb := &Store{
 		db:               db,
-		listeners:        map[string]chan Event{},
+		listeners:        map[string]chan objectstore.Event{},
 		listenersLock:    sync.RWMutex{},
+		hooks:            map[string]Hook{},
 		tableLockObjects: sync.Mutex{},
 		tableLockPins:    sync.Mutex{},
 		tableLockKeys:    sync.Mutex{},
+		tableLockOutbox:  sync.Mutex{},
+		blobThreshold:    defaultBlobThreshold,
+		done:             make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ndb)
 	}
 
+	// sqlite only allows a single writer, and pragmas only apply to the
+	// connection they were set on; using a single connection means that the
+	// gc and indexing cannot fail other writes with SQLITE_BUSY, and that
+	// the pragmas below apply to all queries
+	db.SetMaxOpenConns(1)
+
 	// run migrations
 	if err := migration.Up(db, migrations...); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
@@ -115,10 +140,17 @@ func New(
 	}
 
 	// Initialize the garbage collector in the background to run every minute
+	// until the store is closed
 	go func() {
+		ticker := time.NewTicker(gcInterval)
+		defer ticker.Stop()
 		for {
-			ndb.gc() // nolint
-			time.Sleep(1 * time.Minute)
+			select {
+			case <-ndb.done:
+				return
+			case <-ticker.C:
+				ndb.GC(false) // nolint
+			}
 		}
 	}()
 
@@ -126,15 +158,70 @@ func New(
 }
 
 func (st *Store) Close() error {
+	st.closeOnce.Do(func() {
+		close(st.done)
+	})
 	return st.db.Close()
 }
 
+// CreateIndex declares a secondary index on a data path of the objects of the
+// given type, so that filtering them with FilterByData on the same path does
+// not need to go through all of their bodies.
+// Indexes are persisted, and declaring an existing index is a no-op.
+func (st *Store) CreateIndex(objectType, path string) error {
+	expression, _, err := dataExpression(path)
+	if err != nil {
+		return err
+	}
+
+	st.tableLockObjects.Lock()
+	defer st.tableLockObjects.Unlock()
+
+	name := fmt.Sprintf(
+		"Data_%x_idx",
+		sha256.Sum256([]byte(objectType+"\x00"+path)),
+	)
+	// nolint: gosec
+	_, err = st.db.Exec(
+		"CREATE INDEX IF NOT EXISTS " + name + " " +
+			"ON Objects(" + expression + ") " +
+			"WHERE Type = " + quote(objectType),
+	)
+	if err != nil {
+		return fmt.Errorf("could not create index: %w", err)
+	}
+	return nil
+}
+
 func (st *Store) Get(
 	hash tilde.Digest,
 ) (*object.Object, error) {
 	st.tableLockObjects.Lock()
 	defer st.tableLockObjects.Unlock()
 
+	return st.get(hash)
+}
+
+func (st *Store) get(
+	hash tilde.Digest,
+) (*object.Object, error) {
+	obj, err := st.getBody(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := st.restore(hash, obj); err != nil {
+		return nil, fmt.Errorf("could not restore blobs: %w", err)
+	}
+
+	return obj, nil
+}
+
+// getBody returns the object as it was stored, without the values that were
+// moved to the blob store
+func (st *Store) getBody(
+	hash tilde.Digest,
+) (*object.Object, error) {
 	// get the object
 	stmt, err := st.db.Prepare("SELECT Body FROM Objects WHERE Hash=?")
 	if err != nil {
@@ -158,21 +245,34 @@ func (st *Store) Get(
 	return obj, nil
 }
 
+// GetByStream returns the objects of the given stream, ordered by their
+// sequence, without the ones that are still pending co-signatures
 func (st *Store) GetByStream(
 	streamRootHash tilde.Digest,
 ) (object.ReadCloser, error) {
 	return st.Filter(
-		FilterByStreamHash(streamRootHash),
-		FilterOrderBy("sequence"),
-		FilterOrderDir("ASC"),
+		objectstore.FilterByStreamHash(streamRootHash),
+		objectstore.FilterByPending(false),
+		objectstore.FilterOrderBy("sequence"),
+		objectstore.FilterOrderDir("ASC"),
 	)
 }
 
+// GetByType returns the objects of the given type, without the ones that are
+// still pending co-signatures
 func (st *Store) GetByType(
 	objectType string,
 ) (object.ReadCloser, error) {
 	return st.Filter(
-		FilterByObjectType(objectType),
+		objectstore.FilterByObjectType(objectType),
+		objectstore.FilterByPending(false),
+	)
+}
+
+// GetPending returns the objects that do not have enough co-signatures yet
+func (st *Store) GetPending() (object.ReadCloser, error) {
+	return st.Filter(
+		objectstore.FilterByPending(true),
 	)
 }
 
@@ -182,6 +282,9 @@ func (st *Store) Put(
 	return st.PutWithTTL(obj, defaultTTL)
 }
 
+// PutWithTTL stores the object, if the object already exists its
+// signatures are merged with the ones we already have.
+// Objects are marked as pending until they meet their signature threshold.
 func (st *Store) PutWithTTL(
 	obj *object.Object,
 	ttl time.Duration,
@@ -189,6 +292,26 @@ func (st *Store) PutWithTTL(
 	st.tableLockObjects.Lock()
 	defer st.tableLockObjects.Unlock()
 
+	// keep any signatures we already have for the object, and never replace
+	// the object with a redacted copy of it
+	if existing, err := st.get(obj.Hash()); err == nil {
+		obj = object.Copy(obj)
+		if object.IsRedacted(obj) && !object.IsRedacted(existing) {
+			obj.Data = existing.Data
+		}
+		if obj.Metadata.Signature.IsEmpty() {
+			obj.Metadata.Signature = existing.Metadata.Signature
+		}
+		if err := object.MergeSignatures(obj, existing); err != nil {
+			return fmt.Errorf("could not merge signatures: %w", err)
+		}
+	}
+
+	pending := 0
+	if errors.Is(object.VerifyThreshold(obj), object.ErrThresholdNotMet) {
+		pending = 1
+	}
+
 	// TODO(geoah) why replace?
 	stmt, err := st.db.Prepare(`
 	REPLACE INTO Objects (
@@ -201,18 +324,29 @@ func (st *Store) PutWithTTL(
 		Created,
 		LastAccessed,
 		TTL,
-		MetadataDatetime
+		MetadataDatetime,
+		Pending,
+		RefsIndexed
 	) VALUES (
-		?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1
 	) ON CONFLICT (Hash) DO UPDATE SET
-		LastAccessed=?
+		LastAccessed=?,
+		Body=?,
+		Pending=?,
+		RefsIndexed=1
 	`)
 	if err != nil {
 		return fmt.Errorf("could not prepare insert to objects table: %w", err)
 	}
 	defer stmt.Close() // nolint: errcheck
 
-	body, err := json.Marshal(obj)
+	// move large values out of the body before storing it
+	stored, blobs, err := st.offload(obj)
+	if err != nil {
+		return fmt.Errorf("could not offload blobs: %w", err)
+	}
+
+	body, err := json.Marshal(stored)
 	if err != nil {
 		return fmt.Errorf("could not marshal object: %w", err)
 	}
@@ -256,13 +390,24 @@ func (st *Store) PutWithTTL(
 		time.Now().Unix(),
 		int64(ttl.Seconds()),
 		un,
-		// WHERE
+		pending,
+		// ON CONFLICT
 		time.Now().Unix(),
+		body,
+		pending,
 	)
 	if err != nil {
 		return fmt.Errorf("could not insert to objects table: %w", err)
 	}
 
+	if err := st.putBlobs(objHash, blobs); err != nil {
+		return err
+	}
+
+	if err := st.putRefs(objHash, obj); err != nil {
+		return err
+	}
+
 	if len(obj.Metadata.Parents) > 0 {
 		for _, group := range obj.Metadata.Parents {
 			for _, p := range group {
@@ -281,8 +426,12 @@ func (st *Store) PutWithTTL(
 		}
 	}
 
-	st.publishUpdate(Event{
-		Action:     ObjectInserted,
+	if err := st.objectPut(obj); err != nil {
+		return err
+	}
+
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectInserted,
 		ObjectHash: objHash,
 	})
 
@@ -480,45 +629,32 @@ func (st *Store) Remove(
 		return fmt.Errorf("could not delete object: %w", err)
 	}
 
-	st.publishUpdate(Event{
-		Action:     ObjectRemoved,
-		ObjectHash: hash,
-	})
-
-	return nil
-}
-
-func (st *Store) gc() error {
-	st.tableLockObjects.Lock()
-	defer st.tableLockObjects.Unlock()
-
-	stmt, err := st.db.Prepare(`
-	DELETE FROM Objects WHERE
-		Hash NOT IN (
-			SELECT Hash FROM Pins
-		)
-		AND TTL > 0
-		AND datetime(LastAccessed + TTL, 'unixepoch') < datetime ('now');
-	`)
-	if err != nil {
-		return fmt.Errorf("could not prepare query: %w", err)
+	if err := st.removeOrphans(); err != nil {
+		return err
 	}
-	defer stmt.Close() // nolint: errcheck
 
-	if _, err := stmt.Exec(); err != nil {
-		return fmt.Errorf("could not gc delete objects: %w", err)
+	if err := st.objectRemoved(hash); err != nil {
+		return err
 	}
 
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectRemoved,
+		ObjectHash: hash,
+	})
+
 	return nil
 }
 
 func (st *Store) Filter(
-	filterOptions ...FilterOption,
+	filterOptions ...objectstore.FilterOption,
 ) (object.ReadCloser, error) {
 	st.tableLockObjects.Lock()
 	defer st.tableLockObjects.Unlock()
 
-	options := newFilterOptions(filterOptions...)
+	options := objectstore.NewFilterOptions(filterOptions...)
+	if options.Err != nil {
+		return nil, options.Err
+	}
 
 	where := "WHERE 1 "
 	whereArgs := []interface{}{}
@@ -529,10 +665,18 @@ func (st *Store) Filter(
 		whereArgs = append(whereArgs, ahtoai(options.Filters.ObjectHashes)...)
 	}
 
-	if len(options.Filters.ContentTypes) > 0 {
-		qs := strings.Repeat(",?", len(options.Filters.ContentTypes))[1:]
-		where += "AND Type IN (" + qs + ") "
-		whereArgs = append(whereArgs, astoai(options.Filters.ContentTypes)...)
+	// types are inlined so that the partial indexes created by CreateIndex
+	// can be used
+	switch len(options.Filters.ContentTypes) {
+	case 0:
+	case 1:
+		where += "AND Type = " + quote(options.Filters.ContentTypes[0]) + " "
+	default:
+		qs := []string{}
+		for _, t := range options.Filters.ContentTypes {
+			qs = append(qs, quote(t))
+		}
+		where += "AND Type IN (" + strings.Join(qs, ",") + ") "
 	}
 
 	if len(options.Filters.StreamHashes) > 0 {
@@ -547,10 +691,62 @@ func (st *Store) Filter(
 		whereArgs = append(whereArgs, astoai(options.Filters.Owners)...)
 	}
 
+	if options.Filters.Pending != nil {
+		where += "AND Pending=? "
+		pending := 0
+		if *options.Filters.Pending {
+			pending = 1
+		}
+		whereArgs = append(whereArgs, pending)
+	}
+
+	for _, f := range options.Filters.Data {
+		w, args := dataWhere(f)
+		where += "AND " + w
+		whereArgs = append(whereArgs, args...)
+	}
+
+	if options.Filters.After != nil {
+		// cursors of objects we do not have cannot be told apart from the
+		// end of the results otherwise
+		exists := 0
+		if err := st.db.QueryRow(
+			"SELECT COUNT(*) FROM Objects WHERE Hash=?",
+			options.Filters.After.String(),
+		).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("could not query objects: %w", err)
+		}
+		if exists == 0 {
+			return nil, errors.Merge(
+				objectstore.ErrInvalidFilter,
+				fmt.Errorf("page cursor %s not found", options.Filters.After),
+			)
+		}
+		op := ">"
+		if strings.EqualFold(options.Filters.OrderDir, "DESC") {
+			op = "<"
+		}
+		where += fmt.Sprintf(
+			"AND (%s, Hash) %s ("+
+				"(SELECT %s FROM Objects WHERE Hash=?), ?"+
+				") ",
+			options.Filters.OrderBy,
+			op,
+			options.Filters.OrderBy,
+		)
+		whereArgs = append(
+			whereArgs,
+			options.Filters.After.String(),
+			options.Filters.After.String(),
+		)
+	}
+
+	// the hash is used to break ties, so that pages are stable
 	where += fmt.Sprintf(
-		"ORDER BY %s %s ",
+		"ORDER BY %s %s, Hash %s ",
 		options.Filters.OrderBy,
 		options.Filters.OrderDir,
+		options.Filters.OrderDir,
 	)
 
 	if options.Filters.Limit != nil {
@@ -703,8 +899,8 @@ func (st *Store) IsPinned(hash tilde.Digest) (bool, error) {
 	}
 	defer rows.Close() // nolint: errcheck
 
-	st.publishUpdate(Event{
-		Action:     ObjectPinned,
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectPinned,
 		ObjectHash: hash,
 	})
 
@@ -730,8 +926,8 @@ func (st *Store) RemovePin(
 	}
 	defer stmt.Close() // nolint: errcheck
 
-	st.publishUpdate(Event{
-		Action:     ObjectUnpinned,
+	st.publishUpdate(objectstore.Event{
+		Action:     objectstore.ObjectUnpinned,
 		ObjectHash: hash,
 	})
 
@@ -744,11 +940,13 @@ func (st *Store) RemovePin(
 	return nil
 }
 
+// ListenForUpdates returns a channel with the store's updates, updates are
+// dropped if the channel's buffer is full
 func (st *Store) ListenForUpdates() (
-	updates <-chan Event,
+	updates <-chan objectstore.Event,
 	cancel func(),
 ) {
-	c := make(chan Event)
+	c := make(chan objectstore.Event, 100)
 	st.listenersLock.Lock()
 	defer st.listenersLock.Unlock()
 	id := rand.String(8)
@@ -761,7 +959,7 @@ func (st *Store) ListenForUpdates() (
 	return c, f
 }
 
-func (st *Store) publishUpdate(e Event) {
+func (st *Store) publishUpdate(e objectstore.Event) {
 	st.listenersLock.Lock()
 	defer st.listenersLock.Unlock()
 
@@ -845,3 +1043,97 @@ func (st *Store) GetKey(
 
 	return key, nil
 }
+
+func (st *Store) PutOutbox(
+	recipient did.DID,
+	obj *object.Object,
+) error {
+	st.tableLockOutbox.Lock()
+	defer st.tableLockOutbox.Unlock()
+
+	stmt, err := st.db.Prepare(`
+		INSERT OR IGNORE INTO Outbox (Recipient, Hash, Body, Created)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("could not prepare insert to outbox table, %w", err)
+	}
+	defer stmt.Close() // nolint: errcheck
+
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("could not marshal object: %w", err)
+	}
+
+	_, err = stmt.Exec(
+		recipient.String(),
+		obj.Hash().String(),
+		body,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert to outbox table, %w", err)
+	}
+
+	return nil
+}
+
+func (st *Store) GetOutbox() ([]*objectstore.OutboxEntry, error) {
+	st.tableLockOutbox.Lock()
+	defer st.tableLockOutbox.Unlock()
+
+	rows, err := st.db.Query(
+		"SELECT Recipient, Body, Created FROM Outbox ORDER BY Created ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query outbox: %w", err)
+	}
+	defer rows.Close() // nolint: errcheck
+
+	entries := []*objectstore.OutboxEntry{}
+	for rows.Next() {
+		recipient := ""
+		data := []byte{}
+		created := int64(0)
+		if err := rows.Scan(&recipient, &data, &created); err != nil {
+			return nil, fmt.Errorf("could not scan outbox entry: %w", err)
+		}
+		id, err := did.Parse(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse recipient: %w", err)
+		}
+		obj := &object.Object{}
+		if err := json.Unmarshal(data, obj); err != nil {
+			return nil, fmt.Errorf("could not unmarshal data: %w", err)
+		}
+		entries = append(entries, &objectstore.OutboxEntry{
+			Recipient: *id,
+			Object:    obj,
+			Created:   time.Unix(created, 0),
+		})
+	}
+
+	return entries, rows.Err()
+}
+
+func (st *Store) RemoveOutbox(
+	recipient did.DID,
+	hash tilde.Digest,
+) error {
+	st.tableLockOutbox.Lock()
+	defer st.tableLockOutbox.Unlock()
+
+	stmt, err := st.db.Prepare(
+		"DELETE FROM Outbox WHERE Recipient=? AND Hash=?",
+	)
+	if err != nil {
+		return fmt.Errorf("could not prepare query: %w", err)
+	}
+	defer stmt.Close() // nolint: errcheck
+
+	if _, err := stmt.Exec(recipient.String(), hash.String()); err != nil {
+		return fmt.Errorf("could not delete outbox entry: %w", err)
+	}
+
+	return nil
+}
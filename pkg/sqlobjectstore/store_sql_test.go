@@ -1,8 +1,10 @@
 package sqlobjectstore
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"io"
 	"path"
 	"testing"
 	"time"
@@ -13,6 +15,7 @@ import (
 	_ "modernc.org/sqlite"
 
 	"nimona.io/internal/fixtures"
+	"nimona.io/pkg/blobstore"
 	"nimona.io/pkg/crypto"
 	"nimona.io/pkg/errors"
 	"nimona.io/pkg/object"
@@ -35,6 +38,10 @@ func TestNewDatabase(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, store)
 
+	// a single connection is used, so that busy_timeout applies to all
+	// queries and writes cannot fail each other with SQLITE_BUSY
+	require.Equal(t, 1, dblite.Stats().MaxOpenConnections)
+
 	err = store.Close()
 	require.NoError(t, err)
 }
@@ -153,11 +160,11 @@ func TestFilter(t *testing.T) {
 	}
 
 	objectReader, err := store.Filter(
-		FilterByHash(hashes[0]),
-		FilterByHash(hashes[1]),
-		FilterByHash(hashes[2]),
-		FilterByHash(hashes[3]),
-		FilterByHash(hashes[4]),
+		objectstore.FilterByHash(hashes[0]),
+		objectstore.FilterByHash(hashes[1]),
+		objectstore.FilterByHash(hashes[2]),
+		objectstore.FilterByHash(hashes[3]),
+		objectstore.FilterByHash(hashes[4]),
 	)
 	require.NotNil(t, objectReader)
 	require.NoError(t, err)
@@ -165,7 +172,7 @@ func TestFilter(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, len(hashes), len(got))
 	objectReader, err = store.Filter(
-		FilterByOwner(k.PublicKey().DID()),
+		objectstore.FilterByOwner(k.PublicKey().DID()),
 	)
 	require.NoError(t, err)
 	got, err = object.ReadAll(objectReader)
@@ -173,7 +180,7 @@ func TestFilter(t *testing.T) {
 	require.Equal(t, 3, len(got))
 
 	objectReader, err = store.Filter(
-		FilterByObjectType(fixtures.TestSubscribedType),
+		objectstore.FilterByObjectType(fixtures.TestSubscribedType),
 	)
 	require.NoError(t, err)
 	got, err = object.ReadAll(objectReader)
@@ -181,7 +188,7 @@ func TestFilter(t *testing.T) {
 	require.Equal(t, len(hashes), len(got))
 
 	objectReader, err = store.Filter(
-		FilterByStreamHash(ph),
+		objectstore.FilterByStreamHash(ph),
 	)
 	require.NoError(t, err)
 	got, err = object.ReadAll(objectReader)
@@ -190,10 +197,10 @@ func TestFilter(t *testing.T) {
 
 	t.Run("filter with limit 1 offset 0", func(t *testing.T) {
 		objectReader, err = store.Filter(
-			FilterByStreamHash(ph),
-			FilterLimit(1, 0),
-			FilterOrderBy("MetadataDatetime"),
-			FilterOrderDir("ASC"),
+			objectstore.FilterByStreamHash(ph),
+			objectstore.FilterLimit(1, 0),
+			objectstore.FilterOrderBy("MetadataDatetime"),
+			objectstore.FilterOrderDir("ASC"),
 		)
 		require.NoError(t, err)
 		got, err = object.ReadAll(objectReader)
@@ -204,10 +211,10 @@ func TestFilter(t *testing.T) {
 
 	t.Run("filter with limit 1 offset 1", func(t *testing.T) {
 		objectReader, err = store.Filter(
-			FilterByStreamHash(ph),
-			FilterLimit(1, 1),
-			FilterOrderBy("MetadataDatetime"),
-			FilterOrderDir("ASC"),
+			objectstore.FilterByStreamHash(ph),
+			objectstore.FilterLimit(1, 1),
+			objectstore.FilterOrderBy("MetadataDatetime"),
+			objectstore.FilterOrderDir("ASC"),
 		)
 		require.NoError(t, err)
 		got, err = object.ReadAll(objectReader)
@@ -217,9 +224,9 @@ func TestFilter(t *testing.T) {
 	})
 
 	objectReader, err = store.Filter(
-		FilterByHash(hashes[0]),
-		FilterByObjectType(fixtures.TestSubscribedType),
-		FilterByStreamHash(ph),
+		objectstore.FilterByHash(hashes[0]),
+		objectstore.FilterByObjectType(fixtures.TestSubscribedType),
+		objectstore.FilterByStreamHash(ph),
 	)
 	require.NoError(t, err)
 	got, err = object.ReadAll(objectReader)
@@ -429,8 +436,8 @@ func TestStore_GC(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, o, got)
 
-	// gc()
-	err = store.gc()
+	// GC()
+	_, err = store.GC(false)
 	require.NoError(t, err)
 
 	// object should still be there
@@ -441,8 +448,8 @@ func TestStore_GC(t *testing.T) {
 	// wait 5 seconds and check again
 	time.Sleep(time.Second * 5)
 
-	// gc()
-	err = store.gc()
+	// GC()
+	_, err = store.GC(false)
 	require.NoError(t, err)
 
 	// object should not be there any more
@@ -461,8 +468,8 @@ func TestStore_GC(t *testing.T) {
 	// wait 2 seconds and check again
 	time.Sleep(time.Second * 2)
 
-	// gc()
-	err = store.gc()
+	// GC()
+	_, err = store.GC(false)
 	require.NoError(t, err)
 
 	// object should still be there
@@ -471,6 +478,245 @@ func TestStore_GC(t *testing.T) {
 	require.Equal(t, o, got)
 }
 
+func TestStore_GC_Reachable(t *testing.T) {
+	blobStore, err := blobstore.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	store, err := New(
+		tempSqlite3(t),
+		WithBlobStore(blobStore),
+		WithBlobThreshold(16),
+	)
+	require.NoError(t, err)
+
+	chunk := func(s string) *object.Object {
+		return &object.Object{
+			Type: "chunk",
+			Data: tilde.Map{
+				"data": tilde.Data(bytes.Repeat([]byte(s), 100)),
+			},
+		}
+	}
+	c1, c2 := chunk("a"), chunk("b")
+	blob := &object.Object{
+		Type: "blob",
+		Data: tilde.Map{
+			"chunks": tilde.DigestArray{c1.Hash(), c2.Hash()},
+		},
+	}
+	root := &object.Object{
+		Type: "root",
+	}
+	event := &object.Object{
+		Type: "event",
+		Metadata: object.Metadata{
+			Root: root.Hash(),
+			Parents: object.Parents{
+				"*": tilde.DigestArray{root.Hash()},
+			},
+		},
+	}
+	target := &object.Object{
+		Type: "target",
+	}
+	ref := &object.Object{
+		Type: "ref",
+		Data: tilde.Map{
+			"nested": tilde.MapArray{{
+				"target": target.Hash(),
+			}},
+		},
+	}
+	garbage := &object.Object{
+		Type: "garbage",
+	}
+	for _, o := range []*object.Object{
+		c1, c2, blob, root, event, target, ref, garbage,
+	} {
+		require.NoError(t, store.PutWithTTL(o, time.Second))
+	}
+
+	// expire everything
+	_, err = store.db.Exec(
+		"UPDATE Objects SET LastAccessed = LastAccessed - 10",
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Pin(blob.Hash()))
+	require.NoError(t, store.Pin(root.Hash()))
+	require.NoError(t, store.Pin(ref.Hash()))
+
+	t.Run("dry run", func(t *testing.T) {
+		report, err := store.GC(true)
+		require.NoError(t, err)
+		assert.Equal(t, []tilde.Digest{garbage.Hash()}, report.Expired)
+		assert.Empty(t, report.Evicted)
+		assert.Positive(t, report.FreedBytes)
+
+		_, err = store.Get(garbage.Hash())
+		require.NoError(t, err)
+	})
+
+	t.Run("unreachable objects are removed", func(t *testing.T) {
+		report, err := store.GC(false)
+		require.NoError(t, err)
+		assert.Equal(t, []tilde.Digest{garbage.Hash()}, report.Expired)
+
+		_, err = store.Get(garbage.Hash())
+		require.ErrorIs(t, err, objectstore.ErrNotFound)
+		for _, o := range []*object.Object{
+			c1, c2, blob, root, event, target, ref,
+		} {
+			_, err := store.Get(o.Hash())
+			require.NoError(t, err, o.Type)
+		}
+	})
+
+	t.Run("unpinned blobs are removed with their chunks", func(t *testing.T) {
+		require.NoError(t, store.RemovePin(blob.Hash()))
+		report, err := store.GC(false)
+		require.NoError(t, err)
+		assert.ElementsMatch(
+			t,
+			[]tilde.Digest{c1.Hash(), c2.Hash(), blob.Hash()},
+			report.Expired,
+		)
+		// the chunks' data should be freed as well
+		assert.Greater(t, report.FreedBytes, int64(200))
+
+		digest := tilde.Data(bytes.Repeat([]byte("a"), 100)).Hash()
+		_, err = blobStore.Get(digest)
+		require.ErrorIs(t, err, blobstore.ErrNotFound)
+	})
+}
+
+func TestStore_GC_IndexRefs(t *testing.T) {
+	defaultBatchSize := indexRefsBatchSize
+	indexRefsBatchSize = 2
+	t.Cleanup(func() {
+		indexRefsBatchSize = defaultBatchSize
+	})
+
+	store, err := New(tempSqlite3(t))
+	require.NoError(t, err)
+
+	chunks := tilde.DigestArray{}
+	for i := 0; i < 5; i++ {
+		c := &object.Object{
+			Type: "chunk",
+			Data: tilde.Map{
+				"data": tilde.Data(fmt.Sprintf("chunk %d", i)),
+			},
+		}
+		require.NoError(t, store.PutWithTTL(c, time.Second))
+		chunks = append(chunks, c.Hash())
+	}
+	blob := &object.Object{
+		Type: "blob",
+		Data: tilde.Map{
+			"chunks": chunks,
+		},
+	}
+	require.NoError(t, store.PutWithTTL(blob, time.Second))
+	require.NoError(t, store.Pin(blob.Hash()))
+
+	// pretend the objects were stored before their references were kept,
+	// and that they have all expired
+	_, err = store.db.Exec("DELETE FROM Refs")
+	require.NoError(t, err)
+	_, err = store.db.Exec(
+		"UPDATE Objects SET RefsIndexed=0, LastAccessed = LastAccessed - 10",
+	)
+	require.NoError(t, err)
+
+	report, err := store.GC(false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Expired)
+
+	for _, c := range chunks {
+		_, err := store.Get(c)
+		require.NoError(t, err)
+	}
+
+	unindexed := -1
+	require.NoError(t, store.db.QueryRow(
+		"SELECT COUNT(*) FROM Objects WHERE RefsIndexed=0",
+	).Scan(&unindexed))
+	assert.Equal(t, 0, unindexed)
+}
+
+func TestStore_GC_Quota(t *testing.T) {
+	store, err := New(tempSqlite3(t))
+	require.NoError(t, err)
+
+	objects := []*object.Object{}
+	for i := 0; i < 4; i++ {
+		o := &object.Object{
+			Type: "foo",
+			Data: tilde.Map{
+				"n": tilde.Int(i),
+			},
+		}
+		require.NoError(t, store.PutWithTTL(o, time.Hour))
+		// the first objects are the least recently accessed ones
+		_, err := store.db.Exec(
+			"UPDATE Objects SET LastAccessed = ? WHERE Hash = ?",
+			time.Now().Unix()+int64(i),
+			o.Hash().String(),
+		)
+		require.NoError(t, err)
+		objects = append(objects, o)
+	}
+
+	// objects without a ttl are never removed
+	kept := &object.Object{
+		Type: "kept",
+	}
+	require.NoError(t, store.PutWithTTL(kept, 0))
+
+	require.NoError(t, store.Pin(objects[0].Hash()))
+
+	used, err := store.usage()
+	require.NoError(t, err)
+
+	t.Run("nothing is evicted without a quota", func(t *testing.T) {
+		report, err := store.GC(true)
+		require.NoError(t, err)
+		assert.Empty(t, report.Evicted)
+		assert.Equal(t, used, report.UsedBytes)
+	})
+
+	t.Run("least recently accessed objects are evicted", func(t *testing.T) {
+		store.quota = used - 1
+		report, err := store.GC(false)
+		require.NoError(t, err)
+		assert.Empty(t, report.Expired)
+		assert.Equal(t, []tilde.Digest{objects[1].Hash()}, report.Evicted)
+		assert.LessOrEqual(t, report.UsedBytes, store.quota)
+
+		_, err = store.Get(objects[1].Hash())
+		require.ErrorIs(t, err, objectstore.ErrNotFound)
+	})
+
+	t.Run("pinned objects are never evicted", func(t *testing.T) {
+		store.quota = 1
+		report, err := store.GC(false)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			[]tilde.Digest{objects[2].Hash(), objects[3].Hash()},
+			report.Evicted,
+		)
+
+		hashes, err := store.queryHashes("SELECT Hash FROM Objects")
+		require.NoError(t, err)
+		assert.ElementsMatch(
+			t,
+			[]tilde.Digest{objects[0].Hash(), kept.Hash()},
+			hashes,
+		)
+	})
+}
+
 func TestStore_Keys(t *testing.T) {
 	dblite := tempSqlite3(t)
 	store, err := New(dblite)
@@ -513,3 +759,476 @@ func TestStore_Keys(t *testing.T) {
 		require.Equal(t, k2, *g1)
 	})
 }
+
+func TestStore_Outbox(t *testing.T) {
+	dblite := tempSqlite3(t)
+	store, err := New(dblite)
+	require.NoError(t, err)
+	require.NotNil(t, store)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	obj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+		},
+	}
+
+	t.Run("put for k1 and k2", func(t *testing.T) {
+		require.NoError(t, store.PutOutbox(k1.PublicKey().DID(), obj))
+		require.NoError(t, store.PutOutbox(k2.PublicKey().DID(), obj))
+		// putting the same entry again should be a noop
+		require.NoError(t, store.PutOutbox(k2.PublicKey().DID(), obj))
+	})
+
+	t.Run("get entries", func(t *testing.T) {
+		entries, err := store.GetOutbox()
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		recipients := []string{}
+		for _, e := range entries {
+			recipients = append(recipients, e.Recipient.String())
+			assert.Equal(t, obj, e.Object)
+			assert.WithinDuration(t, time.Now(), e.Created, time.Minute)
+		}
+		assert.ElementsMatch(t, []string{
+			k1.PublicKey().DID().String(),
+			k2.PublicKey().DID().String(),
+		}, recipients)
+	})
+
+	t.Run("remove k1's entry", func(t *testing.T) {
+		err := store.RemoveOutbox(k1.PublicKey().DID(), obj.Hash())
+		require.NoError(t, err)
+		entries, err := store.GetOutbox()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, k2.PublicKey().DID(), entries[0].Recipient)
+	})
+}
+
+func TestStore_Pending(t *testing.T) {
+	dblite := tempSqlite3(t)
+	store, err := New(dblite)
+	require.NoError(t, err)
+	require.NotNil(t, store)
+
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	newObject := func() *object.Object {
+		return &object.Object{
+			Type: "foo",
+			Metadata: object.Metadata{
+				Threshold: object.SignatureThreshold{
+					Keys: []crypto.PublicKey{
+						k1.PublicKey(),
+						k2.PublicKey(),
+					},
+					Threshold: 2,
+				},
+			},
+			Data: tilde.Map{
+				"foo": tilde.String("bar"),
+			},
+		}
+	}
+
+	t.Run("put partially signed object", func(t *testing.T) {
+		obj := newObject()
+		require.NoError(t, object.CoSign(k1, obj))
+		require.NoError(t, store.Put(obj))
+
+		r, err := store.GetPending()
+		require.NoError(t, err)
+		os, err := object.ReadAll(r)
+		require.NoError(t, err)
+		require.Len(t, os, 1)
+
+		_, err = store.GetByType("foo")
+		require.True(t, errors.Is(err, objectstore.ErrNotFound))
+	})
+
+	t.Run("put co-signed object", func(t *testing.T) {
+		obj := newObject()
+		require.NoError(t, object.CoSign(k2, obj))
+		require.NoError(t, store.Put(obj))
+
+		// signatures have been merged
+		got, err := store.Get(obj.Hash())
+		require.NoError(t, err)
+		require.Len(t, got.Metadata.Signatures, 2)
+		require.NoError(t, object.VerifyThreshold(got))
+
+		_, err = store.GetPending()
+		require.True(t, errors.Is(err, objectstore.ErrNotFound))
+
+		r, err := store.GetByType("foo")
+		require.NoError(t, err)
+		os, err := object.ReadAll(r)
+		require.NoError(t, err)
+		require.Len(t, os, 1)
+	})
+}
+
+func TestStore_Redacted(t *testing.T) {
+	dblite := tempSqlite3(t)
+	store, err := New(dblite)
+	require.NoError(t, err)
+	require.NotNil(t, store)
+
+	obj := &object.Object{
+		Type: "foo",
+		Data: tilde.Map{
+			"foo": tilde.String("bar"),
+			"baz": tilde.String("qux"),
+		},
+	}
+	red, err := object.Redact(obj, "baz")
+	require.NoError(t, err)
+
+	// redacted copies should not replace the full object
+	require.NoError(t, store.Put(obj))
+	require.NoError(t, store.Put(red))
+	got, err := store.Get(obj.Hash())
+	require.NoError(t, err)
+	require.False(t, object.IsRedacted(got))
+	require.Equal(t, obj.Data, got.Data)
+}
+
+func TestStore_FilterByData(t *testing.T) {
+	store, err := New(tempSqlite3(t))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		err := store.Put(&object.Object{
+			Type: "message",
+			Data: tilde.Map{
+				"n":    tilde.Int(i),
+				"body": tilde.String(fmt.Sprintf("msg-%d", i)),
+				"author": tilde.Map{
+					"name": tilde.String([]string{"foo", "bar"}[i%2]),
+				},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name    string
+		options []objectstore.FilterOption
+		want    []int64
+		wantErr error
+	}{{
+		name: "equal",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataEqual,
+				tilde.Int(2),
+			),
+		},
+		want: []int64{2},
+	}, {
+		name: "range",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataGreaterThan,
+				tilde.Int(0),
+			),
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataLessThanOrEqual,
+				tilde.Int(3),
+			),
+		},
+		want: []int64{1, 2, 3},
+	}, {
+		name: "prefix",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"body:s",
+				objectstore.DataHasPrefix,
+				tilde.String("msg-4"),
+			),
+		},
+		want: []int64{4},
+	}, {
+		name: "nested",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"author:m/name:s",
+				objectstore.DataEqual,
+				tilde.String("bar"),
+			),
+		},
+		want: []int64{1, 3},
+	}, {
+		name: "with type",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByObjectType("message"),
+			objectstore.FilterByData(
+				"author:m/name:s",
+				objectstore.DataNotEqual,
+				tilde.String("bar"),
+			),
+		},
+		want: []int64{0, 2, 4},
+	}, {
+		name: "no matches",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataGreaterThan,
+				tilde.Int(4),
+			),
+		},
+		wantErr: objectstore.ErrNotFound,
+	}, {
+		name: "unhinted path",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData("n", objectstore.DataEqual, tilde.Int(2)),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}, {
+		name: "hint mismatch",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataEqual,
+				tilde.String("2"),
+			),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}, {
+		name: "prefix on ints",
+		options: []objectstore.FilterOption{
+			objectstore.FilterByData(
+				"n:i",
+				objectstore.DataHasPrefix,
+				tilde.Int(2),
+			),
+		},
+		wantErr: objectstore.ErrInvalidFilter,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := store.Filter(
+				append(tt.options, objectstore.FilterOrderBy("Created"))...,
+			)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got, err := object.ReadAll(reader)
+			require.NoError(t, err)
+			ns := []int64{}
+			for _, o := range got {
+				ns = append(ns, int64(o.Data["n"].(tilde.Int)))
+			}
+			assert.ElementsMatch(t, tt.want, ns)
+		})
+	}
+}
+
+func TestStore_CreateIndex(t *testing.T) {
+	store, err := New(tempSqlite3(t))
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateIndex("message", "author:m/name:s"))
+	// declaring an index twice should be fine
+	require.NoError(t, store.CreateIndex("message", "author:m/name:s"))
+
+	err = store.CreateIndex("message", "author/name")
+	require.ErrorIs(t, err, objectstore.ErrInvalidFilter)
+
+	o := &object.Object{
+		Type: "message",
+		Data: tilde.Map{
+			"author": tilde.Map{
+				"name": tilde.String("foo"),
+			},
+		},
+	}
+	require.NoError(t, store.Put(o))
+
+	// the query planner should be using the index
+	f, err := objectstore.NewDataFilter(
+		"author:m/name:s",
+		objectstore.DataEqual,
+		tilde.String("foo"),
+	)
+	require.NoError(t, err)
+	w, args := dataWhere(*f)
+	rows, err := store.db.Query(
+		"EXPLAIN QUERY PLAN SELECT Hash FROM Objects "+
+			"WHERE Type = 'message' AND "+w,
+		args...,
+	)
+	require.NoError(t, err)
+	defer rows.Close() // nolint: errcheck
+	plan := ""
+	for rows.Next() {
+		var id, parent, notUsed int
+		detail := ""
+		require.NoError(t, rows.Scan(&id, &parent, &notUsed, &detail))
+		plan += detail + "\n"
+	}
+	require.NoError(t, rows.Err())
+	assert.Contains(t, plan, "USING INDEX Data_")
+
+	reader, err := store.Filter(
+		objectstore.FilterByObjectType("message"),
+		objectstore.FilterByData(
+			"author:m/name:s",
+			objectstore.DataEqual,
+			tilde.String("foo"),
+		),
+	)
+	require.NoError(t, err)
+	got, err := object.ReadAll(reader)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, o.Hash(), got[0].Hash())
+}
+
+func TestStore_FilterPage(t *testing.T) {
+	store, err := New(tempSqlite3(t))
+	require.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		err := store.Put(&object.Object{
+			Type: "message",
+			Data: tilde.Map{
+				"n": tilde.Int(i),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	for _, dir := range []string{"ASC", "DESC"} {
+		t.Run(dir, func(t *testing.T) {
+			reader, err := store.Filter(objectstore.FilterOrderDir(dir))
+			require.NoError(t, err)
+			all, err := object.ReadAll(reader)
+			require.NoError(t, err)
+			require.Len(t, all, 7)
+
+			got := []*object.Object{}
+			after := tilde.Digest("")
+			for {
+				reader, err := store.Filter(
+					objectstore.FilterOrderDir(dir),
+					objectstore.FilterPage(after, 3),
+				)
+				if errors.Is(err, objectstore.ErrNotFound) {
+					break
+				}
+				require.NoError(t, err)
+				page, err := object.ReadAll(reader)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(page), 3)
+				got = append(got, page...)
+				after = page[len(page)-1].Hash()
+			}
+			require.Equal(t, len(all), len(got))
+			for i := range all {
+				assert.Equal(t, all[i].Hash(), got[i].Hash())
+			}
+		})
+	}
+
+	// cursors of objects we do not have are not treated as empty pages
+	_, err = store.Filter(objectstore.FilterPage("foo", 3))
+	require.ErrorIs(t, err, objectstore.ErrInvalidFilter)
+}
+
+func TestStore_Blobs(t *testing.T) {
+	blobStore, err := blobstore.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	store, err := New(
+		tempSqlite3(t),
+		WithBlobStore(blobStore),
+		WithBlobThreshold(16),
+	)
+	require.NoError(t, err)
+
+	large := bytes.Repeat([]byte("foo"), 1000)
+	obj := &object.Object{
+		Type: "chunk",
+		Data: tilde.Map{
+			"data":  tilde.Data(large),
+			"small": tilde.Data("bar"),
+			"file": tilde.Map{
+				"data": tilde.Data(large),
+			},
+		},
+	}
+	require.NoError(t, store.Put(obj))
+
+	// large values should not be in the database
+	body := ""
+	require.NoError(t, store.db.QueryRow(
+		"SELECT Body FROM Objects WHERE Hash=?",
+		obj.Hash().String(),
+	).Scan(&body))
+	assert.Less(t, len(body), len(large))
+
+	got, err := store.Get(obj.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, obj.Hash(), got.Hash())
+	assert.Equal(t, obj.Data, got.Data)
+
+	readData := func(hash tilde.Digest, path string) []byte {
+		t.Helper()
+		r, err := store.GetData(hash, path)
+		require.NoError(t, err)
+		defer r.Close() // nolint: errcheck
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("get data", func(t *testing.T) {
+		assert.Equal(t, large, readData(obj.Hash(), "data:d"))
+		assert.Equal(t, large, readData(obj.Hash(), "file:m/data:d"))
+		assert.Equal(t, []byte("bar"), readData(obj.Hash(), "small:d"))
+
+		_, err := store.GetData(obj.Hash(), "missing:d")
+		require.ErrorIs(t, err, objectstore.ErrNotFound)
+		_, err = store.GetData(obj.Hash(), "file:m")
+		require.ErrorIs(t, err, objectstore.ErrInvalidFilter)
+		_, err = store.GetData("missing", "data:d")
+		require.ErrorIs(t, err, objectstore.ErrNotFound)
+	})
+
+	t.Run("shared values are removed with the last object", func(t *testing.T) {
+		other := &object.Object{
+			Type: "chunk",
+			Data: tilde.Map{
+				"data": tilde.Data(large),
+			},
+		}
+		require.NoError(t, store.Put(other))
+
+		digest := tilde.Data(large).Hash()
+
+		require.NoError(t, store.Remove(obj.Hash()))
+		assert.Equal(t, large, readData(other.Hash(), "data:d"))
+
+		require.NoError(t, store.Remove(other.Hash()))
+		_, err := blobStore.Get(digest)
+		require.ErrorIs(t, err, blobstore.ErrNotFound)
+	})
+}
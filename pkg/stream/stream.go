@@ -2,6 +2,7 @@ package stream
 
 import (
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
 	"nimona.io/pkg/did"
 	"nimona.io/pkg/errors"
 	"nimona.io/pkg/object"
@@ -10,6 +11,10 @@ import (
 
 const (
 	ErrNotFound = errors.Error("not found")
+	// ErrRejected is returned for objects that cannot be applied to a
+	// stream, either because their signature is invalid or because their
+	// signer is not allowed to write to the stream
+	ErrRejected = errors.Error("object rejected")
 )
 
 type (
@@ -32,6 +37,10 @@ type (
 		GetSubscribers() ([]did.DID, error)
 		ContainsDigest(cid tilde.Digest) bool
 		GetReader(context.Context) (object.ReadCloser, error)
+		InsertEncrypted(interface{}) (tilde.Digest, error)
+		RotateGroupKey(...crypto.PublicKey) error
+		RemoveGroupMember(crypto.PublicKey) error
+		GetGroupMembers() []crypto.PublicKey
 		// Sync(context.Context) error
 		// Subscribe(context.Context) (object.ReadCloser, error)
 	}
@@ -1,41 +1,108 @@
 package stream
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/Code-Hex/go-generics-cache/policy/simple"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"nimona.io/internal/rand"
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
 	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
 	"nimona.io/pkg/network"
 	"nimona.io/pkg/object"
-	"nimona.io/pkg/sqlobjectstore"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
 )
 
 var ErrInvalidRoot = fmt.Errorf("root object doesn't match stream's hash")
 
+// ErrNoGroupKey is returned when trying to encrypt objects for a stream that
+// has no group key we can use
+var ErrNoGroupKey = errors.Error("stream has no group key")
+
+var objRejectedCounter = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "nimona_stream_object_rejected_total",
+		Help: "Total number of objects rejected by streams",
+	},
+)
+
 type (
 	controller struct {
 		lock sync.RWMutex
 		// services
 		network     network.Network
-		objectStore *sqlobjectstore.Store
+		objectStore objectstore.Store
 		// dag graph
 		graph *Graph[tilde.Digest, object.Metadata]
 		// state, not thread safe
 		streamInfo *Info
 		// subscriptions
 		subscriptions *simple.Cache[did.DID, bool]
+		// keyResolver is used to find the key streams signers have been
+		// delegated by
+		keyResolver object.KeyResolver
+		// peerKey is used to sign the objects we encrypt, and to decrypt the
+		// group keys that have been shared with us
+		peerKey crypto.PrivateKey
+		// groupKeys are the stream's group keys we know of, by key id, and
+		// groupKey is the latest of them
+		groupKeys map[string]*GroupKey
+		groupKey  *GroupKey
+		// validator is used to validate objects against their schema
+		// contexts before they are applied
+		validator *schema.Validator
+	}
+	// ControllerOption for customizing NewController
+	ControllerOption func(*controller)
+	// validation is the result of checking an object against its schema
+	// context, it is worked out before taking the lock as the context might
+	// have to be fetched
+	validation struct {
+		// err is set if the context could not be fetched
+		err error
+		// violation is set if the object does not conform to its context
+		violation error
 	}
 )
 
+// ControllerWithKeyResolver allows the stream's write policies to be
+// evaluated against the key streams of the objects' signers
+func ControllerWithKeyResolver(r object.KeyResolver) ControllerOption {
+	return func(c *controller) {
+		c.keyResolver = r
+	}
+}
+
+// ControllerWithPeerKey allows the controller to encrypt objects for the
+// stream, and decrypt the ones that have been encrypted for us
+func ControllerWithPeerKey(k crypto.PrivateKey) ControllerOption {
+	return func(c *controller) {
+		c.peerKey = k
+	}
+}
+
+// ControllerWithValidator rejects objects that do not conform to their
+// schema contexts
+func ControllerWithValidator(v *schema.Validator) ControllerOption {
+	return func(c *controller) {
+		c.validator = v
+	}
+}
+
 func NewController(
 	cid tilde.Digest,
 	network network.Network,
-	objectStore *sqlobjectstore.Store,
+	objectStore objectstore.Store,
+	opts ...ControllerOption,
 ) Controller {
 	c := &controller{
 		graph:         NewGraph[tilde.Digest, object.Metadata](),
@@ -43,6 +110,10 @@ func NewController(
 		objectStore:   objectStore,
 		streamInfo:    NewInfo(),
 		subscriptions: simple.NewCache[did.DID, bool](),
+		groupKeys:     map[string]*GroupKey{},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 	c.streamInfo.RootDigest = cid
 	return c
@@ -62,7 +133,7 @@ func (s *controller) Insert(v interface{}) (tilde.Digest, error) {
 		o = vv
 	default:
 		var err error
-		o, err = object.Marshal(o)
+		o, err = object.Marshal(v)
 		if err != nil {
 			return tilde.EmptyDigest, fmt.Errorf("failed to marshal object: %w", err)
 		}
@@ -73,20 +144,103 @@ func (s *controller) Insert(v interface{}) (tilde.Digest, error) {
 		return tilde.EmptyDigest, fmt.Errorf("object type is required")
 	}
 
+	val := s.validate(o)
+
+	s.lock.Lock()
+	h, err := s.insert(o, val)
+	s.lock.Unlock()
+	if err != nil {
+		return tilde.EmptyDigest, err
+	}
+
+	// the root object is not announced
+	if h.Equal(s.streamInfo.RootDigest) {
+		return h, nil
+	}
+
+	// TODO: figure out how to move announcements to first-time applies
+	if err := s.announce(h); err != nil {
+		return tilde.EmptyDigest, err
+	}
+
+	return h, nil
+}
+
+// insert prepares and applies the object, the lock must be held so that
+// concurrent inserts do not end up with the same parents and sequence
+func (s *controller) insert(
+	o *object.Object,
+	v validation,
+) (tilde.Digest, error) {
 	// if the object has no root, set it to the stream root
 	if o.Metadata.Root.IsEmpty() && s.streamInfo.RootObject == nil {
-		err := s.Apply(o)
+		err := s.apply(o, v)
 		if err != nil {
 			return tilde.EmptyDigest, fmt.Errorf("failed to apply object: %w", err)
 		}
 		return o.Hash(), nil
 	}
 
+	if err := s.prepare(o); err != nil {
+		return tilde.EmptyDigest, err
+	}
+
+	// get the object's hash
+	h := o.Hash()
+
+	// apply the event
+	err := s.apply(o, v)
+	if err != nil {
+		return tilde.EmptyDigest, fmt.Errorf("failed to apply object: %w", err)
+	}
+
+	return h, nil
+}
+
+// announce the event to subscribers
+func (s *controller) announce(h tilde.Digest) error {
+	subscribers := s.subscriptions.Keys()
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	announcement := &Announcement{
+		Metadata: object.Metadata{
+			Owner: s.network.GetPeerKey().PublicKey().DID(),
+		},
+		StreamHash:   s.streamInfo.RootDigest,
+		ObjectHashes: []tilde.Digest{h},
+	}
+
+	announcementObject, err := object.Marshal(announcement)
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %w", err)
+	}
+
+	for _, sub := range subscribers {
+		err := s.network.Send(
+			context.New(
+				context.WithTimeout(time.Second*2),
+			),
+			announcementObject,
+			sub,
+		)
+		if err != nil {
+			// TODO: log error
+			continue
+		}
+	}
+
+	return nil
+}
+
+// prepare sets the object's root, parents, and sequence if they are not set
+func (s *controller) prepare(o *object.Object) error {
 	// verify or set the object's root
 	if o.Metadata.Root.IsEmpty() {
 		o.Metadata.Root = s.streamInfo.RootDigest
 	} else if !o.Metadata.Root.Equal(s.streamInfo.RootDigest) {
-		return tilde.EmptyDigest, fmt.Errorf("roots don't match")
+		return fmt.Errorf("roots don't match")
 	}
 
 	// verify or set the object's parents
@@ -114,60 +268,164 @@ func (s *controller) Insert(v interface{}) (tilde.Digest, error) {
 		o.Metadata.Sequence = uint64(len(pns))
 	}
 
-	// get the object's hash
-	h := o.Hash()
+	return nil
+}
 
-	// apply the event
-	err := s.Apply(o)
+// InsertEncrypted encrypts an event with the stream's latest group key, and
+// inserts it to the stream.
+// The event's metadata is set as with Insert, and the encrypted object is
+// signed with our peer key.
+// Returns the encrypted object's hash.
+func (s *controller) InsertEncrypted(v interface{}) (tilde.Digest, error) {
+	s.lock.RLock()
+	gk := s.groupKey
+	s.lock.RUnlock()
+	if gk == nil {
+		return tilde.EmptyDigest, ErrNoGroupKey
+	}
+	return s.insertEncrypted(v, object.EncryptWithGroupKey(gk.KeyID, gk.Key))
+}
+
+func (s *controller) insertEncrypted(
+	v interface{},
+	opts ...object.EncryptOption,
+) (tilde.Digest, error) {
+	o, err := object.Marshal(v)
 	if err != nil {
-		return tilde.EmptyDigest, fmt.Errorf("failed to apply object: %w", err)
+		return tilde.EmptyDigest, fmt.Errorf("failed to marshal object: %w", err)
 	}
 
-	// TODO: figure out how to move announcements to first-time applies
+	s.lock.Lock()
+	h, err := s.insertEncryptedLocked(o, opts...)
+	s.lock.Unlock()
+	if err != nil {
+		return tilde.EmptyDigest, err
+	}
 
-	// announce the event to subscribers
-	subscribers := s.subscriptions.Keys()
-	if len(subscribers) == 0 {
-		return h, nil
+	if err := s.announce(h); err != nil {
+		return tilde.EmptyDigest, err
 	}
 
-	announcement := &Announcement{
-		Metadata: object.Metadata{
-			Owner: s.network.GetPeerKey().PublicKey().DID(),
-		},
-		StreamHash:   s.streamInfo.RootDigest,
-		ObjectHashes: []tilde.Digest{h},
+	return h, nil
+}
+
+// insertEncryptedLocked must be called with the lock held, so that the
+// object's parents and sequence do not change until it has been applied
+func (s *controller) insertEncryptedLocked(
+	o *object.Object,
+	opts ...object.EncryptOption,
+) (tilde.Digest, error) {
+	if s.streamInfo.RootObject == nil {
+		return tilde.EmptyDigest, fmt.Errorf("stream root has not been applied")
 	}
 
-	announcementObject, err := object.Marshal(announcement)
+	if err := s.prepare(o); err != nil {
+		return tilde.EmptyDigest, err
+	}
+
+	// both the object and its envelope are signed, so that recipients can
+	// check who created the object and not just who inserted it
+	if !s.peerKey.IsEmpty() {
+		if err := object.Sign(s.peerKey, o); err != nil {
+			return tilde.EmptyDigest,
+				fmt.Errorf("failed to sign object: %w", err)
+		}
+	}
+
+	e, err := object.Encrypt(o, opts...)
 	if err != nil {
-		return tilde.EmptyDigest,
-			fmt.Errorf("failed to marshal announcement: %w", err)
+		return tilde.EmptyDigest, fmt.Errorf("failed to encrypt object: %w", err)
 	}
 
-	for _, sub := range subscribers {
-		err := s.network.Send(
-			context.New(
-				context.WithTimeout(time.Second*2),
-			),
-			announcementObject,
-			sub,
+	if !s.peerKey.IsEmpty() {
+		if err := object.Sign(s.peerKey, e); err != nil {
+			return tilde.EmptyDigest,
+				fmt.Errorf("failed to sign object: %w", err)
+		}
+	}
+
+	// encrypted objects have no context, so validating them does not need
+	// to fetch anything
+	return s.insert(e, s.validate(e))
+}
+
+// RotateGroupKey creates a new group key for the stream, and shares it with
+// the given members.
+// Objects inserted with InsertEncrypted from now on will only be readable
+// by them; we can always read them as long as we have a peer key.
+func (s *controller) RotateGroupKey(members ...crypto.PublicKey) error {
+	signer := crypto.PublicKey{}
+	if !s.peerKey.IsEmpty() {
+		signer = s.peerKey.PublicKey()
+	}
+	s.lock.RLock()
+	ok := s.streamInfo.RootObject != nil && s.canAdmin(signer)
+	s.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("cannot rotate group key: %w", object.ErrForbidden)
+	}
+
+	key, err := crypto.NewSymmetricKey()
+	if err != nil {
+		return fmt.Errorf("failed to create group key: %w", err)
+	}
+
+	gk := &GroupKey{
+		KeyID:   rand.String(16),
+		Key:     key,
+		Members: members,
+	}
+
+	recipients := members
+	if !s.peerKey.IsEmpty() {
+		recipients = append(
+			[]crypto.PublicKey{s.peerKey.PublicKey()},
+			members...,
 		)
-		if err != nil {
-			// TODO: log error
+	}
+
+	_, err = s.insertEncrypted(gk, object.EncryptFor(recipients...))
+	if err != nil {
+		return err
+	}
+
+	// the group key will have been picked up when applying it, unless we
+	// have no peer key to decrypt it with
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.groupKeys[gk.KeyID]; !ok {
+		s.groupKeys[gk.KeyID] = gk
+		s.groupKey = gk
+	}
+	return nil
+}
+
+// RemoveGroupMember rotates the stream's group key, so that the given member
+// can no longer read new objects
+func (s *controller) RemoveGroupMember(member crypto.PublicKey) error {
+	members := []crypto.PublicKey{}
+	for _, m := range s.GetGroupMembers() {
+		if m.Equals(member) {
 			continue
 		}
+		members = append(members, m)
 	}
+	return s.RotateGroupKey(members...)
+}
 
-	return h, nil
+// GetGroupMembers returns the members of the stream's latest group key
+func (s *controller) GetGroupMembers() []crypto.PublicKey {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.groupKey == nil {
+		return nil
+	}
+	return s.groupKey.Members
 }
 
 // Apply an event to the stream.
 // Can either accept an Object, or anything that can be marshaled into one.
 func (s *controller) Apply(v interface{}) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
 	var o *object.Object
 	switch vv := v.(type) {
 	case *object.Object:
@@ -180,17 +438,40 @@ func (s *controller) Apply(v interface{}) error {
 		}
 	}
 
+	val := s.validate(o)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.apply(o, val)
+}
+
+// apply must be called with the lock held, v is the result of validating
+// the object
+func (s *controller) apply(o *object.Object, v validation) error {
 	// verify that the object has the basic metadata
 	if o.Type == "" {
 		return fmt.Errorf("object type is required")
 	}
 
-	// verify that the object has not been applied already
+	// verify that the object has not been applied or rejected already;
+	// signatures are not part of the object's hash, so rejected objects are
+	// only checked again if they carry signatures we have not seen yet
 	digest := o.Hash()
 	_, ok := s.streamInfo.Objects[digest]
 	if ok {
 		return nil
 	}
+	if r, ok := s.streamInfo.Rejected[digest]; ok &&
+		!hasNewSignatures(r.Metadata, o.Metadata) {
+		return errors.Merge(ErrRejected, errors.Error(r.Reason))
+	}
+
+	// redacted copies share the hash and signatures of the full object, but
+	// cannot be validated or used in its place; they are not recorded as
+	// rejected so that the full object can still be applied
+	if object.IsRedacted(o) {
+		return errors.Merge(ErrRejected, object.ErrRedacted)
+	}
 
 	// check if we're applying the root object
 	if o.Metadata.Root.IsEmpty() && s.streamInfo.RootObject == nil {
@@ -199,6 +480,17 @@ func (s *controller) Apply(v interface{}) error {
 		if !h.Equal(s.streamInfo.RootDigest) {
 			return ErrInvalidRoot
 		}
+		// verify the object's signatures and schema
+		if err := s.verify(o); err != nil {
+			return err
+		}
+		if err := s.checkValidation(o, v); err != nil {
+			return err
+		}
+		// and that its policies can be met
+		if err := o.Metadata.Policies.Validate(); err != nil {
+			return errors.Merge(ErrRejected, err)
+		}
 		// store the object
 		err := s.objectStore.Put(o)
 		if err != nil {
@@ -212,6 +504,7 @@ func (s *controller) Apply(v interface{}) error {
 		// add the object to the metadata list
 		oi := GetObjectInfo(o)
 		s.streamInfo.Objects[digest] = oi
+		delete(s.streamInfo.Rejected, digest)
 		// return
 		return nil
 	}
@@ -231,8 +524,53 @@ func (s *controller) Apply(v interface{}) error {
 		return fmt.Errorf("object has no sequence")
 	}
 
+	// the root's policies can only be evaluated once we have it
+	if s.streamInfo.RootObject == nil {
+		return fmt.Errorf("stream root has not been applied")
+	}
+
+	// verify the object's signature, and that its signer is allowed to
+	// write to the stream; the owner is not taken into account, as objects
+	// in key streams are signed by the stream's keys on behalf of their owner
+	if err := s.verify(o); err != nil {
+		return err
+	}
+	if err := s.checkValidation(o, v); err != nil {
+		return err
+	}
+	q, err := object.NewPolicyQuery(
+		s.keyResolver,
+		o.Metadata.Signature.Key,
+		o.Type,
+		object.WriteAction,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve signer's keys: %w", err)
+	}
+	// objects cannot grant themselves access, so only the root's policies
+	// are taken into account
+	ps := s.rootPolicies(object.WriteAction)
+	d := ps.Query(q)
+	if d.Result != object.Allow {
+		return s.reject(o, d.Explain())
+	}
+	// for the same reason, the number of co-signatures shared resources need
+	// is set by the policy that allowed the object and not the object itself
+	if i := d.Applied(); i >= 0 && ps[i].Threshold > 0 {
+		err := object.VerifyThresholdFor(o, object.SignatureThreshold{
+			Keys:      ps[i].Subjects,
+			Threshold: ps[i].Threshold,
+		})
+		if errors.Is(err, object.ErrThresholdNotMet) {
+			return err
+		}
+		if err != nil {
+			return s.reject(o, err.Error())
+		}
+	}
+
 	// store the object
-	err := s.objectStore.Put(o)
+	err = s.objectStore.Put(o)
 	if err != nil {
 		return fmt.Errorf("failed to store object: %w", err)
 	}
@@ -243,6 +581,7 @@ func (s *controller) Apply(v interface{}) error {
 	// add the object to the metadata list
 	oi := GetObjectInfo(o)
 	s.streamInfo.Objects[oi.Digest] = oi
+	delete(s.streamInfo.Rejected, oi.Digest)
 
 	// handle special objects
 	switch o.Type {
@@ -258,11 +597,208 @@ func (s *controller) Apply(v interface{}) error {
 			break
 		}
 		s.subscriptions.Set(sub.Metadata.Owner, true)
+	case object.EncryptedType:
+		// group keys are shared with their members directly
+		if s.peerKey.IsEmpty() {
+			break
+		}
+		do, err := object.Decrypt(o, object.DecryptWithPrivateKey(s.peerKey))
+		// in case of error, we're most likely not one of the recipients
+		if err != nil || do.Type != GroupKeyType {
+			break
+		}
+		// only keys belonging to the stream and signed by one of its admins
+		// can be adopted, else any writer could share their own key
+		if !do.Metadata.Root.Equal(s.streamInfo.RootDigest) {
+			break
+		}
+		if object.VerifySignature(do) != nil {
+			break
+		}
+		if !s.canAdmin(do.Metadata.Signature.Key) {
+			break
+		}
+		gk := &GroupKey{}
+		if err := object.Unmarshal(do, gk); err != nil {
+			break
+		}
+		s.groupKeys[gk.KeyID] = gk
+		if s.groupKey == nil ||
+			gk.Metadata.Sequence >= s.groupKey.Metadata.Sequence {
+			s.groupKey = gk
+		}
+	}
+
+	return nil
+}
+
+// rootPolicies returns the root's policies, roots that have an owner but
+// no policies for the given action only allow their owner, the keys it has
+// delegated to, and the key that signed the root on its behalf to perform
+// it; must be called with the lock held
+func (s *controller) rootPolicies(action object.PolicyAction) object.Policies {
+	root := s.streamInfo.RootObject
+	ps := root.Metadata.Policies
+	if root.Metadata.Owner.IsEmpty() {
+		return ps
+	}
+	for _, p := range ps {
+		if p.Type == object.GroupPolicy {
+			continue
+		}
+		if len(p.Actions) == 0 {
+			return ps
+		}
+		for _, a := range p.Actions {
+			if a == action {
+				return ps
+			}
+		}
+	}
+	ps = append(
+		append(object.Policies{}, ps...),
+		object.Policy{
+			Name:    "default",
+			Actions: []object.PolicyAction{action},
+			Effect:  object.DenyEffect,
+		},
+		object.Policy{
+			Name:       "owner",
+			Identities: []did.DID{root.Metadata.Owner},
+			Actions:    []object.PolicyAction{action},
+			Effect:     object.AllowEffect,
+		},
+	)
+	// key streams are owned by the peer that created them, but their events
+	// are signed by the keys their inception requires as co-signers
+	ks := append([]crypto.PublicKey{}, root.Metadata.Threshold.Keys...)
+	if k := root.Metadata.Signature.Key; !k.IsEmpty() {
+		ks = append(ks, k)
+	}
+	if len(ks) > 0 {
+		ps = append(ps, object.Policy{
+			Name:     "signer",
+			Subjects: ks,
+			Actions:  []object.PolicyAction{action},
+			Effect:   object.AllowEffect,
+		})
+	}
+	return ps
+}
+
+// canAdmin checks whether the root's policies allow the given key to manage
+// the stream's group keys; must be called with the lock held
+func (s *controller) canAdmin(k crypto.PublicKey) bool {
+	q, err := object.NewPolicyQuery(
+		s.keyResolver,
+		k,
+		GroupKeyType,
+		object.AdminAction,
+	)
+	if err != nil {
+		return false
+	}
+	d := s.rootPolicies(object.AdminAction).Query(q)
+	return d.Result == object.Allow
+}
+
+// decryptOptions returns the keys we can decrypt the stream's objects with
+func (s *controller) decryptOptions() []object.DecryptOption {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	opts := []object.DecryptOption{}
+	if !s.peerKey.IsEmpty() {
+		opts = append(opts, object.DecryptWithPrivateKey(s.peerKey))
+	}
+	for id, gk := range s.groupKeys {
+		opts = append(opts, object.DecryptWithGroupKey(id, gk.Key))
+	}
+	return opts
+}
+
+// verify checks the object's signatures, objects that are only missing
+// co-signatures are not rejected as they can be applied once they have them
+func (s *controller) verify(o *object.Object) error {
+	if err := object.VerifySignature(o); err != nil {
+		return s.reject(o, err.Error())
+	}
+	if err := object.VerifyThreshold(o); err != nil {
+		if errors.Is(err, object.ErrThresholdNotMet) {
+			return err
+		}
+		return s.reject(o, err.Error())
+	}
+	return nil
+}
+
+// validate checks the object against its schema context, if the controller
+// has a validator; it must be called without holding the lock, as fetching
+// the context can take a while
+func (s *controller) validate(o *object.Object) validation {
+	if s.validator == nil {
+		return validation{}
+	}
+	ctx := context.New(
+		context.WithTimeout(time.Second * 5),
+	)
+	defer ctx.Cancel()
+	r, err := s.validator.Validate(ctx, o)
+	if err != nil {
+		return validation{
+			err: err,
+		}
+	}
+	return validation{
+		violation: r.Err(),
 	}
+}
 
+// checkValidation rejects objects that do not conform to their context;
+// objects whose context could not be fetched are not rejected, as they
+// might be valid once it is available. Must be called with the lock held.
+func (s *controller) checkValidation(o *object.Object, v validation) error {
+	if v.err != nil {
+		return fmt.Errorf("failed to validate object: %w", v.err)
+	}
+	if v.violation != nil {
+		return s.reject(o, v.violation.Error())
+	}
 	return nil
 }
 
+// hasNewSignatures returns whether the second metadata have any signatures
+// or co-signatures that the first do not
+func hasNewSignatures(seen, m object.Metadata) bool {
+	sigs := append([]object.Signature{seen.Signature}, seen.Signatures...)
+	for _, n := range append([]object.Signature{m.Signature}, m.Signatures...) {
+		if n.IsEmpty() {
+			continue
+		}
+		found := false
+		for _, s := range sigs {
+			if s.Key.Equals(n.Key) && bytes.Equal(s.X, n.X) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// reject records the object as rejected instead of applying it, must be
+// called with the lock held
+func (s *controller) reject(o *object.Object, reason string) error {
+	s.streamInfo.Rejected[o.Hash()] = &RejectedObjectInfo{
+		ObjectInfo: *GetObjectInfo(o),
+		Reason:     reason,
+	}
+	objRejectedCounter.Inc()
+	return errors.Merge(ErrRejected, errors.Error(reason))
+}
+
 func (s *controller) GetStreamInfo() Info {
 	// TODO lock and copy
 	return *s.streamInfo
@@ -281,6 +817,9 @@ func (s *controller) GetDigests() ([]tilde.Digest, error) {
 	return s.graph.TopologicalSort()
 }
 
+// GetReader returns the stream's objects in topological order.
+// Encrypted objects are decrypted as long as we have the keys for them, else
+// they are returned as they are.
 func (s *controller) GetReader(ctx context.Context) (object.ReadCloser, error) {
 	os := make(chan *object.Object)
 	er := make(chan error)
@@ -290,6 +829,7 @@ func (s *controller) GetReader(ctx context.Context) (object.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	opts := s.decryptOptions()
 	go func() {
 		defer close(os)
 		defer close(er)
@@ -300,6 +840,12 @@ func (s *controller) GetReader(ctx context.Context) (object.ReadCloser, error) {
 				er <- err
 				return
 			}
+			// decrypt the objects we can, and leave the rest as they are
+			if object.IsEncrypted(o) {
+				if do, err := object.Decrypt(o, opts...); err == nil {
+					o = do
+				}
+			}
 			select {
 			case os <- o:
 			case <-ctx.Done():
@@ -4,15 +4,23 @@ import (
 	"database/sql"
 	"fmt"
 	"path"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
 
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/memobjectstore"
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
 )
 
 // Graph of the test stream:
@@ -35,6 +43,19 @@ func Test_Controller(t *testing.T) {
 	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
 	require.NoError(t, err)
 
+	memStore := memobjectstore.New()
+	defer memStore.Close() // nolint: errcheck
+
+	t.Run("sqlobjectstore", func(t *testing.T) {
+		testController(t, sqlStore)
+	})
+
+	t.Run("memobjectstore", func(t *testing.T) {
+		testController(t, memStore)
+	})
+}
+
+func testController(t *testing.T, str objectstore.Store) {
 	nA := &object.Object{
 		Type: "test/root",
 		Data: tilde.Map{
@@ -44,7 +65,7 @@ func Test_Controller(t *testing.T) {
 
 	hA := nA.Hash()
 
-	c := NewController(hA, nil, sqlStore)
+	c := NewController(hA, nil, str)
 	require.NotNil(t, c)
 
 	nAh, err := c.Insert(nA)
@@ -159,10 +180,10 @@ func Test_Controller(t *testing.T) {
 	fmt.Println("-------------")
 
 	t.Run("apply all events", func(t *testing.T) {
-		r, err := sqlStore.GetByStream(nAh)
+		r, err := str.GetByStream(nAh)
 		require.NoError(t, err)
 
-		c := NewController(nAh, nil, sqlStore)
+		c := NewController(nAh, nil, str)
 		require.NotNil(t, c)
 
 		i := 0
@@ -194,7 +215,7 @@ func Test_Controller(t *testing.T) {
 	fmt.Println("-------------")
 
 	t.Run("controller from manager", func(t *testing.T) {
-		m, err := NewManager(context.New(), nil, nil, sqlStore)
+		m, err := NewManager(context.New(), nil, nil, str)
 		require.NoError(t, err)
 
 		c, err := m.GetController(nAh)
@@ -226,3 +247,806 @@ func print(o *object.Object) {
 	}
 	fmt.Println(string(y))
 }
+
+type testKeyResolver map[string][]did.DID
+
+func (r testKeyResolver) ResolveKeys(
+	k crypto.PublicKey,
+) ([]crypto.PublicKey, error) {
+	return nil, nil
+}
+
+func (r testKeyResolver) ResolveIdentities(
+	k crypto.PublicKey,
+) ([]did.DID, error) {
+	return r[k.String()], nil
+}
+
+func Test_Controller_WritePolicies(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	id2 := did.MustParse("did:nimona:keystream:id2")
+
+	nA := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Policies: object.Policies{{
+				Effect:  object.DenyEffect,
+				Actions: []object.PolicyAction{object.WriteAction},
+			}, {
+				Subjects:   []crypto.PublicKey{k0.PublicKey()},
+				Identities: []did.DID{*id2},
+				Actions:    []object.PolicyAction{object.WriteAction},
+				Effect:     object.AllowEffect,
+			}},
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	hA := nA.Hash()
+
+	c := NewController(
+		hA,
+		nil,
+		sqlStore,
+		ControllerWithKeyResolver(testKeyResolver{
+			k2.PublicKey().String(): []did.DID{*id2},
+		}),
+	)
+	require.NoError(t, c.Apply(nA))
+
+	newEvent := func(name string) *object.Object {
+		return &object.Object{
+			Type: "test/event",
+			Metadata: object.Metadata{
+				Root: hA,
+				Parents: object.Parents{
+					"*": []tilde.Digest{hA},
+				},
+				Sequence: 1,
+			},
+			Data: tilde.Map{
+				"name": tilde.String(name),
+			},
+		}
+	}
+
+	// allowed signer
+	nB := newEvent("nB")
+	require.NoError(t, object.Sign(k0, nB))
+	require.NoError(t, c.Apply(nB))
+
+	// signer allowed through its key stream
+	nC := newEvent("nC")
+	require.NoError(t, object.Sign(k2, nC))
+	require.NoError(t, c.Apply(nC))
+
+	// signer not allowed to write
+	nD := newEvent("nD")
+	require.NoError(t, object.Sign(k1, nD))
+	err = c.Apply(nD)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// unsigned
+	nE := newEvent("nE")
+	err = c.Apply(nE)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// invalid signature
+	nF := newEvent("nF")
+	require.NoError(t, object.Sign(k0, nF))
+	nF.Data["name"] = tilde.String("nF2")
+	err = c.Apply(nF)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// rejected objects are recorded, and rejected again
+	info := c.GetStreamInfo()
+	require.Len(t, info.Objects, 3)
+	require.Len(t, info.Rejected, 3)
+	require.Contains(t, info.Rejected, nD.Hash())
+	require.Contains(t, info.Rejected, nE.Hash())
+	require.Contains(t, info.Rejected, nF.Hash())
+	err = c.Apply(nD)
+	require.True(t, errors.Is(err, ErrRejected))
+}
+
+func Test_Controller_DefaultWritePolicies(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	// the root has an owner but no policies
+	nA := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Owner: k0.PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	require.NoError(t, object.Sign(k0, nA))
+	hA := nA.Hash()
+
+	c := NewController(
+		hA,
+		nil,
+		sqlStore,
+		ControllerWithKeyResolver(testKeyResolver{
+			k2.PublicKey().String(): []did.DID{k0.PublicKey().DID()},
+		}),
+	)
+	require.NoError(t, c.Apply(nA))
+
+	newEvent := func(name string) *object.Object {
+		return &object.Object{
+			Type: "test/event",
+			Metadata: object.Metadata{
+				Root: hA,
+				Parents: object.Parents{
+					"*": []tilde.Digest{hA},
+				},
+				Sequence: 1,
+			},
+			Data: tilde.Map{
+				"name": tilde.String(name),
+			},
+		}
+	}
+
+	// the owner can write
+	nB := newEvent("nB")
+	require.NoError(t, object.Sign(k0, nB))
+	require.NoError(t, c.Apply(nB))
+
+	// and so can the keys it has delegated to
+	nC := newEvent("nC")
+	require.NoError(t, object.Sign(k2, nC))
+	require.NoError(t, c.Apply(nC))
+
+	// but no one else
+	nD := newEvent("nD")
+	require.NoError(t, object.Sign(k1, nD))
+	err = c.Apply(nD)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	nE := newEvent("nE")
+	err = c.Apply(nE)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// roots signed on behalf of their owner, ie key stream inceptions, can
+	// also be written to by their signer
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	nF := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Owner: k1.PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nF"),
+		},
+	}
+	require.NoError(t, object.Sign(k3, nF))
+	hF := nF.Hash()
+
+	cF := NewController(hF, nil, sqlStore)
+	require.NoError(t, cF.Apply(nF))
+
+	newEventF := func(name string) *object.Object {
+		o := newEvent(name)
+		o.Metadata.Root = hF
+		o.Metadata.Parents = object.Parents{
+			"*": []tilde.Digest{hF},
+		}
+		return o
+	}
+
+	nG := newEventF("nG")
+	require.NoError(t, object.Sign(k3, nG))
+	require.NoError(t, cF.Apply(nG))
+
+	nH := newEventF("nH")
+	require.NoError(t, object.Sign(k0, nH))
+	err = cF.Apply(nH)
+	require.True(t, errors.Is(err, ErrRejected))
+}
+
+func Test_Controller_Threshold(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	// any two of k0, k1, and k2 need to sign events
+	nA := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Policies: object.Policies{{
+				Effect:  object.DenyEffect,
+				Actions: []object.PolicyAction{object.WriteAction},
+			}, {
+				Subjects: []crypto.PublicKey{
+					k0.PublicKey(),
+					k1.PublicKey(),
+					k2.PublicKey(),
+				},
+				Actions:   []object.PolicyAction{object.WriteAction},
+				Effect:    object.AllowEffect,
+				Threshold: 2,
+			}},
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	hA := nA.Hash()
+
+	c := NewController(hA, nil, sqlStore)
+	require.NoError(t, c.Apply(nA))
+
+	newEvent := func(name string) *object.Object {
+		return &object.Object{
+			Type: "test/event",
+			Metadata: object.Metadata{
+				Root: hA,
+				Parents: object.Parents{
+					"*": []tilde.Digest{hA},
+				},
+				Sequence: 1,
+			},
+			Data: tilde.Map{
+				"name": tilde.String(name),
+			},
+		}
+	}
+
+	// events without a threshold of their own still need the policy's
+	nB := newEvent("nB")
+	require.NoError(t, object.Sign(k0, nB))
+	err = c.Apply(nB)
+	require.ErrorIs(t, err, object.ErrThresholdNotMet)
+	require.NotContains(t, c.GetStreamInfo().Rejected, nB.Hash())
+
+	require.NoError(t, object.CoSign(k1, nB))
+	require.NoError(t, c.Apply(nB))
+
+	// events with invalid co-signatures are rejected
+	nC := newEvent("nC")
+	require.NoError(t, object.Sign(k0, nC))
+	require.NoError(t, object.CoSign(k1, nC))
+	invalid := *nC
+	invalid.Metadata.Signatures = []object.Signature{
+		invalid.Metadata.Signatures[0],
+	}
+	x := append([]byte{}, invalid.Metadata.Signatures[0].X...)
+	x[0] ^= 0xff
+	invalid.Metadata.Signatures[0].X = x
+	err = c.Apply(&invalid)
+	require.ErrorIs(t, err, ErrRejected)
+
+	// and stay rejected, unless they come with new signatures
+	reason := c.GetStreamInfo().Rejected[nC.Hash()].Reason
+	c.(*controller).streamInfo.Rejected[nC.Hash()].Reason = "cached"
+	err = c.Apply(&invalid)
+	require.ErrorIs(t, err, ErrRejected)
+	require.ErrorContains(t, err, "cached")
+	c.(*controller).streamInfo.Rejected[nC.Hash()].Reason = reason
+
+	require.NoError(t, c.Apply(nC))
+	require.NotContains(t, c.GetStreamInfo().Rejected, nC.Hash())
+
+	// roots with thresholds that can never be met are rejected
+	nX := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Policies: object.Policies{{
+				Identities: []did.DID{k0.PublicKey().DID()},
+				Subjects: []crypto.PublicKey{
+					k1.PublicKey(),
+					k2.PublicKey(),
+				},
+				Actions:   []object.PolicyAction{object.WriteAction},
+				Effect:    object.AllowEffect,
+				Threshold: 2,
+			}},
+		},
+	}
+	err = NewController(nX.Hash(), nil, sqlStore).Apply(nX)
+	require.ErrorIs(t, err, ErrRejected)
+	require.ErrorIs(t, err, object.ErrInvalidPolicy)
+}
+
+func Test_Controller_Redacted(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	nA := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Owner: k0.PublicKey().DID(),
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	require.NoError(t, object.Sign(k0, nA))
+	hA := nA.Hash()
+
+	c := NewController(hA, nil, sqlStore)
+	require.NoError(t, c.Apply(nA))
+
+	nB := &object.Object{
+		Type: "test/event",
+		Metadata: object.Metadata{
+			Owner: k0.PublicKey().DID(),
+			Root:  hA,
+			Parents: object.Parents{
+				"*": []tilde.Digest{hA},
+			},
+			Sequence: 1,
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nB"),
+		},
+	}
+	require.NoError(t, object.Sign(k0, nB))
+	hB := nB.Hash()
+
+	// a preview of the event arrives first, it should not be applied
+	pB, err := object.Redact(nB, "name")
+	require.NoError(t, err)
+	err = c.Apply(pB)
+	require.ErrorIs(t, err, ErrRejected)
+	require.ErrorIs(t, err, object.ErrRedacted)
+	require.False(t, c.ContainsDigest(hB))
+
+	// and should not keep the full event from being applied
+	require.NoError(t, c.Apply(nB))
+	require.True(t, c.ContainsDigest(hB))
+
+	gB, err := sqlStore.Get(hB)
+	require.NoError(t, err)
+	require.False(t, object.IsRedacted(gB))
+	require.Equal(t, tilde.String("nB"), gB.Data["name"])
+}
+
+func Test_Controller_Validation(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	sc, err := object.Marshal(&schema.Context{
+		Name: "test",
+		Types: []*schema.Type{{
+			Name: "test/event",
+			Properties: []*schema.Property{{
+				Name:     "name",
+				Hint:     tilde.StringHint,
+				Required: true,
+			}},
+			Strict: true,
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sqlStore.Put(sc))
+
+	nA := &object.Object{
+		Type: "test/root",
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	hA := nA.Hash()
+
+	c := NewController(
+		hA,
+		nil,
+		sqlStore,
+		ControllerWithValidator(
+			schema.NewValidator(
+				object.GetterFunc(
+					func(_ context.Context, h tilde.Digest) (*object.Object, error) {
+						return sqlStore.Get(h)
+					},
+				),
+			),
+		),
+	)
+	require.NoError(t, c.Apply(nA))
+
+	newEvent := func(data tilde.Map) *object.Object {
+		o := &object.Object{
+			Context: sc.Hash(),
+			Type:    "test/event",
+			Metadata: object.Metadata{
+				Root: hA,
+				Parents: object.Parents{
+					"*": []tilde.Digest{hA},
+				},
+				Sequence: 1,
+			},
+			Data: data,
+		}
+		require.NoError(t, object.Sign(k0, o))
+		return o
+	}
+
+	// valid event
+	nB := newEvent(tilde.Map{
+		"name": tilde.String("nB"),
+	})
+	require.NoError(t, c.Apply(nB))
+
+	// missing required property
+	nC := newEvent(tilde.Map{})
+	err = c.Apply(nC)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// unknown property
+	nD := newEvent(tilde.Map{
+		"name": tilde.String("nD"),
+		"foo":  tilde.String("bar"),
+	})
+	err = c.Apply(nD)
+	require.True(t, errors.Is(err, ErrRejected))
+
+	// unknown context, not rejected
+	nE := newEvent(tilde.Map{
+		"name": tilde.String("nE"),
+	})
+	nE.Context = "foo"
+	require.NoError(t, object.Sign(k0, nE))
+	err = c.Apply(nE)
+	require.True(t, errors.Is(err, schema.ErrInvalidContext))
+
+	info := c.GetStreamInfo()
+	require.Len(t, info.Objects, 2)
+	require.Len(t, info.Rejected, 2)
+	require.Contains(t, info.Rejected, nC.Hash())
+	require.Contains(t, info.Rejected, nD.Hash())
+}
+
+func Test_Controller_ValidationUnlocked(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	nA := &object.Object{
+		Type: "test/root",
+	}
+	hA := nA.Hash()
+
+	// the getter blocks until the context is released
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	c := NewController(
+		hA,
+		nil,
+		sqlStore,
+		ControllerWithValidator(
+			schema.NewValidator(
+				object.GetterFunc(
+					func(
+						_ context.Context,
+						_ tilde.Digest,
+					) (*object.Object, error) {
+						close(fetching)
+						<-release
+						return nil, objectstore.ErrNotFound
+					},
+				),
+			),
+		),
+	)
+	require.NoError(t, c.Apply(nA))
+
+	nB := &object.Object{
+		Context: "foo",
+		Type:    "test/event",
+		Metadata: object.Metadata{
+			Root: hA,
+			Parents: object.Parents{
+				"*": []tilde.Digest{hA},
+			},
+			Sequence: 1,
+		},
+	}
+	require.NoError(t, object.Sign(k0, nB))
+
+	errs := make(chan error)
+	go func() {
+		errs <- c.Apply(nB)
+	}()
+
+	// the stream should be usable while the context is being fetched
+	<-fetching
+	contains := make(chan bool)
+	go func() {
+		contains <- c.ContainsDigest(hA)
+	}()
+	select {
+	case ok := <-contains:
+		require.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("stream was locked while fetching the context")
+	}
+
+	close(release)
+	require.ErrorIs(t, <-errs, schema.ErrInvalidContext)
+}
+
+func Test_Controller_Encryption(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k3, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	nA := &object.Object{
+		Type: "test/root",
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	hA := nA.Hash()
+
+	c0 := NewController(hA, nil, sqlStore, ControllerWithPeerKey(k0))
+	require.NoError(t, c0.Apply(nA))
+
+	newEvent := func(name string) *object.Object {
+		return &object.Object{
+			Type: "test/event",
+			Data: tilde.Map{
+				"name": tilde.String(name),
+			},
+		}
+	}
+
+	// there is no group key yet
+	_, err = c0.InsertEncrypted(newEvent("n0"))
+	require.ErrorIs(t, err, ErrNoGroupKey)
+
+	require.NoError(t, c0.RotateGroupKey(k1.PublicKey(), k2.PublicKey()))
+	require.Len(t, c0.GetGroupMembers(), 2)
+
+	_, err = c0.InsertEncrypted(newEvent("n1"))
+	require.NoError(t, err)
+
+	// removing a member rotates the key
+	require.NoError(t, c0.RemoveGroupMember(k2.PublicKey()))
+	require.Len(t, c0.GetGroupMembers(), 1)
+	require.True(t, c0.GetGroupMembers()[0].Equals(k1.PublicKey()))
+
+	_, err = c0.InsertEncrypted(newEvent("n2"))
+	require.NoError(t, err)
+
+	// the objects stored are all encrypted
+	ds, err := c0.GetDigests()
+	require.NoError(t, err)
+	require.Len(t, ds, 5)
+	for _, d := range ds[1:] {
+		o, err := sqlStore.Get(d)
+		require.NoError(t, err)
+		require.Equal(t, object.EncryptedType, o.Type)
+	}
+
+	// readEvents applies the stream to a new controller with the given key,
+	// and returns the names of the events it can read
+	readEvents := func(c Controller) []string {
+		for _, d := range ds {
+			o, err := sqlStore.Get(d)
+			require.NoError(t, err)
+			require.NoError(t, c.Apply(o))
+		}
+		r, err := c.GetReader(context.New())
+		require.NoError(t, err)
+		names := []string{}
+		for {
+			o, err := r.Read()
+			if errors.Is(err, object.ErrReaderDone) {
+				break
+			}
+			require.NoError(t, err)
+			if o.Type == "test/event" {
+				names = append(names, string(o.Data["name"].(tilde.String)))
+			}
+		}
+		return names
+	}
+
+	require.Equal(t,
+		[]string{"n1", "n2"},
+		readEvents(c0),
+	)
+	require.Equal(t,
+		[]string{"n1", "n2"},
+		readEvents(NewController(hA, nil, sqlStore, ControllerWithPeerKey(k1))),
+	)
+	require.Equal(t,
+		[]string{"n1"},
+		readEvents(NewController(hA, nil, sqlStore, ControllerWithPeerKey(k2))),
+	)
+	require.Equal(t,
+		[]string{},
+		readEvents(NewController(hA, nil, sqlStore, ControllerWithPeerKey(k3))),
+	)
+}
+
+func Test_Controller_GroupKeyAdmins(t *testing.T) {
+	sqlStoreDB, err := sql.Open(
+		"sqlite",
+		path.Join(t.TempDir(), "db.sqlite"),
+	)
+	require.NoError(t, err)
+
+	sqlStore, err := sqlobjectstore.New(sqlStoreDB)
+	require.NoError(t, err)
+
+	k0, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k1, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+	k2, err := crypto.NewEd25519PrivateKey()
+	require.NoError(t, err)
+
+	// k0 owns the stream, and k1 can only write to it
+	nA := &object.Object{
+		Type: "test/root",
+		Metadata: object.Metadata{
+			Owner: k0.PublicKey().DID(),
+			Policies: object.Policies{{
+				Subjects: []crypto.PublicKey{
+					k0.PublicKey(),
+					k1.PublicKey(),
+				},
+				Actions: []object.PolicyAction{object.WriteAction},
+				Effect:  object.AllowEffect,
+			}},
+		},
+		Data: tilde.Map{
+			"name": tilde.String("nA"),
+		},
+	}
+	require.NoError(t, object.Sign(k0, nA))
+	hA := nA.Hash()
+
+	c0 := NewController(hA, nil, sqlStore, ControllerWithPeerKey(k0))
+	require.NoError(t, c0.Apply(nA))
+	require.NoError(t, c0.RotateGroupKey(k1.PublicKey(), k2.PublicKey()))
+
+	// concurrent inserts should not end up with the same sequence
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c0.InsertEncrypted(&object.Object{
+				Type: "test/event",
+				Data: tilde.Map{
+					"name": tilde.String(fmt.Sprintf("n%d", i)),
+				},
+			})
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	ds, err := c0.GetDigests()
+	require.NoError(t, err)
+	require.Len(t, ds, 12)
+	seqs := map[uint64]bool{}
+	for _, d := range ds {
+		o, err := sqlStore.Get(d)
+		require.NoError(t, err)
+		require.False(t, seqs[o.Metadata.Sequence])
+		seqs[o.Metadata.Sequence] = true
+	}
+
+	// k1 can write, but cannot rotate the group key
+	c1 := NewController(hA, nil, sqlStore, ControllerWithPeerKey(k1))
+	for _, d := range ds {
+		o, err := sqlStore.Get(d)
+		require.NoError(t, err)
+		require.NoError(t, c1.Apply(o))
+	}
+	gk := c1.(*controller).groupKey
+	require.NotNil(t, gk)
+	require.ErrorIs(t, c1.RotateGroupKey(k2.PublicKey()), object.ErrForbidden)
+
+	// and keys it shares anyway are not adopted
+	_, err = c1.(*controller).insertEncrypted(
+		&GroupKey{
+			KeyID:   "rogue",
+			Key:     gk.Key,
+			Members: []crypto.PublicKey{k2.PublicKey()},
+		},
+		object.EncryptFor(k1.PublicKey(), k2.PublicKey()),
+	)
+	require.NoError(t, err)
+
+	ds, err = c1.GetDigests()
+	require.NoError(t, err)
+	c2 := NewController(hA, nil, sqlStore, ControllerWithPeerKey(k2))
+	for _, d := range ds {
+		o, err := sqlStore.Get(d)
+		require.NoError(t, err)
+		require.NoError(t, c2.Apply(o))
+	}
+	require.Equal(t, gk.KeyID, c2.(*controller).groupKey.KeyID)
+	require.Equal(t, gk.KeyID, c1.(*controller).groupKey.KeyID)
+}
@@ -3,14 +3,16 @@
 package stream
 
 import (
+	crypto "nimona.io/pkg/crypto"
 	object "nimona.io/pkg/object"
 	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const PolicyType = "nimona.io/stream.Policy"
 
 type Policy struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Policy"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Policy,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	Subjects   []string        `nimona:"subjects:as"`
 	Resources  []string        `nimona:"resources:as"`
 	Conditions []string        `nimona:"conditions:as"`
@@ -20,7 +22,7 @@ type Policy struct {
 const RequestType = "nimona.io/stream.Request"
 
 type Request struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Request"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Request,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	RequestID string          `nimona:"requestID:s"`
 	RootHash  tilde.Digest    `nimona:"rootHash:r"`
 }
@@ -28,7 +30,7 @@ type Request struct {
 const RequestLinearType = "nimona.io/stream.RequestLinear"
 
 type RequestLinear struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.RequestLinear"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.RequestLinear,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	RequestID string          `nimona:"requestID:s"`
 	RootHash  tilde.Digest    `nimona:"rootHash:r"`
 	Limit     int64           `nimona:"limit:i"`
@@ -38,17 +40,18 @@ type RequestLinear struct {
 const ResponseType = "nimona.io/stream.Response"
 
 type Response struct {
-	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Response"`
+	Metadata  object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Response,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	RequestID string          `nimona:"requestID:s"`
 	RootHash  tilde.Digest    `nimona:"rootHash:r"`
 	Leaves    []tilde.Digest  `nimona:"leaves:ar"`
 	Total     int64           `nimona:"total:i"`
+	Forbidden bool            `nimona:"forbidden:b"`
 }
 
 const AnnouncementType = "nimona.io/stream.Announcement"
 
 type Announcement struct {
-	Metadata     object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Announcement"`
+	Metadata     object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Announcement,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	StreamHash   tilde.Digest    `nimona:"streamHash:r"`
 	ObjectHashes []tilde.Digest  `nimona:"objectHashes:ar"`
 }
@@ -56,7 +59,124 @@ type Announcement struct {
 const SubscriptionType = "nimona.io/stream.Subscription"
 
 type Subscription struct {
-	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Subscription"`
+	Metadata   object.Metadata `nimona:"@metadata:m,type=nimona.io/stream.Subscription,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
 	RootHashes []tilde.Digest  `nimona:"rootHashes:ar"`
 	Expiry     string          `nimona:"expiry:s"`
 }
+
+const GroupKeyType = "nimona.io/stream.GroupKey"
+
+type GroupKey struct {
+	Metadata object.Metadata    `nimona:"@metadata:m,type=nimona.io/stream.GroupKey,context=HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f"`
+	KeyID    string             `nimona:"keyID:s"`
+	Key      []byte             `nimona:"key:d"`
+	Members  []crypto.PublicKey `nimona:"members:as"`
+}
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("HWXvz7z5uaNQQrXpkZ53WithBzXeSWU3Ff185ModKA4f")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/stream",
+	Types: []*schema.Type{{
+		Name: "nimona.io/stream.Policy",
+		Properties: []*schema.Property{{
+			Name:     "subjects",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "resources",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name:     "conditions",
+			Hint:     "s",
+			Repeated: true,
+		}, {
+			Name: "action",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/stream.Request",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "rootHash",
+			Hint: "r",
+		}},
+	}, {
+		Name: "nimona.io/stream.RequestLinear",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "rootHash",
+			Hint: "r",
+		}, {
+			Name: "limit",
+			Hint: "i",
+		}, {
+			Name: "skip",
+			Hint: "i",
+		}},
+	}, {
+		Name: "nimona.io/stream.Response",
+		Properties: []*schema.Property{{
+			Name: "requestID",
+			Hint: "s",
+		}, {
+			Name: "rootHash",
+			Hint: "r",
+		}, {
+			Name:     "leaves",
+			Hint:     "r",
+			Repeated: true,
+		}, {
+			Name: "total",
+			Hint: "i",
+		}, {
+			Name: "forbidden",
+			Hint: "b",
+		}},
+	}, {
+		Name: "nimona.io/stream.Announcement",
+		Properties: []*schema.Property{{
+			Name: "streamHash",
+			Hint: "r",
+		}, {
+			Name:     "objectHashes",
+			Hint:     "r",
+			Repeated: true,
+		}},
+	}, {
+		Name: "nimona.io/stream.Subscription",
+		Properties: []*schema.Property{{
+			Name:     "rootHashes",
+			Hint:     "r",
+			Repeated: true,
+		}, {
+			Name: "expiry",
+			Hint: "s",
+		}},
+	}, {
+		Name: "nimona.io/stream.GroupKey",
+		Properties: []*schema.Property{{
+			Name: "keyID",
+			Hint: "s",
+		}, {
+			Name: "key",
+			Hint: "d",
+		}, {
+			Name:     "members",
+			Hint:     "s",
+			Repeated: true,
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
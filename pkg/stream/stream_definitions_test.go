@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/memobjectstore"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
+)
+
+func TestSchemaContext(t *testing.T) {
+	c, err := object.Marshal(SchemaContext)
+	require.NoError(t, err)
+	require.Equal(t, SchemaContextDigest, c.Hash())
+
+	o, err := object.Marshal(&Subscription{
+		RootHashes: []tilde.Digest{"foo"},
+		Expiry:     "bar",
+	})
+	require.NoError(t, err)
+	require.Equal(t, SchemaContextDigest, o.Context)
+
+	v := schema.NewValidator(
+		object.GetterFunc(
+			func(_ context.Context, h tilde.Digest) (*object.Object, error) {
+				require.Equal(t, SchemaContextDigest, h)
+				return c, nil
+			},
+		),
+	)
+	r, err := v.Validate(context.New(), o)
+	require.NoError(t, err)
+	require.NoError(t, r.Err())
+}
+
+func TestSchemaContext_Registered(t *testing.T) {
+	// generated contexts should not need to be fetched
+	v := schema.NewValidator(
+		object.GetterFunc(
+			func(_ context.Context, h tilde.Digest) (*object.Object, error) {
+				return nil, objectstore.ErrNotFound
+			},
+		),
+	)
+
+	str := memobjectstore.New()
+	defer str.Close() // nolint: errcheck
+
+	root, err := object.Marshal(&Subscription{
+		RootHashes: []tilde.Digest{"foo"},
+		Expiry:     "bar",
+	})
+	require.NoError(t, err)
+
+	c := NewController(
+		root.Hash(),
+		nil,
+		str,
+		ControllerWithValidator(v),
+	)
+	require.NoError(t, c.Apply(root))
+
+	event, err := object.Marshal(&Announcement{
+		Metadata: object.Metadata{
+			Root: root.Hash(),
+			Parents: object.Parents{
+				"*": []tilde.Digest{root.Hash()},
+			},
+			Sequence: 1,
+		},
+		StreamHash:   root.Hash(),
+		ObjectHashes: []tilde.Digest{"foo"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.Apply(event))
+	require.Empty(t, c.GetStreamInfo().Rejected)
+}
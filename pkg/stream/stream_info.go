@@ -11,11 +11,18 @@ type (
 		Digest   tilde.Digest
 		Metadata object.Metadata
 	}
+	// RejectedObjectInfo is an object that was not applied to the stream,
+	// along with the reason it was rejected
+	RejectedObjectInfo struct {
+		ObjectInfo
+		Reason string
+	}
 	Info struct {
 		RootType   string
 		RootDigest tilde.Digest
 		RootObject *object.Object
 		Objects    map[tilde.Digest]*ObjectInfo
+		Rejected   map[tilde.Digest]*RejectedObjectInfo
 	}
 )
 
@@ -29,6 +36,7 @@ func GetObjectInfo(o *object.Object) *ObjectInfo {
 
 func NewInfo() *Info {
 	return &Info{
-		Objects: map[tilde.Digest]*ObjectInfo{},
+		Objects:  map[tilde.Digest]*ObjectInfo{},
+		Rejected: map[tilde.Digest]*RejectedObjectInfo{},
 	}
 }
@@ -10,40 +10,70 @@ import (
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/network"
 	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
 	"nimona.io/pkg/resolver"
-	"nimona.io/pkg/sqlobjectstore"
 	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
 )
 
 type (
 	manager struct {
 		Network     network.Network
-		ObjectStore *sqlobjectstore.Store
+		ObjectStore objectstore.Store
 		// controller cache
 		controllers     *simple.Cache[tilde.Digest, Controller]
 		controllersLock sync.RWMutex
 		// sync strategy
 		strategy SyncStrategy
+		// keyResolver is used to find the key streams of peers requesting
+		// streams
+		keyResolver object.KeyResolver
+		// validator is passed to the stream controllers
+		validator *schema.Validator
 	}
+	// ManagerOption for customizing NewManager
+	ManagerOption func(*manager)
 )
 
+// WithKeyResolver allows the policies of streams to be evaluated against the
+// key streams of the peers requesting them
+func WithKeyResolver(r object.KeyResolver) ManagerOption {
+	return func(m *manager) {
+		m.keyResolver = r
+	}
+}
+
+// WithValidator rejects stream objects that do not conform to their schema
+// contexts
+func WithValidator(v *schema.Validator) ManagerOption {
+	return func(m *manager) {
+		m.validator = v
+	}
+}
+
 func NewManager(
 	ctx context.Context,
 	network network.Network,
 	resolver resolver.Resolver,
-	objectStore *sqlobjectstore.Store,
+	objectStore objectstore.Store,
+	opts ...ManagerOption,
 ) (Manager, error) {
 	m := &manager{
 		Network:     network,
 		ObjectStore: objectStore,
 		controllers: simple.NewCache[tilde.Digest, Controller](),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
 	if network != nil && resolver != nil {
-		m.strategy = NewTopographicalSyncStrategy(
+		strategy := NewTopographicalSyncStrategy(
 			network,
 			resolver,
 			objectStore,
 		)
+		strategy.keyResolver = m.keyResolver
+		m.strategy = strategy
 		go m.strategy.Serve(ctx, m)
 	}
 	return m, nil
@@ -69,10 +99,18 @@ func (m *manager) GetController(cid tilde.Digest) (Controller, error) {
 
 	// create a new controller
 	m.controllersLock.Lock()
+	opts := []ControllerOption{
+		ControllerWithKeyResolver(m.keyResolver),
+		ControllerWithValidator(m.validator),
+	}
+	if m.Network != nil {
+		opts = append(opts, ControllerWithPeerKey(m.Network.GetPeerKey()))
+	}
 	c = NewController(
 		cid,
 		m.Network,
 		m.ObjectStore,
+		opts...,
 	)
 
 	m.controllers.Set(cid, c)
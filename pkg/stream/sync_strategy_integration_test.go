@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"nimona.io/internal/net"
 	"nimona.io/pkg/config"
 	"nimona.io/pkg/context"
 	"nimona.io/pkg/crypto"
@@ -19,13 +20,20 @@ import (
 )
 
 func TestSyncStrategy_Integration(t *testing.T) {
-	_, c0 := provider.NewTestProvider(context.Background(), t)
+	sb := net.NewSwitchboard(1)
+	netOpts := []net.Option{
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	}
+
+	_, c0 := provider.NewTestProvider(context.Background(), t, netOpts...)
 
 	k0, err := crypto.PublicKeyFromDID(c0.Metadata.Owner)
 	require.NoError(t, err)
 
 	d1, err := daemon.New(
 		context.New(),
+		daemon.WithNetOptions(netOpts...),
 		daemon.WithConfigOptions(
 			config.WithDefaultPath(t.TempDir()),
 			config.WithDefaultListenOnLocalIPs(),
@@ -41,6 +49,7 @@ func TestSyncStrategy_Integration(t *testing.T) {
 
 	d2, err := daemon.New(
 		context.New(),
+		daemon.WithNetOptions(netOpts...),
 		daemon.WithConfigOptions(
 			config.WithDefaultPath(t.TempDir()),
 			config.WithDefaultListenOnLocalIPs(),
@@ -92,13 +101,20 @@ func TestSyncStrategy_Integration(t *testing.T) {
 }
 
 func TestSyncStrategy_Announcements_Integration(t *testing.T) {
-	_, c0 := provider.NewTestProvider(context.Background(), t)
+	sb := net.NewSwitchboard(1)
+	netOpts := []net.Option{
+		net.WithMemoryTransport(sb),
+		net.WithoutTransports("tcps", "quic"),
+	}
+
+	_, c0 := provider.NewTestProvider(context.Background(), t, netOpts...)
 
 	k0, err := crypto.PublicKeyFromDID(c0.Metadata.Owner)
 	require.NoError(t, err)
 
 	d1, err := daemon.New(
 		context.New(),
+		daemon.WithNetOptions(netOpts...),
 		daemon.WithConfigOptions(
 			config.WithDefaultPath(t.TempDir()),
 			config.WithDefaultListenOnLocalIPs(),
@@ -112,6 +128,7 @@ func TestSyncStrategy_Announcements_Integration(t *testing.T) {
 
 	d2, err := daemon.New(
 		context.New(),
+		daemon.WithNetOptions(netOpts...),
 		daemon.WithConfigOptions(
 			config.WithDefaultPath(t.TempDir()),
 			config.WithDefaultListenOnLocalIPs(),
@@ -147,12 +164,18 @@ func TestSyncStrategy_Announcements_Integration(t *testing.T) {
 		&stream.Subscription{
 			Metadata: object.Metadata{
 				Owner: d2.Network().GetConnectionInfo().Metadata.Owner,
+				Root:  h1,
+				Parents: object.Parents{
+					"*": []tilde.Digest{h1},
+				},
+				Sequence: 1,
 			},
 			RootHashes: []tilde.Digest{
 				h1,
 			},
 		},
 	)
+	require.NoError(t, object.Sign(d2.Network().GetPeerKey(), o2))
 	_, err = c1.Insert(o2)
 	require.NoError(t, err)
 
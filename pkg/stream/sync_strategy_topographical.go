@@ -7,6 +7,9 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"nimona.io/pkg/context"
+	"nimona.io/pkg/crypto"
+	"nimona.io/pkg/did"
+	"nimona.io/pkg/errors"
 	"nimona.io/pkg/network"
 	"nimona.io/pkg/object"
 	"nimona.io/pkg/objectstore"
@@ -19,6 +22,7 @@ type (
 		network      network.Network
 		resolver     resolver.Resolver
 		store        objectstore.Store
+		keyResolver  object.KeyResolver
 		newRequestID func() string
 	}
 )
@@ -73,13 +77,14 @@ func (f *syncStrategyTopographical) handleRequests(
 			continue
 		}
 
-		respond := func(leaves []tilde.Digest, total int) {
+		respond := func(leaves []tilde.Digest, total int, forbidden bool) {
 			res := &Response{
 				// TODO add metadata
 				RequestID: req.RequestID,
 				RootHash:  req.RootHash,
 				Leaves:    leaves,
 				Total:     int64(total),
+				Forbidden: forbidden,
 			}
 			obj, err := object.Marshal(res)
 			if err != nil {
@@ -95,22 +100,49 @@ func (f *syncStrategyTopographical) handleRequests(
 			}
 		}
 
+		// the stream's policies are the ones of its root
+		if !f.canRead(env.Sender, req.RootHash) {
+			respond(nil, 0, true)
+			continue
+		}
+
 		ctrl, err := manager.GetController(req.RootHash)
 		if err != nil {
-			respond(nil, 0)
+			respond(nil, 0, false)
 			continue
 		}
 
 		leaves, err := ctrl.GetDigests()
 		if err != nil {
-			respond(nil, 0)
+			respond(nil, 0, false)
 			continue
 		}
 
-		respond(leaves, len(leaves))
+		respond(leaves, len(leaves), false)
 	}
 }
 
+// canRead checks whether the policies of the stream's root allow the given
+// peer to read the stream, streams we do not have are left for the caller
+func (f *syncStrategyTopographical) canRead(
+	sender did.DID,
+	rootHash tilde.Digest,
+) bool {
+	root, err := f.store.Get(rootHash)
+	if err != nil {
+		return errors.Is(err, objectstore.ErrNotFound)
+	}
+	subject := crypto.PublicKey{}
+	if k, err := crypto.PublicKeyFromDID(sender); err == nil {
+		subject = *k
+	}
+	ok, err := objectstore.CanRead(f.store, f.keyResolver, subject, root)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
 // TODO: move to manager?
 func (f *syncStrategyTopographical) handleAnnouncements(
 	ctx context.Context,
@@ -232,8 +264,10 @@ func (f *syncStrategyTopographical) Fetch(
 	// ask providers for first 100 objects of stream
 	var errs error
 	for _, provider := range providers {
-		// keep track of missing objects
-		missing := map[tilde.Digest]struct{}{}
+		// keep track of missing objects, in the order the provider sent them
+		// so parents, and the stream root, are applied before their children
+		missing := []tilde.Digest{}
+		missingSet := map[tilde.Digest]struct{}{}
 		// keep track of progress
 		limit := int64(100)
 		skip := int64(0)
@@ -257,14 +291,19 @@ func (f *syncStrategyTopographical) Fetch(
 				errs = multierror.Append(errs, err)
 				break
 			}
+			if res.Forbidden {
+				errs = multierror.Append(errs, object.ErrForbidden)
+				break
+			}
 			for _, digest := range res.Leaves {
 				if _, exists := currentDigests[digest]; exists {
 					continue
 				}
-				if _, exists := missing[digest]; exists {
+				if _, exists := missingSet[digest]; exists {
 					continue
 				}
-				missing[digest] = struct{}{}
+				missingSet[digest] = struct{}{}
+				missing = append(missing, digest)
 			}
 			if skip+limit >= res.Total {
 				break
@@ -274,7 +313,7 @@ func (f *syncStrategyTopographical) Fetch(
 			}
 			skip += limit
 		}
-		for digest := range missing {
+		for _, digest := range missing {
 			// get object from provider
 			res := &object.Response{}
 			err := f.network.Send(
@@ -292,6 +331,10 @@ func (f *syncStrategyTopographical) Fetch(
 				errs = multierror.Append(errs, err)
 				continue
 			}
+			if res.Forbidden {
+				errs = multierror.Append(errs, object.ErrForbidden)
+				continue
+			}
 			// add object to graph
 			err = controller.Apply(res.Object)
 			if err != nil {
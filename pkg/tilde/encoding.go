@@ -0,0 +1,494 @@
+package tilde
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"nimona.io/pkg/errors"
+)
+
+// Values can be encoded into a canonical binary form, so that any
+// implementation can reproduce the exact bytes, and therefore digests, of
+// any given value.
+// Every value is encoded as its hint followed by its payload, where the hint
+// is a length-prefixed string.
+// Lengths and counts are unsigned varints, in their shortest form.
+// Payloads are encoded as follows:
+//   b:  a single byte, 0x00 or 0x01
+//   d:  length, raw bytes
+//   s:  length, utf-8 bytes
+//   r:  length, utf-8 bytes
+//   i:  8 bytes, big endian two's complement
+//   u:  8 bytes, big endian
+//   f:  8 bytes, big endian IEEE 754, infinities and NaN are not allowed
+//   m:  count, followed by the entries sorted by their key's bytes; each
+//       entry is the key as a length-prefixed string, and the value
+//   aX: count, followed by the payloads of the items, without their hints
+// Redacted values are encoded with their original hint prefixed with `!`,
+// and their digest as a length-prefixed string.
+// Entries whose values have an empty digest (nil, empty strings, maps and
+// arrays) are omitted, as they are not part of the map's digest either.
+// Decoding rejects anything that is not in its canonical form.
+
+const (
+	ErrInvalidEncoding      = errors.Error("invalid encoding")
+	ErrNonCanonicalEncoding = errors.Error("non canonical encoding")
+)
+
+// Marshal returns the canonical binary encoding of the value
+func Marshal(v Value) ([]byte, error) {
+	if v == nil {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("nil value"),
+		)
+	}
+	e := &encoder{}
+	e.writeString(encodingHint(v))
+	if err := e.writePayload(v); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// Unmarshal decodes a value from its canonical binary encoding
+func Unmarshal(b []byte) (Value, error) {
+	d := &decoder{
+		buf: b,
+	}
+	h, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.readPayload(Hint(h))
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buf) > 0 {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("trailing bytes"),
+		)
+	}
+	return v, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUvarint(n uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutUvarint(b, n)])
+}
+
+func (e *encoder) writeUint64(n uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	e.buf.Write(b)
+}
+
+func (e *encoder) writeBytes(b []byte) {
+	e.writeUvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeBytes([]byte(s))
+}
+
+func (e *encoder) writeFloat(f Float) error {
+	if math.IsInf(float64(f), 0) || math.IsNaN(float64(f)) {
+		return errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("float inf and nan are not supported"),
+		)
+	}
+	e.writeUint64(math.Float64bits(float64(f)))
+	return nil
+}
+
+func (e *encoder) writePayload(v Value) error {
+	switch vv := v.(type) {
+	case Bool:
+		if vv {
+			e.buf.WriteByte(1)
+		} else {
+			e.buf.WriteByte(0)
+		}
+	case Data:
+		e.writeBytes(vv)
+	case String:
+		e.writeString(string(vv))
+	case Digest:
+		e.writeString(string(vv))
+	case Int:
+		e.writeUint64(uint64(vv))
+	case Uint:
+		e.writeUint64(uint64(vv))
+	case Float:
+		return e.writeFloat(vv)
+	case Redacted:
+		e.writeString(string(vv.Digest))
+	case Map:
+		ks := []string{}
+		for k, iv := range vv {
+			if iv == nil {
+				continue
+			}
+			if err := validateFloats(iv); err != nil {
+				return err
+			}
+			if iv.Hash().IsEmpty() {
+				continue
+			}
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+		e.writeUvarint(uint64(len(ks)))
+		for _, k := range ks {
+			iv := vv[k]
+			e.writeString(k)
+			e.writeString(encodingHint(iv))
+			if err := e.writePayload(iv); err != nil {
+				return err
+			}
+		}
+	case ArrayValue:
+		e.writeUvarint(uint64(vv.Len()))
+		var err error
+		vv.Range(func(_ int, iv Value) bool {
+			err = e.writePayload(iv)
+			return err != nil
+		})
+		return err
+	default:
+		return errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unsupported hint "+string(v.Hint())),
+		)
+	}
+	return nil
+}
+
+// encodingHint returns the hint values are encoded with, which for redacted
+// values is their original hint prefixed with `!`
+func encodingHint(v Value) string {
+	if _, ok := v.(Redacted); ok {
+		return "!" + string(v.Hint())
+	}
+	return string(v.Hint())
+}
+
+// validateFloats makes sure we don't try to hash floats that cannot be
+// encoded, as their hash panics
+func validateFloats(v Value) error {
+	switch vv := v.(type) {
+	case Float:
+		return (&encoder{}).writeFloat(vv)
+	case FloatArray:
+		for _, f := range vv {
+			if err := (&encoder{}).writeFloat(f); err != nil {
+				return err
+			}
+		}
+	case Map:
+		for _, iv := range vv {
+			if err := validateFloats(iv); err != nil {
+				return err
+			}
+		}
+	case MapArray:
+		for _, iv := range vv {
+			if err := validateFloats(iv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) readUvarint() (uint64, error) {
+	n, l := binary.Uvarint(d.buf)
+	if l <= 0 {
+		return 0, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("invalid varint"),
+		)
+	}
+	b := make([]byte, binary.MaxVarintLen64)
+	if binary.PutUvarint(b, n) != l {
+		return 0, errors.Merge(
+			ErrNonCanonicalEncoding,
+			errors.Error("varint is not in its shortest form"),
+		)
+	}
+	d.buf = d.buf[l:]
+	return n, nil
+}
+
+func (d *decoder) readN(n uint64) ([]byte, error) {
+	if uint64(len(d.buf)) < n {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unexpected end of input"),
+		)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) readBytes() ([]byte, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b...), nil
+}
+
+func (d *decoder) readString() (string, error) {
+	b, err := d.readBytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("invalid utf-8 string"),
+		)
+	}
+	return string(b), nil
+}
+
+func (d *decoder) readUint64() (uint64, error) {
+	b, err := d.readN(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *decoder) readFloat() (Float, error) {
+	n, err := d.readUint64()
+	if err != nil {
+		return 0, err
+	}
+	f := math.Float64frombits(n)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("float inf and nan are not supported"),
+		)
+	}
+	return Float(f), nil
+}
+
+// readCount reads the number of items of a map or array, making sure they
+// could actually fit in the remaining input, as every item is at least a
+// byte long
+func (d *decoder) readCount() (int, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unexpected end of input"),
+		)
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readPayload(h Hint) (Value, error) {
+	if strings.HasPrefix(string(h), "!") {
+		return d.readRedacted(Hint(strings.TrimPrefix(string(h), "!")))
+	}
+
+	switch h {
+	case BoolHint:
+		b, err := d.readN(1)
+		if err != nil {
+			return nil, err
+		}
+		switch b[0] {
+		case 0:
+			return Bool(false), nil
+		case 1:
+			return Bool(true), nil
+		}
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("invalid bool"),
+		)
+	case DataHint:
+		b, err := d.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		return Data(b), nil
+	case StringHint:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return String(s), nil
+	case DigestHint:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return Digest(s), nil
+	case IntHint:
+		n, err := d.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		return Int(n), nil
+	case UintHint:
+		n, err := d.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		return Uint(n), nil
+	case FloatHint:
+		return d.readFloat()
+	case MapHint:
+		return d.readMap()
+	}
+
+	if len(h) != 2 || h[0] != 'a' {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unsupported hint "+string(h)),
+		)
+	}
+
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	var a ArrayValue
+	switch h {
+	case BoolArrayHint:
+		a = make(BoolArray, n)
+	case DataArrayHint:
+		a = make(DataArray, n)
+	case FloatArrayHint:
+		a = make(FloatArray, n)
+	case IntArrayHint:
+		a = make(IntArray, n)
+	case MapArrayHint:
+		a = make(MapArray, n)
+	case StringArrayHint:
+		a = make(StringArray, n)
+	case UintArrayHint:
+		a = make(UintArray, n)
+	case DigestArrayHint:
+		a = make(DigestArray, n)
+	default:
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unsupported hint "+string(h)),
+		)
+	}
+	for i := 0; i < n; i++ {
+		iv, err := d.readPayload(Hint(h[1:]))
+		if err != nil {
+			return nil, err
+		}
+		switch aa := a.(type) {
+		case BoolArray:
+			aa[i] = iv.(Bool)
+		case DataArray:
+			aa[i] = iv.(Data)
+		case FloatArray:
+			aa[i] = iv.(Float)
+		case IntArray:
+			aa[i] = iv.(Int)
+		case MapArray:
+			aa[i] = iv.(Map)
+		case StringArray:
+			aa[i] = iv.(String)
+		case UintArray:
+			aa[i] = iv.(Uint)
+		case DigestArray:
+			aa[i] = iv.(Digest)
+		}
+	}
+	return a, nil
+}
+
+func (d *decoder) readRedacted(h Hint) (Value, error) {
+	if _, ok := hints[string(h)]; !ok {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("unsupported hint "+string(h)),
+		)
+	}
+	s, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	if s == "" {
+		return nil, errors.Merge(
+			ErrInvalidEncoding,
+			errors.Error("redacted value without digest"),
+		)
+	}
+	return Redacted{
+		OriginalHint: h,
+		Digest:       Digest(s),
+	}, nil
+}
+
+func (d *decoder) readMap() (Map, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	m := Map{}
+	lk := ""
+	for i := 0; i < n; i++ {
+		k, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && k <= lk {
+			return nil, errors.Merge(
+				ErrNonCanonicalEncoding,
+				errors.Error("map keys are not sorted, or not unique"),
+			)
+		}
+		lk = k
+		h, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		iv, err := d.readPayload(Hint(h))
+		if err != nil {
+			return nil, err
+		}
+		if iv.Hash().IsEmpty() {
+			return nil, errors.Merge(
+				ErrNonCanonicalEncoding,
+				errors.Error("map includes empty value for "+k),
+			)
+		}
+		m[k] = iv
+	}
+	return m, nil
+}
@@ -0,0 +1,117 @@
+package tilde
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/errors"
+)
+
+// testdata/encoding.json contains test vectors that other implementations
+// of the encoding can use as well
+type encodingVectors struct {
+	Valid []struct {
+		Name    string          `json:"name"`
+		JSON    json.RawMessage `json:"json"`
+		Encoded string          `json:"encoded"`
+		Digest  Digest          `json:"digest"`
+	} `json:"valid"`
+	Invalid []struct {
+		Name    string `json:"name"`
+		Encoded string `json:"encoded"`
+		Error   string `json:"error"`
+	} `json:"invalid"`
+}
+
+func TestEncoding_Vectors(t *testing.T) {
+	b, err := os.ReadFile("testdata/encoding.json")
+	require.NoError(t, err)
+
+	vectors := &encodingVectors{}
+	require.NoError(t, json.Unmarshal(b, vectors))
+
+	for _, v := range vectors.Valid {
+		t.Run(v.Name, func(t *testing.T) {
+			m := Map{}
+			require.NoError(t, json.Unmarshal(v.JSON, &m))
+			require.Equal(t, v.Digest, m.Hash())
+
+			b, err := Marshal(m)
+			require.NoError(t, err)
+			require.Equal(t, v.Encoded, hex.EncodeToString(b))
+
+			u, err := Unmarshal(b)
+			require.NoError(t, err)
+			require.Equal(t, v.Digest, u.Hash())
+
+			// decoded values should encode to the same bytes
+			ub, err := Marshal(u)
+			require.NoError(t, err)
+			require.Equal(t, b, ub)
+		})
+	}
+
+	for _, v := range vectors.Invalid {
+		t.Run(v.Name, func(t *testing.T) {
+			b, err := hex.DecodeString(v.Encoded)
+			require.NoError(t, err)
+			_, err = Unmarshal(b)
+			require.True(
+				t,
+				errors.Is(err, errors.Error(v.Error)),
+				"expected %s, got %v", v.Error, err,
+			)
+		})
+	}
+}
+
+func TestEncoding_Values(t *testing.T) {
+	tests := []Value{
+		Bool(true),
+		Data("foo"),
+		Float(-1.5),
+		Int(-1),
+		String("foo"),
+		Uint(math.MaxUint64),
+		Digest("foo"),
+		BoolArray{true, false},
+		DataArray{Data("foo"), Data("")},
+		FloatArray{0.1, 0.2},
+		IntArray{math.MinInt64, math.MaxInt64},
+		MapArray{{"foo": String("bar")}, {"foo": Int(1)}},
+		StringArray{"foo", ""},
+		UintArray{0, math.MaxUint64},
+		DigestArray{"foo", "bar"},
+		Map{"foo": Map{"bar": StringArray{"baz"}}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.Hint()), func(t *testing.T) {
+			b, err := Marshal(tt)
+			require.NoError(t, err)
+			got, err := Unmarshal(b)
+			require.NoError(t, err)
+			assert.Equal(t, tt, got)
+			assert.Equal(t, tt.Hash(), got.Hash())
+		})
+	}
+}
+
+func TestEncoding_UnsupportedFloats(t *testing.T) {
+	tests := []Value{
+		Float(math.NaN()),
+		Float(math.Inf(1)),
+		FloatArray{1, Float(math.Inf(-1))},
+		Map{"foo": Float(math.NaN())},
+		Map{"foo": MapArray{{"bar": Float(math.Inf(1))}}},
+	}
+	for _, tt := range tests {
+		_, err := Marshal(tt)
+		require.ErrorIs(t, err, ErrInvalidEncoding)
+	}
+}
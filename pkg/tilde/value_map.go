@@ -38,7 +38,7 @@ func (v Map) Hash() Digest {
 		}
 
 		k += ":"
-		if _, ok := iv.(Map); ok {
+		if iv.Hint() == MapHint {
 			k += string(DigestHint)
 		} else {
 			k += string(iv.Hint())
@@ -186,6 +186,14 @@ func (v Map) UnmarshalJSON(b []byte) error {
 		if dataType == jsonparser.Null {
 			return nil
 		}
+		if dataType == jsonparser.Object && isRedactedJSON(h, value) {
+			iv, err := jsonUnmarshalRedacted(h, value)
+			if err != nil {
+				return err
+			}
+			v[k] = iv
+			return nil
+		}
 		iv, err := jsonUnmarshalValue(h, value)
 		if err != nil {
 			return err
@@ -0,0 +1,78 @@
+package tilde
+
+import (
+	"encoding/json"
+
+	"nimona.io/pkg/errors"
+)
+
+// Redacted replaces a value with its digest, while keeping the original
+// value's hint, so that the map it is part of still has the same digest.
+// In JSON, redacted values keep their hinted key, and their value is
+// replaced with a `{"@redacted:r": "<digest>"}` map.
+type Redacted struct {
+	// OriginalHint is the hint of the value that has been redacted
+	OriginalHint Hint
+	Digest       Digest
+}
+
+const ErrInvalidRedacted = errors.Error("invalid redacted value")
+
+// Redact returns the redacted version of the given value
+func Redact(v Value) Redacted {
+	if r, ok := v.(Redacted); ok {
+		return r
+	}
+	return Redacted{
+		OriginalHint: v.Hint(),
+		Digest:       v.Hash(),
+	}
+}
+
+func (v Redacted) Hint() Hint {
+	return v.OriginalHint
+}
+
+func (v Redacted) _isValue() {
+}
+
+func (v Redacted) Hash() Digest {
+	return v.Digest
+}
+
+func (v Redacted) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"@redacted:r": string(v.Digest),
+	})
+}
+
+// jsonUnmarshalRedacted parses the value of a redacted key with the given
+// hint
+func jsonUnmarshalRedacted(h Hint, value []byte) (Value, error) {
+	m := Map{}
+	if err := json.Unmarshal(value, &m); err != nil {
+		return nil, err
+	}
+	d, ok := m["@redacted"].(Digest)
+	if len(m) != 1 || !ok || d.IsEmpty() {
+		return nil, ErrInvalidRedacted
+	}
+	return Redacted{
+		OriginalHint: h,
+		Digest:       d,
+	}, nil
+}
+
+// isRedactedJSON checks whether a JSON map is a redacted value, maps are the
+// only values that can be JSON objects, so everything else has to be
+func isRedactedJSON(h Hint, value []byte) bool {
+	if h != MapHint {
+		return true
+	}
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &m); err != nil {
+		return false
+	}
+	_, ok := m["@redacted:r"]
+	return ok && len(m) == 1
+}
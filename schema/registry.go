@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"sync"
+
+	"nimona.io/pkg/tilde"
+)
+
+// Generated packages register their contexts when they are loaded, so that
+// validators do not need to fetch the contexts of our own objects, and so
+// that they can be served to peers that do not have them.
+
+var registry = struct {
+	lock     sync.RWMutex
+	contexts map[tilde.Digest]*Context
+}{
+	contexts: map[tilde.Digest]*Context{},
+}
+
+// Register makes the context available to all validators under the given
+// digest, which is trusted to be the digest of the context
+func Register(h tilde.Digest, c *Context) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.contexts[h] = c
+}
+
+// Registered returns the registered contexts by their digest
+func Registered() map[tilde.Digest]*Context {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	r := make(map[tilde.Digest]*Context, len(registry.contexts))
+	for h, c := range registry.contexts {
+		r[h] = c
+	}
+	return r
+}
+
+func registered(h tilde.Digest) (*Context, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	c, ok := registry.contexts[h]
+	return c, ok
+}
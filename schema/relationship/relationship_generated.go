@@ -5,18 +5,20 @@ package relationship
 import (
 	crypto "nimona.io/pkg/crypto"
 	object "nimona.io/pkg/object"
+	tilde "nimona.io/pkg/tilde"
+	schema "nimona.io/schema"
 )
 
 const RelationshipStreamRootType = "stream:nimona.io/schema/relationship"
 
 type RelationshipStreamRoot struct {
-	Metadata object.Metadata `nimona:"@metadata:m,type=stream:nimona.io/schema/relationship"`
+	Metadata object.Metadata `nimona:"@metadata:m,type=stream:nimona.io/schema/relationship,context=CpcKuP9Mj5rh14JxfzdJcXqZhT6tUdAGibpzgp6nVW1v"`
 }
 
 const AddedType = "event:nimona.io/schema/relationship.Added"
 
 type Added struct {
-	Metadata    object.Metadata  `nimona:"@metadata:m,type=event:nimona.io/schema/relationship.Added"`
+	Metadata    object.Metadata  `nimona:"@metadata:m,type=event:nimona.io/schema/relationship.Added,context=CpcKuP9Mj5rh14JxfzdJcXqZhT6tUdAGibpzgp6nVW1v"`
 	Alias       string           `nimona:"alias:s"`
 	RemoteParty crypto.PublicKey `nimona:"remoteParty:s"`
 	Timestamp   string           `nimona:"timestamp:s"`
@@ -25,7 +27,45 @@ type Added struct {
 const RemovedType = "event:nimona.io/schema/relationship.Removed"
 
 type Removed struct {
-	Metadata    object.Metadata  `nimona:"@metadata:m,type=event:nimona.io/schema/relationship.Removed"`
+	Metadata    object.Metadata  `nimona:"@metadata:m,type=event:nimona.io/schema/relationship.Removed,context=CpcKuP9Mj5rh14JxfzdJcXqZhT6tUdAGibpzgp6nVW1v"`
 	RemoteParty crypto.PublicKey `nimona:"remoteParty:s"`
 	Timestamp   string           `nimona:"timestamp:s"`
 }
+
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("CpcKuP9Mj5rh14JxfzdJcXqZhT6tUdAGibpzgp6nVW1v")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "nimona.io/schema/relationship",
+	Types: []*schema.Type{{
+		Name:       "stream:nimona.io/schema/relationship",
+		Properties: []*schema.Property{},
+	}, {
+		Name: "event:nimona.io/schema/relationship.Added",
+		Properties: []*schema.Property{{
+			Name: "alias",
+			Hint: "s",
+		}, {
+			Name: "remoteParty",
+			Hint: "s",
+		}, {
+			Name: "timestamp",
+			Hint: "s",
+		}},
+	}, {
+		Name: "event:nimona.io/schema/relationship.Removed",
+		Properties: []*schema.Property{{
+			Name: "remoteParty",
+			Hint: "s",
+		}, {
+			Name: "timestamp",
+			Hint: "s",
+		}},
+	}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
@@ -30,5 +30,3 @@ type (
 		Repeated bool            `nimona:"repeated:b"`
 	}
 )
-
-// TODO add validation method
@@ -0,0 +1,260 @@
+package schema
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/errors"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+)
+
+// Objects can reference the schema they conform to via their context, which
+// is the digest of a Context object.
+// The validator fetches the object's context, finds the type with the same
+// name as the object's type, and checks the object's properties against it.
+// Objects without a context are not validated.
+
+const (
+	ErrMissingProperty = errors.Error("missing required property")
+	ErrInvalidHint     = errors.Error("invalid property hint")
+	ErrUnknownProperty = errors.Error("unknown property")
+	ErrUnknownType     = errors.Error("unknown type")
+	// ErrInvalidContext is returned when the context could not be fetched,
+	// or the fetched object is not a valid context
+	ErrInvalidContext = errors.Error("invalid context")
+)
+
+type (
+	// Violation describes a property that does not conform to its type,
+	// the path of the top level object is empty
+	Violation struct {
+		Path  string
+		Error error
+	}
+	// ValidationReport is the result of Validate
+	ValidationReport struct {
+		Violations []Violation
+	}
+	// Validator validates objects against their contexts, contexts are
+	// cached as they are content addressed
+	Validator struct {
+		getter   object.Getter
+		contexts map[tilde.Digest]*Context
+		lock     sync.RWMutex
+	}
+)
+
+// NewValidator returns a validator that uses the given getter to fetch the
+// contexts of the objects it validates
+func NewValidator(getter object.Getter) *Validator {
+	return &Validator{
+		getter:   getter,
+		contexts: map[tilde.Digest]*Context{},
+	}
+}
+
+// Validate checks the object against the type of the same name in its
+// context. An error is only returned if the context could not be fetched,
+// violations are part of the report.
+func (v *Validator) Validate(
+	ctx context.Context,
+	o *object.Object,
+) (*ValidationReport, error) {
+	report := &ValidationReport{
+		Violations: []Violation{},
+	}
+	if o == nil || o.Context.IsEmpty() {
+		return report, nil
+	}
+	c, err := v.getContext(ctx, o.Context)
+	if err != nil {
+		return nil, err
+	}
+	t := c.getType(o.Type)
+	if t == nil {
+		report.add("", ErrUnknownType)
+		return report, nil
+	}
+	if err := v.validateMap(ctx, c, t, "", o.Data, report); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		return report.Violations[i].Path < report.Violations[j].Path
+	})
+	return report, nil
+}
+
+// Err returns the first violation as an error, or nil if there are none
+func (r *ValidationReport) Err() error {
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	f := r.Violations[0]
+	if f.Path == "" {
+		return f.Error
+	}
+	return errors.Merge(
+		errors.Error("property "+f.Path),
+		f.Error,
+	)
+}
+
+func (r *ValidationReport) add(path string, err error) {
+	r.Violations = append(r.Violations, Violation{
+		Path:  path,
+		Error: err,
+	})
+}
+
+func (v *Validator) validateMap(
+	ctx context.Context,
+	c *Context,
+	t *Type,
+	path string,
+	m tilde.Map,
+	report *ValidationReport,
+) error {
+	known := map[string]bool{}
+	for _, p := range t.Properties {
+		known[p.Name] = true
+		pv, ok := m[p.Name]
+		if !ok || pv == nil {
+			if p.Required {
+				report.add(joinPath(path, p.Name), ErrMissingProperty)
+			}
+			continue
+		}
+		if err := v.validateProperty(
+			ctx,
+			c,
+			p,
+			joinPath(path, p.Name),
+			pv,
+			report,
+		); err != nil {
+			return err
+		}
+	}
+	if !t.Strict {
+		return nil
+	}
+	for k := range m {
+		// special and hidden keys are not part of the type
+		if strings.HasPrefix(k, "@") || strings.HasPrefix(k, "_") {
+			continue
+		}
+		if !known[k] {
+			report.add(joinPath(path, k), ErrUnknownProperty)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateProperty(
+	ctx context.Context,
+	c *Context,
+	p *Property,
+	path string,
+	pv tilde.Value,
+	report *ValidationReport,
+) error {
+	if h := p.hint(); h != "" && pv.Hint() != h {
+		report.add(path, ErrInvalidHint)
+		return nil
+	}
+	if p.Type == "" {
+		return nil
+	}
+	// nested types are only checked for maps
+	var ms []tilde.Map
+	switch vv := pv.(type) {
+	case tilde.Map:
+		ms = []tilde.Map{vv}
+	case tilde.MapArray:
+		ms = vv
+	default:
+		return nil
+	}
+	// types can be part of a different context
+	if !p.Context.IsEmpty() {
+		var err error
+		c, err = v.getContext(ctx, p.Context)
+		if err != nil {
+			return err
+		}
+	}
+	t := c.getType(p.Type)
+	if t == nil {
+		report.add(path, ErrUnknownType)
+		return nil
+	}
+	for i, m := range ms {
+		ip := path
+		if _, ok := pv.(tilde.MapArray); ok {
+			ip = joinPath(path, strconv.Itoa(i))
+		}
+		if err := v.validateMap(ctx, c, t, ip, m, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Validator) getContext(
+	ctx context.Context,
+	h tilde.Digest,
+) (*Context, error) {
+	v.lock.RLock()
+	c, ok := v.contexts[h]
+	v.lock.RUnlock()
+	if ok {
+		return c, nil
+	}
+	if c, ok := registered(h); ok {
+		return c, nil
+	}
+	o, err := v.getter.Get(ctx, h)
+	if err != nil {
+		return nil, errors.Merge(ErrInvalidContext, err)
+	}
+	if !o.Hash().Equal(h) {
+		return nil, errors.Merge(
+			ErrInvalidContext,
+			errors.Error("digest mismatch"),
+		)
+	}
+	c = &Context{}
+	if err := object.Unmarshal(o, c); err != nil {
+		return nil, errors.Merge(ErrInvalidContext, err)
+	}
+	v.lock.Lock()
+	v.contexts[h] = c
+	v.lock.Unlock()
+	return c, nil
+}
+
+func (c *Context) getType(name string) *Type {
+	for _, t := range c.Types {
+		if t != nil && t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// hint returns the hint values of the property are expected to have,
+// repeated properties are arrays of their hint
+func (p *Property) hint() tilde.Hint {
+	if p.Hint == "" || !p.Repeated || strings.HasPrefix(string(p.Hint), "a") {
+		return p.Hint
+	}
+	return "a" + p.Hint
+}
+
+func joinPath(path, key string) string {
+	return strings.Trim(path+"/"+key, "/")
+}
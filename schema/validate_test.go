@@ -0,0 +1,184 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"nimona.io/pkg/context"
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/objectstore"
+	"nimona.io/pkg/tilde"
+)
+
+func TestValidator_Validate(t *testing.T) {
+	contexts := map[tilde.Digest]*object.Object{}
+	getter := object.GetterFunc(
+		func(_ context.Context, h tilde.Digest) (*object.Object, error) {
+			o, ok := contexts[h]
+			if !ok {
+				return nil, objectstore.ErrNotFound
+			}
+			return o, nil
+		},
+	)
+	addContext := func(c *Context) tilde.Digest {
+		o, err := object.Marshal(c)
+		require.NoError(t, err)
+		contexts[o.Hash()] = o
+		return o.Hash()
+	}
+
+	// an external context with a strict address type
+	addressContext := addContext(&Context{
+		Name: "address",
+		Types: []*Type{{
+			Name: "Address",
+			Properties: []*Property{{
+				Name:     "city",
+				Hint:     tilde.StringHint,
+				Required: true,
+			}},
+			Strict: true,
+		}},
+	})
+	personContext := addContext(&Context{
+		Name: "person",
+		Types: []*Type{{
+			Name: "Person",
+			Properties: []*Property{{
+				Name:     "name",
+				Hint:     tilde.StringHint,
+				Required: true,
+			}, {
+				Name:     "nicknames",
+				Hint:     tilde.StringHint,
+				Repeated: true,
+			}, {
+				Name: "pet",
+				Hint: tilde.MapHint,
+				Type: "Pet",
+			}, {
+				Name:     "addresses",
+				Hint:     tilde.MapHint,
+				Type:     "Address",
+				Context:  addressContext,
+				Repeated: true,
+			}, {
+				Name: "friend",
+				Hint: tilde.MapHint,
+				Type: "Friend",
+			}},
+		}, {
+			Name: "Pet",
+			Properties: []*Property{{
+				Name:     "age",
+				Hint:     tilde.UintHint,
+				Required: true,
+			}},
+		}},
+	})
+
+	tests := []struct {
+		name       string
+		object     *object.Object
+		violations []Violation
+		wantErr    error
+	}{{
+		name: "no context",
+		object: &object.Object{
+			Type: "Person",
+			Data: tilde.Map{},
+		},
+		violations: []Violation{},
+	}, {
+		name: "valid",
+		object: &object.Object{
+			Context: personContext,
+			Type:    "Person",
+			Data: tilde.Map{
+				"name":      tilde.String("foo"),
+				"nicknames": tilde.StringArray{"f", "fo"},
+				"pet": tilde.Map{
+					"age": tilde.Uint(3),
+				},
+				"addresses": tilde.MapArray{{
+					"city": tilde.String("bar"),
+				}},
+				"extra": tilde.String("not strict"),
+			},
+		},
+		violations: []Violation{},
+	}, {
+		name: "violations",
+		object: &object.Object{
+			Context: personContext,
+			Type:    "Person",
+			Data: tilde.Map{
+				"nicknames": tilde.String("f"),
+				"pet":       tilde.Map{},
+				"addresses": tilde.MapArray{{
+					"city": tilde.String("bar"),
+				}, {
+					"street": tilde.String("baz"),
+				}},
+				"friend": tilde.Map{},
+			},
+		},
+		violations: []Violation{{
+			Path:  "addresses/1/city",
+			Error: ErrMissingProperty,
+		}, {
+			Path:  "addresses/1/street",
+			Error: ErrUnknownProperty,
+		}, {
+			Path:  "friend",
+			Error: ErrUnknownType,
+		}, {
+			Path:  "name",
+			Error: ErrMissingProperty,
+		}, {
+			Path:  "nicknames",
+			Error: ErrInvalidHint,
+		}, {
+			Path:  "pet/age",
+			Error: ErrMissingProperty,
+		}},
+	}, {
+		name: "unknown type",
+		object: &object.Object{
+			Context: personContext,
+			Type:    "Car",
+			Data:    tilde.Map{},
+		},
+		violations: []Violation{{
+			Error: ErrUnknownType,
+		}},
+	}, {
+		name: "missing context",
+		object: &object.Object{
+			Context: "foo",
+			Type:    "Person",
+			Data:    tilde.Map{},
+		},
+		wantErr: ErrInvalidContext,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(getter)
+			r, err := v.Validate(context.New(), tt.object)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.violations, r.Violations)
+			if len(tt.violations) == 0 {
+				assert.NoError(t, r.Err())
+			} else {
+				assert.ErrorIs(t, r.Err(), tt.violations[0].Error)
+			}
+		})
+	}
+}
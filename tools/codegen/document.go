@@ -1,11 +1,19 @@
 package main
 
+import (
+	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
+)
+
 type Document struct {
 	PackageAlias string
 	Package      string
 	Imports      map[string]string
 	Objects      []*Object
 	Streams      []*Stream
+	// Schema describes the document's objects, and is set during generation
+	Schema       *schema.Context
+	SchemaDigest tilde.Digest
 }
 
 type Stream struct {
@@ -29,7 +37,6 @@ type Object struct {
 // |        |        - SimpleType
 // |        - IsRepeated
 // - Tag
-//
 type Member struct {
 	Name       string
 	GoFullType string
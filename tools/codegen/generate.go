@@ -49,6 +49,40 @@ type {{ structName $object.Name }} struct {
 }
 
 {{ end }}
+
+{{- if .Schema }}
+// SchemaContextDigest is the digest of SchemaContext, and the context of the
+// package's objects
+const SchemaContextDigest = tilde.Digest("{{ .SchemaDigest }}")
+
+// SchemaContext describes the package's objects
+var SchemaContext = &schema.Context{
+	Name: "{{ .Schema.Name }}",
+	Types: []*schema.Type{
+	{{- range $i, $type := .Schema.Types }}
+		{{- if $i }}, {{ end }}{
+		Name: "{{ $type.Name }}",
+		Properties: []*schema.Property{
+		{{- range $j, $property := $type.Properties }}
+			{{- if $j }}, {{ end }}{
+			Name: "{{ $property.Name }}",
+			Hint: "{{ $property.Hint }}",
+			{{- if $property.Type }}
+			Type: "{{ $property.Type }}",
+			{{- end }}
+			{{- if $property.Repeated }}
+			Repeated: true,
+			{{- end }}
+		}
+		{{- end }}},
+	}
+	{{- end }}},
+}
+
+func init() {
+	schema.Register(SchemaContextDigest, SchemaContext)
+}
+{{- end }}
 `
 
 func Generate(doc *Document, output string) ([]byte, error) {
@@ -62,7 +96,11 @@ func Generate(doc *Document, output string) ([]byte, error) {
 			return "`nimona:\"" + m.Tag + ":" + h + "\"`"
 		},
 		"tagMetadata": func(name string) string {
-			return "`nimona:\"@metadata:m,type=" + name + "\"`"
+			if doc.SchemaDigest.IsEmpty() {
+				return "`nimona:\"@metadata:m,type=" + name + "\"`"
+			}
+			return "`nimona:\"@metadata:m,type=" + name +
+				",context=" + string(doc.SchemaDigest) + "\"`"
 		},
 		"key": func(m Member) string {
 			h := m.Hint
@@ -148,6 +186,13 @@ func Generate(doc *Document, output string) ([]byte, error) {
 		}
 	}
 
+	if !schemaDependencies[doc.Package] {
+		doc.Schema, doc.SchemaDigest, err = schemaContext(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	doc.Imports["json"] = "encoding/json"
 	doc.Imports["tilde"] = "nimona.io/tilde"
 
@@ -172,6 +217,10 @@ func Generate(doc *Document, output string) ([]byte, error) {
 	}
 
 	for i, pkg := range doc.Imports {
+		// the schema packages are not under pkg
+		if strings.HasPrefix(pkg, "nimona.io/schema") {
+			continue
+		}
 		doc.Imports[i] = strings.Replace(pkg, "nimona.io/", "nimona.io/pkg/", 1)
 	}
 
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"nimona.io/pkg/object"
+	"nimona.io/pkg/tilde"
+	"nimona.io/schema"
+)
+
+// packages the schema package depends on cannot reference it, so their
+// objects do not get a context
+var schemaDependencies = map[string]bool{
+	"nimona.io/object": true,
+}
+
+// schemaContext describes the document's objects as a schema.Context, and
+// returns its digest.
+// Properties are never required, as empty values are omitted when objects
+// are marshaled, and types are not strict so older peers can still accept
+// objects with properties they don't know of.
+func schemaContext(doc *Document) (*schema.Context, tilde.Digest, error) {
+	names := map[string]bool{}
+	for _, o := range doc.Objects {
+		names[o.Name] = true
+	}
+	c := &schema.Context{
+		Name:  doc.Package,
+		Types: []*schema.Type{},
+	}
+	for _, o := range doc.Objects {
+		t := &schema.Type{
+			Name:       o.Name,
+			Properties: []*schema.Property{},
+		}
+		for _, m := range o.Members {
+			p := &schema.Property{
+				Name:     m.Tag,
+				Hint:     tilde.Hint(m.Hint),
+				Repeated: m.IsRepeated,
+			}
+			// only types of the same document can be referenced, as we
+			// don't know the digests of other contexts
+			if m.Hint == string(tilde.MapHint) && names[m.GoFullType] {
+				p.Type = m.GoFullType
+			}
+			t.Properties = append(t.Properties, p)
+		}
+		c.Types = append(c.Types, t)
+	}
+	o, err := object.Marshal(c)
+	if err != nil {
+		return nil, tilde.EmptyDigest, err
+	}
+	return c, o.Hash(), nil
+}